@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"cloud-driver/internal/config"
+	"cloud-driver/internal/middleware"
+)
+
+// Mints a signed bearer token using auth.token_secret from the server config
+func main() {
+	subject := flag.String("sub", "", "token subject, shown in logs and errors")
+	scopes := flag.String("scopes", "read", "comma-separated scopes: read,write,offline,upload,login")
+	ttl := flag.Duration("ttl", 24*time.Hour, "token lifetime")
+	flag.Parse()
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	if cfg.Auth.TokenSecret == "" {
+		log.Fatal("auth.token_secret is not configured")
+	}
+	if *subject == "" {
+		log.Fatal("-sub is required")
+	}
+
+	claims := middleware.TokenClaims{
+		Subject:   *subject,
+		Scopes:    strings.Split(*scopes, ","),
+		ExpiresAt: time.Now().Add(*ttl).Unix(),
+	}
+	// Reject unknown scopes before handing out a token the server would refuse
+	if err := middleware.ValidateScopes(claims.Scopes); err != nil {
+		log.Fatal(err)
+	}
+
+	token, err := middleware.SignToken(cfg.Auth.TokenSecret, claims)
+	if err != nil {
+		log.Fatalf("Failed to sign token: %v", err)
+	}
+	fmt.Println(token)
+}
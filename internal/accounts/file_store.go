@@ -0,0 +1,88 @@
+package accounts
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud-driver/internal/models"
+	"cloud-driver/internal/recordstore"
+)
+
+// FileStore is a Store kept in the shared encrypted record store, one sealed
+// record per handle. Accounts are also cached in memory, since every request
+// made with a handle looks one up.
+type FileStore struct {
+	store *recordstore.FileStore[Account]
+	now   func() time.Time
+
+	mu       sync.RWMutex
+	accounts map[string]Account
+}
+
+// NewFileStore opens or creates the encrypted account store at path
+func NewFileStore(path, key string) (*FileStore, error) {
+	store, err := recordstore.NewFileStore[Account](path, key, "accounts")
+	if err != nil {
+		return nil, err
+	}
+	records, err := store.Load(context.Background())
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("load accounts: %w", err)
+	}
+	accounts := make(map[string]Account, len(records))
+	for _, account := range records {
+		accounts[account.Handle] = account
+	}
+	return &FileStore{store: store, now: time.Now, accounts: accounts}, nil
+}
+
+// Create stores credentials under a new random handle
+func (s *FileStore) Create(ctx context.Context, credentials models.Drive115Credentials) (*Account, error) {
+	handle, err := recordstore.NewID("acct_")
+	if err != nil {
+		return nil, err
+	}
+	credentials.Account = ""
+	account := Account{Handle: handle, Credentials: credentials, CreatedAt: s.now().UTC()}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.store.Save(ctx, account); err != nil {
+		return nil, err
+	}
+	s.accounts[handle] = account
+	return &account, nil
+}
+
+// Get returns the account for handle or ErrNotFound
+func (s *FileStore) Get(ctx context.Context, handle string) (*Account, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	account, ok := s.accounts[handle]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &account, nil
+}
+
+// Delete forgets handle
+func (s *FileStore) Delete(ctx context.Context, handle string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.accounts[handle]; !ok {
+		return ErrNotFound
+	}
+	if err := s.store.Delete(ctx, handle); err != nil {
+		return err
+	}
+	delete(s.accounts, handle)
+	return nil
+}
+
+// Close releases the file
+func (s *FileStore) Close() error {
+	return s.store.Close()
+}
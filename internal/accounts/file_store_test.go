@@ -0,0 +1,59 @@
+package accounts
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"cloud-driver/internal/models"
+)
+
+const testKey = "test-account-store-key-at-least-32-characters"
+
+func TestFileStorePersistsEncryptedAccounts(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "accounts.db")
+	store, err := NewFileStore(path, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account, err := store.Create(ctx, models.Drive115Credentials{UID: "uid", CID: "cid", SEID: "secret-seid", KID: "kid"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("secret-seid")) {
+		t.Fatal("account store contains plaintext credentials")
+	}
+
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewFileStore(path, "another-account-store-key-of-32-characters"); err == nil {
+		t.Fatal("opened account store with the wrong key")
+	}
+
+	reopened, err := NewFileStore(path, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	got, err := reopened.Get(ctx, account.Handle)
+	if err != nil || got.Credentials.SEID != "secret-seid" {
+		t.Fatalf("Get = %+v, %v", got, err)
+	}
+
+	if err := reopened.Delete(ctx, account.Handle); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reopened.Get(ctx, account.Handle); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("Get after delete = %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,36 @@
+// Package accounts keeps 115 credentials server-side behind opaque handles so
+// clients no longer need to hold live 115 cookies.
+package accounts
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"cloud-driver/internal/models"
+)
+
+// ErrNotFound is returned when a handle does not name a stored account
+var ErrNotFound = errors.New("account not found")
+
+// Account is a stored 115 login addressed by an opaque handle
+type Account struct {
+	Handle      string                     `json:"handle"`
+	Credentials models.Drive115Credentials `json:"credentials"`
+	CreatedAt   time.Time                  `json:"created_at"`
+}
+
+// Store persists 115 credentials behind opaque account handles
+type Store interface {
+	// Create stores credentials under a new random handle
+	Create(ctx context.Context, credentials models.Drive115Credentials) (*Account, error)
+	// Get returns the account for handle or ErrNotFound
+	Get(ctx context.Context, handle string) (*Account, error)
+	// Delete forgets handle; deleting an unknown handle returns ErrNotFound
+	Delete(ctx context.Context, handle string) error
+}
+
+// RecordID keys the stored account by its handle
+func (a Account) RecordID() string {
+	return a.Handle
+}
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/labstack/gommon/bytes"
 	"github.com/spf13/viper"
@@ -12,10 +14,127 @@ import (
 
 // Config represents the application configuration
 type Config struct {
-	Server              ServerConfig `mapstructure:"server"`
-	UploadPartBodyLimit string       `mapstructure:"upload_part_body_limit"`
-	UploadSessionSecret string       `mapstructure:"upload_session_secret"`
-	AllowedOrigins      []string     `mapstructure:"allowed_origins"`
+	Server              ServerConfig       `mapstructure:"server"`
+	UploadPartBodyLimit string             `mapstructure:"upload_part_body_limit"`
+	UploadSessionSecret string             `mapstructure:"upload_session_secret"`
+	AllowedOrigins      []string           `mapstructure:"allowed_origins"`
+	AccountStore        AccountStoreConfig `mapstructure:"account_store"`
+	Auth                AuthConfig         `mapstructure:"auth"`
+	WebDAV              WebDAVConfig       `mapstructure:"webdav"`
+	S3                  S3Config           `mapstructure:"s3"`
+	SourceUpload        SourceUploadConfig `mapstructure:"source_upload"`
+	Jobs                JobsConfig         `mapstructure:"jobs"`
+	Webhooks            WebhooksConfig     `mapstructure:"webhooks"`
+	Rules               RulesConfig        `mapstructure:"rules"`
+}
+
+// AccountStoreConfig enables server-side account handles when Path is set
+type AccountStoreConfig struct {
+	Path string `mapstructure:"path"`
+	Key  string `mapstructure:"key"`
+}
+
+// AuthConfig lists the API keys and token secret accepted by the API. Leaving
+// both empty disables caller authentication.
+type AuthConfig struct {
+	APIKeys     []APIKeyConfig `mapstructure:"api_keys"`
+	TokenSecret string         `mapstructure:"token_secret"`
+}
+
+// APIKeyConfig is a static API key and its scopes
+type APIKeyConfig struct {
+	Name   string   `mapstructure:"name"`
+	Key    string   `mapstructure:"key"`
+	Scopes []string `mapstructure:"scopes"`
+}
+
+// WebDAVConfig enables the WebDAV front-end below Prefix. PUT bodies are
+// spooled to SpoolDir (the system temp directory when empty) to hash them.
+type WebDAVConfig struct {
+	Enabled       bool               `mapstructure:"enabled"`
+	Prefix        string             `mapstructure:"prefix"`
+	SpoolDir      string             `mapstructure:"spool_dir"`
+	MaxUploadSize string             `mapstructure:"max_upload_size"`
+	Users         []WebDAVUserConfig `mapstructure:"users"`
+}
+
+// WebDAVUserConfig maps a basic-auth login to an account handle or raw credentials
+type WebDAVUserConfig struct {
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+	Account  string `mapstructure:"account"`
+	UID      string `mapstructure:"uid"`
+	CID      string `mapstructure:"cid"`
+	SEID     string `mapstructure:"seid"`
+	KID      string `mapstructure:"kid"`
+}
+
+// S3Config enables the S3 gateway on its own listener. Object and part
+// bodies are spooled to SpoolDir (the system temp directory when empty).
+type S3Config struct {
+	Enabled       bool                `mapstructure:"enabled"`
+	Host          string              `mapstructure:"host"`
+	Port          int                 `mapstructure:"port"`
+	Region        string              `mapstructure:"region"`
+	SpoolDir      string              `mapstructure:"spool_dir"`
+	MaxUploadSize string              `mapstructure:"max_upload_size"`
+	AccessKeys    []S3AccessKeyConfig `mapstructure:"access_keys"`
+}
+
+// S3AccessKeyConfig maps an S3 access key pair to an account handle or raw credentials
+type S3AccessKeyConfig struct {
+	AccessKeyID     string `mapstructure:"access_key_id"`
+	SecretAccessKey string `mapstructure:"secret_access_key"`
+	Account         string `mapstructure:"account"`
+	UID             string `mapstructure:"uid"`
+	CID             string `mapstructure:"cid"`
+	SEID            string `mapstructure:"seid"`
+	KID             string `mapstructure:"kid"`
+}
+
+// SourceUploadConfig controls server-side uploads from URLs and local paths.
+// Local paths are accepted only below LocalRoots; URL downloads are spooled to
+// SpoolDir (the system temp directory when empty).
+type SourceUploadConfig struct {
+	LocalRoots           []string `mapstructure:"local_roots"`
+	SpoolDir             string   `mapstructure:"spool_dir"`
+	MaxDownloadSize      string   `mapstructure:"max_download_size"`
+	AllowPrivateNetworks bool     `mapstructure:"allow_private_networks"`
+	Concurrency          int      `mapstructure:"concurrency"`
+}
+
+// JobsConfig controls the background job workers. Jobs are kept in memory
+// unless Path is set, in which case they are stored encrypted with Key and
+// resumed after a restart.
+type JobsConfig struct {
+	Path        string        `mapstructure:"path"`
+	Key         string        `mapstructure:"key"`
+	Workers     int           `mapstructure:"workers"`
+	MaxAttempts int           `mapstructure:"max_attempts"`
+	RetryDelay  time.Duration `mapstructure:"retry_delay"`
+	Retention   time.Duration `mapstructure:"retention"`
+}
+
+// WebhooksConfig controls offline task webhooks. Webhooks are kept in memory
+// unless Path is set, in which case they are stored encrypted with Key.
+// Deliveries reach only public addresses unless AllowPrivateNetworks is set.
+type WebhooksConfig struct {
+	Path                 string        `mapstructure:"path"`
+	Key                  string        `mapstructure:"key"`
+	AllowPrivateNetworks bool          `mapstructure:"allow_private_networks"`
+	MaxAttempts          int           `mapstructure:"max_attempts"`
+	RetryDelay           time.Duration `mapstructure:"retry_delay"`
+	Timeout              time.Duration `mapstructure:"timeout"`
+}
+
+// RulesConfig controls the rules that organize finished offline downloads.
+// Rules are kept in memory unless Path is set, in which case they are stored
+// encrypted with Key. Rule actions are appended to AuditLog as JSON Lines, or
+// kept in memory when it is empty.
+type RulesConfig struct {
+	Path     string `mapstructure:"path"`
+	Key      string `mapstructure:"key"`
+	AuditLog string `mapstructure:"audit_log"`
 }
 
 // ServerConfig contains server-related configuration
@@ -37,9 +156,43 @@ func Load() (*Config, error) {
 	viper.SetDefault("upload_part_body_limit", "17M")
 	viper.SetDefault("upload_session_secret", "")
 	viper.SetDefault("allowed_origins", []string{"https://drive.syzroy.com", "http://localhost:3012", "http://127.0.0.1:3012"})
+	viper.SetDefault("account_store.path", "")
+	viper.SetDefault("account_store.key", "")
+	viper.SetDefault("auth.token_secret", "")
+	viper.SetDefault("webdav.enabled", false)
+	viper.SetDefault("webdav.prefix", "/dav")
+	viper.SetDefault("webdav.spool_dir", "")
+	viper.SetDefault("webdav.max_upload_size", "20G")
+	viper.SetDefault("s3.enabled", false)
+	viper.SetDefault("s3.host", "localhost")
+	viper.SetDefault("s3.port", 9000)
+	viper.SetDefault("s3.region", "us-east-1")
+	viper.SetDefault("s3.spool_dir", "")
+	viper.SetDefault("s3.max_upload_size", "20G")
+	viper.SetDefault("source_upload.local_roots", []string{})
+	viper.SetDefault("source_upload.spool_dir", "")
+	viper.SetDefault("source_upload.max_download_size", "20G")
+	viper.SetDefault("source_upload.allow_private_networks", false)
+	viper.SetDefault("source_upload.concurrency", 2)
+	viper.SetDefault("jobs.path", "")
+	viper.SetDefault("jobs.key", "")
+	viper.SetDefault("jobs.workers", 4)
+	viper.SetDefault("jobs.max_attempts", 3)
+	viper.SetDefault("jobs.retry_delay", "10s")
+	viper.SetDefault("jobs.retention", "168h")
+	viper.SetDefault("webhooks.path", "")
+	viper.SetDefault("webhooks.key", "")
+	viper.SetDefault("webhooks.allow_private_networks", false)
+	viper.SetDefault("webhooks.max_attempts", 8)
+	viper.SetDefault("webhooks.retry_delay", "10s")
+	viper.SetDefault("webhooks.timeout", "10s")
+	viper.SetDefault("rules.path", "")
+	viper.SetDefault("rules.key", "")
+	viper.SetDefault("rules.audit_log", "")
 
 	// Environment variable support
 	viper.SetEnvPrefix("CLOUD_DRIVER")
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	viper.AutomaticEnv()
 
 	if err := viper.ReadInConfig(); err != nil {
@@ -76,6 +229,31 @@ func validate(cfg *Config) error {
 	if len(cfg.UploadSessionSecret) < 32 {
 		return fmt.Errorf("upload_session_secret must be at least 32 characters")
 	}
+	if cfg.AccountStore.Path != "" && len(cfg.AccountStore.Key) < 32 {
+		return fmt.Errorf("account_store.key must be at least 32 characters")
+	}
+	if cfg.WebDAV.Enabled {
+		if err := validateWebDAV(&cfg.WebDAV); err != nil {
+			return err
+		}
+	}
+	if cfg.S3.Enabled {
+		if err := validateS3(&cfg.S3, cfg.Server.Port); err != nil {
+			return err
+		}
+	}
+	if err := validateSourceUpload(&cfg.SourceUpload); err != nil {
+		return err
+	}
+	if err := validateJobs(&cfg.Jobs); err != nil {
+		return err
+	}
+	if err := validateWebhooks(&cfg.Webhooks); err != nil {
+		return err
+	}
+	if cfg.Rules.Path != "" && len(cfg.Rules.Key) < 32 {
+		return fmt.Errorf("rules.key must be at least 32 characters")
+	}
 	for _, origin := range cfg.AllowedOrigins {
 		if origin != "*" && !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
 			return fmt.Errorf("invalid allowed origin: %q", origin)
@@ -84,3 +262,91 @@ func validate(cfg *Config) error {
 
 	return nil
 }
+
+func validateWebDAV(cfg *WebDAVConfig) error {
+	prefix := cfg.Prefix
+	if !strings.HasPrefix(prefix, "/") || strings.HasSuffix(prefix, "/") || prefix == "/api" || strings.HasPrefix(prefix, "/api/") || prefix == "/health" {
+		return fmt.Errorf("invalid webdav prefix: %q", prefix)
+	}
+	if size, err := bytes.Parse(cfg.MaxUploadSize); err != nil || size <= 0 {
+		return fmt.Errorf("invalid webdav max upload size: %q", cfg.MaxUploadSize)
+	}
+	for _, user := range cfg.Users {
+		if !hasOneCredential(user.Account, user.UID, user.CID, user.SEID, user.KID) {
+			return fmt.Errorf("webdav user %q needs either an account handle or uid, cid, seid and kid", user.Username)
+		}
+	}
+	return nil
+}
+
+func validateS3(cfg *S3Config, serverPort int) error {
+	if cfg.Port <= 0 || cfg.Port > 65535 || cfg.Port == serverPort {
+		return fmt.Errorf("invalid s3 port: %d", cfg.Port)
+	}
+	if cfg.Region == "" {
+		return fmt.Errorf("s3 region is required")
+	}
+	if size, err := bytes.Parse(cfg.MaxUploadSize); err != nil || size <= 0 {
+		return fmt.Errorf("invalid s3 max upload size: %q", cfg.MaxUploadSize)
+	}
+	if len(cfg.AccessKeys) == 0 {
+		return fmt.Errorf("s3 needs at least one access key")
+	}
+	for _, key := range cfg.AccessKeys {
+		if !hasOneCredential(key.Account, key.UID, key.CID, key.SEID, key.KID) {
+			return fmt.Errorf("s3 access key %q needs either an account handle or uid, cid, seid and kid", key.AccessKeyID)
+		}
+	}
+	return nil
+}
+
+func validateSourceUpload(cfg *SourceUploadConfig) error {
+	if size, err := bytes.Parse(cfg.MaxDownloadSize); err != nil || size <= 0 {
+		return fmt.Errorf("invalid source upload max download size: %q", cfg.MaxDownloadSize)
+	}
+	if cfg.Concurrency <= 0 {
+		return fmt.Errorf("invalid source upload concurrency: %d", cfg.Concurrency)
+	}
+	for _, root := range cfg.LocalRoots {
+		if !filepath.IsAbs(root) {
+			return fmt.Errorf("source upload root must be absolute: %q", root)
+		}
+	}
+	return nil
+}
+
+func validateJobs(cfg *JobsConfig) error {
+	if cfg.Path != "" && len(cfg.Key) < 32 {
+		return fmt.Errorf("jobs.key must be at least 32 characters")
+	}
+	if cfg.Workers <= 0 || cfg.Workers > 64 {
+		return fmt.Errorf("invalid jobs workers: %d", cfg.Workers)
+	}
+	if cfg.MaxAttempts <= 0 {
+		return fmt.Errorf("invalid jobs max attempts: %d", cfg.MaxAttempts)
+	}
+	if cfg.RetryDelay <= 0 || cfg.Retention <= 0 {
+		return fmt.Errorf("jobs retry_delay and retention must be positive")
+	}
+	return nil
+}
+
+func validateWebhooks(cfg *WebhooksConfig) error {
+	if cfg.Path != "" && len(cfg.Key) < 32 {
+		return fmt.Errorf("webhooks.key must be at least 32 characters")
+	}
+	if cfg.MaxAttempts <= 0 {
+		return fmt.Errorf("invalid webhooks max attempts: %d", cfg.MaxAttempts)
+	}
+	if cfg.RetryDelay <= 0 || cfg.Timeout <= 0 {
+		return fmt.Errorf("webhooks retry_delay and timeout must be positive")
+	}
+	return nil
+}
+
+// hasOneCredential reports whether exactly one of an account handle and a
+// full set of cookies is given
+func hasOneCredential(account, uid, cid, seid, kid string) bool {
+	hasCookies := uid != "" && cid != "" && seid != "" && kid != ""
+	return (account == "") == hasCookies
+}
@@ -21,3 +21,13 @@ func TestLoadUploadSecurityConfigFromEnvironment(t *testing.T) {
 		t.Fatalf("allowed origins = %#v, want %#v", cfg.AllowedOrigins, want)
 	}
 }
+
+func TestLoadRequiresJobStoreKeyWithPath(t *testing.T) {
+	viper.Reset()
+	t.Cleanup(viper.Reset)
+	t.Setenv("CLOUD_DRIVER_UPLOAD_SESSION_SECRET", "test-upload-session-secret-at-least-32-characters")
+	t.Setenv("CLOUD_DRIVER_JOBS_PATH", "data/jobs.db")
+	if _, err := Load(); err == nil {
+		t.Fatal("loaded a job store path without a key")
+	}
+}
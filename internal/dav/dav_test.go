@@ -0,0 +1,227 @@
+package dav
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud-driver/internal/models"
+	"cloud-driver/internal/services/servicestest"
+
+	"golang.org/x/net/webdav"
+)
+
+// newFakeBackend returns a drive holding /Movies/a.mkv
+func newFakeBackend() *servicestest.Drive {
+	backend := servicestest.NewDrive()
+	movies := backend.Add("0", "Movies", true, "")
+	backend.Add(movies, "a.mkv", false, "0123456789")
+	return backend
+}
+
+func newTestHandler(backend *servicestest.Drive) *Handler {
+	return &Handler{Prefix: "/dav", Backend: backend}
+}
+
+// testCredentials is the account every test request is served for
+var testCredentials = models.Drive115Credentials{UID: "uid", CID: "cid", SEID: "seid", KID: "kid"}
+
+func serve(h *Handler, method, target string, body io.Reader, header map[string]string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, target, body)
+	for key, value := range header {
+		request.Header.Set(key, value)
+	}
+	recorder := httptest.NewRecorder()
+	h.Serve(recorder, request, testCredentials)
+	return recorder
+}
+
+func TestPropfindListsDirectory(t *testing.T) {
+	h := newTestHandler(newFakeBackend())
+	response := serve(h, "PROPFIND", "/dav/Movies/", nil, map[string]string{"Depth": "1"})
+	if response.Code != http.StatusMultiStatus {
+		t.Fatalf("status = %d: %s", response.Code, response.Body)
+	}
+	body := response.Body.String()
+	for _, want := range []string{"/dav/Movies/a.mkv", "<D:getcontentlength>10</D:getcontentlength>", "video/x-matroska"} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("PROPFIND body lacks %q:\n%s", want, body)
+		}
+	}
+}
+
+func TestGetForwardsRange(t *testing.T) {
+	h := newTestHandler(newFakeBackend())
+	response := serve(h, http.MethodGet, "/dav/Movies/a.mkv", nil, map[string]string{"Range": "bytes=2-4"})
+	if response.Code != http.StatusPartialContent || response.Body.String() != "234" {
+		t.Fatalf("status = %d body = %q", response.Code, response.Body)
+	}
+	if got := response.Header().Get("Content-Range"); got != "bytes 2-4/10" {
+		t.Fatalf("Content-Range = %q", got)
+	}
+}
+
+func TestPutUploadsAndReplaces(t *testing.T) {
+	backend := newFakeBackend()
+	h := newTestHandler(backend)
+	h.SpoolDir = t.TempDir()
+
+	response := serve(h, http.MethodPut, "/dav/Movies/b.txt", strings.NewReader("hello"), nil)
+	if response.Code != http.StatusCreated {
+		t.Fatalf("create status = %d: %s", response.Code, response.Body)
+	}
+	if len(backend.Uploads) != 1 || backend.Uploads[0].Digest.QuickID != "AAF4C61DDCC5E8A2DABEDE0F3B482CD9AEA9434D" {
+		t.Fatalf("uploads = %v", backend.Uploads)
+	}
+
+	response = serve(h, http.MethodPut, "/dav/Movies/b.txt", strings.NewReader("hello again"), nil)
+	if response.Code != http.StatusNoContent {
+		t.Fatalf("replace status = %d", response.Code)
+	}
+	entries, _ := backend.ListDir(context.Background(), models.Drive115Credentials{}, "/Movies")
+	if len(entries) != 2 || entries[1].Size != int64(len("hello again")) {
+		t.Fatalf("entries after replace = %+v", entries)
+	}
+
+	if response := serve(h, http.MethodPut, "/dav/Missing/b.txt", strings.NewReader("x"), nil); response.Code != http.StatusConflict {
+		t.Fatalf("missing parent status = %d", response.Code)
+	}
+	h.MaxUploadSize = 3
+	if response := serve(h, http.MethodPut, "/dav/Movies/c.txt", strings.NewReader("toolong"), nil); response.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversize status = %d", response.Code)
+	}
+}
+
+func TestCopyUsesServerSideCopy(t *testing.T) {
+	backend := newFakeBackend()
+	h := newTestHandler(backend)
+
+	response := serve(h, "COPY", "/dav/Movies/a.mkv", nil, map[string]string{"Destination": "/dav/Movies/b.mkv"})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", response.Code, response.Body)
+	}
+	if backend.Copies != 1 {
+		t.Fatalf("copies = %d", backend.Copies)
+	}
+	copied, err := backend.ResolvePath(context.Background(), models.Drive115Credentials{}, "/Movies/b.mkv")
+	if err != nil || copied.Size != 10 {
+		t.Fatalf("copy = %+v, %v", copied, err)
+	}
+
+	response = serve(h, "COPY", "/dav/Movies/a.mkv", nil, map[string]string{"Destination": "/dav/Movies/b.mkv", "Overwrite": "F"})
+	if response.Code != http.StatusPreconditionFailed {
+		t.Fatalf("overwrite=F status = %d", response.Code)
+	}
+}
+
+func TestCopyIgnoresConcurrentWrites(t *testing.T) {
+	backend := newFakeBackend()
+	h := newTestHandler(backend)
+	movies, err := backend.ResolvePath(context.Background(), testCredentials, "/Movies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another client writes to the destination directory while 115 copies
+	backend.BeforeCopy = func() { backend.Add(movies.ID, "0.mkv", false, "other") }
+
+	response := serve(h, "COPY", "/dav/Movies/a.mkv", nil, map[string]string{"Destination": "/dav/Movies/b.mkv"})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", response.Code, response.Body)
+	}
+	entries, err := backend.ListDir(context.Background(), testCredentials, "/Movies")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+	if strings.Join(names, ",") != "0.mkv,a.mkv,b.mkv" {
+		t.Fatalf("Movies = %v", names)
+	}
+	copied, err := backend.ResolvePath(context.Background(), testCredentials, "/Movies/b.mkv")
+	if err != nil || copied.Size != 10 {
+		t.Fatalf("copy = %+v, %v", copied, err)
+	}
+}
+
+func TestPutAndCopyHonorLocks(t *testing.T) {
+	backend := newFakeBackend()
+	h := newTestHandler(backend)
+	h.SpoolDir = t.TempDir()
+	token, err := h.lockSystem(testCredentials.Key()).Create(time.Now(), webdav.LockDetails{Root: "/Movies/b.txt", Duration: time.Hour, ZeroDepth: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response := serve(h, http.MethodPut, "/dav/Movies/b.txt", strings.NewReader("hello"), nil); response.Code != webdav.StatusLocked {
+		t.Fatalf("PUT to locked file status = %d", response.Code)
+	}
+	if response := serve(h, "COPY", "/dav/Movies/a.mkv", nil, map[string]string{"Destination": "/dav/Movies/b.txt"}); response.Code != webdav.StatusLocked {
+		t.Fatalf("COPY onto locked file status = %d", response.Code)
+	}
+	if response := serve(h, http.MethodPut, "/dav/Movies/b.txt", strings.NewReader("hello"), map[string]string{"If": "(<wrong-token>)"}); response.Code != http.StatusPreconditionFailed {
+		t.Fatalf("PUT with wrong token status = %d", response.Code)
+	}
+	if len(backend.Uploads) != 0 || backend.Copies != 0 {
+		t.Fatalf("uploads = %v, copies = %d", backend.Uploads, backend.Copies)
+	}
+	if response := serve(h, http.MethodPut, "/dav/Movies/b.txt", strings.NewReader("hello"), map[string]string{"If": "(<" + token + ">)"}); response.Code != http.StatusCreated {
+		t.Fatalf("PUT with lock token status = %d: %s", response.Code, response.Body)
+	}
+
+	// Another login to the same account, here through a handle, sees the lock
+	request := httptest.NewRequest(http.MethodPut, "/dav/Movies/b.txt", strings.NewReader("hello"))
+	recorder := httptest.NewRecorder()
+	handle := testCredentials
+	handle.Account = "acct_test"
+	h.Serve(recorder, request, handle)
+	if recorder.Code != webdav.StatusLocked {
+		t.Fatalf("PUT from another login status = %d", recorder.Code)
+	}
+}
+
+func TestParseIfHeader(t *testing.T) {
+	lists, ok := parseIfHeader(`</dav/a.mkv> (<urn:lock:1> ["etag"]) (Not <urn:lock:2>)`)
+	if !ok || len(lists) != 2 || lists[0].resourceTag != "/dav/a.mkv" || lists[1].resourceTag != "/dav/a.mkv" {
+		t.Fatalf("lists = %+v", lists)
+	}
+	if c := lists[0].conditions; len(c) != 2 || c[0].Token != "urn:lock:1" || c[1].ETag != `"etag"` {
+		t.Fatalf("conditions = %+v", c)
+	}
+	if c := lists[1].conditions; len(c) != 1 || !c[0].Not || c[0].Token != "urn:lock:2" {
+		t.Fatalf("conditions = %+v", c)
+	}
+	for _, header := range []string{"(", "()", "<x>", "(<a>", "x"} {
+		if _, ok := parseIfHeader(header); ok {
+			t.Fatalf("accepted %q", header)
+		}
+	}
+}
+
+func TestMoveAndMkcolUseFileSystem(t *testing.T) {
+	backend := newFakeBackend()
+	h := newTestHandler(backend)
+
+	if response := serve(h, "MKCOL", "/dav/Shows", nil, nil); response.Code != http.StatusCreated {
+		t.Fatalf("MKCOL status = %d", response.Code)
+	}
+	response := serve(h, "MOVE", "/dav/Movies/a.mkv", nil, map[string]string{"Destination": "/dav/Shows/c.mkv"})
+	if response.Code != http.StatusCreated {
+		t.Fatalf("MOVE status = %d: %s", response.Code, response.Body)
+	}
+	moved, err := backend.ResolvePath(context.Background(), models.Drive115Credentials{}, "/Shows/c.mkv")
+	if err != nil || moved.Size != 10 {
+		t.Fatalf("moved = %+v, %v", moved, err)
+	}
+	if response := serve(h, http.MethodDelete, "/dav/Shows/c.mkv", nil, nil); response.Code != http.StatusNoContent {
+		t.Fatalf("DELETE status = %d", response.Code)
+	}
+	if response := serve(h, "PROPFIND", "/dav/Shows/c.mkv", nil, map[string]string{"Depth": "0"}); response.Code != http.StatusNotFound {
+		t.Fatalf("PROPFIND after delete status = %d", response.Code)
+	}
+}
@@ -0,0 +1,295 @@
+// Package dav adapts the 115 drive to golang.org/x/net/webdav. Paths are
+// resolved through the service's per-account path cache, so PROPFIND walks and
+// repeated lookups cost one listing per directory.
+package dav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"cloud-driver/internal/models"
+	"cloud-driver/internal/services"
+
+	hash "github.com/SheltonZhu/115driver/pkg/crypto"
+	"github.com/SheltonZhu/115driver/pkg/driver"
+	"golang.org/x/net/webdav"
+)
+
+// Backend is the part of Drive115Service the WebDAV front-end uses
+type Backend interface {
+	ResolvePath(ctx context.Context, credentials models.Drive115Credentials, filePath string) (services.PathEntry, error)
+	ListDir(ctx context.Context, credentials models.Drive115Credentials, dirPath string) ([]services.PathEntry, error)
+	Mkdir(ctx context.Context, credentials models.Drive115Credentials, parentID, name string) (string, error)
+	MoveFiles(ctx context.Context, credentials models.Drive115Credentials, fileIDs []string, targetDirID string) error
+	CopyFiles(ctx context.Context, credentials models.Drive115Credentials, fileIDs []string, targetDirID string) error
+	RenameFiles(ctx context.Context, credentials models.Drive115Credentials, files []models.RenameFileItem) error
+	DeleteFiles(ctx context.Context, credentials models.Drive115Credentials, fileIDs []string) error
+	OpenPickCodeStream(ctx context.Context, credentials models.Drive115Credentials, pickCode, fileName, method string, requestHeader http.Header) (*services.DownloadStream, error)
+	UploadFile(ctx context.Context, credentials models.Drive115Credentials, dirID, name string, source io.ReaderAt, digest hash.DigestResult, progress func(int64)) (bool, error)
+}
+
+// errReadOnly is returned when a client writes through an opened file; uploads
+// go through PUT instead
+var errReadOnly = errors.New("files are written with PUT")
+
+// FileSystem is a webdav.FileSystem over one 115 account. File contents are not
+// read through it: GET and PUT are served by the handler, which streams from the
+// CDN and uploads through OSS.
+type FileSystem struct {
+	backend     Backend
+	credentials models.Drive115Credentials
+}
+
+// NewFileSystem returns a file system for the account behind credentials
+func NewFileSystem(backend Backend, credentials models.Drive115Credentials) *FileSystem {
+	return &FileSystem{backend: backend, credentials: credentials}
+}
+
+var _ webdav.FileSystem = (*FileSystem)(nil)
+
+// Resolve returns the entry at name
+func (f *FileSystem) Resolve(ctx context.Context, name string) (services.PathEntry, error) {
+	entry, err := f.backend.ResolvePath(ctx, f.credentials, name)
+	return entry, fsError("stat", name, err)
+}
+
+// Mkdir creates a single directory; its parent must exist
+func (f *FileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	parent, base, err := f.parentDir(ctx, name)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Resolve(ctx, name); err == nil {
+		return os.ErrExist
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	_, err = f.backend.Mkdir(ctx, f.credentials, parent.ID, base)
+	return fsError("mkdir", name, err)
+}
+
+// OpenFile opens a file or directory for PROPFIND. Opening a missing name with
+// O_CREATE, as LOCK does for unmapped URLs, returns an empty placeholder that is
+// never stored; the following PUT creates the real file.
+func (f *FileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	entry, err := f.Resolve(ctx, name)
+	if errors.Is(err, os.ErrNotExist) && flag&os.O_CREATE != 0 {
+		if _, _, err := f.parentDir(ctx, name); err != nil {
+			return nil, err
+		}
+		return &file{fs: f, name: name, info: fileInfo{entry: services.PathEntry{Name: path.Base(name)}}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &file{ctx: ctx, fs: f, name: name, info: newFileInfo(name, entry)}, nil
+}
+
+// RemoveAll moves name to the recycle bin
+func (f *FileSystem) RemoveAll(ctx context.Context, name string) error {
+	entry, err := f.Resolve(ctx, name)
+	if err != nil {
+		return err
+	}
+	if entry.ID == "0" {
+		return os.ErrPermission
+	}
+	return fsError("remove", name, f.backend.DeleteFiles(ctx, f.credentials, []string{entry.ID}))
+}
+
+// Rename moves and renames with 115's move and rename, so no bytes are copied
+func (f *FileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	source, err := f.Resolve(ctx, oldName)
+	if err != nil {
+		return err
+	}
+	if source.ID == "0" {
+		return os.ErrPermission
+	}
+	oldParent, err := f.Resolve(ctx, path.Dir(path.Clean("/"+oldName)))
+	if err != nil {
+		return err
+	}
+	newParent, base, err := f.parentDir(ctx, newName)
+	if err != nil {
+		return err
+	}
+	if newParent.ID != oldParent.ID {
+		if err := f.backend.MoveFiles(ctx, f.credentials, []string{source.ID}, newParent.ID); err != nil {
+			return fsError("rename", oldName, err)
+		}
+	}
+	if base != source.Name {
+		item := models.RenameFileItem{FileID: source.ID, Name: base}
+		if err := f.backend.RenameFiles(ctx, f.credentials, []models.RenameFileItem{item}); err != nil {
+			return fsError("rename", oldName, err)
+		}
+	}
+	return nil
+}
+
+// Stat describes name
+func (f *FileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	entry, err := f.Resolve(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return newFileInfo(name, entry), nil
+}
+
+// parentDir resolves the directory that holds name and returns name's base
+func (f *FileSystem) parentDir(ctx context.Context, name string) (services.PathEntry, string, error) {
+	clean := path.Clean("/" + name)
+	if clean == "/" {
+		return services.PathEntry{}, "", os.ErrPermission
+	}
+	parent, err := f.Resolve(ctx, path.Dir(clean))
+	if err != nil {
+		return services.PathEntry{}, "", err
+	}
+	if !parent.IsDir {
+		return services.PathEntry{}, "", &fs.PathError{Op: "stat", Path: path.Dir(clean), Err: os.ErrNotExist}
+	}
+	return parent, path.Base(clean), nil
+}
+
+// fsError converts 115 errors to the os errors webdav.Handler maps to
+// statuses. It checks them with os.IsNotExist, which sees through
+// *fs.PathError but not other wrapping.
+func fsError(op, name string, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, driver.ErrNotExist):
+		return &fs.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	case errors.Is(err, driver.ErrExist):
+		return &fs.PathError{Op: op, Path: name, Err: os.ErrExist}
+	case errors.Is(err, driver.ErrCyclicMove), errors.Is(err, driver.ErrCyclicCopy):
+		return &fs.PathError{Op: op, Path: name, Err: os.ErrPermission}
+	}
+	return err
+}
+
+// file is an opened directory or file. Only its metadata and directory
+// listing are read through it.
+type file struct {
+	ctx  context.Context
+	fs   *FileSystem
+	name string
+	info fileInfo
+
+	children []os.FileInfo
+	listed   bool
+	offset   int
+}
+
+func (f *file) Close() error { return nil }
+
+func (f *file) Read([]byte) (int, error) {
+	if f.info.IsDir() {
+		return 0, &fs.PathError{Op: "read", Path: f.name, Err: errors.New("is a directory")}
+	}
+	// Never reached for GET, which the handler streams from the CDN
+	return 0, io.EOF
+}
+
+func (f *file) Seek(offset int64, whence int) (int64, error) {
+	if offset == 0 && (whence == io.SeekStart || whence == io.SeekEnd) {
+		if whence == io.SeekEnd {
+			return f.info.Size(), nil
+		}
+		return 0, nil
+	}
+	return 0, &fs.PathError{Op: "seek", Path: f.name, Err: errors.ErrUnsupported}
+}
+
+func (f *file) Write([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "write", Path: f.name, Err: errReadOnly}
+}
+
+func (f *file) Stat() (os.FileInfo, error) { return f.info, nil }
+
+// Readdir lists the directory once and pages through the result like os.File
+func (f *file) Readdir(count int) ([]os.FileInfo, error) {
+	if !f.info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: f.name, Err: errors.New("not a directory")}
+	}
+	if !f.listed {
+		ctx := f.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		entries, err := f.fs.backend.ListDir(ctx, f.fs.credentials, f.name)
+		if err != nil {
+			return nil, fsError("readdir", f.name, err)
+		}
+		f.children = make([]os.FileInfo, 0, len(entries))
+		for _, entry := range entries {
+			f.children = append(f.children, newFileInfo(path.Join(f.name, entry.Name), entry))
+		}
+		f.listed = true
+	}
+	remaining := f.children[f.offset:]
+	if count <= 0 {
+		f.offset = len(f.children)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(remaining) {
+		count = len(remaining)
+	}
+	f.offset += count
+	return remaining[:count], nil
+}
+
+// fileInfo describes a PathEntry. It supplies content types and ETags itself so
+// webdav.Handler never reads file bodies to sniff them.
+type fileInfo struct {
+	entry services.PathEntry
+}
+
+func newFileInfo(name string, entry services.PathEntry) fileInfo {
+	if entry.Name == "" {
+		entry.Name = path.Base(path.Clean("/" + name))
+	}
+	return fileInfo{entry: entry}
+}
+
+func (i fileInfo) Name() string       { return i.entry.Name }
+func (i fileInfo) Size() int64        { return i.entry.Size }
+func (i fileInfo) ModTime() time.Time { return i.entry.ModTime }
+func (i fileInfo) IsDir() bool        { return i.entry.IsDir }
+func (i fileInfo) Sys() interface{}   { return i.entry }
+
+func (i fileInfo) Mode() os.FileMode {
+	if i.entry.IsDir {
+		return fs.ModeDir | 0o755
+	}
+	return 0o644
+}
+
+// ContentType guesses from the extension, since sniffing would download the file
+func (i fileInfo) ContentType(ctx context.Context) (string, error) {
+	if contentType := mime.TypeByExtension(path.Ext(i.entry.Name)); contentType != "" {
+		return contentType, nil
+	}
+	return "application/octet-stream", nil
+}
+
+// ETag prefers the content SHA1 and falls back to the ID and modification time
+func (i fileInfo) ETag(ctx context.Context) (string, error) {
+	if i.entry.SHA1 != "" {
+		return `"` + i.entry.SHA1 + `"`, nil
+	}
+	return fmt.Sprintf(`"%s-%x-%x"`, i.entry.ID, i.entry.ModTime.UnixNano(), i.entry.Size), nil
+}
@@ -0,0 +1,325 @@
+package dav
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"cloud-driver/internal/models"
+	"cloud-driver/internal/recordstore"
+	"cloud-driver/internal/services"
+
+	"golang.org/x/net/webdav"
+)
+
+// Handler serves WebDAV below Prefix. GET, HEAD, PUT and COPY are handled here
+// so bytes stream from the CDN, uploads go through rapid upload or OSS, and
+// copies stay on 115; every other method goes to webdav.Handler.
+type Handler struct {
+	Prefix        string
+	Backend       Backend
+	SpoolDir      string
+	MaxUploadSize int64
+
+	mu    sync.Mutex
+	locks map[string]webdav.LockSystem
+}
+
+// Serve handles one request for the account behind credentials. Locks are
+// kept per account, so every login that reaches the same cookies, whether a
+// configured user or an account handle, sees the same locks.
+func (h *Handler) Serve(w http.ResponseWriter, r *http.Request, credentials models.Drive115Credentials) {
+	fsys := NewFileSystem(h.Backend, credentials)
+	locks := h.lockSystem(credentials.Key())
+	dav := &webdav.Handler{Prefix: h.Prefix, FileSystem: fsys, LockSystem: locks}
+
+	var status int
+	var err error
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		status, err = h.serveFile(w, r, fsys, dav)
+	case http.MethodPut:
+		status, err = h.put(r, fsys, locks)
+	case "COPY":
+		status, err = h.copy(r, fsys, locks)
+	default:
+		dav.ServeHTTP(w, r)
+		return
+	}
+	if status != 0 {
+		w.WriteHeader(status)
+		if status != http.StatusNoContent && r.Method != http.MethodHead {
+			w.Write([]byte(statusText(status, err)))
+		}
+	}
+}
+
+func (h *Handler) lockSystem(key string) webdav.LockSystem {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.locks == nil {
+		h.locks = make(map[string]webdav.LockSystem)
+	}
+	locks, ok := h.locks[key]
+	if !ok {
+		locks = webdav.NewMemLS()
+		h.locks[key] = locks
+	}
+	return locks
+}
+
+// serveFile relays a file from the 115 CDN, forwarding Range and If-Range.
+// Collections go to webdav.Handler, which rejects GET on them.
+func (h *Handler) serveFile(w http.ResponseWriter, r *http.Request, fsys *FileSystem, dav *webdav.Handler) (int, error) {
+	name, status, err := h.stripPrefix(r.URL.Path)
+	if err != nil {
+		return status, err
+	}
+	entry, err := fsys.Resolve(r.Context(), name)
+	if err != nil {
+		return errorStatus(err), err
+	}
+	if entry.IsDir {
+		dav.ServeHTTP(w, r)
+		return 0, nil
+	}
+
+	stream, err := h.Backend.OpenPickCodeStream(r.Context(), fsys.credentials, entry.PickCode, entry.Name, r.Method, r.Header)
+	if err != nil {
+		return errorStatus(err), err
+	}
+	defer stream.Response.Body.Close()
+
+	header := w.Header()
+	for _, key := range []string{"Content-Length", "Content-Range", "Accept-Ranges", "Last-Modified"} {
+		if value := stream.Response.Header.Get(key); value != "" {
+			header.Set(key, value)
+		}
+	}
+	info := newFileInfo(name, entry)
+	contentType, _ := info.ContentType(r.Context())
+	etag, _ := info.ETag(r.Context())
+	header.Set("Content-Type", contentType)
+	header.Set("ETag", etag)
+	w.WriteHeader(stream.Response.StatusCode)
+	if r.Method != http.MethodHead {
+		io.Copy(w, stream.Response.Body)
+	}
+	return 0, nil
+}
+
+// put spools the body to disk to hash it, uploads it next to any existing file
+// and then deletes the old one, so a failed upload leaves the old file intact
+func (h *Handler) put(r *http.Request, fsys *FileSystem, locks webdav.LockSystem) (int, error) {
+	name, status, err := h.stripPrefix(r.URL.Path)
+	if err != nil {
+		return status, err
+	}
+	release, status, err := h.confirmLocks(r, locks, name, "")
+	if err != nil {
+		return status, err
+	}
+	defer release()
+	ctx := r.Context()
+	parent, base, err := fsys.parentDir(ctx, name)
+	if err != nil {
+		return conflictStatus(err), err
+	}
+	existing, err := fsys.Resolve(ctx, name)
+	if err == nil && existing.IsDir {
+		return http.StatusMethodNotAllowed, nil
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return errorStatus(err), err
+	}
+
+	spooled, err := services.SpoolFile(r.Body, h.SpoolDir, h.MaxUploadSize)
+	if errors.Is(err, services.ErrUploadTooLarge) {
+		return http.StatusRequestEntityTooLarge, err
+	}
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	defer spooled.Close()
+
+	if _, err := h.Backend.UploadFile(ctx, fsys.credentials, parent.ID, base, spooled, spooled.Digest, nil); err != nil {
+		return http.StatusBadGateway, err
+	}
+	if existing.ID == "" {
+		return http.StatusCreated, nil
+	}
+	if err := h.Backend.DeleteFiles(ctx, fsys.credentials, []string{existing.ID}); err != nil {
+		return http.StatusBadGateway, err
+	}
+	return http.StatusNoContent, nil
+}
+
+// copy uses 115's server-side copy. 115 copies into a directory under the
+// source name, so a copy to a new name goes through copyAs. Like
+// webdav.Handler, only the destination must be unlocked.
+func (h *Handler) copy(r *http.Request, fsys *FileSystem, locks webdav.LockSystem) (int, error) {
+	src, dst, status, err := h.copyPaths(r)
+	if err != nil {
+		return status, err
+	}
+	release, status, err := h.confirmLocks(r, locks, "", dst)
+	if err != nil {
+		return status, err
+	}
+	defer release()
+	if depth := r.Header.Get("Depth"); depth != "" && depth != "infinity" {
+		// A shallow collection copy would need 115 to copy without children
+		return http.StatusNotImplemented, errors.New("only Depth: infinity copies are supported")
+	}
+	ctx := r.Context()
+	source, err := fsys.Resolve(ctx, src)
+	if err != nil {
+		return errorStatus(err), err
+	}
+	if source.ID == "0" {
+		return http.StatusForbidden, nil
+	}
+	parent, base, err := fsys.parentDir(ctx, dst)
+	if err != nil {
+		return conflictStatus(err), err
+	}
+
+	created := true
+	if _, err := fsys.Resolve(ctx, dst); err == nil {
+		if r.Header.Get("Overwrite") == "F" {
+			return http.StatusPreconditionFailed, nil
+		}
+		if err := fsys.RemoveAll(ctx, dst); err != nil {
+			return errorStatus(err), err
+		}
+		created = false
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return errorStatus(err), err
+	}
+
+	if base == source.Name {
+		if err := h.Backend.CopyFiles(ctx, fsys.credentials, []string{source.ID}, parent.ID); err != nil {
+			err = fsError("copy", src, err)
+			return errorStatus(err), err
+		}
+	} else if status, err := h.copyAs(ctx, fsys, source, parent.ID, path.Dir(path.Clean("/"+dst)), base); err != nil {
+		return status, err
+	}
+	if created {
+		return http.StatusCreated, nil
+	}
+	return http.StatusNoContent, nil
+}
+
+// copyAs copies source into the directory at dirPath under a new name. 115's
+// copy does not report the new ID, so the copy is made in a staging directory
+// that nothing else writes to, renamed there and then moved into place.
+func (h *Handler) copyAs(ctx context.Context, fsys *FileSystem, source services.PathEntry, parentID, dirPath, name string) (int, error) {
+	stagingName, err := recordstore.NewID(".cloud-driver-copy-")
+	if err != nil {
+		return http.StatusInternalServerError, err
+	}
+	stagingID, err := h.Backend.Mkdir(ctx, fsys.credentials, parentID, stagingName)
+	if err != nil {
+		return http.StatusBadGateway, err
+	}
+	// Empty once the copy has moved out; otherwise this drops a stray copy
+	defer h.Backend.DeleteFiles(context.WithoutCancel(ctx), fsys.credentials, []string{stagingID})
+
+	if err := h.Backend.CopyFiles(ctx, fsys.credentials, []string{source.ID}, stagingID); err != nil {
+		err = fsError("copy", source.Name, err)
+		return errorStatus(err), err
+	}
+	entries, err := h.Backend.ListDir(ctx, fsys.credentials, path.Join(dirPath, stagingName))
+	if err != nil {
+		return http.StatusBadGateway, err
+	}
+	if len(entries) != 1 {
+		return http.StatusBadGateway, errors.New("115 did not report the copied file")
+	}
+	copied := entries[0].ID
+	if err := h.Backend.RenameFiles(ctx, fsys.credentials, []models.RenameFileItem{{FileID: copied, Name: name}}); err != nil {
+		return http.StatusBadGateway, err
+	}
+	if err := h.Backend.MoveFiles(ctx, fsys.credentials, []string{copied}, parentID); err != nil {
+		return http.StatusBadGateway, err
+	}
+	return 0, nil
+}
+
+// copyPaths reads the source and Destination paths the way webdav.Handler does
+func (h *Handler) copyPaths(r *http.Request) (string, string, int, error) {
+	destination := r.Header.Get("Destination")
+	if destination == "" {
+		return "", "", http.StatusBadRequest, errors.New("missing Destination header")
+	}
+	u, err := url.Parse(destination)
+	if err != nil {
+		return "", "", http.StatusBadRequest, errors.New("invalid Destination header")
+	}
+	if u.Host != "" && u.Host != r.Host {
+		return "", "", http.StatusBadGateway, errors.New("Destination is on another server")
+	}
+	src, status, err := h.stripPrefix(r.URL.Path)
+	if err != nil {
+		return "", "", status, err
+	}
+	dst, status, err := h.stripPrefix(u.Path)
+	if err != nil {
+		return "", "", status, err
+	}
+	if path.Clean(dst) == path.Clean(src) {
+		return "", "", http.StatusForbidden, errors.New("Destination equals source")
+	}
+	return src, dst, 0, nil
+}
+
+func (h *Handler) stripPrefix(p string) (string, int, error) {
+	if h.Prefix == "" {
+		return p, 0, nil
+	}
+	if r := strings.TrimPrefix(p, h.Prefix); len(r) < len(p) {
+		if r == "" {
+			r = "/"
+		}
+		return r, 0, nil
+	}
+	return p, http.StatusNotFound, os.ErrNotExist
+}
+
+// errorStatus maps a file system error to a response status
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return http.StatusNotFound
+	case errors.Is(err, os.ErrExist):
+		return http.StatusMethodNotAllowed
+	case errors.Is(err, os.ErrPermission):
+		return http.StatusForbidden
+	case errors.Is(err, context.Canceled):
+		return 499
+	}
+	return http.StatusBadGateway
+}
+
+// conflictStatus maps a missing parent to 409, as RFC 4918 asks of PUT, MKCOL and COPY
+func conflictStatus(err error) int {
+	if errors.Is(err, os.ErrNotExist) {
+		return http.StatusConflict
+	}
+	return errorStatus(err)
+}
+
+func statusText(status int, err error) string {
+	text := http.StatusText(status)
+	if err != nil && status >= 400 && status < 500 {
+		text += ": " + err.Error()
+	}
+	return text
+}
@@ -0,0 +1,140 @@
+package dav
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// confirmLocks checks src and dst against the lock system before PUT or COPY
+// writes, the way webdav.Handler does for the methods it serves. Without an
+// If header it takes short-lived locks, so a resource locked by another
+// client is refused with 423 Locked. The returned func releases the locks.
+func (h *Handler) confirmLocks(r *http.Request, locks webdav.LockSystem, src, dst string) (func(), int, error) {
+	header := r.Header.Get("If")
+	if header == "" {
+		now := time.Now()
+		var tokens []string
+		release := func() {
+			for _, token := range tokens {
+				locks.Unlock(now, token)
+			}
+		}
+		for _, name := range []string{src, dst} {
+			if name == "" {
+				continue
+			}
+			token, err := locks.Create(now, webdav.LockDetails{Root: name, Duration: -1, ZeroDepth: true})
+			if err != nil {
+				release()
+				if errors.Is(err, webdav.ErrLocked) {
+					return nil, webdav.StatusLocked, err
+				}
+				return nil, http.StatusInternalServerError, err
+			}
+			tokens = append(tokens, token)
+		}
+		return release, 0, nil
+	}
+
+	lists, ok := parseIfHeader(header)
+	if !ok {
+		return nil, http.StatusBadRequest, errors.New("invalid If header")
+	}
+	// The lists are alternatives, so any one that confirms will do
+	for _, list := range lists {
+		name := src
+		if list.resourceTag != "" {
+			u, err := url.Parse(list.resourceTag)
+			if err != nil || u.Host != r.Host {
+				continue
+			}
+			var status int
+			if name, status, err = h.stripPrefix(u.Path); err != nil {
+				return nil, status, err
+			}
+		}
+		release, err := locks.Confirm(time.Now(), name, dst, list.conditions...)
+		if errors.Is(err, webdav.ErrConfirmationFailed) {
+			continue
+		}
+		if err != nil {
+			return nil, http.StatusInternalServerError, err
+		}
+		return release, 0, nil
+	}
+	// RFC 4918 section 10.4.1: when every list fails the request fails with 412
+	return nil, http.StatusPreconditionFailed, webdav.ErrLocked
+}
+
+// ifList is one parenthesized list of an If header and its optional resource tag
+type ifList struct {
+	resourceTag string
+	conditions  []webdav.Condition
+}
+
+// parseIfHeader parses the If header of RFC 4918 section 10.4, such as
+// `</dav/a.mkv> (<opaquelocktoken:...> ["etag"])`
+func parseIfHeader(header string) ([]ifList, bool) {
+	var lists []ifList
+	tag := ""
+	s := strings.TrimSpace(header)
+	for s != "" {
+		switch s[0] {
+		case '<':
+			end := strings.IndexByte(s, '>')
+			if end < 0 {
+				return nil, false
+			}
+			tag, s = s[1:end], s[end+1:]
+		case '(':
+			list := ifList{resourceTag: tag}
+			s = s[1:]
+			for {
+				s = strings.TrimLeft(s, " \t")
+				if s == "" {
+					return nil, false
+				}
+				if s[0] == ')' {
+					s = s[1:]
+					break
+				}
+				var condition webdav.Condition
+				if rest, ok := strings.CutPrefix(s, "Not"); ok {
+					condition.Not = true
+					s = strings.TrimLeft(rest, " \t")
+				}
+				if s == "" || (s[0] != '<' && s[0] != '[') {
+					return nil, false
+				}
+				closing := byte('>')
+				if s[0] == '[' {
+					closing = ']'
+				}
+				end := strings.IndexByte(s, closing)
+				if end < 0 {
+					return nil, false
+				}
+				if closing == ']' {
+					condition.ETag = s[1:end]
+				} else {
+					condition.Token = s[1:end]
+				}
+				list.conditions = append(list.conditions, condition)
+				s = s[end+1:]
+			}
+			if len(list.conditions) == 0 {
+				return nil, false
+			}
+			lists = append(lists, list)
+		default:
+			return nil, false
+		}
+		s = strings.TrimLeft(s, " \t")
+	}
+	return lists, len(lists) > 0
+}
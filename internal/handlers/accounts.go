@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"cloud-driver/internal/accounts"
+	"cloud-driver/internal/middleware"
+	"cloud-driver/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// DeleteAccount forgets a stored account handle
+func (h *Drive115Handler) DeleteAccount(c echo.Context) error {
+	var req models.DeleteAccountRequest
+	if err := middleware.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+	if h.accounts == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Account store is not configured")
+	}
+
+	err := h.accounts.Delete(c.Request().Context(), req.Account)
+	if errors.Is(err, accounts.ErrNotFound) {
+		return middleware.NewError(http.StatusNotFound, "unknown_account", "Unknown account handle")
+	}
+	if err != nil {
+		return serviceError("Failed to delete account", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "Account deleted successfully",
+	})
+}
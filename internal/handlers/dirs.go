@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"net/http"
+
+	"cloud-driver/internal/middleware"
+	"cloud-driver/internal/models"
+	"cloud-driver/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Mkdir creates a single directory under parent_id (root by default)
+func (h *Drive115Handler) Mkdir(c echo.Context) error {
+	var req models.MkdirRequest
+	if err := middleware.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+	if name, err := validUploadFileName(req.Name); err != nil || name != req.Name {
+		return echo.NewHTTPError(http.StatusBadRequest, "name must be a valid file name without path separators")
+	}
+	parentID := req.ParentID
+	if parentID == "" {
+		parentID = "0"
+	}
+
+	id, err := h.service.Mkdir(c.Request().Context(), req.Credentials, parentID, req.Name)
+	if err != nil {
+		return serviceError("Failed to create directory", err)
+	}
+
+	return c.JSON(http.StatusCreated, models.DirResponse{
+		ID:       id,
+		Name:     req.Name,
+		ParentID: parentID,
+	})
+}
+
+// ResolveDir returns the ID of the directory at a path
+func (h *Drive115Handler) ResolveDir(c echo.Context) error {
+	var req models.DirPathRequest
+	if err := middleware.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+	parts, err := services.SplitDirPath(req.Path)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	id, err := h.service.ResolveDir(c.Request().Context(), req.Credentials, req.Path)
+	if err != nil {
+		return serviceError("Failed to resolve directory", err)
+	}
+
+	return c.JSON(http.StatusOK, models.DirResponse{ID: id, Path: services.JoinDirPath(parts)})
+}
+
+// EnsureDir resolves a directory path, creating missing directories on the way
+func (h *Drive115Handler) EnsureDir(c echo.Context) error {
+	var req models.DirPathRequest
+	if err := middleware.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+	parts, err := services.SplitDirPath(req.Path)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	id, created, err := h.service.EnsureDir(c.Request().Context(), req.Credentials, req.Path)
+	if err != nil {
+		return serviceError("Failed to create directory path", err)
+	}
+
+	return c.JSON(http.StatusOK, models.DirResponse{ID: id, Path: services.JoinDirPath(parts), Created: created})
+}
@@ -6,9 +6,14 @@ import (
 	"net/http"
 	"strconv"
 
+	"cloud-driver/internal/accounts"
+	"cloud-driver/internal/jobs"
 	"cloud-driver/internal/middleware"
 	"cloud-driver/internal/models"
+	"cloud-driver/internal/offline"
+	"cloud-driver/internal/rules"
 	"cloud-driver/internal/services"
+	"cloud-driver/internal/webhooks"
 
 	"github.com/labstack/echo/v4"
 )
@@ -18,6 +23,13 @@ type Drive115Handler struct {
 	service     *services.Drive115Service
 	uploads     uploadService
 	uploadCodec *uploadSessionCodec
+	streamLinks *streamLinkCodec
+	accounts    accounts.Store
+	sources     *services.SourceUploader
+	jobs        *jobs.Manager
+	watcher     *offline.Watcher
+	webhooks    *webhooks.Manager
+	rules       *rules.Manager
 }
 
 type uploadService interface {
@@ -28,13 +40,28 @@ type uploadService interface {
 	AbortUpload(context.Context, services.UploadSession) error
 }
 
-// NewDrive115Handler creates a new 115drive handler
-func NewDrive115Handler(service *services.Drive115Service, uploadSessionSecret string) (*Drive115Handler, error) {
+// NewDrive115Handler creates a new 115drive handler. accountStore may be nil
+// when server-side account handles are disabled. Background jobs run on
+// jobManager, which must have the services job types registered. Task event
+// streams subscribe to watcher, webhookManager owns offline task webhooks and
+// ruleManager the rules that organize finished downloads.
+func NewDrive115Handler(service *services.Drive115Service, uploadSessionSecret string, accountStore accounts.Store, sources *services.SourceUploader, jobManager *jobs.Manager, watcher *offline.Watcher, webhookManager *webhooks.Manager, ruleManager *rules.Manager) (*Drive115Handler, error) {
 	codec, err := newUploadSessionCodec(uploadSessionSecret)
 	if err != nil {
 		return nil, err
 	}
-	return &Drive115Handler{service: service, uploads: service, uploadCodec: codec}, nil
+	return &Drive115Handler{
+		service:     service,
+		uploads:     service,
+		uploadCodec: codec,
+		streamLinks: newStreamLinkCodec(codec.box),
+		accounts:    accountStore,
+		sources:     sources,
+		jobs:        jobManager,
+		watcher:     watcher,
+		webhooks:    webhookManager,
+		rules:       ruleManager,
+	}, nil
 }
 
 // GetUser returns the current user information
@@ -46,7 +73,7 @@ func (h *Drive115Handler) GetUser(c echo.Context) error {
 
 	userInfo, err := h.service.GetUser(c.Request().Context(), req.Credentials)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get user info: "+err.Error())
+		return serviceError("Failed to get user info", err)
 	}
 
 	return c.JSON(http.StatusOK, userInfo)
@@ -74,31 +101,92 @@ func (h *Drive115Handler) ListOfflineTasks(c echo.Context) error {
 
 	tasks, err := h.service.ListOfflineTasks(c.Request().Context(), req.Credentials, req.Page)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to list offline tasks: "+err.Error())
+		return serviceError("Failed to list offline tasks", err)
 	}
 
 	return c.JSON(http.StatusOK, tasks)
 }
 
-// AddOfflineTask adds new offline download tasks
+// QueryOfflineTasks returns every offline task across all pages, filtered and
+// sorted on the server
+func (h *Drive115Handler) QueryOfflineTasks(c echo.Context) error {
+	var req models.TaskQueryRequest
+	if err := middleware.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	saveDirID, err := h.resolveDirPath(ctx, req.Credentials, req.SaveDirPath, req.SaveDirID)
+	if err != nil {
+		return err
+	}
+	req.SaveDirID = saveDirID
+
+	result, err := h.service.QueryOfflineTasks(ctx, req)
+	if err != nil {
+		return serviceError("Failed to list offline tasks", err)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// AddOfflineTask adds new offline download tasks, skipping URIs that are
+// already tasks
 func (h *Drive115Handler) AddOfflineTask(c echo.Context) error {
 	var req models.OfflineDownloadRequest
 	if err := middleware.ValidateRequest(c, &req); err != nil {
 		return err
 	}
 
-	hashes, err := h.service.AddOfflineTaskURIs(c.Request().Context(), req.Credentials, req.URLs, req.SaveDirID)
+	ctx := c.Request().Context()
+	saveDirID, err := h.resolveDirPath(ctx, req.Credentials, req.SaveDirPath, req.SaveDirID)
+	if err != nil {
+		return err
+	}
+
+	results, err := h.service.AddOfflineTasks(ctx, req.Credentials, req.URLs, saveDirID, req.SkipExistingFiles)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to add offline task: "+err.Error())
+		return serviceError("Failed to add offline task", err)
 	}
 
+	hashes := make([]string, 0, len(results))
+	skipped := 0
+	for _, result := range results {
+		switch result.Status {
+		case services.OfflineURIAdded:
+			hashes = append(hashes, result.Hash)
+		case services.OfflineURISkipped:
+			skipped++
+		}
+	}
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"message": "Offline download tasks added successfully",
 		"hashes":  hashes,
 		"count":   len(hashes),
+		"skipped": skipped,
+		"results": results,
 	})
 }
 
+// AddOfflineTasksBulk starts an offline_add job for any number of URIs and
+// returns the job for polling
+func (h *Drive115Handler) AddOfflineTasksBulk(c echo.Context) error {
+	var req models.OfflineBulkRequest
+	if err := middleware.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	params, err := h.offlineAddJobParams(ctx, req.Credentials, req.OfflineAddJobParams)
+	if err != nil {
+		return err
+	}
+	job, err := h.jobs.Submit(ctx, req.Credentials, services.JobOfflineAdd, params)
+	if err != nil {
+		return jobError("Failed to start offline add", err)
+	}
+	return c.JSON(http.StatusAccepted, job)
+}
+
 // DeleteOfflineTasks deletes offline tasks
 func (h *Drive115Handler) DeleteOfflineTasks(c echo.Context) error {
 	var req models.DeleteTasksRequest
@@ -108,7 +196,7 @@ func (h *Drive115Handler) DeleteOfflineTasks(c echo.Context) error {
 
 	err := h.service.DeleteOfflineTasks(c.Request().Context(), req.Credentials, req.Hashes, req.DeleteFiles)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete offline tasks: "+err.Error())
+		return serviceError("Failed to delete offline tasks", err)
 	}
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
@@ -127,7 +215,7 @@ func (h *Drive115Handler) ClearOfflineTasks(c echo.Context) error {
 
 	err := h.service.ClearOfflineTasks(c.Request().Context(), req.Credentials, req.ClearFlag)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to clear offline tasks: "+err.Error())
+		return serviceError("Failed to clear offline tasks", err)
 	}
 
 	return c.JSON(http.StatusOK, map[string]string{
@@ -154,6 +242,19 @@ func (h *Drive115Handler) ListFiles(c echo.Context) error {
 		}
 	}
 
+	if req.Path == "" && req.DirID == 0 {
+		req.Path = c.QueryParam("path")
+	}
+	if req.Path != "" {
+		dirID, err := h.resolveDirPath(c.Request().Context(), req.Credentials, req.Path, "")
+		if err != nil {
+			return err
+		}
+		if req.DirID, err = strconv.ParseInt(dirID, 10, 64); err != nil {
+			return echo.NewHTTPError(http.StatusBadGateway, "115 returned an invalid directory ID")
+		}
+	}
+
 	if req.Offset == 0 {
 		offsetStr := c.QueryParam("offset")
 		if offsetStr != "" {
@@ -188,7 +289,7 @@ func (h *Drive115Handler) ListFiles(c echo.Context) error {
 
 	files, err := h.service.ListFiles(c.Request().Context(), req.Credentials, req.DirID, req.Offset, req.Limit)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to list files: "+err.Error())
+		return serviceError("Failed to list files", err)
 	}
 
 	return c.JSON(http.StatusOK, files)
@@ -236,7 +337,7 @@ func (h *Drive115Handler) CheckFolderVideos(c echo.Context) error {
 
 	result, err := h.service.CheckFolderVideos(c.Request().Context(), req.Credentials, req.DirID, req.Limit, req.IndexedName)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check folder videos: "+err.Error())
+		return serviceError("Failed to check folder videos", err)
 	}
 
 	return c.JSON(http.StatusOK, result)
@@ -261,7 +362,7 @@ func (h *Drive115Handler) GetFileInfo(c echo.Context) error {
 
 	fileInfo, err := h.service.GetFileInfo(c.Request().Context(), req.Credentials, req.FileID)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get file info: "+err.Error())
+		return serviceError("Failed to get file info", err)
 	}
 
 	return c.JSON(http.StatusOK, fileInfo)
@@ -284,9 +385,14 @@ func (h *Drive115Handler) DownloadFile(c echo.Context) error {
 		return err
 	}
 
-	downloadInfo, err := h.service.GetDownloadInfo(c.Request().Context(), req.Credentials, req.FileID)
+	// 115 CDN links only work for the User-Agent that requested them
+	if req.UserAgent == "" {
+		req.UserAgent = c.Request().UserAgent()
+	}
+
+	downloadInfo, err := h.service.GetDownloadInfo(c.Request().Context(), req.Credentials, req.FileID, req.UserAgent)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get download info: "+err.Error())
+		return serviceError("Failed to get download info", err)
 	}
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
@@ -304,7 +410,7 @@ func (h *Drive115Handler) QRCodeStart(c echo.Context) error {
 
 	response, err := h.service.QRCodeStart(c.Request().Context())
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to start QR code session: "+err.Error())
+		return serviceError("Failed to start QR code session", err)
 	}
 
 	return c.JSON(http.StatusOK, response)
@@ -319,7 +425,7 @@ func (h *Drive115Handler) QRCodeImage(c echo.Context) error {
 
 	imageData, err := h.service.QRCodeGetImage(c.Request().Context(), req.UID)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to generate QR code image: "+err.Error())
+		return serviceError("Failed to generate QR code image", err)
 	}
 
 	// Set proper headers for PNG image
@@ -339,7 +445,7 @@ func (h *Drive115Handler) QRCodeStatus(c echo.Context) error {
 
 	response, err := h.service.QRCodeCheckStatus(c.Request().Context(), req.UID, req.Sign, req.Time)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check QR code status: "+err.Error())
+		return serviceError("Failed to check QR code status", err)
 	}
 
 	return c.JSON(http.StatusOK, response)
@@ -352,9 +458,23 @@ func (h *Drive115Handler) QRCodeLogin(c echo.Context) error {
 		return err
 	}
 
+	if req.Store && h.accounts == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Account store is not configured")
+	}
+
 	response, err := h.service.QRCodeLogin(c.Request().Context(), req.UID, req.Sign, req.Time, req.App)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to complete QR code login: "+err.Error())
+		return serviceError("Failed to complete QR code login", err)
+	}
+
+	// Keep the cookies server-side and hand the client an opaque handle instead
+	if req.Store && response.Success {
+		account, err := h.accounts.Create(c.Request().Context(), *response.Credentials)
+		if err != nil {
+			return serviceError("Failed to store account", err)
+		}
+		response.Account = account.Handle
+		response.Credentials = nil
 	}
 
 	// Return appropriate status code based on success
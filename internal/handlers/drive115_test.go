@@ -43,7 +43,7 @@ func TestUploadProtocolIntegration(t *testing.T) {
 		t.Skip("set CLOUD_DRIVER_INTEGRATION=1 to run integration tests")
 	}
 
-	handler, err := NewDrive115Handler(services.NewDrive115Service(), "test-upload-session-secret-at-least-32-characters")
+	handler, err := NewDrive115Handler(services.NewDrive115Service(), "test-upload-session-secret-at-least-32-characters", nil, nil, nil, nil, nil, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
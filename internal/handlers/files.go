@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"net/http"
+
+	"cloud-driver/internal/middleware"
+	"cloud-driver/internal/models"
+	"github.com/labstack/echo/v4"
+)
+
+// MoveFiles moves files into a target directory
+func (h *Drive115Handler) MoveFiles(c echo.Context) error {
+	var req models.MoveFilesRequest
+	if err := middleware.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	fileIDs, err := h.resolveFilePaths(ctx, req.Credentials, req.Paths, req.FileIDs)
+	if err != nil {
+		return err
+	}
+	targetDirID, err := h.resolveDirPath(ctx, req.Credentials, req.TargetDirPath, req.TargetDirID)
+	if err != nil {
+		return err
+	}
+
+	if err := h.service.MoveFiles(ctx, req.Credentials, fileIDs, targetDirID); err != nil {
+		return serviceError("Failed to move files", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":       "Files moved successfully",
+		"count":         len(fileIDs),
+		"target_dir_id": targetDirID,
+	})
+}
+
+// CopyFiles copies files into a target directory
+func (h *Drive115Handler) CopyFiles(c echo.Context) error {
+	var req models.CopyFilesRequest
+	if err := middleware.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	fileIDs, err := h.resolveFilePaths(ctx, req.Credentials, req.Paths, req.FileIDs)
+	if err != nil {
+		return err
+	}
+	targetDirID, err := h.resolveDirPath(ctx, req.Credentials, req.TargetDirPath, req.TargetDirID)
+	if err != nil {
+		return err
+	}
+
+	if err := h.service.CopyFiles(ctx, req.Credentials, fileIDs, targetDirID); err != nil {
+		return serviceError("Failed to copy files", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":       "Files copied successfully",
+		"count":         len(fileIDs),
+		"target_dir_id": targetDirID,
+	})
+}
+
+// RenameFiles renames a batch of files
+func (h *Drive115Handler) RenameFiles(c echo.Context) error {
+	var req models.RenameFilesRequest
+	if err := middleware.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+	for _, file := range req.Files {
+		if name, err := validUploadFileName(file.Name); err != nil || name != file.Name {
+			return echo.NewHTTPError(http.StatusBadRequest, "name must be a valid file name without path separators")
+		}
+	}
+	ctx := c.Request().Context()
+	for index, file := range req.Files {
+		if file.Path == "" {
+			continue
+		}
+		ids, err := h.resolveFilePaths(ctx, req.Credentials, []string{file.Path}, nil)
+		if err != nil {
+			return err
+		}
+		req.Files[index].FileID = ids[0]
+	}
+
+	if err := h.service.RenameFiles(ctx, req.Credentials, req.Files); err != nil {
+		return serviceError("Failed to rename files", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "Files renamed successfully",
+		"count":   len(req.Files),
+	})
+}
+
+// DeleteFiles moves files to the recycle bin
+func (h *Drive115Handler) DeleteFiles(c echo.Context) error {
+	var req models.DeleteFilesRequest
+	if err := middleware.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+	ctx := c.Request().Context()
+	fileIDs, err := h.resolveFilePaths(ctx, req.Credentials, req.Paths, req.FileIDs)
+	if err != nil {
+		return err
+	}
+
+	if err := h.service.DeleteFiles(ctx, req.Credentials, fileIDs); err != nil {
+		return serviceError("Failed to delete files", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "Files deleted successfully",
+		"count":   len(fileIDs),
+	})
+}
+
+// serviceError wraps a service failure as a 500 that keeps the original error,
+// so the central error handler can map known 115 errors to a better status
+func serviceError(message string, err error) error {
+	return echo.NewHTTPError(http.StatusInternalServerError, message+": "+err.Error()).SetInternal(err)
+}
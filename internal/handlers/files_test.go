@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"cloud-driver/internal/middleware"
+
+	"github.com/SheltonZhu/115driver/pkg/driver"
+	"github.com/labstack/echo/v4"
+)
+
+func TestServiceErrorStatus(t *testing.T) {
+	cases := map[string]struct {
+		err  error
+		want int
+	}{
+		"cyclic move":  {err: driver.GetErr(800006, `{"errno":800006}`), want: http.StatusBadRequest},
+		"cyclic copy":  {err: driver.GetErr(91002), want: http.StatusBadRequest},
+		"exists":       {err: driver.GetErr(20004, `{"errno":20004}`), want: http.StatusConflict},
+		"not exists":   {err: driver.ErrNotExist, want: http.StatusNotFound},
+		"pick code":    {err: driver.GetErr(50003), want: http.StatusNotFound},
+		"directory":    {err: driver.ErrDownloadDirectory, want: http.StatusBadRequest},
+		"unexpected":   {err: errors.New("boom"), want: http.StatusInternalServerError},
+		"wrapped move": {err: errors.Join(errors.New("rename 1"), driver.ErrCyclicMove), want: http.StatusBadRequest},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			e := echo.New()
+			rec := httptest.NewRecorder()
+			middleware.HTTPErrorHandler(serviceError("Failed", tc.err), e.NewContext(httptest.NewRequest(http.MethodPost, "/", nil), rec))
+			if rec.Code != tc.want {
+				t.Fatalf("got %d, want status %d", rec.Code, tc.want)
+			}
+		})
+	}
+}
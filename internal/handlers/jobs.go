@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"cloud-driver/internal/jobs"
+	"cloud-driver/internal/middleware"
+	"cloud-driver/internal/models"
+	"cloud-driver/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// jobScopes is the scope a caller needs to create or cancel each job type,
+// matching the synchronous route the job stands in for
+var jobScopes = map[string]middleware.Scope{
+	services.JobWalk:         middleware.ScopeRead,
+	services.JobVideoCheck:   middleware.ScopeRead,
+	services.JobMove:         middleware.ScopeWrite,
+	services.JobCopy:         middleware.ScopeWrite,
+	services.JobDelete:       middleware.ScopeWrite,
+	services.JobSourceUpload: middleware.ScopeUpload,
+	services.JobOfflineAdd:   middleware.ScopeOffline,
+}
+
+// CreateJob queues a background job and returns it for polling
+func (h *Drive115Handler) CreateJob(c echo.Context) error {
+	var req models.JobCreateRequest
+	if err := middleware.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+	if err := middleware.RequireScope(c, jobScopes[req.Type]); err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	var params interface{}
+	var err error
+	switch req.Type {
+	case services.JobWalk:
+		params, err = h.walkJobParams(c, req)
+	case services.JobMove, services.JobCopy, services.JobDelete:
+		params, err = h.fileBatchJobParams(c, req)
+	case services.JobVideoCheck:
+		var input models.VideoCheckJobParams
+		if err = decodeJobParams(c, req.Params, &input); err == nil {
+			params = services.VideoCheckJob{DirID: input.DirID, Limit: input.Limit, IndexedName: input.IndexedName}
+		}
+	case services.JobOfflineAdd:
+		var input models.OfflineAddJobParams
+		if err = decodeJobParams(c, req.Params, &input); err == nil {
+			params, err = h.offlineAddJobParams(ctx, req.Credentials, input)
+		}
+	case services.JobSourceUpload:
+		var input models.SourceUploadJobParams
+		if err = decodeJobParams(c, req.Params, &input); err == nil {
+			params, err = h.sourceUploadParams(ctx, req.Credentials, input)
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	job, err := h.jobs.Submit(ctx, req.Credentials, req.Type, params)
+	if err != nil {
+		return jobError("Failed to create job", err)
+	}
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// ListJobs returns the caller's jobs, newest first
+func (h *Drive115Handler) ListJobs(c echo.Context) error {
+	var req models.JobListRequest
+	if err := middleware.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+	if req.Limit == 0 {
+		req.Limit = 20
+	}
+	list, total := h.jobs.List(req.Credentials, jobs.ListFilter{
+		Type: req.Type, Status: jobs.Status(req.Status), Offset: req.Offset, Limit: req.Limit,
+	})
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"jobs": list, "total": total, "offset": req.Offset, "limit": req.Limit,
+	})
+}
+
+// GetJob returns one of the caller's jobs
+func (h *Drive115Handler) GetJob(c echo.Context) error {
+	var req models.JobRequest
+	if err := middleware.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+	job, err := h.jobs.Get(req.Credentials, c.Param("id"))
+	if err != nil {
+		return jobError("Failed to get job", err)
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
+// CancelJob cancels a queued or running job
+func (h *Drive115Handler) CancelJob(c echo.Context) error {
+	var req models.JobRequest
+	if err := middleware.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+	job, err := h.jobs.Get(req.Credentials, c.Param("id"))
+	if err != nil {
+		return jobError("Failed to cancel job", err)
+	}
+	if err := middleware.RequireScope(c, jobScopes[job.Type]); err != nil {
+		return err
+	}
+	if job, err = h.jobs.Cancel(req.Credentials, job.ID); err != nil {
+		return jobError("Failed to cancel job", err)
+	}
+	return c.JSON(http.StatusAccepted, job)
+}
+
+func (h *Drive115Handler) walkJobParams(c echo.Context, req models.JobCreateRequest) (interface{}, error) {
+	var input models.WalkJobParams
+	if err := decodeJobParams(c, req.Params, &input); err != nil {
+		return nil, err
+	}
+	if input.DirPath != "" {
+		dirID, err := h.resolveDirPath(c.Request().Context(), req.Credentials, input.DirPath, "")
+		if err != nil {
+			return nil, err
+		}
+		if input.DirID, err = strconv.ParseInt(dirID, 10, 64); err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadGateway, "115 returned an invalid directory ID")
+		}
+	}
+	return services.WalkJob{DirID: input.DirID, MaxDepth: input.MaxDepth, Concurrency: input.Concurrency, FilesOnly: input.FilesOnly}, nil
+}
+
+// fileBatchJobParams resolves paths when the job is created, so a retried job
+// acts on the same files even after earlier batches moved them
+func (h *Drive115Handler) fileBatchJobParams(c echo.Context, req models.JobCreateRequest) (interface{}, error) {
+	var input models.FileBatchJobParams
+	if err := decodeJobParams(c, req.Params, &input); err != nil {
+		return nil, err
+	}
+	ctx := c.Request().Context()
+	fileIDs, err := h.resolveFilePaths(ctx, req.Credentials, input.Paths, input.FileIDs)
+	if err != nil {
+		return nil, err
+	}
+	if req.Type == services.JobDelete {
+		if input.TargetDirID != "" || input.TargetDirPath != "" {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "delete jobs take no target directory")
+		}
+		return services.FileBatchJob{FileIDs: fileIDs}, nil
+	}
+	if input.TargetDirID == "" && input.TargetDirPath == "" {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "target_dir_id or target_dir_path is required")
+	}
+	targetDirID, err := h.resolveDirPath(ctx, req.Credentials, input.TargetDirPath, input.TargetDirID)
+	if err != nil {
+		return nil, err
+	}
+	return services.FileBatchJob{FileIDs: fileIDs, TargetDirID: targetDirID}, nil
+}
+
+// maxOfflineAddJobURLs bounds the URIs of one bulk offline add
+const maxOfflineAddJobURLs = 10000
+
+// offlineAddJobParams joins the URIs given as a list and as text, one per
+// line, and resolves the save directory
+func (h *Drive115Handler) offlineAddJobParams(ctx context.Context, credentials models.Drive115Credentials, input models.OfflineAddJobParams) (interface{}, error) {
+	urls := make([]string, 0, len(input.URLs))
+	for _, line := range append(input.URLs, strings.Split(input.Text, "\n")...) {
+		if line = strings.TrimSpace(line); line != "" {
+			urls = append(urls, line)
+		}
+	}
+	if len(urls) == 0 {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "urls or text must list at least one URI")
+	}
+	if len(urls) > maxOfflineAddJobURLs {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "at most 10000 URIs can be added at once")
+	}
+	saveDirID, err := h.resolveDirPath(ctx, credentials, input.SaveDirPath, input.SaveDirID)
+	if err != nil {
+		return nil, err
+	}
+	return services.OfflineAddJob{URLs: urls, SaveDirID: saveDirID, SkipExistingFiles: input.SkipExistingFiles}, nil
+}
+
+// sourceUploadParams checks the source and resolves the target directory
+func (h *Drive115Handler) sourceUploadParams(ctx context.Context, credentials models.Drive115Credentials, input models.SourceUploadJobParams) (interface{}, error) {
+	if (input.URL == "") == (input.Path == "") {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "exactly one of url and path is required")
+	}
+	if input.FileName != "" {
+		fileName, err := validUploadFileName(input.FileName)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		input.FileName = fileName
+	}
+	if input.DirID == "" {
+		input.DirID = "0"
+	}
+	dirID, err := h.resolveDirPath(ctx, credentials, input.DirPath, input.DirID)
+	if err != nil {
+		return nil, err
+	}
+	params, err := h.sources.Prepare(services.SourceUploadRequest{URL: input.URL, Path: input.Path, DirID: dirID, FileName: input.FileName})
+	if errors.Is(err, services.ErrSourceNotAllowed) {
+		return nil, middleware.NewError(http.StatusForbidden, "source_not_allowed", err.Error())
+	}
+	if err != nil {
+		return nil, serviceError("Failed to start upload", err)
+	}
+	return params, nil
+}
+
+// decodeJobParams decodes and validates the params of a job request
+func decodeJobParams(c echo.Context, raw json.RawMessage, params interface{}) error {
+	if len(raw) == 0 {
+		raw = json.RawMessage("{}")
+	}
+	if err := json.Unmarshal(raw, params); err != nil {
+		return middleware.NewError(http.StatusBadRequest, "invalid_request", "Invalid job params: "+err.Error())
+	}
+	return middleware.ValidateStruct(c, params)
+}
+
+func jobError(message string, err error) error {
+	switch {
+	case errors.Is(err, jobs.ErrNotFound):
+		return middleware.NewError(http.StatusNotFound, "not_found", err.Error())
+	case errors.Is(err, jobs.ErrFinished):
+		return middleware.NewError(http.StatusConflict, "job_finished", err.Error())
+	case errors.Is(err, jobs.ErrStopped):
+		return middleware.NewError(http.StatusServiceUnavailable, "shutting_down", err.Error())
+	default:
+		return serviceError(message, err)
+	}
+}
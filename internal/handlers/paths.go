@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+
+	"cloud-driver/internal/models"
+	"cloud-driver/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// resolveDirPath returns the ID of the directory at dirPath, or dirID when no
+// path was given
+func (h *Drive115Handler) resolveDirPath(ctx context.Context, credentials models.Drive115Credentials, dirPath, dirID string) (string, error) {
+	if dirPath == "" {
+		return dirID, nil
+	}
+	if _, err := services.SplitDirPath(dirPath); err != nil {
+		return "", echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	id, err := h.service.ResolveDir(ctx, credentials, dirPath)
+	if err != nil {
+		return "", serviceError("Failed to resolve path", err)
+	}
+	return id, nil
+}
+
+// resolveFilePaths returns the IDs of the files at paths, or fileIDs when no
+// paths were given
+func (h *Drive115Handler) resolveFilePaths(ctx context.Context, credentials models.Drive115Credentials, paths, fileIDs []string) ([]string, error) {
+	if len(paths) == 0 {
+		return fileIDs, nil
+	}
+	for _, filePath := range paths {
+		if _, err := services.SplitDirPath(filePath); err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+	}
+	ids, err := h.service.ResolvePaths(ctx, credentials, paths)
+	if err != nil {
+		return nil, serviceError("Failed to resolve path", err)
+	}
+	return ids, nil
+}
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http"
+
+	"cloud-driver/internal/middleware"
+	"cloud-driver/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ListRecycleBin returns one page of the recycle bin
+func (h *Drive115Handler) ListRecycleBin(c echo.Context) error {
+	var req models.RecycleBinListRequest
+	if err := middleware.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+
+	result, err := h.service.ListRecycleBin(c.Request().Context(), req.Credentials, req.Offset, req.Limit)
+	if err != nil {
+		return serviceError("Failed to list recycle bin", err)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// RestoreRecycleBin restores recycle bin items to their original directories
+func (h *Drive115Handler) RestoreRecycleBin(c echo.Context) error {
+	var req models.RecycleBinRestoreRequest
+	if err := middleware.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+
+	if err := h.service.RestoreRecycleBin(c.Request().Context(), req.Credentials, req.IDs); err != nil {
+		return serviceError("Failed to restore recycle bin items", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "Recycle bin items restored successfully",
+		"count":   len(req.IDs),
+	})
+}
+
+// PurgeRecycleBin permanently deletes recycle bin items
+func (h *Drive115Handler) PurgeRecycleBin(c echo.Context) error {
+	var req models.RecycleBinPurgeRequest
+	if err := middleware.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+
+	if err := h.service.PurgeRecycleBin(c.Request().Context(), req.Credentials, req.Password, req.IDs); err != nil {
+		return serviceError("Failed to purge recycle bin", err)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "Recycle bin purged successfully",
+		"count":   len(req.IDs),
+		"all":     req.All,
+	})
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"cloud-driver/internal/middleware"
+	"cloud-driver/internal/models"
+	"cloud-driver/internal/rules"
+
+	"github.com/labstack/echo/v4"
+)
+
+// CreateRule adds a rule that organizes the caller's finished offline tasks
+func (h *Drive115Handler) CreateRule(c echo.Context) error {
+	var req models.RuleCreateRequest
+	if err := middleware.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+	rule := rules.Rule{
+		Name:        req.Name,
+		TaskPattern: req.TaskPattern,
+		FilePattern: req.FilePattern,
+		Extensions:  req.Extensions,
+		MinSize:     req.MinSize,
+		MaxSize:     req.MaxSize,
+		DryRun:      req.DryRun,
+	}
+	for _, action := range req.Actions {
+		rule.Actions = append(rule.Actions, rules.Action{
+			Type:          action.Type,
+			TargetDirID:   action.TargetDirID,
+			TargetDirPath: action.TargetDirPath,
+			Template:      action.Template,
+			Label:         action.Label,
+		})
+	}
+	rule, err := h.rules.Create(c.Request().Context(), req.Credentials, rule)
+	if err != nil {
+		return ruleError("Failed to create rule", err)
+	}
+	return c.JSON(http.StatusCreated, rule)
+}
+
+// ListRules returns the caller's rules in the order they run
+func (h *Drive115Handler) ListRules(c echo.Context) error {
+	var req models.RuleRequest
+	if err := middleware.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"rules": h.rules.List(req.Credentials),
+	})
+}
+
+// DeleteRule removes one of the caller's rules
+func (h *Drive115Handler) DeleteRule(c echo.Context) error {
+	var req models.RuleRequest
+	if err := middleware.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+	if err := h.rules.Delete(c.Request().Context(), req.Credentials, c.Param("id")); err != nil {
+		return ruleError("Failed to delete rule", err)
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "Rule deleted successfully",
+	})
+}
+
+// RuleAudit returns the caller's latest rule actions, newest first
+func (h *Drive115Handler) RuleAudit(c echo.Context) error {
+	var req models.RuleAuditRequest
+	if err := middleware.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+	if req.Limit == 0 {
+		req.Limit = 100
+	}
+	entries, err := h.rules.Audit(req.Credentials, req.Limit)
+	if err != nil {
+		return serviceError("Failed to read rule audit log", err)
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"entries": entries,
+	})
+}
+
+func ruleError(message string, err error) error {
+	switch {
+	case errors.Is(err, rules.ErrNotFound):
+		return middleware.NewError(http.StatusNotFound, "not_found", err.Error())
+	case errors.Is(err, rules.ErrInvalidRule):
+		return middleware.NewError(http.StatusBadRequest, "invalid_request", err.Error())
+	case errors.Is(err, rules.ErrLimit):
+		return middleware.NewError(http.StatusConflict, "rule_limit", err.Error())
+	default:
+		return serviceError(message, err)
+	}
+}
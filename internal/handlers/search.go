@@ -0,0 +1,25 @@
+package handlers
+
+import (
+	"net/http"
+
+	"cloud-driver/internal/middleware"
+	"cloud-driver/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Search searches files by keyword, type, suffix, date and star filters
+func (h *Drive115Handler) Search(c echo.Context) error {
+	var req models.SearchRequest
+	if err := middleware.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+
+	result, err := h.service.Search(c.Request().Context(), req)
+	if err != nil {
+		return serviceError("Failed to search files", err)
+	}
+
+	return c.JSON(http.StatusOK, result)
+}
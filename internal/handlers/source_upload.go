@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"net/http"
+
+	"cloud-driver/internal/middleware"
+	"cloud-driver/internal/models"
+	"cloud-driver/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// UploadFromSource starts a server-side upload of a URL or local path as a
+// source_upload job and returns the job for polling
+func (h *Drive115Handler) UploadFromSource(c echo.Context) error {
+	var req models.UploadFromSourceRequest
+	if err := middleware.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+	params, err := h.sourceUploadParams(c.Request().Context(), req.Credentials, req.SourceUploadJobParams)
+	if err != nil {
+		return err
+	}
+	job, err := h.jobs.Submit(c.Request().Context(), req.Credentials, services.JobSourceUpload, params)
+	if err != nil {
+		return jobError("Failed to start upload", err)
+	}
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// GetUploadJob reports the progress of a server-side upload
+func (h *Drive115Handler) GetUploadJob(c echo.Context) error {
+	var req models.JobRequest
+	if err := middleware.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+	job, err := h.jobs.Get(req.Credentials, c.Param("id"))
+	if err == nil && job.Type != services.JobSourceUpload {
+		return middleware.NewError(http.StatusNotFound, "not_found", "upload job not found")
+	}
+	if err != nil {
+		return jobError("Failed to get upload job", err)
+	}
+	return c.JSON(http.StatusOK, job)
+}
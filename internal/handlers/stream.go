@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"cloud-driver/internal/middleware"
+	"cloud-driver/internal/models"
+	"cloud-driver/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// streamRelayHeaders are the CDN response headers passed back to the caller
+var streamRelayHeaders = []string{
+	"Accept-Ranges",
+	"Content-Length",
+	"Content-Range",
+	"Content-Type",
+	"ETag",
+	"Last-Modified",
+}
+
+// StreamFile proxies a file's bytes with Range support for GET and HEAD
+func (h *Drive115Handler) StreamFile(c echo.Context) error {
+	var req models.StreamRequest
+
+	fileID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid file ID")
+	}
+	req.FileID = fileID
+
+	if err := middleware.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+
+	return h.streamFile(c, req.Download, func() (*services.DownloadStream, error) {
+		request := c.Request()
+		return h.service.OpenDownloadStream(request.Context(), req.Credentials, req.FileID, request.Method, request.Header)
+	})
+}
+
+// CreateStreamLink mints a signed, expiring link that streams a file without a request body
+func (h *Drive115Handler) CreateStreamLink(c echo.Context) error {
+	var req models.StreamLinkRequest
+
+	fileID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid file ID")
+	}
+	req.FileID = fileID
+
+	if err := middleware.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+
+	file, err := h.service.GetFileInfo(c.Request().Context(), req.Credentials, req.FileID)
+	if err != nil {
+		return serviceError("Failed to create stream link", err)
+	}
+	if file.IsDirectory {
+		return echo.NewHTTPError(http.StatusBadRequest, "Directories cannot be streamed")
+	}
+
+	expiresIn := defaultStreamLinkExpiry
+	if req.ExpiresIn > 0 {
+		expiresIn = time.Duration(req.ExpiresIn) * time.Second
+	}
+	link := streamLink{
+		Credentials: req.Credentials,
+		FileID:      file.ID,
+		PickCode:    file.PickCode,
+		FileName:    file.Name,
+		Download:    req.Download,
+		MaxUses:     req.MaxUses,
+		ExpiresAt:   h.streamLinks.now().Add(expiresIn).Unix(),
+	}
+	if req.BindIP {
+		link.ClientIP = c.RealIP()
+	}
+	token, err := h.streamLinks.encode(link)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create stream link")
+	}
+
+	return c.JSON(http.StatusCreated, models.StreamLinkResponse{
+		URL:       "/api/v1/115/stream/" + token,
+		Token:     token,
+		ExpiresAt: link.ExpiresAt,
+		MaxUses:   link.MaxUses,
+	})
+}
+
+// StreamLink serves a file from a signed stream link for GET and HEAD
+func (h *Drive115Handler) StreamLink(c echo.Context) error {
+	link, err := h.streamLinks.decode(c.Param("token"), c.RealIP())
+	if err != nil {
+		return middleware.NewError(http.StatusForbidden, "invalid_stream_link", err.Error())
+	}
+	// Every request opens the upstream stream, so each one counts as a use
+	request := c.Request()
+	if !h.streamLinks.consume(link) {
+		return middleware.NewError(http.StatusForbidden, "stream_link_exhausted", "stream link use limit reached")
+	}
+
+	return h.streamFile(c, link.Download, func() (*services.DownloadStream, error) {
+		return h.service.OpenPickCodeStream(request.Context(), link.Credentials, link.PickCode, link.FileName, request.Method, request.Header)
+	})
+}
+
+// streamFile opens the CDN stream and relays status, headers and body
+func (h *Drive115Handler) streamFile(c echo.Context, attachment bool, open func() (*services.DownloadStream, error)) error {
+	request := c.Request()
+	stream, err := open()
+	if err != nil {
+		return serviceError("Failed to open download stream", err)
+	}
+	defer stream.Response.Body.Close()
+
+	header := c.Response().Header()
+	copyStreamHeaders(header, stream.Response.Header, stream.FileName)
+	header.Set("Content-Disposition", contentDisposition(stream.FileName, attachment))
+	c.Response().WriteHeader(stream.Response.StatusCode)
+	if request.Method == http.MethodHead {
+		return nil
+	}
+
+	// Errors after the header is written can only abort the connection
+	_, _ = io.Copy(c.Response(), stream.Response.Body)
+	return nil
+}
+
+func copyStreamHeaders(dst, src http.Header, fileName string) {
+	for _, key := range streamRelayHeaders {
+		if value := src.Get(key); value != "" {
+			dst.Set(key, value)
+		}
+	}
+	contentType, _, _ := mime.ParseMediaType(src.Get("Content-Type"))
+	if contentType == "" || contentType == "application/octet-stream" {
+		if guessed := mime.TypeByExtension(path.Ext(fileName)); guessed != "" {
+			dst.Set("Content-Type", guessed)
+		}
+	}
+	if dst.Get("Accept-Ranges") == "" {
+		dst.Set("Accept-Ranges", "bytes")
+	}
+}
+
+func contentDisposition(fileName string, attachment bool) string {
+	disposition := "inline"
+	if attachment {
+		disposition = "attachment"
+	}
+	if value := mime.FormatMediaType(disposition, map[string]string{"filename": fileName}); value != "" {
+		return value
+	}
+	return disposition
+}
@@ -0,0 +1,137 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud-driver/internal/models"
+	"cloud-driver/internal/secretbox"
+)
+
+const (
+	maxStreamLinkTokenSize  = 4 << 10
+	defaultStreamLinkExpiry = 6 * time.Hour
+	maxTrackedStreamLinks   = 10000
+)
+
+// streamLinkPurpose is the AEAD additional data for stream link tokens
+var streamLinkPurpose = []byte("cloud-driver stream link v1")
+
+// streamLink is the sealed payload of a share link. Everything needed to
+// serve the file travels inside the token so links verify without a lookup.
+type streamLink struct {
+	ID          string                     `json:"i"`
+	Credentials models.Drive115Credentials `json:"c"`
+	FileID      string                     `json:"f"`
+	PickCode    string                     `json:"p"`
+	FileName    string                     `json:"n"`
+	Download    bool                       `json:"d,omitempty"`
+	ClientIP    string                     `json:"ip,omitempty"`
+	MaxUses     int                        `json:"m,omitempty"`
+	ExpiresAt   int64                      `json:"e"`
+}
+
+type streamLinkCodec struct {
+	box  *secretbox.Box
+	now  func() time.Time
+	uses *streamLinkUses
+}
+
+func newStreamLinkCodec(box *secretbox.Box) *streamLinkCodec {
+	return &streamLinkCodec{box: box, now: time.Now, uses: newStreamLinkUses(maxTrackedStreamLinks)}
+}
+
+func (c *streamLinkCodec) encode(link streamLink) (string, error) {
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	link.ID = base64.RawURLEncoding.EncodeToString(id)
+	return c.box.SealJSON(link, streamLinkPurpose)
+}
+
+// decode verifies the token, its expiry and optional client IP binding
+func (c *streamLinkCodec) decode(token, clientIP string) (streamLink, error) {
+	var link streamLink
+	if !c.box.OpenJSON(token, maxStreamLinkTokenSize, &link, streamLinkPurpose) || link.ID == "" || link.PickCode == "" {
+		return link, fmt.Errorf("invalid stream link")
+	}
+	if link.ExpiresAt <= c.now().Unix() {
+		return link, fmt.Errorf("stream link expired")
+	}
+	if link.ClientIP != "" && link.ClientIP != clientIP {
+		return link, fmt.Errorf("stream link is bound to another client")
+	}
+	return link, nil
+}
+
+// consume records one use of a link that has a use limit
+func (c *streamLinkCodec) consume(link streamLink) bool {
+	if link.MaxUses <= 0 {
+		return true
+	}
+	return c.uses.consume(link.ID, link.MaxUses, link.ExpiresAt, c.now().Unix())
+}
+
+// streamLinkUses counts uses of limited links in memory, so the limit is
+// best-effort: counts are per process and reset on restart. Entries are
+// dropped once their link expires, and when the table is full the link that
+// expires soonest is forgotten to make room.
+type streamLinkUses struct {
+	mu      sync.Mutex
+	limit   int
+	entries map[string]*streamLinkUse
+}
+
+type streamLinkUse struct {
+	count     int
+	expiresAt int64
+}
+
+func newStreamLinkUses(limit int) *streamLinkUses {
+	return &streamLinkUses{limit: limit, entries: make(map[string]*streamLinkUse)}
+}
+
+func (u *streamLinkUses) consume(id string, maxUses int, expiresAt, now int64) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	entry, ok := u.entries[id]
+	if !ok {
+		if len(u.entries) >= u.limit {
+			u.sweep(now)
+		}
+		if len(u.entries) >= u.limit {
+			u.evictSoonest()
+		}
+		entry = &streamLinkUse{expiresAt: expiresAt}
+		u.entries[id] = entry
+	}
+	if entry.count >= maxUses {
+		return false
+	}
+	entry.count++
+	return true
+}
+
+func (u *streamLinkUses) sweep(now int64) {
+	for id, entry := range u.entries {
+		if entry.expiresAt <= now {
+			delete(u.entries, id)
+		}
+	}
+}
+
+func (u *streamLinkUses) evictSoonest() {
+	var soonest string
+	var expiresAt int64
+	for id, entry := range u.entries {
+		if soonest == "" || entry.expiresAt < expiresAt {
+			soonest, expiresAt = id, entry.expiresAt
+		}
+	}
+	delete(u.entries, soonest)
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"cloud-driver/internal/models"
+	"cloud-driver/internal/secretbox"
+)
+
+func TestStreamLinkCodecConstraints(t *testing.T) {
+	box, err := secretbox.New("test-upload-session-secret-at-least-32-characters")
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec := newStreamLinkCodec(box)
+	now := time.Unix(1_700_000_000, 0)
+	codec.now = func() time.Time { return now }
+
+	token, err := codec.encode(streamLink{
+		Credentials: models.Drive115Credentials{UID: "uid", CID: "cid", SEID: "seid", KID: "kid"},
+		FileID:      "1", PickCode: "pc", FileName: "a.mp4", ClientIP: "10.0.0.1", MaxUses: 2,
+		ExpiresAt: now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	link, err := codec.decode(token, "10.0.0.1")
+	if err != nil || link.PickCode != "pc" || link.Credentials.SEID != "seid" {
+		t.Fatalf("link = %+v, err = %v", link, err)
+	}
+	if _, err := codec.decode(token, "10.0.0.2"); err == nil {
+		t.Fatal("link accepted from another client")
+	}
+	if !codec.consume(link) || !codec.consume(link) || codec.consume(link) {
+		t.Fatal("use limit not enforced")
+	}
+
+	uploadToken, err := box.SealJSON(map[string]string{"i": "x", "p": "pc"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := codec.decode(uploadToken, ""); err == nil {
+		t.Fatal("token sealed for another purpose accepted")
+	}
+
+	codec.now = func() time.Time { return now.Add(2 * time.Hour) }
+	if _, err := codec.decode(token, "10.0.0.1"); err == nil {
+		t.Fatal("expired link accepted")
+	}
+}
+
+func TestStreamLinkUsesEvictsSoonestExpiring(t *testing.T) {
+	uses := newStreamLinkUses(2)
+	if !uses.consume("a", 1, 200, 100) || !uses.consume("b", 1, 150, 100) {
+		t.Fatal("fresh links refused")
+	}
+	if !uses.consume("c", 1, 300, 100) {
+		t.Fatal("new link refused when the table is full")
+	}
+	if _, ok := uses.entries["b"]; ok || len(uses.entries) != 2 {
+		t.Fatalf("entries = %v", uses.entries)
+	}
+	if uses.consume("a", 1, 200, 100) {
+		t.Fatal("exhausted link accepted")
+	}
+}
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCopyStreamHeaders(t *testing.T) {
+	src := http.Header{}
+	src.Set("Content-Type", "application/octet-stream")
+	src.Set("Content-Range", "bytes 0-9/100")
+	src.Set("ETag", `"abc"`)
+	src.Set("Set-Cookie", "secret=1")
+	dst := http.Header{}
+	copyStreamHeaders(dst, src, "poster.png")
+	if dst.Get("Content-Type") != "image/png" {
+		t.Fatalf("content type = %q", dst.Get("Content-Type"))
+	}
+	if dst.Get("Content-Range") != "bytes 0-9/100" || dst.Get("ETag") != `"abc"` || dst.Get("Accept-Ranges") != "bytes" {
+		t.Fatalf("headers = %v", dst)
+	}
+	if dst.Get("Set-Cookie") != "" {
+		t.Fatal("CDN cookie leaked to caller")
+	}
+}
+
+func TestContentDisposition(t *testing.T) {
+	if got := contentDisposition("a.mp4", false); got != "inline; filename=a.mp4" {
+		t.Fatalf("inline = %q", got)
+	}
+	if got := contentDisposition("电影.mkv", true); got != "attachment; filename*=utf-8''%E7%94%B5%E5%BD%B1.mkv" {
+		t.Fatalf("attachment = %q", got)
+	}
+}
@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"cloud-driver/internal/middleware"
+	"cloud-driver/internal/models"
+	"cloud-driver/internal/offline"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// taskEventsKeepalive is how often an idle event stream sends a comment
+	taskEventsKeepalive = 15 * time.Second
+	// taskEventsRetry is the reconnect delay suggested to EventSource clients
+	taskEventsRetry = 5 * time.Second
+)
+
+// taskEventsLinkPurpose is the AEAD additional data for task event links
+var taskEventsLinkPurpose = []byte("cloud-driver task events link v1")
+
+// taskEventsLink is the sealed payload of a task event stream URL
+type taskEventsLink struct {
+	Credentials models.Drive115Credentials `json:"c"`
+	ExpiresAt   int64                      `json:"e"`
+}
+
+// TaskEvents streams the caller's offline task changes as server-sent events
+func (h *Drive115Handler) TaskEvents(c echo.Context) error {
+	var req models.TaskEventsRequest
+	if err := middleware.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+	return h.streamTaskEvents(c, req.Credentials)
+}
+
+// CreateTaskEventsLink mints a signed URL for the task event stream, for
+// EventSource clients that can send neither a body nor headers
+func (h *Drive115Handler) CreateTaskEventsLink(c echo.Context) error {
+	var req models.TaskEventsLinkRequest
+	if err := middleware.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+	expiresIn := defaultStreamLinkExpiry
+	if req.ExpiresIn > 0 {
+		expiresIn = time.Duration(req.ExpiresIn) * time.Second
+	}
+	link := taskEventsLink{Credentials: req.Credentials, ExpiresAt: h.streamLinks.now().Add(expiresIn).Unix()}
+	token, err := h.streamLinks.box.SealJSON(link, taskEventsLinkPurpose)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create task events link")
+	}
+	return c.JSON(http.StatusCreated, models.StreamLinkResponse{
+		URL:       "/api/v1/115/tasks/events/" + token,
+		Token:     token,
+		ExpiresAt: link.ExpiresAt,
+	})
+}
+
+// TaskEventsLink streams task events for a signed link
+func (h *Drive115Handler) TaskEventsLink(c echo.Context) error {
+	var link taskEventsLink
+	if !h.streamLinks.box.OpenJSON(c.Param("token"), maxStreamLinkTokenSize, &link, taskEventsLinkPurpose) {
+		return middleware.NewError(http.StatusForbidden, "invalid_task_events_link", "invalid task events link")
+	}
+	if link.ExpiresAt <= h.streamLinks.now().Unix() {
+		return middleware.NewError(http.StatusForbidden, "invalid_task_events_link", "task events link expired")
+	}
+	return h.streamTaskEvents(c, link.Credentials)
+}
+
+// streamTaskEvents relays a watcher subscription until the client leaves or
+// the subscription ends. EventSource reconnects on its own and receives a
+// fresh snapshot.
+func (h *Drive115Handler) streamTaskEvents(c echo.Context, credentials models.Drive115Credentials) error {
+	subscription, err := h.watcher.Subscribe(credentials)
+	if errors.Is(err, offline.ErrClosed) {
+		return middleware.NewError(http.StatusServiceUnavailable, "shutting_down", err.Error())
+	}
+	if err != nil {
+		return serviceError("Failed to watch offline tasks", err)
+	}
+	defer subscription.Close()
+
+	response := c.Response()
+	response.Header().Set(echo.HeaderContentType, "text/event-stream")
+	response.Header().Set(echo.HeaderCacheControl, "no-cache")
+	response.Header().Set("X-Accel-Buffering", "no")
+	response.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprintf(response, "retry: %d\n\n", taskEventsRetry.Milliseconds()); err != nil {
+		return nil
+	}
+	response.Flush()
+
+	keepalive := time.NewTicker(taskEventsKeepalive)
+	defer keepalive.Stop()
+	var id int64
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case event, ok := <-subscription.Events():
+			if !ok {
+				return nil
+			}
+			data, err := json.Marshal(event)
+			if err != nil {
+				return nil
+			}
+			id++
+			if _, err := fmt.Fprintf(response, "id: %d\nevent: %s\ndata: %s\n\n", id, event.Type, data); err != nil {
+				return nil
+			}
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(response, ": keepalive\n\n"); err != nil {
+				return nil
+			}
+		}
+		response.Flush()
+	}
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	"cloud-driver/internal/middleware"
+	"cloud-driver/internal/models"
+	"cloud-driver/internal/torrent"
+
+	"github.com/labstack/echo/v4"
+)
+
+// maxTorrentFileSize bounds an uploaded .torrent file. The route body limit
+// leaves room for the other form fields.
+const maxTorrentFileSize = 10 << 20
+
+// AddOfflineTorrent parses a .torrent file sent as the multipart field
+// "torrent" and, unless previewing, adds an offline task for the selected
+// files. Both answers list every file with its size.
+func (h *Drive115Handler) AddOfflineTorrent(c echo.Context) error {
+	var req models.TorrentTaskRequest
+	if err := middleware.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+	data, err := readTorrentFile(c)
+	if err != nil {
+		return err
+	}
+	meta, err := torrent.Parse(data)
+	if err != nil {
+		return middleware.NewError(http.StatusBadRequest, "invalid_torrent", err.Error())
+	}
+	selected, err := meta.Select(req.Files)
+	if err != nil {
+		return middleware.NewError(http.StatusBadRequest, "invalid_request", err.Error())
+	}
+	resp := torrentTaskResponse(meta, selected)
+	if req.Preview {
+		return c.JSON(http.StatusOK, resp)
+	}
+	if len(selected) == 0 {
+		return middleware.NewError(http.StatusBadRequest, "invalid_request", "torrent has no files to download")
+	}
+
+	ctx := c.Request().Context()
+	saveDirID, err := h.resolveDirPath(ctx, req.Credentials, req.SaveDirPath, req.SaveDirID)
+	if err != nil {
+		return err
+	}
+	infoHash, err := h.service.AddOfflineTorrent(ctx, req.Credentials, data, meta, selected, saveDirID)
+	if err != nil {
+		return serviceError("Failed to add offline task", err)
+	}
+	if infoHash != "" {
+		resp.InfoHash = strings.ToLower(infoHash)
+	}
+	resp.Added = true
+	return c.JSON(http.StatusOK, resp)
+}
+
+func readTorrentFile(c echo.Context) ([]byte, error) {
+	header, err := c.FormFile("torrent")
+	if err != nil {
+		return nil, middleware.NewError(http.StatusBadRequest, "invalid_request", "multipart field torrent is required")
+	}
+	if header.Size > maxTorrentFileSize {
+		return nil, middleware.NewError(http.StatusRequestEntityTooLarge, "torrent_too_large", "torrent file exceeds 10 MiB")
+	}
+	file, err := header.Open()
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Failed to read torrent file").SetInternal(err)
+	}
+	defer file.Close()
+	data, err := io.ReadAll(io.LimitReader(file, maxTorrentFileSize+1))
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Failed to read torrent file").SetInternal(err)
+	}
+	if len(data) > maxTorrentFileSize {
+		return nil, middleware.NewError(http.StatusRequestEntityTooLarge, "torrent_too_large", "torrent file exceeds 10 MiB")
+	}
+	return data, nil
+}
+
+func torrentTaskResponse(meta *torrent.MetaInfo, selected []int) models.TorrentTaskResponse {
+	resp := models.TorrentTaskResponse{
+		InfoHash:      meta.InfoHash,
+		Name:          meta.Name,
+		Size:          meta.Size,
+		SelectedCount: len(selected),
+		Files:         make([]models.TorrentFile, len(meta.Files)),
+	}
+	for i, file := range meta.Files {
+		resp.Files[i] = models.TorrentFile{Index: file.Index, Path: file.Path, Size: file.Size, Padding: file.Padding}
+	}
+	for _, index := range selected {
+		resp.Files[index].Selected = true
+		resp.SelectedSize += meta.Files[index].Size
+	}
+	return resp
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"cloud-driver/internal/middleware"
+	"cloud-driver/internal/models"
+	"cloud-driver/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestAddOfflineTorrentPreview(t *testing.T) {
+	handler, err := NewDrive115Handler(services.NewDrive115Service(), "test-upload-session-secret-at-least-32-characters", nil, nil, nil, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := echo.New()
+	e.Use(middleware.ValidationMiddleware())
+	e.POST("/tasks/add/torrent", handler.AddOfflineTorrent)
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for key, value := range map[string]string{"uid": "1", "cid": "cid", "seid": "seid", "kid": "kid", "preview": "true"} {
+		form.WriteField(key, value)
+	}
+	form.WriteField("files", "1")
+	part, _ := form.CreateFormFile("torrent", "movie.torrent")
+	part.Write([]byte("d4:infod5:filesld6:lengthi10e4:pathl10:sample.mkveed6:lengthi900e4:pathl9:movie.mkveee4:name5:Movie12:piece lengthi16384e6:pieces0:ee"))
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/tasks/add/torrent", &body)
+	req.Header.Set(echo.HeaderContentType, form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+	var resp models.TorrentTaskResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Added || resp.Name != "Movie" || resp.Size != 910 || resp.SelectedSize != 900 || resp.SelectedCount != 1 {
+		t.Fatalf("resp = %+v", resp)
+	}
+	if len(resp.Files) != 2 || resp.Files[0].Selected || !resp.Files[1].Selected || resp.Files[0].Size != 10 {
+		t.Fatalf("files = %+v", resp.Files)
+	}
+}
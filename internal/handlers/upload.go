@@ -32,6 +32,9 @@ func (h *Drive115Handler) InitUpload(c echo.Context) error {
 	if req.DirID == "" {
 		req.DirID = "0"
 	}
+	if req.DirID, err = h.resolveDirPath(c.Request().Context(), req.Credentials, req.DirPath, req.DirID); err != nil {
+		return err
+	}
 	req.FileName = fileName
 	req.SHA1 = strings.ToUpper(req.SHA1)
 	req.PreSHA1 = strings.ToUpper(req.PreSHA1)
@@ -39,7 +42,7 @@ func (h *Drive115Handler) InitUpload(c echo.Context) error {
 	expiresAt := time.Now().Add(uploadSessionLifetime).Unix()
 	result, err := h.uploads.InitUpload(c.Request().Context(), req, expiresAt)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusBadGateway, "Failed to initialize upload: "+err.Error())
+		return echo.NewHTTPError(http.StatusBadGateway, "Failed to initialize upload: "+err.Error()).SetInternal(err)
 	}
 
 	switch result.State {
@@ -77,7 +80,7 @@ func (h *Drive115Handler) UploadStatus(c echo.Context) error {
 	}
 	progress, serviceErr := h.uploads.UploadStatus(c.Request().Context(), session)
 	if serviceErr != nil {
-		return echo.NewHTTPError(http.StatusBadGateway, "Failed to read upload status: "+serviceErr.Error())
+		return echo.NewHTTPError(http.StatusBadGateway, "Failed to read upload status: "+serviceErr.Error()).SetInternal(serviceErr)
 	}
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"next_part": progress.NextPart, "uploaded_bytes": progress.UploadedBytes, "complete": progress.Complete,
@@ -102,7 +105,7 @@ func (h *Drive115Handler) UploadPart(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "part Content-Length does not match expected size")
 	}
 	if err := h.uploads.UploadPart(c.Request().Context(), session, partNumber, c.Request().Body); err != nil {
-		return echo.NewHTTPError(http.StatusBadGateway, "Failed to upload part: "+err.Error())
+		return echo.NewHTTPError(http.StatusBadGateway, "Failed to upload part: "+err.Error()).SetInternal(err)
 	}
 	return c.NoContent(http.StatusNoContent)
 }
@@ -113,7 +116,7 @@ func (h *Drive115Handler) CompleteUpload(c echo.Context) error {
 		return err
 	}
 	if err := h.uploads.CompleteUpload(c.Request().Context(), session); err != nil {
-		return echo.NewHTTPError(http.StatusBadGateway, "Failed to complete upload: "+err.Error())
+		return echo.NewHTTPError(http.StatusBadGateway, "Failed to complete upload: "+err.Error()).SetInternal(err)
 	}
 	return c.JSON(http.StatusCreated, map[string]interface{}{
 		"message": "File uploaded successfully", "dir_id": session.DirID, "name": session.FileName, "size": session.FileSize,
@@ -126,7 +129,7 @@ func (h *Drive115Handler) AbortUpload(c echo.Context) error {
 		return err
 	}
 	if err := h.uploads.AbortUpload(c.Request().Context(), session); err != nil {
-		return echo.NewHTTPError(http.StatusBadGateway, "Failed to abort upload: "+err.Error())
+		return echo.NewHTTPError(http.StatusBadGateway, "Failed to abort upload: "+err.Error()).SetInternal(err)
 	}
 	return c.NoContent(http.StatusNoContent)
 }
@@ -134,11 +137,11 @@ func (h *Drive115Handler) AbortUpload(c echo.Context) error {
 func (h *Drive115Handler) sessionFromRequest(c echo.Context, allowExpired bool) (services.UploadSession, error) {
 	authorization := c.Request().Header.Get(echo.HeaderAuthorization)
 	if !strings.HasPrefix(authorization, "Bearer ") {
-		return services.UploadSession{}, echo.NewHTTPError(http.StatusUnauthorized, "Missing upload session")
+		return services.UploadSession{}, middleware.NewError(http.StatusUnauthorized, "missing_upload_session", "Missing upload session")
 	}
 	session, err := h.uploadCodec.decode(strings.TrimPrefix(authorization, "Bearer "), allowExpired)
 	if err != nil {
-		return services.UploadSession{}, echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		return services.UploadSession{}, middleware.NewError(http.StatusUnauthorized, "invalid_upload_session", err.Error())
 	}
 	return session, nil
 }
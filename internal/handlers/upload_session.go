@@ -1,66 +1,35 @@
 package handlers
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
-	"crypto/rand"
-	"crypto/sha256"
-	"encoding/base64"
-	"encoding/json"
 	"fmt"
 	"time"
 
+	"cloud-driver/internal/secretbox"
 	"cloud-driver/internal/services"
 )
 
 const maxUploadSessionTokenSize = 16 << 10
 
 type uploadSessionCodec struct {
-	aead cipher.AEAD
-	now  func() time.Time
+	box *secretbox.Box
+	now func() time.Time
 }
 
 func newUploadSessionCodec(secret string) (*uploadSessionCodec, error) {
-	if len(secret) < 32 {
-		return nil, fmt.Errorf("upload session secret must be at least 32 characters")
-	}
-	key := sha256.Sum256([]byte(secret))
-	block, err := aes.NewCipher(key[:])
-	if err != nil {
-		return nil, err
-	}
-	aead, err := cipher.NewGCM(block)
+	box, err := secretbox.New(secret)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("upload session %w", err)
 	}
-	return &uploadSessionCodec{aead: aead, now: time.Now}, nil
+	return &uploadSessionCodec{box: box, now: time.Now}, nil
 }
 
 func (c *uploadSessionCodec) encode(session services.UploadSession) (string, error) {
-	plain, err := json.Marshal(session)
-	if err != nil {
-		return "", err
-	}
-	nonce := make([]byte, c.aead.NonceSize())
-	if _, err := rand.Read(nonce); err != nil {
-		return "", err
-	}
-	sealed := c.aead.Seal(nonce, nonce, plain, nil)
-	return base64.RawURLEncoding.EncodeToString(sealed), nil
+	return c.box.SealJSON(session, nil)
 }
 
 func (c *uploadSessionCodec) decode(token string, allowExpired bool) (services.UploadSession, error) {
 	var session services.UploadSession
-	if token == "" || len(token) > maxUploadSessionTokenSize {
-		return session, fmt.Errorf("invalid upload session")
-	}
-	sealed, err := base64.RawURLEncoding.DecodeString(token)
-	if err != nil || len(sealed) < c.aead.NonceSize() {
-		return session, fmt.Errorf("invalid upload session")
-	}
-	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
-	plain, err := c.aead.Open(nil, nonce, ciphertext, nil)
-	if err != nil || json.Unmarshal(plain, &session) != nil {
+	if !c.box.OpenJSON(token, maxUploadSessionTokenSize, &session, nil) {
 		return session, fmt.Errorf("invalid upload session")
 	}
 	now := c.now().Unix()
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"cloud-driver/internal/middleware"
+	"cloud-driver/internal/models"
+	"cloud-driver/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// walkFlushEvery is how many NDJSON lines are buffered before flushing
+const walkFlushEvery = 100
+
+// WalkFiles streams every entry below a directory as NDJSON. The last line is
+// a WalkSummary, or an error object if the walk failed after streaming began.
+func (h *Drive115Handler) WalkFiles(c echo.Context) error {
+	var req models.WalkFilesRequest
+	if err := middleware.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+
+	response := c.Response()
+	encoder := json.NewEncoder(response)
+	summary := models.WalkSummary{}
+	start := func() {
+		if !response.Committed {
+			response.Header().Set(echo.HeaderContentType, "application/x-ndjson")
+			response.WriteHeader(http.StatusOK)
+		}
+	}
+
+	options := services.WalkOptions{MaxDepth: req.MaxDepth, Concurrency: req.Concurrency, FilesOnly: req.FilesOnly}
+	err := h.service.Walk(c.Request().Context(), req.Credentials, req.DirID, options, func(entry models.WalkEntry) error {
+		start()
+		if entry.IsDirectory {
+			summary.Directories++
+		} else {
+			summary.Files++
+		}
+		if err := encoder.Encode(entry); err != nil {
+			return err
+		}
+		if (summary.Files+summary.Directories)%walkFlushEvery == 0 {
+			response.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		if !response.Committed {
+			return serviceError("Failed to walk directory", err)
+		}
+		// The status line is gone; report the failure in-band
+		return encoder.Encode(map[string]interface{}{
+			"error": middleware.ErrorResponse{Code: "walk_failed", Message: "Failed to walk directory: " + err.Error()},
+		})
+	}
+
+	start()
+	summary.Done = true
+	return encoder.Encode(summary)
+}
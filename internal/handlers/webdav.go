@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+
+	"cloud-driver/internal/dav"
+	"cloud-driver/internal/middleware"
+	"cloud-driver/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// WebDAVUser maps a basic-auth login to raw 115 credentials or an account handle
+type WebDAVUser struct {
+	Username    string
+	Password    string
+	Credentials models.Drive115Credentials
+}
+
+type webdavUser struct {
+	passwordHash [sha256.Size]byte
+	credentials  models.Drive115Credentials
+}
+
+// WebDAVHandler authenticates WebDAV clients and serves the caller's account.
+// Configured users log in with their own password. When API authentication
+// is enabled any other username is taken as a stored account handle, with an
+// API key or signed token as the password.
+type WebDAVHandler struct {
+	dav      *dav.Handler
+	users    map[string]webdavUser
+	resolver *middleware.CredentialResolver
+	auth     *middleware.Authenticator
+}
+
+// NewWebDAVHandler validates the configured users
+func NewWebDAVHandler(davHandler *dav.Handler, users []WebDAVUser, resolver *middleware.CredentialResolver, auth *middleware.Authenticator) (*WebDAVHandler, error) {
+	h := &WebDAVHandler{dav: davHandler, users: make(map[string]webdavUser, len(users)), resolver: resolver, auth: auth}
+	for _, user := range users {
+		if user.Username == "" || len(user.Password) < 16 {
+			return nil, fmt.Errorf("webdav user %q needs a username and a password of at least 16 characters", user.Username)
+		}
+		if _, ok := h.users[user.Username]; ok {
+			return nil, fmt.Errorf("webdav user %q is configured twice", user.Username)
+		}
+		h.users[user.Username] = webdavUser{passwordHash: sha256.Sum256([]byte(user.Password)), credentials: user.Credentials}
+	}
+	return h, nil
+}
+
+// Serve handles every WebDAV method below the configured prefix
+func (h *WebDAVHandler) Serve(c echo.Context) error {
+	credentials, err := h.authenticate(c)
+	if err != nil {
+		c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="cloud-driver"`)
+		return err
+	}
+	h.dav.Serve(c.Response(), c.Request(), credentials)
+	return nil
+}
+
+func (h *WebDAVHandler) authenticate(c echo.Context) (models.Drive115Credentials, error) {
+	username, password, ok := c.Request().BasicAuth()
+	if !ok {
+		return models.Drive115Credentials{}, middleware.NewError(http.StatusUnauthorized, "missing_credentials", "WebDAV requires basic authentication")
+	}
+	ctx := c.Request().Context()
+
+	if user, ok := h.users[username]; ok {
+		hash := sha256.Sum256([]byte(password))
+		if subtle.ConstantTimeCompare(hash[:], user.passwordHash[:]) != 1 {
+			return models.Drive115Credentials{}, middleware.NewError(http.StatusUnauthorized, "invalid_credentials", "Invalid WebDAV username or password")
+		}
+		credentials := user.credentials
+		if err := h.resolver.Resolve(ctx, &credentials); err != nil {
+			return models.Drive115Credentials{}, err
+		}
+		return credentials, nil
+	}
+
+	// Without API authentication there is no password to check a handle against
+	if !h.auth.Enabled() {
+		return models.Drive115Credentials{}, middleware.NewError(http.StatusUnauthorized, "invalid_credentials", "Invalid WebDAV username or password")
+	}
+	principal, err := h.auth.Authenticate(password)
+	if err != nil {
+		return models.Drive115Credentials{}, err
+	}
+	scope := middleware.ScopeWrite
+	if webdavReadMethods[c.Request().Method] {
+		scope = middleware.ScopeRead
+	}
+	if !principal.Scopes[scope] {
+		return models.Drive115Credentials{}, middleware.NewError(http.StatusForbidden, "insufficient_scope", fmt.Sprintf("API key %q lacks the %q scope", principal.Name, scope))
+	}
+	credentials := models.Drive115Credentials{Account: username}
+	if err := h.resolver.Resolve(ctx, &credentials); err != nil {
+		return models.Drive115Credentials{}, err
+	}
+	return credentials, nil
+}
+
+// webdavReadMethods need the read scope; every other method changes the drive
+var webdavReadMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodOptions: true,
+	"PROPFIND":         true,
+}
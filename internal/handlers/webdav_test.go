@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"cloud-driver/internal/accounts"
+	"cloud-driver/internal/middleware"
+	"cloud-driver/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+type webdavAccountStore map[string]models.Drive115Credentials
+
+func (s webdavAccountStore) Create(context.Context, models.Drive115Credentials) (*accounts.Account, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (s webdavAccountStore) Get(_ context.Context, handle string) (*accounts.Account, error) {
+	credentials, ok := s[handle]
+	if !ok {
+		return nil, accounts.ErrNotFound
+	}
+	return &accounts.Account{Handle: handle, Credentials: credentials}, nil
+}
+
+func (s webdavAccountStore) Delete(context.Context, string) error { return nil }
+
+func TestWebDAVAuthentication(t *testing.T) {
+	store := webdavAccountStore{"acct_1": {UID: "uid", CID: "cid", SEID: "stored", KID: "kid"}}
+	auth, err := middleware.NewAuthenticator([]middleware.APIKey{{Name: "reader", Key: "reader-key-0123456789", Scopes: []string{"read"}}}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	users := []WebDAVUser{{Username: "nas", Password: "nas-password-0123456789", Credentials: models.Drive115Credentials{UID: "u", CID: "c", SEID: "configured", KID: "k"}}}
+	h, err := NewWebDAVHandler(nil, users, middleware.NewCredentialResolver(store), auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]struct {
+		method, username, password string
+		noAuth                     bool
+		wantStatus                 int
+		wantSEID                   string
+	}{
+		"configured user":          {method: "PROPFIND", username: "nas", password: "nas-password-0123456789", wantSEID: "configured"},
+		"configured user bad":      {method: "PROPFIND", username: "nas", password: "wrong", wantStatus: http.StatusUnauthorized},
+		"handle with read key":     {method: "PROPFIND", username: "acct_1", password: "reader-key-0123456789", wantSEID: "stored"},
+		"handle write needs scope": {method: http.MethodPut, username: "acct_1", password: "reader-key-0123456789", wantStatus: http.StatusForbidden},
+		"handle with bad key":      {method: "PROPFIND", username: "acct_1", password: "not-a-key", wantStatus: http.StatusUnauthorized},
+		"unknown handle":           {method: "PROPFIND", username: "acct_2", password: "reader-key-0123456789", wantStatus: http.StatusUnauthorized},
+		"no basic auth":            {method: "PROPFIND", noAuth: true, wantStatus: http.StatusUnauthorized},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			request := httptest.NewRequest(tc.method, "/dav/", nil)
+			if !tc.noAuth {
+				request.SetBasicAuth(tc.username, tc.password)
+			}
+			c := echo.New().NewContext(request, httptest.NewRecorder())
+			credentials, err := h.authenticate(c)
+			if tc.wantStatus != 0 {
+				var httpErr *echo.HTTPError
+				if !errors.As(err, &httpErr) || httpErr.Code != tc.wantStatus {
+					t.Fatalf("err = %v, want status %d", err, tc.wantStatus)
+				}
+				return
+			}
+			if err != nil || credentials.SEID != tc.wantSEID {
+				t.Fatalf("credentials = %+v, err = %v", credentials, err)
+			}
+		})
+	}
+}
+
+func TestWebDAVHandleNeedsAPIAuth(t *testing.T) {
+	store := webdavAccountStore{"acct_1": {UID: "uid", CID: "cid", SEID: "stored", KID: "kid"}}
+	auth, err := middleware.NewAuthenticator(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := NewWebDAVHandler(nil, nil, middleware.NewCredentialResolver(store), auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := httptest.NewRequest("PROPFIND", "/dav/", nil)
+	request.SetBasicAuth("acct_1", "anything")
+	_, err = h.authenticate(echo.New().NewContext(request, httptest.NewRecorder()))
+	var httpErr *echo.HTTPError
+	if !errors.As(err, &httpErr) || httpErr.Code != http.StatusUnauthorized {
+		t.Fatalf("err = %v, want 401", err)
+	}
+}
@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"cloud-driver/internal/middleware"
+	"cloud-driver/internal/models"
+	"cloud-driver/internal/webhooks"
+
+	"github.com/labstack/echo/v4"
+)
+
+// CreateWebhook subscribes a URL to the caller's finished offline tasks
+func (h *Drive115Handler) CreateWebhook(c echo.Context) error {
+	var req models.WebhookCreateRequest
+	if err := middleware.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+	webhook, err := h.webhooks.Create(c.Request().Context(), req.Credentials, req.URL, req.Secret, req.Events)
+	if err != nil {
+		return webhookError("Failed to create webhook", err)
+	}
+	return c.JSON(http.StatusCreated, webhook)
+}
+
+// ListWebhooks returns the caller's webhooks with their latest delivery
+func (h *Drive115Handler) ListWebhooks(c echo.Context) error {
+	var req models.WebhookRequest
+	if err := middleware.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"webhooks": h.webhooks.List(req.Credentials),
+	})
+}
+
+// DeleteWebhook removes one of the caller's webhooks
+func (h *Drive115Handler) DeleteWebhook(c echo.Context) error {
+	var req models.WebhookRequest
+	if err := middleware.ValidateRequest(c, &req); err != nil {
+		return err
+	}
+	if err := h.webhooks.Delete(c.Request().Context(), req.Credentials, c.Param("id")); err != nil {
+		return webhookError("Failed to delete webhook", err)
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "Webhook deleted successfully",
+	})
+}
+
+func webhookError(message string, err error) error {
+	switch {
+	case errors.Is(err, webhooks.ErrNotFound):
+		return middleware.NewError(http.StatusNotFound, "not_found", err.Error())
+	case errors.Is(err, webhooks.ErrInvalidURL):
+		return middleware.NewError(http.StatusBadRequest, "invalid_request", err.Error())
+	case errors.Is(err, webhooks.ErrLimit):
+		return middleware.NewError(http.StatusConflict, "webhook_limit", err.Error())
+	default:
+		return serviceError(message, err)
+	}
+}
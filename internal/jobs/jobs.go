@@ -0,0 +1,665 @@
+// Package jobs runs long operations in a background worker pool so they no
+// longer die with the HTTP request that started them. Jobs are persisted with
+// their progress, retried with backoff, canceled through their context and
+// resumed after a restart.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"cloud-driver/internal/accounts"
+	"cloud-driver/internal/models"
+	"cloud-driver/internal/recordstore"
+)
+
+// Status is the lifecycle state of a job
+type Status string
+
+// Job states. Queued jobs wait for a worker, possibly until NextRunAt when a
+// failed attempt is being retried.
+const (
+	StatusQueued    Status = "queued"
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+	StatusCanceled  Status = "canceled"
+)
+
+// Finished reports whether the job will not run again
+func (s Status) Finished() bool {
+	return s == StatusSucceeded || s == StatusFailed || s == StatusCanceled
+}
+
+// progressSaveInterval bounds how often progress alone is written to the store
+const progressSaveInterval = 5 * time.Second
+
+var (
+	// ErrNotFound is returned for unknown jobs and jobs of another account
+	ErrNotFound = errors.New("job not found")
+	// ErrUnknownType is returned when no handler is registered for a job type
+	ErrUnknownType = errors.New("unknown job type")
+	// ErrFinished is returned when canceling a job that already finished
+	ErrFinished = errors.New("job already finished")
+	// ErrStopped is returned when submitting to a stopped manager
+	ErrStopped = errors.New("job manager stopped")
+
+	errCanceled = errors.New("job canceled")
+	errShutdown = errors.New("job manager shutting down")
+)
+
+// Progress is what a running job last reported about itself
+type Progress struct {
+	Done    int64  `json:"done"`
+	Total   int64  `json:"total,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// Job is the client-facing view of a background job
+type Job struct {
+	ID          string          `json:"id"`
+	Type        string          `json:"type"`
+	Status      Status          `json:"status"`
+	Params      json.RawMessage `json:"params"`
+	Progress    Progress        `json:"progress"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	Error       string          `json:"error,omitempty"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"max_attempts"`
+	CreatedAt   time.Time       `json:"created_at"`
+	UpdatedAt   time.Time       `json:"updated_at"`
+	StartedAt   *time.Time      `json:"started_at,omitempty"`
+	FinishedAt  *time.Time      `json:"finished_at,omitempty"`
+	NextRunAt   *time.Time      `json:"next_run_at,omitempty"`
+}
+
+// HandlerFunc runs one attempt of a job. The context is canceled when the job
+// is canceled or the manager stops. The result is stored as JSON.
+type HandlerFunc func(ctx context.Context, run *Run) (interface{}, error)
+
+// Run is the job as seen by its handler
+type Run struct {
+	ID          string
+	Type        string
+	Credentials models.Drive115Credentials
+	Params      json.RawMessage
+	Attempt     int
+
+	manager *Manager
+	entry   *entry
+}
+
+// Decode unmarshals the job parameters into v
+func (r *Run) Decode(v interface{}) error {
+	return json.Unmarshal(r.Params, v)
+}
+
+// Checkpoint returns the progress reported before this attempt started, so a
+// retried or resumed job can skip work it already finished
+func (r *Run) Checkpoint() Progress {
+	r.manager.mu.Lock()
+	defer r.manager.mu.Unlock()
+	return r.entry.record.Job.Progress
+}
+
+// Report records the job's progress. It reaches the store at most every few
+// seconds; use Commit for checkpoints a resumed job relies on.
+func (r *Run) Report(progress Progress) {
+	r.report(progress, false)
+}
+
+// Commit records the job's progress and writes it to the store at once
+func (r *Run) Commit(progress Progress) {
+	r.report(progress, true)
+}
+
+func (r *Run) report(progress Progress, force bool) {
+	m := r.manager
+	m.mu.Lock()
+	now := m.now()
+	job := &r.entry.record.Job
+	job.Progress = progress
+	job.UpdatedAt = now
+	save := force || now.Sub(r.entry.savedAt) >= progressSaveInterval
+	if save {
+		r.entry.savedAt = now
+	}
+	m.mu.Unlock()
+	if save {
+		m.persist(r.entry)
+	}
+}
+
+// permanentError marks an error that retrying cannot fix
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err so the job fails without further attempts
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+// Options tunes the manager. Zero values take the defaults noted per field.
+type Options struct {
+	// Workers is the number of jobs run at once (4)
+	Workers int
+	// MaxAttempts is how often a failing job is tried before it fails (3)
+	MaxAttempts int
+	// RetryDelay is the wait before the first retry, doubled per attempt (10s)
+	RetryDelay time.Duration
+	// MaxRetryDelay caps the retry delay (10m)
+	MaxRetryDelay time.Duration
+	// Retention is how long finished jobs are kept (7 days)
+	Retention time.Duration
+	// Retryable reports whether a failed attempt may be retried. Errors
+	// wrapped with Permanent are never retried.
+	Retryable func(error) bool
+	// Accounts looks up the handle of jobs submitted with one before every
+	// attempt, so they follow the account and stop once it is deleted
+	Accounts accounts.Store
+}
+
+type entry struct {
+	record   Record
+	cancel   context.CancelCauseFunc
+	savedAt  time.Time
+	canceled bool
+	removed  bool
+}
+
+// Manager owns the job queue and its workers
+type Manager struct {
+	store    recordstore.Store[Record]
+	options  Options
+	handlers map[string]HandlerFunc
+	now      func() time.Time
+
+	saveMu  sync.Mutex
+	mu      sync.Mutex
+	entries map[string]*entry
+	wake    chan struct{}
+	started bool
+	stopped bool
+	quit    chan struct{}
+	workers sync.WaitGroup
+}
+
+// NewManager loads the stored jobs. Jobs that were running when the process
+// last stopped are queued again; their interrupted attempt counts unless the
+// manager was stopped cleanly.
+func NewManager(store recordstore.Store[Record], options Options) (*Manager, error) {
+	if options.Workers <= 0 {
+		options.Workers = 4
+	}
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = 3
+	}
+	if options.RetryDelay <= 0 {
+		options.RetryDelay = 10 * time.Second
+	}
+	if options.MaxRetryDelay <= 0 {
+		options.MaxRetryDelay = 10 * time.Minute
+	}
+	if options.Retention <= 0 {
+		options.Retention = 7 * 24 * time.Hour
+	}
+	m := &Manager{
+		store:    store,
+		options:  options,
+		handlers: map[string]HandlerFunc{},
+		now:      time.Now,
+		entries:  map[string]*entry{},
+		wake:     make(chan struct{}),
+		quit:     make(chan struct{}),
+	}
+
+	records, err := store.Load(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("load jobs: %w", err)
+	}
+	for _, record := range records {
+		e := &entry{record: record}
+		m.entries[record.Job.ID] = e
+		job := &e.record.Job
+		if job.Status != StatusRunning {
+			continue
+		}
+		now := m.now()
+		job.UpdatedAt = now
+		if job.Attempts >= job.MaxAttempts {
+			job.Status, job.Error, job.FinishedAt = StatusFailed, "interrupted by a restart", &now
+		} else {
+			job.Status, job.NextRunAt = StatusQueued, nil
+		}
+		if err := store.Save(context.Background(), e.record); err != nil {
+			return nil, fmt.Errorf("recover job %s: %w", job.ID, err)
+		}
+	}
+	return m, nil
+}
+
+// Register installs the handler for a job type. Call it before Start.
+func (m *Manager) Register(jobType string, handler HandlerFunc) {
+	m.handlers[jobType] = handler
+}
+
+// Start launches the workers
+func (m *Manager) Start() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.started || m.stopped {
+		return
+	}
+	m.started = true
+	m.workers.Add(m.options.Workers + 1)
+	for i := 0; i < m.options.Workers; i++ {
+		go m.worker()
+	}
+	go m.janitor()
+}
+
+// Stop cancels the running jobs, waits for the workers and closes the store.
+// Interrupted jobs are queued again so they resume after a restart.
+func (m *Manager) Stop(ctx context.Context) error {
+	m.mu.Lock()
+	if !m.stopped {
+		m.stopped = true
+		close(m.quit)
+		for _, e := range m.entries {
+			if e.cancel != nil {
+				e.cancel(errShutdown)
+			}
+		}
+	}
+	m.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		m.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return m.store.Close()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Submit queues a job of jobType run with credentials. params is stored as JSON.
+func (m *Manager) Submit(ctx context.Context, credentials models.Drive115Credentials, jobType string, params interface{}) (Job, error) {
+	if _, ok := m.handlers[jobType]; !ok {
+		return Job{}, fmt.Errorf("%w: %s", ErrUnknownType, jobType)
+	}
+	raw, err := json.Marshal(params)
+	if err != nil {
+		return Job{}, err
+	}
+	id, err := recordstore.NewID("job_")
+	if err != nil {
+		return Job{}, err
+	}
+	now := m.now()
+	owner := credentials.Key()
+	if credentials.Account != "" {
+		// Keep only the handle; the cookies are looked up for every attempt
+		credentials = models.Drive115Credentials{Account: credentials.Account}
+	}
+	e := &entry{
+		record: Record{
+			Job: Job{
+				ID:          id,
+				Type:        jobType,
+				Status:      StatusQueued,
+				Params:      raw,
+				MaxAttempts: m.options.MaxAttempts,
+				CreatedAt:   now,
+				UpdatedAt:   now,
+			},
+			Owner:       owner,
+			Credentials: credentials,
+		},
+		savedAt: now,
+	}
+
+	m.mu.Lock()
+	stopped := m.stopped
+	m.mu.Unlock()
+	if stopped {
+		return Job{}, ErrStopped
+	}
+	m.saveMu.Lock()
+	err = m.store.Save(ctx, e.record)
+	m.saveMu.Unlock()
+	if err != nil {
+		return Job{}, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[id] = e
+	m.notify()
+	return e.record.Job, nil
+}
+
+// Get returns the job if it belongs to credentials
+func (m *Manager) Get(credentials models.Drive115Credentials, id string) (Job, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, err := m.owned(credentials, id)
+	if err != nil {
+		return Job{}, err
+	}
+	return e.record.Job, nil
+}
+
+// ListFilter narrows List to a job type and status. Empty fields match all.
+type ListFilter struct {
+	Type   string
+	Status Status
+	Offset int
+	Limit  int
+}
+
+// List returns the jobs of credentials, newest first, and the number matching
+// the filter before paging
+func (m *Manager) List(credentials models.Drive115Credentials, filter ListFilter) ([]Job, int) {
+	owner := credentials.Key()
+	m.mu.Lock()
+	matched := make([]Job, 0)
+	for _, e := range m.entries {
+		job := e.record.Job
+		if e.record.Owner != owner || (filter.Type != "" && job.Type != filter.Type) || (filter.Status != "" && job.Status != filter.Status) {
+			continue
+		}
+		matched = append(matched, job)
+	}
+	m.mu.Unlock()
+
+	sort.Slice(matched, func(i, j int) bool {
+		if matched[i].CreatedAt.Equal(matched[j].CreatedAt) {
+			return matched[i].ID > matched[j].ID
+		}
+		return matched[i].CreatedAt.After(matched[j].CreatedAt)
+	})
+	total := len(matched)
+	if filter.Offset >= total {
+		return []Job{}, total
+	}
+	matched = matched[filter.Offset:]
+	if filter.Limit > 0 && len(matched) > filter.Limit {
+		matched = matched[:filter.Limit]
+	}
+	return matched, total
+}
+
+// Cancel stops a queued or running job. A running job reports canceled once
+// its handler returns.
+func (m *Manager) Cancel(credentials models.Drive115Credentials, id string) (Job, error) {
+	m.mu.Lock()
+	e, err := m.owned(credentials, id)
+	if err != nil {
+		m.mu.Unlock()
+		return Job{}, err
+	}
+	job := &e.record.Job
+	switch {
+	case job.Status.Finished():
+		m.mu.Unlock()
+		return Job{}, ErrFinished
+	case job.Status == StatusRunning:
+		e.canceled = true
+		e.cancel(errCanceled)
+		snapshot := *job
+		m.mu.Unlock()
+		return snapshot, nil
+	}
+	now := m.now()
+	job.Status, job.Error, job.FinishedAt, job.NextRunAt, job.UpdatedAt = StatusCanceled, errCanceled.Error(), &now, nil, now
+	snapshot := *job
+	m.mu.Unlock()
+	m.persist(e)
+	return snapshot, nil
+}
+
+// owned returns the entry for id if credentials own it; callers hold m.mu
+func (m *Manager) owned(credentials models.Drive115Credentials, id string) (*entry, error) {
+	e, ok := m.entries[id]
+	if !ok || e.record.Owner != credentials.Key() {
+		return nil, ErrNotFound
+	}
+	return e, nil
+}
+
+// notify wakes every idle worker; callers hold m.mu
+func (m *Manager) notify() {
+	close(m.wake)
+	m.wake = make(chan struct{})
+}
+
+func (m *Manager) worker() {
+	defer m.workers.Done()
+	for {
+		e, ctx, wait, wake := m.claim()
+		if e != nil {
+			m.execute(ctx, e)
+			continue
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-m.quit:
+			timer.Stop()
+			return
+		case <-wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// claim marks the oldest due job running. Without one it returns how long to
+// wait for the next retry and a channel closed when new work arrives.
+func (m *Manager) claim() (*entry, context.Context, time.Duration, <-chan struct{}) {
+	m.mu.Lock()
+	now := m.now()
+	wait := time.Hour
+	var next *entry
+	if !m.stopped {
+		for _, e := range m.entries {
+			job := e.record.Job
+			if job.Status != StatusQueued {
+				continue
+			}
+			if job.NextRunAt != nil && job.NextRunAt.After(now) {
+				wait = min(wait, job.NextRunAt.Sub(now))
+				continue
+			}
+			if next == nil || job.CreatedAt.Before(next.record.Job.CreatedAt) {
+				next = e
+			}
+		}
+	}
+	if next == nil {
+		wake := m.wake
+		m.mu.Unlock()
+		return nil, nil, wait, wake
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	next.cancel = cancel
+	job := &next.record.Job
+	job.Status, job.NextRunAt, job.StartedAt, job.UpdatedAt = StatusRunning, nil, &now, now
+	job.Attempts++
+	next.savedAt = now
+	m.mu.Unlock()
+	m.persist(next)
+	return next, ctx, 0, nil
+}
+
+func (m *Manager) execute(ctx context.Context, e *entry) {
+	m.mu.Lock()
+	run := &Run{
+		ID:          e.record.Job.ID,
+		Type:        e.record.Job.Type,
+		Credentials: e.record.Credentials,
+		Params:      e.record.Job.Params,
+		Attempt:     e.record.Job.Attempts,
+		manager:     m,
+		entry:       e,
+	}
+	m.mu.Unlock()
+
+	var result interface{}
+	credentials, err := m.credentials(ctx, run.Credentials)
+	if err == nil {
+		run.Credentials = credentials
+		result, err = m.call(ctx, run)
+	}
+	var raw json.RawMessage
+	if err == nil && result != nil {
+		if raw, err = json.Marshal(result); err != nil {
+			err = Permanent(fmt.Errorf("encode result: %w", err))
+		}
+	}
+
+	m.mu.Lock()
+	cause := context.Cause(ctx)
+	e.cancel(nil)
+	e.cancel = nil
+	now := m.now()
+	job := &e.record.Job
+	job.UpdatedAt = now
+	switch {
+	case err == nil:
+		job.Status, job.Result, job.Error, job.FinishedAt = StatusSucceeded, raw, "", &now
+	case errors.Is(cause, errShutdown):
+		// Not the job's fault; run this attempt again after the restart
+		job.Status = StatusQueued
+		job.Attempts--
+	case e.canceled || errors.Is(cause, errCanceled):
+		job.Status, job.Error, job.FinishedAt = StatusCanceled, errCanceled.Error(), &now
+	case job.Attempts < job.MaxAttempts && m.retryable(err):
+		next := now.Add(m.retryDelay(job.Attempts))
+		job.Status, job.Error, job.NextRunAt = StatusQueued, err.Error(), &next
+		m.notify()
+	default:
+		job.Status, job.Error, job.FinishedAt = StatusFailed, err.Error(), &now
+	}
+	m.mu.Unlock()
+	m.persist(e)
+}
+
+// credentials returns what an attempt runs with: the stored cookies, or the
+// current cookies of the account behind a stored handle
+func (m *Manager) credentials(ctx context.Context, stored models.Drive115Credentials) (models.Drive115Credentials, error) {
+	if stored.Account == "" {
+		return stored, nil
+	}
+	if m.options.Accounts == nil {
+		return models.Drive115Credentials{}, Permanent(errors.New("account handles are not enabled"))
+	}
+	account, err := m.options.Accounts.Get(ctx, stored.Account)
+	if errors.Is(err, accounts.ErrNotFound) {
+		return models.Drive115Credentials{}, Permanent(fmt.Errorf("account %s no longer exists", stored.Account))
+	}
+	if err != nil {
+		return models.Drive115Credentials{}, fmt.Errorf("load account: %w", err)
+	}
+	credentials := account.Credentials
+	credentials.Account = stored.Account
+	return credentials, nil
+}
+
+// call runs the handler, turning a panic into a permanent failure
+func (m *Manager) call(ctx context.Context, run *Run) (result interface{}, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			log.Printf("job %s (%s) panicked: %v", run.ID, run.Type, recovered)
+			err = Permanent(fmt.Errorf("job panicked: %v", recovered))
+		}
+	}()
+	return m.handlers[run.Type](ctx, run)
+}
+
+func (m *Manager) retryable(err error) bool {
+	var permanent permanentError
+	if errors.As(err, &permanent) {
+		return false
+	}
+	return m.options.Retryable == nil || m.options.Retryable(err)
+}
+
+// retryDelay doubles the base delay for every failed attempt up to the cap
+func (m *Manager) retryDelay(attempts int) time.Duration {
+	delay := m.options.RetryDelay
+	for i := 1; i < attempts && delay < m.options.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, m.options.MaxRetryDelay)
+}
+
+// janitor drops finished jobs once they are past the retention period
+func (m *Manager) janitor() {
+	defer m.workers.Done()
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		m.prune()
+		select {
+		case <-m.quit:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Manager) prune() {
+	m.mu.Lock()
+	cutoff := m.now().Add(-m.options.Retention)
+	var expired []string
+	for id, e := range m.entries {
+		job := e.record.Job
+		if job.Status.Finished() && job.FinishedAt != nil && job.FinishedAt.Before(cutoff) {
+			e.removed = true
+			delete(m.entries, id)
+			expired = append(expired, id)
+		}
+	}
+	m.mu.Unlock()
+
+	m.saveMu.Lock()
+	defer m.saveMu.Unlock()
+	for _, id := range expired {
+		if err := m.store.Delete(context.Background(), id); err != nil {
+			log.Printf("delete expired job %s: %v", id, err)
+		}
+	}
+}
+
+// persist writes the entry's current state. Saves are serialized and each
+// takes its snapshot under the save lock, so a stale state never overwrites
+// a newer one.
+func (m *Manager) persist(e *entry) {
+	m.saveMu.Lock()
+	defer m.saveMu.Unlock()
+	m.mu.Lock()
+	record, removed := e.record, e.removed
+	m.mu.Unlock()
+	if removed {
+		return
+	}
+	if err := m.store.Save(context.Background(), record); err != nil {
+		log.Printf("save job %s: %v", record.Job.ID, err)
+	}
+}
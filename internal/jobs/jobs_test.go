@@ -0,0 +1,246 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"cloud-driver/internal/accounts"
+	"cloud-driver/internal/models"
+	"cloud-driver/internal/recordstore"
+)
+
+const testKey = "test-job-store-key-at-least-32-characters"
+
+var (
+	owner = models.Drive115Credentials{UID: "uid", CID: "cid", SEID: "secret-seid", KID: "kid"}
+	other = models.Drive115Credentials{UID: "uid2", CID: "cid", SEID: "seid", KID: "kid"}
+)
+
+func newTestManager(t *testing.T, store recordstore.Store[Record]) *Manager {
+	t.Helper()
+	m, err := NewManager(store, Options{Workers: 2, RetryDelay: time.Millisecond, MaxRetryDelay: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Stop(context.Background()) })
+	return m
+}
+
+func waitFor(t *testing.T, m *Manager, id string, status Status) Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := m.Get(owner, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if job.Status == status {
+			return job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	job, _ := m.Get(owner, id)
+	t.Fatalf("job %s: status %s, want %s", id, job.Status, status)
+	return Job{}
+}
+
+func TestManagerRetriesUntilSuccess(t *testing.T) {
+	m := newTestManager(t, recordstore.NewMemoryStore[Record]())
+	m.Register("flaky", func(ctx context.Context, run *Run) (interface{}, error) {
+		var params struct{ Succeed int }
+		if err := run.Decode(&params); err != nil {
+			return nil, err
+		}
+		run.Report(Progress{Done: int64(run.Attempt), Total: 3})
+		if run.Attempt < params.Succeed {
+			return nil, errors.New("temporary")
+		}
+		return map[string]int{"attempt": run.Attempt}, nil
+	})
+	m.Register("broken", func(ctx context.Context, run *Run) (interface{}, error) {
+		return nil, Permanent(errors.New("bad input"))
+	})
+	m.Start()
+
+	job, err := m.Submit(context.Background(), owner, "flaky", map[string]int{"Succeed": 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := waitFor(t, m, job.ID, StatusSucceeded)
+	if done.Attempts != 3 || string(done.Result) != `{"attempt":3}` || done.Progress.Done != 3 {
+		t.Fatalf("job = %+v", done)
+	}
+
+	job, err = m.Submit(context.Background(), owner, "broken", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed := waitFor(t, m, job.ID, StatusFailed); failed.Attempts != 1 || failed.Error != "bad input" {
+		t.Fatalf("permanent failure = %+v", failed)
+	}
+
+	if _, err := m.Submit(context.Background(), owner, "missing", nil); !errors.Is(err, ErrUnknownType) {
+		t.Fatalf("unknown type: %v", err)
+	}
+	if _, err := m.Get(other, job.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("other account read the job: %v", err)
+	}
+	if jobs, total := m.List(owner, ListFilter{Status: StatusSucceeded}); total != 1 || jobs[0].Type != "flaky" {
+		t.Fatalf("List = %+v, %d", jobs, total)
+	}
+}
+
+func TestManagerCancel(t *testing.T) {
+	m := newTestManager(t, recordstore.NewMemoryStore[Record]())
+	started := make(chan struct{})
+	m.Register("block", func(ctx context.Context, run *Run) (interface{}, error) {
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	m.Start()
+
+	job, err := m.Submit(context.Background(), owner, "block", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if _, err := m.Cancel(other, job.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("other account canceled the job: %v", err)
+	}
+	if _, err := m.Cancel(owner, job.ID); err != nil {
+		t.Fatal(err)
+	}
+	if canceled := waitFor(t, m, job.ID, StatusCanceled); canceled.Attempts != 1 {
+		t.Fatalf("canceled job retried: %+v", canceled)
+	}
+	if _, err := m.Cancel(owner, job.ID); !errors.Is(err, ErrFinished) {
+		t.Fatalf("second cancel: %v", err)
+	}
+}
+
+func TestManagerResumesAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "jobs.db")
+	store, err := recordstore.NewFileStore[Record](path, testKey, "jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := NewManager(store, Options{Workers: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	started := make(chan struct{})
+	first.Register("work", func(ctx context.Context, run *Run) (interface{}, error) {
+		run.Report(Progress{Done: 7})
+		close(started)
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	first.Start()
+	job, err := first.Submit(context.Background(), owner, "work", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-started
+	if err := first.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("secret-seid")) {
+		t.Fatal("job store contains plaintext credentials")
+	}
+
+	reopened, err := recordstore.NewFileStore[Record](path, testKey, "jobs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := newTestManager(t, reopened)
+	second.Register("work", func(ctx context.Context, run *Run) (interface{}, error) {
+		if run.Credentials.SEID != "secret-seid" {
+			return nil, Permanent(errors.New("credentials lost"))
+		}
+		return run.Checkpoint(), nil
+	})
+	second.Start()
+	done := waitFor(t, second, job.ID, StatusSucceeded)
+	if done.Attempts != 1 || string(done.Result) != `{"done":7}` {
+		t.Fatalf("resumed job = %+v", done)
+	}
+}
+
+// fakeAccounts is an account store holding handles in a map
+type fakeAccounts map[string]models.Drive115Credentials
+
+func (f fakeAccounts) Create(ctx context.Context, credentials models.Drive115Credentials) (*accounts.Account, error) {
+	return nil, errors.New("not supported")
+}
+
+func (f fakeAccounts) Get(ctx context.Context, handle string) (*accounts.Account, error) {
+	credentials, ok := f[handle]
+	if !ok {
+		return nil, accounts.ErrNotFound
+	}
+	return &accounts.Account{Handle: handle, Credentials: credentials}, nil
+}
+
+func (f fakeAccounts) Delete(ctx context.Context, handle string) error {
+	delete(f, handle)
+	return nil
+}
+
+func TestManagerResolvesAccountHandlePerAttempt(t *testing.T) {
+	store := recordstore.NewMemoryStore[Record]()
+	stored := fakeAccounts{"acct_1": owner}
+	m, err := NewManager(store, Options{Workers: 1, RetryDelay: time.Millisecond, MaxRetryDelay: time.Millisecond, Accounts: stored})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Stop(context.Background()) })
+	rekeyed := owner
+	rekeyed.SEID = "new-seid"
+	seen := make(chan string, 3)
+	m.Register("work", func(ctx context.Context, run *Run) (interface{}, error) {
+		seen <- run.Credentials.SEID
+		if run.Attempt == 1 {
+			// The account logs in again before the retry
+			stored["acct_1"] = rekeyed
+			return nil, errors.New("temporary")
+		}
+		return nil, nil
+	})
+	m.Start()
+
+	handle := owner
+	handle.Account = "acct_1"
+	job, err := m.Submit(context.Background(), handle, "work", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, m, job.ID, StatusSucceeded)
+	if first, second := <-seen, <-seen; first != "secret-seid" || second != "new-seid" {
+		t.Fatalf("attempts ran with %q then %q", first, second)
+	}
+	records, err := store.Load(context.Background())
+	if err != nil || len(records) != 1 || records[0].Credentials != (models.Drive115Credentials{Account: "acct_1"}) {
+		t.Fatalf("stored records = %+v, %v", records, err)
+	}
+
+	// Once the account is deleted its jobs fail without running
+	stored.Delete(context.Background(), "acct_1")
+	job, err = m.Submit(context.Background(), handle, "work", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed := waitFor(t, m, job.ID, StatusFailed); failed.Attempts != 1 || failed.Error != "account acct_1 no longer exists" || len(seen) != 0 {
+		t.Fatalf("job of a deleted account = %+v", failed)
+	}
+}
@@ -0,0 +1,16 @@
+package jobs
+
+import "cloud-driver/internal/models"
+
+// Record is a job as persisted, with the owner and the credentials it runs
+// with. Jobs submitted with an account handle store the handle alone.
+type Record struct {
+	Job         Job                        `json:"job"`
+	Owner       string                     `json:"owner"`
+	Credentials models.Drive115Credentials `json:"credentials"`
+}
+
+// RecordID keys the record by its job ID
+func (r Record) RecordID() string {
+	return r.Job.ID
+}
@@ -0,0 +1,227 @@
+package middleware
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Scope names a group of routes an API caller may use
+type Scope string
+
+const (
+	ScopeRead    Scope = "read"
+	ScopeWrite   Scope = "write"
+	ScopeOffline Scope = "offline"
+	ScopeUpload  Scope = "upload"
+	ScopeLogin   Scope = "login"
+)
+
+var knownScopes = map[Scope]bool{ScopeRead: true, ScopeWrite: true, ScopeOffline: true, ScopeUpload: true, ScopeLogin: true}
+
+// HeaderAPIKey carries a static API key or a signed token. Upload routes use
+// Authorization for their session token, so callers send API tokens here.
+const HeaderAPIKey = "X-API-Key"
+
+// APIKey is a static key and the scopes granted to it
+type APIKey struct {
+	Name   string
+	Key    string
+	Scopes []string
+}
+
+// TokenClaims is the payload of a signed bearer token
+type TokenClaims struct {
+	Subject   string   `json:"sub"`
+	Scopes    []string `json:"scopes"`
+	ExpiresAt int64    `json:"exp"`
+}
+
+// Principal is the authenticated caller
+type Principal struct {
+	Name   string
+	Scopes map[Scope]bool
+}
+
+type apiKeyEntry struct {
+	hash      [sha256.Size]byte
+	principal Principal
+}
+
+// Authenticator verifies static API keys and HMAC-signed bearer tokens. With
+// no keys and no token secret configured it allows every request.
+type Authenticator struct {
+	keys        []apiKeyEntry
+	tokenSecret []byte
+	now         func() time.Time
+}
+
+// NewAuthenticator validates keys and scopes from configuration
+func NewAuthenticator(keys []APIKey, tokenSecret string) (*Authenticator, error) {
+	if tokenSecret != "" && len(tokenSecret) < 32 {
+		return nil, fmt.Errorf("auth token secret must be at least 32 characters")
+	}
+	a := &Authenticator{tokenSecret: []byte(tokenSecret), now: time.Now}
+	for _, key := range keys {
+		if len(key.Key) < 16 {
+			return nil, fmt.Errorf("api key %q must be at least 16 characters", key.Name)
+		}
+		// A dot marks a signed token, so such a key could never match
+		if strings.Contains(key.Key, ".") {
+			return nil, fmt.Errorf("api key %q must not contain a dot", key.Name)
+		}
+		scopes, err := parseScopes(key.Scopes)
+		if err != nil {
+			return nil, fmt.Errorf("api key %q: %w", key.Name, err)
+		}
+		a.keys = append(a.keys, apiKeyEntry{hash: sha256.Sum256([]byte(key.Key)), principal: Principal{Name: key.Name, Scopes: scopes}})
+	}
+	return a, nil
+}
+
+// Enabled reports whether any credential source is configured
+func (a *Authenticator) Enabled() bool {
+	return a != nil && (len(a.keys) > 0 || len(a.tokenSecret) > 0)
+}
+
+// Require authenticates the caller and rejects it unless it holds scope
+func (a *Authenticator) Require(scope Scope) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !a.Enabled() {
+				return next(c)
+			}
+			principal, err := a.authenticate(c.Request())
+			if err != nil {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer realm="cloud-driver"`)
+				return err
+			}
+			if !principal.Scopes[scope] {
+				return authError(http.StatusForbidden, "insufficient_scope", fmt.Sprintf("API key %q lacks the %q scope", principal.Name, scope))
+			}
+			c.Set("principal", principal)
+			return next(c)
+		}
+	}
+}
+
+// RequireScope checks a scope inside a handler, for routes whose scope depends
+// on the request body. It passes when authentication is disabled.
+func RequireScope(c echo.Context, scope Scope) error {
+	principal, ok := c.Get("principal").(*Principal)
+	if !ok || principal.Scopes[scope] {
+		return nil
+	}
+	return authError(http.StatusForbidden, "insufficient_scope", fmt.Sprintf("API key %q lacks the %q scope", principal.Name, scope))
+}
+
+func (a *Authenticator) authenticate(r *http.Request) (*Principal, error) {
+	credential := r.Header.Get(HeaderAPIKey)
+	if credential == "" {
+		// Upload session tokens also arrive as bearer tokens but never contain a dot
+		if bearer, ok := strings.CutPrefix(r.Header.Get(echo.HeaderAuthorization), "Bearer "); ok && strings.Contains(bearer, ".") {
+			credential = bearer
+		}
+	}
+	return a.Authenticate(credential)
+}
+
+// Authenticate verifies a static API key or signed token given outside the
+// usual headers, such as a WebDAV basic-auth password
+func (a *Authenticator) Authenticate(credential string) (*Principal, error) {
+	if credential == "" {
+		return nil, authError(http.StatusUnauthorized, "missing_credentials", "An API key or bearer token is required")
+	}
+	if strings.Contains(credential, ".") {
+		return a.verifyToken(credential)
+	}
+
+	hash := sha256.Sum256([]byte(credential))
+	var match *Principal
+	for i := range a.keys {
+		if subtle.ConstantTimeCompare(hash[:], a.keys[i].hash[:]) == 1 {
+			match = &a.keys[i].principal
+		}
+	}
+	if match == nil {
+		return nil, authError(http.StatusUnauthorized, "invalid_credentials", "Invalid API key")
+	}
+	return match, nil
+}
+
+func (a *Authenticator) verifyToken(token string) (*Principal, error) {
+	invalid := authError(http.StatusUnauthorized, "invalid_token", "Invalid bearer token")
+	if len(a.tokenSecret) == 0 {
+		return nil, invalid
+	}
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, invalid
+	}
+	got, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(got, signPayload(a.tokenSecret, payload)) {
+		return nil, invalid
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, invalid
+	}
+	var claims TokenClaims
+	if err := json.Unmarshal(raw, &claims); err != nil {
+		return nil, invalid
+	}
+	if claims.ExpiresAt <= a.now().Unix() {
+		return nil, authError(http.StatusUnauthorized, "token_expired", "Bearer token has expired")
+	}
+	scopes, err := parseScopes(claims.Scopes)
+	if err != nil {
+		return nil, invalid
+	}
+	return &Principal{Name: claims.Subject, Scopes: scopes}, nil
+}
+
+// SignToken mints a bearer token accepted by an Authenticator sharing secret
+func SignToken(secret string, claims TokenClaims) (string, error) {
+	raw, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(raw)
+	return payload + "." + base64.RawURLEncoding.EncodeToString(signPayload([]byte(secret), payload)), nil
+}
+
+func signPayload(secret []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// ValidateScopes reports the first unknown scope name
+func ValidateScopes(names []string) error {
+	_, err := parseScopes(names)
+	return err
+}
+
+func parseScopes(names []string) (map[Scope]bool, error) {
+	scopes := make(map[Scope]bool, len(names))
+	for _, name := range names {
+		scope := Scope(strings.TrimSpace(name))
+		if !knownScopes[scope] {
+			return nil, fmt.Errorf("unknown scope %q", name)
+		}
+		scopes[scope] = true
+	}
+	return scopes, nil
+}
+
+func authError(status int, code, message string) error {
+	return NewError(status, code, message)
+}
@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const testTokenSecret = "test-auth-token-secret-at-least-32-characters"
+
+func TestAuthenticatorRequire(t *testing.T) {
+	auth, err := NewAuthenticator([]APIKey{{Name: "reader", Key: "reader-key-0123456789", Scopes: []string{"read"}}}, testTokenSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uploadToken, _ := SignToken(testTokenSecret, TokenClaims{Subject: "ci", Scopes: []string{"upload"}, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+	expiredToken, _ := SignToken(testTokenSecret, TokenClaims{Subject: "ci", Scopes: []string{"read"}, ExpiresAt: time.Now().Add(-time.Minute).Unix()})
+	forgedToken, _ := SignToken("another-token-secret-of-at-least-32-chars", TokenClaims{Subject: "ci", Scopes: []string{"read"}, ExpiresAt: time.Now().Add(time.Hour).Unix()})
+
+	cases := map[string]struct {
+		scope  Scope
+		header string
+		value  string
+		want   int
+	}{
+		"missing":               {scope: ScopeRead, want: http.StatusUnauthorized},
+		"api key":               {scope: ScopeRead, header: HeaderAPIKey, value: "reader-key-0123456789", want: http.StatusOK},
+		"wrong api key":         {scope: ScopeRead, header: HeaderAPIKey, value: "reader-key-9876543210", want: http.StatusUnauthorized},
+		"missing scope":         {scope: ScopeWrite, header: HeaderAPIKey, value: "reader-key-0123456789", want: http.StatusForbidden},
+		"bearer token":          {scope: ScopeUpload, header: echo.HeaderAuthorization, value: "Bearer " + uploadToken, want: http.StatusOK},
+		"token in api key":      {scope: ScopeUpload, header: HeaderAPIKey, value: uploadToken, want: http.StatusOK},
+		"expired token":         {scope: ScopeRead, header: HeaderAPIKey, value: expiredToken, want: http.StatusUnauthorized},
+		"forged token":          {scope: ScopeRead, header: HeaderAPIKey, value: forgedToken, want: http.StatusUnauthorized},
+		"upload session bearer": {scope: ScopeUpload, header: echo.HeaderAuthorization, value: "Bearer c2Vzc2lvbg", want: http.StatusUnauthorized},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			e := echo.New()
+			e.GET("/", func(c echo.Context) error { return c.NoContent(http.StatusOK) }, auth.Require(tc.scope))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.header != "" {
+				req.Header.Set(tc.header, tc.value)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != tc.want {
+				t.Fatalf("status = %d, want %d, body = %s", rec.Code, tc.want, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestAuthenticatorDisabledAllowsAll(t *testing.T) {
+	auth, err := NewAuthenticator(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := echo.New()
+	e.GET("/", func(c echo.Context) error { return c.NoContent(http.StatusOK) }, auth.Require(ScopeWrite))
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+}
+
+func TestNewAuthenticatorRejectsUnknownScope(t *testing.T) {
+	if _, err := NewAuthenticator([]APIKey{{Name: "k", Key: "reader-key-0123456789", Scopes: []string{"admin"}}}, ""); err == nil {
+		t.Fatal("accepted unknown scope")
+	}
+}
+
+func TestNewAuthenticatorRejectsDottedKey(t *testing.T) {
+	if _, err := NewAuthenticator([]APIKey{{Name: "k", Key: "reader.key.0123456789", Scopes: []string{"read"}}}, ""); err == nil {
+		t.Fatal("accepted a key that reads as a signed token")
+	}
+}
@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+
+	"cloud-driver/internal/accounts"
+	"cloud-driver/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+// CredentialResolver swaps account handles for the credentials kept in the
+// account store. A resolver without a store rejects every handle.
+type CredentialResolver struct {
+	store accounts.Store
+}
+
+// NewCredentialResolver creates a resolver backed by store, which may be nil
+func NewCredentialResolver(store accounts.Store) *CredentialResolver {
+	return &CredentialResolver{store: store}
+}
+
+// Resolve fills credentials from the account store when an account handle is set.
+// Raw credentials are left untouched.
+func (r *CredentialResolver) Resolve(ctx context.Context, credentials *models.Drive115Credentials) error {
+	if credentials.Account == "" {
+		return nil
+	}
+	if r == nil || r.store == nil {
+		return NewError(http.StatusBadRequest, "account_handles_disabled", "Account handles are not enabled on this server")
+	}
+	account, err := r.store.Get(ctx, credentials.Account)
+	if errors.Is(err, accounts.ErrNotFound) {
+		return NewError(http.StatusUnauthorized, "unknown_account", "Unknown account handle")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load account: "+err.Error()).SetInternal(err)
+	}
+	handle := credentials.Account
+	*credentials = account.Credentials
+	credentials.Account = handle
+	return nil
+}
+
+// CredentialMiddleware stores the credential resolver in the context for ValidateRequest
+func CredentialMiddleware(resolver *CredentialResolver) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set("credential_resolver", resolver)
+			return next(c)
+		}
+	}
+}
+
+// ResolveCredentials resolves every top-level Drive115Credentials field of req
+func ResolveCredentials(c echo.Context, req interface{}) error {
+	resolver, _ := c.Get("credential_resolver").(*CredentialResolver)
+	for _, credentials := range credentialFields(req) {
+		if err := resolver.Resolve(c.Request().Context(), credentials); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var credentialsType = reflect.TypeOf(models.Drive115Credentials{})
+
+func credentialFields(req interface{}) []*models.Drive115Credentials {
+	value := reflect.ValueOf(req)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
+		return nil
+	}
+	value = value.Elem()
+	if value.Type() == credentialsType {
+		return []*models.Drive115Credentials{value.Addr().Interface().(*models.Drive115Credentials)}
+	}
+
+	var fields []*models.Drive115Credentials
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Field(i)
+		if field.Type() == credentialsType && field.CanAddr() && value.Type().Field(i).IsExported() {
+			fields = append(fields, field.Addr().Interface().(*models.Drive115Credentials))
+		}
+	}
+	return fields
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"cloud-driver/internal/accounts"
+	"cloud-driver/internal/models"
+
+	"github.com/labstack/echo/v4"
+)
+
+type fakeAccountStore map[string]models.Drive115Credentials
+
+func (f fakeAccountStore) Create(context.Context, models.Drive115Credentials) (*accounts.Account, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (f fakeAccountStore) Get(_ context.Context, handle string) (*accounts.Account, error) {
+	credentials, ok := f[handle]
+	if !ok {
+		return nil, accounts.ErrNotFound
+	}
+	return &accounts.Account{Handle: handle, Credentials: credentials}, nil
+}
+
+func (f fakeAccountStore) Delete(context.Context, string) error { return nil }
+
+func TestCredentialResolver(t *testing.T) {
+	store := fakeAccountStore{"acct_1": {UID: "uid", CID: "cid", SEID: "seid", KID: "kid"}}
+	cases := map[string]struct {
+		resolver *CredentialResolver
+		account  string
+		want     int
+		wantSEID string
+	}{
+		"raw credentials": {resolver: NewCredentialResolver(nil), wantSEID: "raw"},
+		"known handle":    {resolver: NewCredentialResolver(store), account: "acct_1", wantSEID: "seid"},
+		"unknown handle":  {resolver: NewCredentialResolver(store), account: "acct_2", want: http.StatusUnauthorized},
+		"no store":        {resolver: NewCredentialResolver(nil), account: "acct_1", want: http.StatusBadRequest},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			req := models.GetUserRequest{Credentials: models.Drive115Credentials{SEID: "raw", Account: tc.account}}
+			for _, credentials := range credentialFields(&req) {
+				err := tc.resolver.Resolve(context.Background(), credentials)
+				var httpErr *echo.HTTPError
+				if tc.want != 0 {
+					if !errors.As(err, &httpErr) || httpErr.Code != tc.want {
+						t.Fatalf("err = %v, want status %d", err, tc.want)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if req.Credentials.SEID != tc.wantSEID {
+				t.Fatalf("seid = %q, want %q", req.Credentials.SEID, tc.wantSEID)
+			}
+		})
+	}
+}
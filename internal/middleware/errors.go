@@ -0,0 +1,130 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/SheltonZhu/115driver/pkg/driver"
+	"github.com/labstack/echo/v4"
+)
+
+// ErrorResponse is the JSON body of every failed request. Code is a stable
+// machine-readable identifier; Message is meant for humans and may change.
+type ErrorResponse struct {
+	Code    string      `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// NewError returns an HTTP error carrying an explicit error code
+func NewError(status int, code, message string) *echo.HTTPError {
+	return echo.NewHTTPError(status, ErrorResponse{Code: code, Message: message})
+}
+
+type errorMapping struct {
+	err    error
+	status int
+	code   string
+}
+
+// knownErrors maps 115driver sentinels to client-facing statuses. The first
+// match wins, so more specific errors come first.
+var knownErrors = []errorMapping{
+	{driver.ErrNotLogin, http.StatusUnauthorized, "not_logged_in"},
+	{driver.ErrDoesLoggedOut, http.StatusUnauthorized, "logged_out"},
+	{driver.ErrCredentialInvalid, http.StatusUnauthorized, "credential_invalid"},
+	{driver.ErrSessionExited, http.StatusUnauthorized, "session_exited"},
+	{driver.ErrBadCookie, http.StatusUnauthorized, "bad_cookie"},
+	{driver.ErrLoginTwoStepVerify, http.StatusForbidden, "two_step_verification_required"},
+	{driver.ErrPasswordIncorrect, http.StatusForbidden, "password_incorrect"},
+	{driver.ErrQrcodeExpired, http.StatusGone, "qrcode_expired"},
+	{driver.ErrNotExist, http.StatusNotFound, "not_found"},
+	{driver.ErrPickCodeNotExist, http.StatusNotFound, "not_found"},
+	{driver.ErrPickCodeIsNotExistOrHasDeleted, http.StatusNotFound, "not_found"},
+	{driver.ErrDownloadFileNotExistOrHasDeleted, http.StatusNotFound, "not_found"},
+	{driver.ErrExist, http.StatusConflict, "already_exists"},
+	{driver.ErrOfflineTaskExisted, http.StatusConflict, "offline_task_exists"},
+	{driver.ErrOfflineNoTimes, http.StatusTooManyRequests, "offline_quota_exhausted"},
+	{driver.ErrOfflineInvalidLink, http.StatusBadRequest, "invalid_link"},
+	{driver.ErrCyclicMove, http.StatusBadRequest, "cyclic_operation"},
+	{driver.ErrCyclicCopy, http.StatusBadRequest, "cyclic_operation"},
+	{driver.ErrDownloadDirectory, http.StatusBadRequest, "is_directory"},
+	{driver.ErrImportDirectory, http.StatusBadRequest, "is_directory"},
+	{driver.ErrWrongParams, http.StatusBadRequest, "invalid_parameters"},
+	{driver.ErrOrderNotSupport, http.StatusBadRequest, "invalid_parameters"},
+	{driver.ErrUploadTooLarge, http.StatusRequestEntityTooLarge, "upload_too_large"},
+	{driver.ErrDownloadFileTooBig, http.StatusUnprocessableEntity, "file_too_big"},
+	{driver.ErrVideoNotReady, http.StatusConflict, "video_not_ready"},
+	{driver.ErrDownloadEmpty, http.StatusBadGateway, "download_unavailable"},
+	{context.DeadlineExceeded, http.StatusGatewayTimeout, "timeout"},
+	{context.Canceled, 499, "client_closed_request"},
+}
+
+// ClassifyError returns the status and code for a known 115 or context error
+func ClassifyError(err error) (int, string, bool) {
+	for _, known := range knownErrors {
+		if errors.Is(err, known.err) {
+			return known.status, known.code, true
+		}
+	}
+	return 0, "", false
+}
+
+// HTTPErrorHandler writes every error as an ErrorResponse. Handlers attach the
+// underlying service error with SetInternal so known 115 errors override the
+// handler's fallback status.
+func HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	status := http.StatusInternalServerError
+	var response ErrorResponse
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) {
+		status = httpErr.Code
+		switch message := httpErr.Message.(type) {
+		case ErrorResponse:
+			response = message
+		case string:
+			response.Message = message
+		case error:
+			response.Message = message.Error()
+		default:
+			response.Message = http.StatusText(status)
+			response.Details = message
+		}
+		if response.Code == "" && httpErr.Internal != nil {
+			if known, code, ok := ClassifyError(httpErr.Internal); ok {
+				status, response.Code = known, code
+			}
+		}
+	} else if known, code, ok := ClassifyError(err); ok {
+		status, response.Code, response.Message = known, code, err.Error()
+	} else {
+		response.Message = http.StatusText(status)
+	}
+	if response.Code == "" {
+		response.Code = statusCode(status)
+	}
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(status)
+	} else {
+		err = c.JSON(status, response)
+	}
+	if err != nil {
+		c.Logger().Error(err)
+	}
+}
+
+// statusCode derives a fallback code such as "bad_gateway" from an HTTP status
+func statusCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "error"
+	}
+	return strings.ReplaceAll(strings.ToLower(text), " ", "_")
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/SheltonZhu/115driver/pkg/driver"
+	"github.com/labstack/echo/v4"
+)
+
+func TestHTTPErrorHandler(t *testing.T) {
+	cases := map[string]struct {
+		err        error
+		wantStatus int
+		wantCode   string
+	}{
+		"not login":       {err: echo.NewHTTPError(http.StatusInternalServerError, "Failed").SetInternal(driver.GetErr(99)), wantStatus: http.StatusUnauthorized, wantCode: "not_logged_in"},
+		"offline quota":   {err: echo.NewHTTPError(http.StatusInternalServerError, "Failed").SetInternal(driver.ErrOfflineNoTimes), wantStatus: http.StatusTooManyRequests, wantCode: "offline_quota_exhausted"},
+		"task existed":    {err: echo.NewHTTPError(http.StatusInternalServerError, "Failed").SetInternal(driver.ErrOfflineTaskExisted), wantStatus: http.StatusConflict, wantCode: "offline_task_exists"},
+		"upstream":        {err: echo.NewHTTPError(http.StatusBadGateway, "Failed").SetInternal(errors.New("oss")), wantStatus: http.StatusBadGateway, wantCode: "bad_gateway"},
+		"explicit code":   {err: NewError(http.StatusForbidden, "insufficient_scope", "no"), wantStatus: http.StatusForbidden, wantCode: "insufficient_scope"},
+		"plain not found": {err: driver.ErrNotExist, wantStatus: http.StatusNotFound, wantCode: "not_found"},
+		"plain error":     {err: errors.New("boom"), wantStatus: http.StatusInternalServerError, wantCode: "internal_server_error"},
+		"route not found": {err: echo.ErrNotFound, wantStatus: http.StatusNotFound, wantCode: "not_found"},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			e := echo.New()
+			rec := httptest.NewRecorder()
+			HTTPErrorHandler(tc.err, e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec))
+
+			var body ErrorResponse
+			if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != tc.wantStatus || body.Code != tc.wantCode || body.Message == "" {
+				t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
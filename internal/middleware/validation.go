@@ -22,12 +22,23 @@ func ValidationMiddleware() echo.MiddlewareFunc {
 func ValidateRequest(c echo.Context, req interface{}) error {
 	// Bind the request body to the struct
 	if err := c.Bind(req); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, map[string]interface{}{
-			"error":   "Invalid request format",
-			"details": err.Error(),
+		return echo.NewHTTPError(http.StatusBadRequest, ErrorResponse{
+			Code:    "invalid_request",
+			Message: "Invalid request format",
+			Details: err.Error(),
 		})
 	}
 
+	if err := ValidateStruct(c, req); err != nil {
+		return err
+	}
+
+	return ResolveCredentials(c, req)
+}
+
+// ValidateStruct validates a value decoded outside Bind, such as the params of
+// a job request
+func ValidateStruct(c echo.Context, req interface{}) error {
 	// Get the validator from context
 	validator, ok := c.Get("validator").(*validation.Validator)
 	if !ok {
@@ -37,16 +48,17 @@ func ValidateRequest(c echo.Context, req interface{}) error {
 	// Validate the struct
 	if err := validator.ValidateStruct(req); err != nil {
 		if validationErr, ok := err.(validation.ValidationErrors); ok {
-			return echo.NewHTTPError(http.StatusBadRequest, map[string]interface{}{
-				"error":   "Validation failed",
-				"details": validationErr.Errors,
+			return echo.NewHTTPError(http.StatusBadRequest, ErrorResponse{
+				Code:    "validation_failed",
+				Message: "Validation failed",
+				Details: validationErr.Errors,
 			})
 		}
-		return echo.NewHTTPError(http.StatusBadRequest, map[string]interface{}{
-			"error":   "Validation failed",
-			"details": err.Error(),
+		return echo.NewHTTPError(http.StatusBadRequest, ErrorResponse{
+			Code:    "validation_failed",
+			Message: "Validation failed",
+			Details: err.Error(),
 		})
 	}
-
 	return nil
 }
@@ -1,17 +1,36 @@
 package models
 
-// Drive115Credentials represents 115driver credentials passed in requests
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+)
+
+// Drive115Credentials represents 115driver credentials passed in requests.
+// Account is an opaque handle for credentials kept in the server-side account
+// store; when set, the cookie fields may be omitted.
 type Drive115Credentials struct {
-	UID  string `json:"uid" form:"uid" validate:"required,drive115_id,min=1,max=100"`
-	CID  string `json:"cid" form:"cid" validate:"required,drive115_id,min=1,max=100"`
-	SEID string `json:"seid" form:"seid" validate:"required,drive115_id,min=1,max=200"`
-	KID  string `json:"kid" form:"kid" validate:"required,drive115_id,min=1,max=100"`
+	UID     string `json:"uid,omitempty" form:"uid" validate:"required_without=Account,omitempty,drive115_id,min=1,max=100"`
+	CID     string `json:"cid,omitempty" form:"cid" validate:"required_without=Account,omitempty,drive115_id,min=1,max=100"`
+	SEID    string `json:"seid,omitempty" form:"seid" validate:"required_without=Account,omitempty,drive115_id,min=1,max=200"`
+	KID     string `json:"kid,omitempty" form:"kid" validate:"required_without=Account,omitempty,drive115_id,min=1,max=100"`
+	Account string `json:"account,omitempty" form:"account" validate:"omitempty,drive115_id,max=100"`
+}
+
+// Key hashes the cookie fields, so caches and owner checks can tell accounts
+// apart without keeping raw cookies as map keys
+func (c Drive115Credentials) Key() string {
+	sum := sha256.Sum256([]byte(c.UID + "\x00" + c.CID + "\x00" + c.SEID + "\x00" + c.KID))
+	return hex.EncodeToString(sum[:])
 }
 
 // UploadInitRequest negotiates rapid upload or creates a resumable OSS upload.
+// The target directory is given by dir_id or dir_path.
 type UploadInitRequest struct {
 	Credentials Drive115Credentials `json:"credentials" validate:"required"`
 	DirID       string              `json:"dir_id" validate:"omitempty,numeric,max=30"`
+	DirPath     string              `json:"dir_path" validate:"omitempty,max=1024,excluded_with=DirID"`
 	FileName    string              `json:"file_name" validate:"required,min=1,max=255"`
 	FileSize    int64               `json:"file_size" validate:"required,gt=0,lte=167772160000"`
 	SHA1        string              `json:"sha1" validate:"required,len=40,hexadecimal"`
@@ -20,11 +39,81 @@ type UploadInitRequest struct {
 	SignValue   string              `json:"sign_value" validate:"omitempty,len=40,hexadecimal"`
 }
 
-// OfflineDownloadRequest represents a request to add offline download tasks
+// UploadFromSourceRequest uploads a file the server fetches itself
+type UploadFromSourceRequest struct {
+	Credentials Drive115Credentials `json:"credentials" validate:"required"`
+	SourceUploadJobParams
+}
+
+// OfflineDownloadRequest represents a request to add offline download tasks.
+// SkipExistingFiles also skips URIs whose name is taken in the save directory.
 type OfflineDownloadRequest struct {
+	Credentials       Drive115Credentials `json:"credentials" validate:"required"`
+	URLs              []string            `json:"urls" validate:"required,min=1,max=50,urls"`
+	SaveDirID         string              `json:"save_dir_id" validate:"omitempty,numeric"`
+	SaveDirPath       string              `json:"save_dir_path" validate:"omitempty,max=1024,excluded_with=SaveDirID"`
+	SkipExistingFiles bool                `json:"skip_existing_files"`
+}
+
+// OfflineBulkRequest adds any number of offline tasks as a background job
+type OfflineBulkRequest struct {
 	Credentials Drive115Credentials `json:"credentials" validate:"required"`
-	URLs        []string            `json:"urls" validate:"required,min=1,max=50,urls"`
-	SaveDirID   string              `json:"save_dir_id" validate:"omitempty,numeric"`
+	OfflineAddJobParams
+}
+
+// OfflineAddJobParams lists the URIs of a bulk offline add, as urls, as
+// newline-separated text, or both. Unlike /tasks/add, a URI with an
+// unsupported scheme fails on its own instead of failing the request.
+type OfflineAddJobParams struct {
+	URLs              []string `json:"urls" validate:"omitempty,max=10000,dive,max=8192"`
+	Text              string   `json:"text" validate:"omitempty,max=16777216"`
+	SaveDirID         string   `json:"save_dir_id" validate:"omitempty,numeric"`
+	SaveDirPath       string   `json:"save_dir_path" validate:"omitempty,max=1024,excluded_with=SaveDirID"`
+	SkipExistingFiles bool     `json:"skip_existing_files"`
+}
+
+// OfflineAddResult reports what happened to one URI of an add request.
+// Status is added, skipped or failed; Reason says why a URI was skipped and
+// ExistingHash names the task it duplicates.
+type OfflineAddResult struct {
+	URL          string `json:"url"`
+	Status       string `json:"status"`
+	Hash         string `json:"hash,omitempty"`
+	Reason       string `json:"reason,omitempty"`
+	ExistingHash string `json:"existing_hash,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// TorrentTaskRequest holds the form fields sent with a .torrent file. Files
+// lists the metainfo indexes to download and defaults to every file but
+// padding; Preview only parses the torrent and adds no task.
+type TorrentTaskRequest struct {
+	Credentials Drive115Credentials `json:"credentials" validate:"required"`
+	Files       []int               `json:"files" form:"files" validate:"omitempty,max=10000,dive,gte=0"`
+	SaveDirID   string              `json:"save_dir_id" form:"save_dir_id" validate:"omitempty,numeric"`
+	SaveDirPath string              `json:"save_dir_path" form:"save_dir_path" validate:"omitempty,max=1024,excluded_with=SaveDirID"`
+	Preview     bool                `json:"preview" form:"preview"`
+}
+
+// TorrentFile is one file of an uploaded torrent, in metainfo order
+type TorrentFile struct {
+	Index    int    `json:"index"`
+	Path     string `json:"path"`
+	Size     int64  `json:"size"`
+	Padding  bool   `json:"padding,omitempty"`
+	Selected bool   `json:"selected"`
+}
+
+// TorrentTaskResponse describes an uploaded torrent and the files selected
+// from it. Added is set once the offline task exists.
+type TorrentTaskResponse struct {
+	InfoHash      string        `json:"info_hash"`
+	Name          string        `json:"name"`
+	Size          int64         `json:"size"`
+	SelectedSize  int64         `json:"selected_size"`
+	SelectedCount int           `json:"selected_count"`
+	Files         []TorrentFile `json:"files"`
+	Added         bool          `json:"added"`
 }
 
 // TaskListRequest represents a request to list offline tasks
@@ -33,6 +122,112 @@ type TaskListRequest struct {
 	Page        int64               `json:"page" validate:"omitempty,gte=1,lte=1000"`
 }
 
+// TaskQueryRequest lists every offline task across all pages, filtered and
+// sorted on the server. Sort defaults to added, newest first.
+type TaskQueryRequest struct {
+	Credentials Drive115Credentials `json:"credentials" validate:"required"`
+	Status      []string            `json:"status" validate:"omitempty,max=4,dive,oneof=todo running done failed"`
+	Name        string              `json:"name" validate:"omitempty,max=255"`
+	AddedAfter  time.Time           `json:"added_after"`
+	SaveDirID   string              `json:"save_dir_id" validate:"omitempty,numeric,max=30"`
+	SaveDirPath string              `json:"save_dir_path" validate:"omitempty,max=1024,excluded_with=SaveDirID"`
+	Sort        string              `json:"sort" validate:"omitempty,oneof=progress speed added"`
+	Asc         bool                `json:"asc"`
+	Offset      int                 `json:"offset" validate:"omitempty,gte=0"`
+	Limit       int                 `json:"limit" validate:"omitempty,gte=1,lte=1000"`
+}
+
+// TaskQueryResponse is one page of filtered offline tasks
+type TaskQueryResponse struct {
+	Tasks      []OfflineTask `json:"tasks"`
+	Total      int           `json:"total"`
+	Offset     int           `json:"offset"`
+	Limit      int           `json:"limit"`
+	NextOffset *int          `json:"next_offset,omitempty"`
+}
+
+// TaskEventsRequest opens a server-sent event stream of offline task changes
+type TaskEventsRequest struct {
+	Credentials Drive115Credentials `json:"credentials" validate:"required"`
+}
+
+// TaskEventsLinkRequest mints a signed URL for EventSource clients, which
+// cannot send a request body or headers
+type TaskEventsLinkRequest struct {
+	Credentials Drive115Credentials `json:"credentials" validate:"required"`
+	ExpiresIn   int64               `json:"expires_in" validate:"omitempty,gte=60,lte=604800"`
+}
+
+// WebhookCreateRequest subscribes a URL to finished offline tasks. Events
+// defaults to both done and failed.
+type WebhookCreateRequest struct {
+	Credentials Drive115Credentials `json:"credentials" validate:"required"`
+	URL         string              `json:"url" validate:"required,url,max=2048"`
+	Secret      string              `json:"secret" validate:"required,min=16,max=256"`
+	Events      []string            `json:"events" validate:"omitempty,max=2,dive,oneof=done failed"`
+}
+
+// WebhookRequest addresses the caller's webhooks
+type WebhookRequest struct {
+	Credentials Drive115Credentials `json:"credentials" validate:"required"`
+}
+
+// RuleCreateRequest adds a rule that organizes the files of finished offline
+// tasks. Empty criteria match everything.
+type RuleCreateRequest struct {
+	Credentials Drive115Credentials `json:"credentials" validate:"required"`
+	Name        string              `json:"name" validate:"required,max=100"`
+	TaskPattern string              `json:"task_pattern" validate:"omitempty,max=512"`
+	FilePattern string              `json:"file_pattern" validate:"omitempty,max=512"`
+	Extensions  []string            `json:"extensions" validate:"omitempty,max=50,dive,min=1,max=16"`
+	MinSize     int64               `json:"min_size" validate:"gte=0"`
+	MaxSize     int64               `json:"max_size" validate:"gte=0"`
+	Actions     []RuleAction        `json:"actions" validate:"required,min=1,max=10,dive"`
+	DryRun      bool                `json:"dry_run"`
+}
+
+// RuleAction is one step of a rule
+type RuleAction struct {
+	Type          string `json:"type" validate:"required,oneof=move rename delete label"`
+	TargetDirID   string `json:"target_dir_id" validate:"omitempty,numeric,max=30"`
+	TargetDirPath string `json:"target_dir_path" validate:"omitempty,max=1024"`
+	Template      string `json:"template" validate:"omitempty,max=255"`
+	Label         string `json:"label" validate:"omitempty,max=30"`
+}
+
+// RuleRequest addresses the caller's rules
+type RuleRequest struct {
+	Credentials Drive115Credentials `json:"credentials" validate:"required"`
+}
+
+// RuleAuditRequest lists the caller's latest rule actions
+type RuleAuditRequest struct {
+	Credentials Drive115Credentials `json:"credentials" validate:"required"`
+	Limit       int                 `json:"limit" validate:"omitempty,min=1,max=1000"`
+}
+
+// OfflineTask is an offline download task in a stable, normalized shape.
+// Status is one of todo, running, done and failed; StatusText is its label
+// for display. ETA is set by task listings for running tasks that report the
+// time left.
+type OfflineTask struct {
+	InfoHash     string     `json:"info_hash"`
+	Name         string     `json:"name"`
+	Size         int64      `json:"size"`
+	URL          string     `json:"url"`
+	Status       string     `json:"status"`
+	StatusText   string     `json:"status_text"`
+	Percent      float64    `json:"percent"`
+	RateDownload float64    `json:"rate_download"`
+	Peers        int64      `json:"peers"`
+	LeftTime     int64      `json:"left_time"`
+	ETA          *time.Time `json:"eta,omitempty"`
+	FileID       string     `json:"file_id,omitempty"`
+	DirID        string     `json:"dir_id,omitempty"`
+	AddedAt      time.Time  `json:"added_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
 // DeleteTasksRequest represents a request to delete offline tasks
 type DeleteTasksRequest struct {
 	Credentials Drive115Credentials `json:"credentials" validate:"required"`
@@ -51,10 +246,12 @@ type GetUserRequest struct {
 	Credentials Drive115Credentials `json:"credentials" validate:"required"`
 }
 
-// ListFilesRequest represents a request to list files
+// ListFilesRequest represents a request to list files in the directory given
+// by dir_id or path
 type ListFilesRequest struct {
 	Credentials Drive115Credentials `json:"credentials" validate:"required"`
 	DirID       int64               `json:"dir_id" validate:"omitempty,gte=0"`
+	Path        string              `json:"path" validate:"omitempty,max=1024,excluded_with=DirID"`
 	Offset      int64               `json:"offset" validate:"omitempty,gte=0"`
 	Limit       int64               `json:"limit" validate:"omitempty,gte=1,lte=25"`
 }
@@ -88,16 +285,259 @@ type FileSummary struct {
 	Matches bool   `json:"matches_indexed_name"`
 }
 
+// WalkFilesRequest represents a request to recursively list a directory tree
+type WalkFilesRequest struct {
+	Credentials Drive115Credentials `json:"credentials" validate:"required"`
+	DirID       int64               `json:"dir_id" validate:"omitempty,gte=0"`
+	MaxDepth    int                 `json:"max_depth" validate:"omitempty,gte=1,lte=64"`
+	Concurrency int                 `json:"concurrency" validate:"omitempty,gte=1,lte=4"`
+	FilesOnly   bool                `json:"files_only"`
+}
+
+// WalkEntry is one NDJSON line of a directory walk
+type WalkEntry struct {
+	ID          string    `json:"id"`
+	ParentID    string    `json:"parent_id"`
+	Name        string    `json:"name"`
+	Path        string    `json:"path"`
+	IsDirectory bool      `json:"is_directory"`
+	Size        int64     `json:"size"`
+	SHA1        string    `json:"sha1,omitempty"`
+	PickCode    string    `json:"pick_code,omitempty"`
+	Depth       int       `json:"depth"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// WalkSummary is the final NDJSON line of a completed directory walk
+type WalkSummary struct {
+	Done        bool `json:"done"`
+	Files       int  `json:"files"`
+	Directories int  `json:"directories"`
+}
+
+// SearchRequest represents a request to search files with the 115 search API
+type SearchRequest struct {
+	Credentials Drive115Credentials `json:"credentials" validate:"required"`
+	Keyword     string              `json:"keyword" validate:"required_without_all=Suffix Type Star Date,max=255"`
+	DirID       int64               `json:"dir_id" validate:"omitempty,gte=0"`
+	Type        string              `json:"type" validate:"omitempty,oneof=all folder doc image video audio archive"`
+	Suffix      string              `json:"suffix" validate:"omitempty,alphanum,max=16"`
+	Date        string              `json:"date" validate:"omitempty,datetime=2006-01-02"`
+	Star        bool                `json:"star"`
+	Order       string              `json:"order" validate:"omitempty,oneof=file_name file_size user_utime user_ptime"`
+	Asc         bool                `json:"asc"`
+	Offset      int                 `json:"offset" validate:"omitempty,gte=0"`
+	Limit       int                 `json:"limit" validate:"omitempty,gte=1,lte=100"`
+}
+
+// SearchResponse is one page of search results
+type SearchResponse struct {
+	Files       []FileRow `json:"files"`
+	Count       int       `json:"count"`
+	FileCount   int       `json:"file_count"`
+	FolderCount int       `json:"folder_count"`
+	Offset      int       `json:"offset"`
+	Limit       int       `json:"limit"`
+	NextOffset  *int      `json:"next_offset,omitempty"`
+}
+
+// FileRow is a normalized file or directory row
+type FileRow struct {
+	ID          string      `json:"id"`
+	ParentID    string      `json:"parent_id"`
+	Name        string      `json:"name"`
+	IsDirectory bool        `json:"is_directory"`
+	Size        int64       `json:"size"`
+	SHA1        string      `json:"sha1,omitempty"`
+	PickCode    string      `json:"pick_code,omitempty"`
+	Star        bool        `json:"star"`
+	Labels      []FileLabel `json:"labels"`
+	ThumbURL    string      `json:"thumb_url,omitempty"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+}
+
+// RecycleBinListRequest represents a request to list one page of the recycle bin
+type RecycleBinListRequest struct {
+	Credentials Drive115Credentials `json:"credentials" validate:"required"`
+	Offset      int                 `json:"offset" validate:"omitempty,gte=0"`
+	Limit       int                 `json:"limit" validate:"omitempty,gte=1,lte=1000"`
+}
+
+// RecycleBinItem is a deleted file or directory in the recycle bin
+type RecycleBinItem struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	ParentID   string    `json:"parent_id"`
+	ParentName string    `json:"parent_name"`
+	DeletedAt  time.Time `json:"deleted_at"`
+}
+
+// RecycleBinListResponse is one page of the recycle bin
+type RecycleBinListResponse struct {
+	Items      []RecycleBinItem `json:"items"`
+	Offset     int              `json:"offset"`
+	Limit      int              `json:"limit"`
+	NextOffset *int             `json:"next_offset,omitempty"`
+}
+
+// RecycleBinRestoreRequest represents a request to restore recycle bin items
+type RecycleBinRestoreRequest struct {
+	Credentials Drive115Credentials `json:"credentials" validate:"required"`
+	IDs         []string            `json:"ids" validate:"required,min=1,max=1000,dive,numeric,max=30"`
+}
+
+// RecycleBinPurgeRequest represents a request to permanently delete recycle bin
+// items. Emptying the whole bin requires all=true instead of ids.
+type RecycleBinPurgeRequest struct {
+	Credentials Drive115Credentials `json:"credentials" validate:"required"`
+	Password    string              `json:"password" validate:"required,max=64"`
+	IDs         []string            `json:"ids" validate:"required_without=All,excluded_with=All,max=1000,dive,numeric,max=30"`
+	All         bool                `json:"all"`
+}
+
+// MkdirRequest represents a request to create a directory
+type MkdirRequest struct {
+	Credentials Drive115Credentials `json:"credentials" validate:"required"`
+	ParentID    string              `json:"parent_id" validate:"omitempty,numeric,max=30"`
+	Name        string              `json:"name" validate:"required,min=1,max=255"`
+}
+
+// DirPathRequest represents a request addressed by a slash-separated directory path
+type DirPathRequest struct {
+	Credentials Drive115Credentials `json:"credentials" validate:"required"`
+	Path        string              `json:"path" validate:"required,max=1024"`
+}
+
+// DirResponse identifies a directory by ID and, when known, its path
+type DirResponse struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name,omitempty"`
+	ParentID string   `json:"parent_id,omitempty"`
+	Path     string   `json:"path,omitempty"`
+	Created  []string `json:"created,omitempty"`
+}
+
+// MoveFilesRequest represents a request to move files into a directory. Files are given by
+// file_ids or paths, and the directory by target_dir_id or target_dir_path.
+type MoveFilesRequest struct {
+	Credentials   Drive115Credentials `json:"credentials" validate:"required"`
+	FileIDs       []string            `json:"file_ids" validate:"required_without=Paths,excluded_with=Paths,max=1000,dive,numeric,max=30"`
+	Paths         []string            `json:"paths" validate:"omitempty,max=1000,dive,required,max=1024"`
+	TargetDirID   string              `json:"target_dir_id" validate:"required_without=TargetDirPath,omitempty,numeric,max=30"`
+	TargetDirPath string              `json:"target_dir_path" validate:"omitempty,max=1024,excluded_with=TargetDirID"`
+}
+
+// CopyFilesRequest represents a request to copy files into a directory. Files are given by
+// file_ids or paths, and the directory by target_dir_id or target_dir_path.
+type CopyFilesRequest struct {
+	Credentials   Drive115Credentials `json:"credentials" validate:"required"`
+	FileIDs       []string            `json:"file_ids" validate:"required_without=Paths,excluded_with=Paths,max=1000,dive,numeric,max=30"`
+	Paths         []string            `json:"paths" validate:"omitempty,max=1000,dive,required,max=1024"`
+	TargetDirID   string              `json:"target_dir_id" validate:"required_without=TargetDirPath,omitempty,numeric,max=30"`
+	TargetDirPath string              `json:"target_dir_path" validate:"omitempty,max=1024,excluded_with=TargetDirID"`
+}
+
+// RenameFilesRequest represents a request to rename files
+type RenameFilesRequest struct {
+	Credentials Drive115Credentials `json:"credentials" validate:"required"`
+	Files       []RenameFileItem    `json:"files" validate:"required,min=1,max=100,dive"`
+}
+
+// RenameFileItem is a single file rename within a batch, addressed by file_id or path
+type RenameFileItem struct {
+	FileID string `json:"file_id" validate:"required_without=Path,excluded_with=Path,omitempty,numeric,max=30"`
+	Path   string `json:"path" validate:"omitempty,max=1024"`
+	Name   string `json:"name" validate:"required,min=1,max=255"`
+}
+
+// DeleteFilesRequest represents a request to delete files given by file_ids or paths
+type DeleteFilesRequest struct {
+	Credentials Drive115Credentials `json:"credentials" validate:"required"`
+	FileIDs     []string            `json:"file_ids" validate:"required_without=Paths,excluded_with=Paths,max=1000,dive,numeric,max=30"`
+	Paths       []string            `json:"paths" validate:"omitempty,max=1000,dive,required,max=1024"`
+}
+
 // FileInfoRequest represents a request to get file info
 type FileInfoRequest struct {
 	Credentials Drive115Credentials `json:"credentials" validate:"required"`
 	FileID      int64               `json:"file_id" validate:"required,gt=0"`
 }
 
+// FileInfoResponse describes a single file or directory
+type FileInfoResponse struct {
+	ID          string      `json:"id"`
+	ParentID    string      `json:"parent_id"`
+	Name        string      `json:"name"`
+	IsDirectory bool        `json:"is_directory"`
+	Size        int64       `json:"size"`
+	SHA1        string      `json:"sha1,omitempty"`
+	PickCode    string      `json:"pick_code"`
+	Star        bool        `json:"star"`
+	Labels      []FileLabel `json:"labels"`
+	Parents     []DirRef    `json:"parents"`
+	FileCount   *int        `json:"file_count,omitempty"`
+	DirCount    *int        `json:"dir_count,omitempty"`
+	ThumbURL    string      `json:"thumb_url,omitempty"`
+	CreatedAt   time.Time   `json:"created_at"`
+	UpdatedAt   time.Time   `json:"updated_at"`
+}
+
+// FileLabel is a label attached to a file
+type FileLabel struct {
+	ID    string `json:"id"`
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+// DirRef identifies a directory in a parent chain, ordered from the root
+type DirRef struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
 // DownloadRequest represents a request to get download info
 type DownloadRequest struct {
 	Credentials Drive115Credentials `json:"credentials" validate:"required"`
 	FileID      int64               `json:"file_id" validate:"required,gt=0"`
+	UserAgent   string              `json:"user_agent" validate:"omitempty,max=512"`
+}
+
+// DownloadInfoResponse carries a CDN download URL and the headers it is bound to
+type DownloadInfoResponse struct {
+	FileID    string            `json:"file_id"`
+	FileName  string            `json:"file_name"`
+	FileSize  int64             `json:"file_size"`
+	PickCode  string            `json:"pick_code"`
+	URL       string            `json:"url"`
+	UserAgent string            `json:"user_agent"`
+	Headers   map[string]string `json:"headers"`
+}
+
+// StreamRequest represents a request to stream a file's bytes
+type StreamRequest struct {
+	Credentials Drive115Credentials `json:"credentials" validate:"required"`
+	FileID      int64               `json:"file_id" validate:"required,gt=0"`
+	Download    bool                `json:"download" query:"download"`
+}
+
+// StreamLinkRequest represents a request to mint a signed stream link
+type StreamLinkRequest struct {
+	Credentials Drive115Credentials `json:"credentials" validate:"required"`
+	FileID      int64               `json:"file_id" validate:"required,gt=0"`
+	ExpiresIn   int64               `json:"expires_in" validate:"omitempty,gte=60,lte=604800"`
+	MaxUses     int                 `json:"max_uses" validate:"omitempty,gte=1,lte=10000"`
+	BindIP      bool                `json:"bind_ip"`
+	Download    bool                `json:"download"`
+}
+
+// StreamLinkResponse carries a signed stream link
+type StreamLinkResponse struct {
+	URL       string `json:"url"`
+	Token     string `json:"token"`
+	ExpiresAt int64  `json:"expires_at"`
+	MaxUses   int    `json:"max_uses,omitempty"`
 }
 
 // QRCodeStartRequest represents a request to start a QR code session
@@ -138,11 +578,78 @@ type QRCodeLoginRequest struct {
 	Sign string `json:"sign" validate:"required"`
 	Time int64  `json:"time" validate:"required"`
 	App  string `json:"app" validate:"omitempty,oneof=web android ios tv alipaymini wechatmini qandroid"`
+	// Store keeps the credentials server-side and returns an account handle instead
+	Store bool `json:"store"`
 }
 
 // QRCodeLoginResponse represents the response from completing QR login
 type QRCodeLoginResponse struct {
-	Credentials Drive115Credentials `json:"credentials"`
-	Success     bool                `json:"success"`
-	Message     string              `json:"message"`
+	Credentials *Drive115Credentials `json:"credentials,omitempty"`
+	Account     string               `json:"account,omitempty"`
+	Success     bool                 `json:"success"`
+	Message     string               `json:"message"`
+}
+
+// DeleteAccountRequest represents a request to forget a stored account
+type DeleteAccountRequest struct {
+	Account string `json:"account" validate:"required,drive115_id,max=100"`
+}
+
+// JobCreateRequest queues a background job. Params holds the fields of the
+// parameter type matching Type.
+type JobCreateRequest struct {
+	Credentials Drive115Credentials `json:"credentials" validate:"required"`
+	Type        string              `json:"type" validate:"required,oneof=walk move copy delete video_check source_upload offline_add"`
+	Params      json.RawMessage     `json:"params"`
+}
+
+// JobListRequest lists the caller's jobs, newest first
+type JobListRequest struct {
+	Credentials Drive115Credentials `json:"credentials" validate:"required"`
+	Type        string              `json:"type" validate:"omitempty,oneof=walk move copy delete video_check source_upload offline_add"`
+	Status      string              `json:"status" validate:"omitempty,oneof=queued running succeeded failed canceled"`
+	Offset      int                 `json:"offset" validate:"omitempty,gte=0"`
+	Limit       int                 `json:"limit" validate:"omitempty,gte=1,lte=100"`
+}
+
+// JobRequest addresses one of the caller's jobs
+type JobRequest struct {
+	Credentials Drive115Credentials `json:"credentials" validate:"required"`
+}
+
+// WalkJobParams walks the tree below dir_id or dir_path into the job result
+type WalkJobParams struct {
+	DirID       int64  `json:"dir_id" validate:"omitempty,gte=0"`
+	DirPath     string `json:"dir_path" validate:"omitempty,max=1024,excluded_with=DirID"`
+	MaxDepth    int    `json:"max_depth" validate:"omitempty,gte=1,lte=64"`
+	Concurrency int    `json:"concurrency" validate:"omitempty,gte=1,lte=4"`
+	FilesOnly   bool   `json:"files_only"`
+}
+
+// FileBatchJobParams moves, copies or deletes files given by file_ids or
+// paths. Moves and copies need target_dir_id or target_dir_path, and deletes
+// take no target directory.
+type FileBatchJobParams struct {
+	FileIDs       []string `json:"file_ids" validate:"required_without=Paths,excluded_with=Paths,max=10000,dive,numeric,max=30"`
+	Paths         []string `json:"paths" validate:"omitempty,max=10000,dive,required,max=1024"`
+	TargetDirID   string   `json:"target_dir_id" validate:"omitempty,numeric,max=30"`
+	TargetDirPath string   `json:"target_dir_path" validate:"omitempty,max=1024,excluded_with=TargetDirID"`
+}
+
+// VideoCheckJobParams checks the direct files of a directory for videos
+type VideoCheckJobParams struct {
+	DirID       int64  `json:"dir_id" validate:"omitempty,gte=0"`
+	Limit       int64  `json:"limit" validate:"omitempty,gte=1,lte=25"`
+	IndexedName string `json:"indexed_name" validate:"omitempty,min=1,max=255"`
+}
+
+// SourceUploadJobParams uploads a file the server fetches itself, from an
+// HTTP(S) URL or a path below one of the configured local roots. FileName
+// defaults to the name suggested by the source.
+type SourceUploadJobParams struct {
+	URL      string `json:"url" validate:"omitempty,url,max=4096,excluded_with=Path"`
+	Path     string `json:"path" validate:"omitempty,max=4096"`
+	DirID    string `json:"dir_id" validate:"omitempty,numeric,max=30"`
+	DirPath  string `json:"dir_path" validate:"omitempty,max=1024,excluded_with=DirID"`
+	FileName string `json:"file_name" validate:"omitempty,max=255"`
 }
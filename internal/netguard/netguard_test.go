@@ -0,0 +1,48 @@
+package netguard
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPublicOnly(t *testing.T) {
+	errNotAllowed := errors.New("not allowed")
+	control := PublicOnly(errNotAllowed)
+	for address, allowed := range map[string]bool{
+		"93.184.216.34:443":      true,
+		"[2606:4700::1]:443":     true,
+		"127.0.0.1:80":           false,
+		"10.1.2.3:80":            false,
+		"192.168.1.10:80":        false,
+		"169.254.169.254:80":     false,
+		"0.0.0.0:80":             false,
+		"[::1]:80":               false,
+		"[fd00::1]:80":           false,
+		"[fe80::1]:80":           false,
+		"100.64.0.1:80":          false,
+		"100.127.255.254:80":     false,
+		"198.18.0.1:80":          false,
+		"198.19.255.1:80":        false,
+		"172.16.0.1:80":          false,
+		"192.0.0.8:80":           false,
+		"192.0.2.1:80":           false,
+		"203.0.113.9:80":         false,
+		"240.0.0.1:80":           false,
+		"255.255.255.255:80":     false,
+		"224.0.0.251:80":         false,
+		"100.128.0.1:443":        true,
+		"198.20.0.1:443":         true,
+		"[::ffff:127.0.0.1]:80":  false,
+		"[::ffff:8.8.8.8]:443":   true,
+		"[64:ff9b::a00:1]:80":    false,
+		"[64:ff9b::808:808]:443": true,
+		"[2002:7f00:1::1]:80":    false,
+		"[2001:db8::1]:80":       false,
+		"[fe80::1%eth0]:80":      false,
+		"example.com:443":        false,
+	} {
+		if err := control("tcp", address, nil); (err == nil) != allowed || (err != nil && !errors.Is(err, errNotAllowed)) {
+			t.Errorf("%s: got %v, want allowed=%v", address, err, allowed)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package offline
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"cloud-driver/internal/models"
+	"cloud-driver/internal/recordstore"
+)
+
+// resubscribeDelay is the wait before polling an account again after its
+// subscription ended, for example because the login expired
+const resubscribeDelay = time.Minute
+
+// Handler receives the events of an account followed by Followers. ctx ends
+// when the account loses its last reference or the followers stop.
+type Handler func(ctx context.Context, owner string, credentials models.Drive115Credentials, event Event)
+
+// Followers keeps one Follow running per account for a consumer whose stored
+// records reference accounts, such as webhooks or rules. An account is
+// followed while at least one record references it.
+type Followers struct {
+	watcher   *Watcher
+	snapshots recordstore.Store[Snapshot]
+	handle    Handler
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	owners  map[string]*followed
+	ending  map[string]chan struct{}
+	started bool
+}
+
+// followed is an account with its reference count and running follow
+type followed struct {
+	credentials models.Drive115Credentials
+	refs        int
+	cancel      context.CancelFunc
+	done        chan struct{}
+}
+
+// NewFollowers creates followers that pass events to handle and keep the last
+// task list of each account in snapshots. Call Start to begin following.
+func NewFollowers(watcher *Watcher, snapshots recordstore.Store[Snapshot], handle Handler) *Followers {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Followers{
+		watcher:   watcher,
+		snapshots: snapshots,
+		handle:    handle,
+		ctx:       ctx,
+		cancel:    cancel,
+		owners:    map[string]*followed{},
+		ending:    map[string]chan struct{}{},
+	}
+}
+
+// Start follows every referenced account
+func (f *Followers) Start() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.started {
+		return
+	}
+	f.started = true
+	for owner, account := range f.owners {
+		f.follow(owner, account)
+	}
+}
+
+// Stop ends every follow, waits for the handlers to return and closes the
+// snapshot store
+func (f *Followers) Stop() error {
+	f.cancel()
+	f.wg.Wait()
+	return f.snapshots.Close()
+}
+
+// Add references the account of credentials, following it once started
+func (f *Followers) Add(credentials models.Drive115Credentials) {
+	credentials.Account = ""
+	owner := credentials.Key()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	account, ok := f.owners[owner]
+	if !ok {
+		account = &followed{credentials: credentials}
+		f.owners[owner] = account
+	}
+	account.refs++
+	if f.started && account.cancel == nil {
+		f.follow(owner, account)
+	}
+}
+
+// Remove drops a reference to owner. The last one stops following the account
+// and forgets its snapshot, so a later Add starts afresh instead of replaying
+// what finished in between.
+func (f *Followers) Remove(ctx context.Context, owner string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	account, ok := f.owners[owner]
+	if !ok {
+		return nil
+	}
+	if account.refs--; account.refs > 0 {
+		return nil
+	}
+	delete(f.owners, owner)
+	if account.cancel == nil {
+		return f.snapshots.Delete(ctx, owner)
+	}
+	// The follow may still save while it winds down, so it deletes the
+	// snapshot itself once done
+	account.cancel()
+	f.ending[owner] = account.done
+	return nil
+}
+
+// follow starts following owner; callers hold f.mu
+func (f *Followers) follow(owner string, account *followed) {
+	ctx, cancel := context.WithCancel(f.ctx)
+	done := make(chan struct{})
+	previous := f.ending[owner]
+	account.cancel, account.done = cancel, done
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+		if previous != nil {
+			// A removed follow of the same account must finish first
+			<-previous
+		}
+		f.watcher.Follow(ctx, account.credentials, resubscribeDelay, f.snapshots, func(event Event) {
+			f.handle(ctx, owner, account.credentials, event)
+		})
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.ending[owner] == done {
+			delete(f.ending, owner)
+		}
+		if f.ctx.Err() == nil && f.owners[owner] != account {
+			if err := f.snapshots.Delete(context.Background(), owner); err != nil {
+				log.Printf("delete offline task snapshot: %v", err)
+			}
+		}
+		close(done)
+	}()
+}
@@ -0,0 +1,76 @@
+package offline
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud-driver/internal/models"
+	"cloud-driver/internal/recordstore"
+)
+
+func TestFollowersFollowWhileReferenced(t *testing.T) {
+	credentials := models.Drive115Credentials{UID: "uid", CID: "cid", SEID: "seid", KID: "kid"}
+	lister := &fakeLister{tasks: []models.OfflineTask{{InfoHash: "a", Status: statusRunning}}}
+	watcher := NewWatcher(lister, Options{MinInterval: time.Millisecond, ActiveInterval: time.Millisecond, MaxInterval: time.Millisecond})
+	defer watcher.Close()
+	snapshots := recordstore.NewMemoryStore[Snapshot]()
+	events := make(chan Event, 16)
+	followers := NewFollowers(watcher, snapshots, func(_ context.Context, owner string, _ models.Drive115Credentials, event Event) {
+		if owner == credentials.Key() {
+			events <- event
+		}
+	})
+
+	// waitSnapshots polls until the store holds want snapshots
+	waitSnapshots := func(want int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			saved, _ := snapshots.Load(context.Background())
+			if len(saved) == want {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("snapshots = %d, want %d", len(saved), want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	followers.Add(credentials)
+	handle := credentials
+	handle.Account = "acct_x"
+	followers.Add(handle)
+	followers.Start()
+	waitSnapshots(1)
+
+	// One reference is left, so the account is still followed
+	if err := followers.Remove(context.Background(), credentials.Key()); err != nil {
+		t.Fatal(err)
+	}
+	lister.set([]models.OfflineTask{{InfoHash: "a", Status: statusDone, Percent: 100}}, nil)
+	select {
+	case event := <-events:
+		if event.Type != EventDone || event.Task.InfoHash != "a" {
+			t.Fatalf("event = %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event while referenced")
+	}
+
+	// The last reference stops following and forgets the snapshot
+	if err := followers.Remove(context.Background(), credentials.Key()); err != nil {
+		t.Fatal(err)
+	}
+	waitSnapshots(0)
+	followers.mu.Lock()
+	owners := len(followers.owners)
+	followers.mu.Unlock()
+	if owners != 0 {
+		t.Fatalf("owners = %d after the last reference", owners)
+	}
+	if err := followers.Stop(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,438 @@
+// Package offline watches the offline download task list of 115 accounts and
+// turns successive snapshots into task events. One poller runs per account,
+// shared by all of its subscribers, and stops with the last of them.
+package offline
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"cloud-driver/internal/models"
+	"cloud-driver/internal/recordstore"
+)
+
+// Event types. A subscriber first receives a snapshot of every task and then
+// the changes between polls. Error events report a failed poll; the poller
+// keeps trying unless the error is fatal, in which case the subscription ends.
+const (
+	EventSnapshot = "snapshot"
+	EventAdd      = "add"
+	EventProgress = "progress"
+	EventDone     = "done"
+	EventFailed   = "failed"
+	EventRemoved  = "removed"
+	EventError    = "error"
+)
+
+// Task states as reported in models.OfflineTask
+const (
+	statusTodo    = "todo"
+	statusRunning = "running"
+	statusDone    = "done"
+	statusFailed  = "failed"
+)
+
+// ErrClosed is returned when subscribing to a closed watcher
+var ErrClosed = errors.New("offline task watcher closed")
+
+// Lister reads every offline task of an account
+type Lister interface {
+	ListAllOfflineTasks(ctx context.Context, credentials models.Drive115Credentials) ([]models.OfflineTask, error)
+}
+
+// Event is one change to an account's offline tasks
+type Event struct {
+	Type  string               `json:"type"`
+	Task  *models.OfflineTask  `json:"task,omitempty"`
+	Tasks []models.OfflineTask `json:"tasks,omitempty"`
+	Error string               `json:"error,omitempty"`
+	Time  time.Time            `json:"time"`
+}
+
+// Options tunes polling. Zero values take the defaults noted per field.
+type Options struct {
+	// MinInterval is the delay after a poll that saw changes (5s)
+	MinInterval time.Duration
+	// ActiveInterval caps the delay while tasks are queued or downloading (15s)
+	ActiveInterval time.Duration
+	// MaxInterval caps the delay when nothing is downloading or polls fail (60s)
+	MaxInterval time.Duration
+	// Buffer is the number of events a subscriber may fall behind before it
+	// is dropped (256)
+	Buffer int
+	// Fatal reports errors that end every subscription of the account, such
+	// as expired credentials
+	Fatal func(error) bool
+}
+
+// Watcher shares one poller per account among its subscribers
+type Watcher struct {
+	lister  Lister
+	options Options
+	now     func() time.Time
+
+	mu      sync.Mutex
+	pollers map[string]*poller
+	closed  bool
+}
+
+type poller struct {
+	key         string
+	credentials models.Drive115Credentials
+	cancel      context.CancelFunc
+	subs        map[*Subscription]struct{}
+	tasks       map[string]models.OfflineTask
+	snapshot    []models.OfflineTask
+	ready       bool
+}
+
+// Subscription receives the events of one account until it is closed. The
+// channel is closed when the subscriber falls too far behind, the account
+// fails fatally or the watcher closes; subscribe again to resume from a
+// fresh snapshot.
+type Subscription struct {
+	events  chan Event
+	watcher *Watcher
+	poller  *poller
+	ready   bool
+	closed  bool
+}
+
+// NewWatcher creates a watcher that polls through lister
+func NewWatcher(lister Lister, options Options) *Watcher {
+	if options.MinInterval <= 0 {
+		options.MinInterval = 5 * time.Second
+	}
+	if options.ActiveInterval <= 0 {
+		options.ActiveInterval = 15 * time.Second
+	}
+	if options.MaxInterval <= 0 {
+		options.MaxInterval = time.Minute
+	}
+	if options.Buffer <= 0 {
+		options.Buffer = 256
+	}
+	return &Watcher{lister: lister, options: options, now: time.Now, pollers: map[string]*poller{}}
+}
+
+// Subscribe starts receiving the task events of credentials
+func (w *Watcher) Subscribe(credentials models.Drive115Credentials) (*Subscription, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil, ErrClosed
+	}
+	credentials.Account = ""
+	key := credentials.Key()
+	p, ok := w.pollers[key]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		p = &poller{key: key, credentials: credentials, cancel: cancel, subs: map[*Subscription]struct{}{}}
+		w.pollers[key] = p
+		go w.run(ctx, p)
+	}
+	sub := &Subscription{events: make(chan Event, w.options.Buffer), watcher: w, poller: p}
+	p.subs[sub] = struct{}{}
+	if p.ready {
+		sub.ready = true
+		sub.events <- Event{Type: EventSnapshot, Tasks: p.snapshot, Time: w.now()}
+	}
+	return sub, nil
+}
+
+// Events returns the subscription's event channel
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Close ends the subscription; the poller stops with its last subscriber
+func (s *Subscription) Close() {
+	s.watcher.mu.Lock()
+	defer s.watcher.mu.Unlock()
+	s.watcher.drop(s)
+}
+
+// Close ends every subscription and stops all pollers
+func (w *Watcher) Close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	for _, p := range w.pollers {
+		for sub := range p.subs {
+			w.drop(sub)
+		}
+	}
+}
+
+// drop closes a subscription and stops its poller when it was the last one;
+// callers hold w.mu
+func (w *Watcher) drop(sub *Subscription) {
+	if sub.closed {
+		return
+	}
+	sub.closed = true
+	close(sub.events)
+	p := sub.poller
+	delete(p.subs, sub)
+	if len(p.subs) == 0 {
+		p.cancel()
+		if w.pollers[p.key] == p {
+			delete(w.pollers, p.key)
+		}
+	}
+}
+
+func (w *Watcher) run(ctx context.Context, p *poller) {
+	interval := w.options.MinInterval
+	for {
+		tasks, err := w.lister.ListAllOfflineTasks(ctx, p.credentials)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			fatal := w.options.Fatal != nil && w.options.Fatal(err)
+			w.publishError(p, err, fatal)
+			if fatal {
+				return
+			}
+			interval = min(interval*2, w.options.MaxInterval)
+		} else {
+			changed, active := w.publish(p, tasks)
+			limit := w.options.MaxInterval
+			if active {
+				limit = w.options.ActiveInterval
+			}
+			if changed {
+				interval = w.options.MinInterval
+			} else {
+				interval = min(interval*2, limit)
+			}
+			interval = min(interval, limit)
+		}
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// publish diffs the poll against the last one and delivers the events. It
+// reports whether anything changed and whether any task is still pending.
+func (w *Watcher) publish(p *poller, tasks []models.OfflineTask) (changed, active bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := w.now()
+	var events []Event
+	if p.ready {
+		events = Diff(p.tasks, tasks, now)
+	}
+	p.tasks = make(map[string]models.OfflineTask, len(tasks))
+	for _, task := range tasks {
+		p.tasks[TaskKey(task)] = task
+		active = active || task.Status == statusTodo || task.Status == statusRunning
+	}
+	p.snapshot, p.ready = tasks, true
+
+	for sub := range p.subs {
+		if !sub.ready {
+			sub.ready = true
+			w.send(sub, Event{Type: EventSnapshot, Tasks: tasks, Time: now})
+			continue
+		}
+		for _, event := range events {
+			if !w.send(sub, event) {
+				break
+			}
+		}
+	}
+	return len(events) > 0, active
+}
+
+func (w *Watcher) publishError(p *poller, err error, fatal bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	event := Event{Type: EventError, Error: err.Error(), Time: w.now()}
+	for sub := range p.subs {
+		if w.send(sub, event) && fatal {
+			w.drop(sub)
+		}
+	}
+}
+
+// send delivers without blocking the poller, dropping a subscriber whose
+// buffer is full; callers hold w.mu
+func (w *Watcher) send(sub *Subscription, event Event) bool {
+	if sub.closed {
+		return false
+	}
+	select {
+	case sub.events <- event:
+		return true
+	default:
+		w.drop(sub)
+		return false
+	}
+}
+
+// Snapshot is the task list of an account as a follower last handled it
+type Snapshot struct {
+	Owner string               `json:"owner"`
+	Tasks []models.OfflineTask `json:"tasks"`
+}
+
+// RecordID keys the snapshot by the account's credential key
+func (s Snapshot) RecordID() string {
+	return s.Owner
+}
+
+// Follow passes the events of credentials to handle until ctx is done or the
+// watcher closes. Unlike a single subscription it outlives being dropped: it
+// subscribes again after retryDelay and reports what changed in between, so
+// consumers that act on completion see every task that finished. Snapshots
+// only seed that state and are not passed on. With snapshots set the state is
+// saved after every handled change and restored on the next Follow, so tasks
+// that finished while the process was down are reported too.
+func (w *Watcher) Follow(ctx context.Context, credentials models.Drive115Credentials, retryDelay time.Duration, snapshots recordstore.Store[Snapshot], handle func(Event)) {
+	owner := credentials.Key()
+	known := map[string]models.OfflineTask{}
+	synced := false
+	if snapshots != nil {
+		if tasks, ok := loadSnapshot(ctx, snapshots, owner); ok {
+			known, synced = indexTasks(tasks), true
+		}
+	}
+	save := func() {
+		if snapshots == nil {
+			return
+		}
+		tasks := make([]models.OfflineTask, 0, len(known))
+		for _, task := range known {
+			tasks = append(tasks, task)
+		}
+		if err := snapshots.Save(context.WithoutCancel(ctx), Snapshot{Owner: owner, Tasks: tasks}); err != nil {
+			log.Printf("save offline task snapshot: %v", err)
+		}
+	}
+
+	for {
+		subscription, err := w.Subscribe(credentials)
+		if err != nil {
+			return
+		}
+		stop := context.AfterFunc(ctx, subscription.Close)
+		for event := range subscription.Events() {
+			switch event.Type {
+			case EventSnapshot:
+				var events []Event
+				if synced {
+					events = Diff(known, event.Tasks, event.Time)
+				}
+				known, synced = indexTasks(event.Tasks), true
+				for _, change := range events {
+					handle(change)
+				}
+				// Saved after handling, so a crash repeats events rather than losing them
+				save()
+				continue
+			case EventRemoved:
+				delete(known, TaskKey(*event.Task))
+			case EventError:
+			default:
+				known[TaskKey(*event.Task)] = *event.Task
+			}
+			handle(event)
+			// Progress alone is not worth a write; a stale percentage only
+			// repeats a progress event after a restart
+			if event.Type != EventProgress && event.Type != EventError {
+				save()
+			}
+		}
+		stop()
+
+		timer := time.NewTimer(retryDelay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// loadSnapshot returns the saved task list of owner, if any
+func loadSnapshot(ctx context.Context, snapshots recordstore.Store[Snapshot], owner string) ([]models.OfflineTask, bool) {
+	saved, err := snapshots.Load(ctx)
+	if err != nil {
+		log.Printf("load offline task snapshots: %v", err)
+		return nil, false
+	}
+	for _, snapshot := range saved {
+		if snapshot.Owner == owner {
+			return snapshot.Tasks, true
+		}
+	}
+	return nil, false
+}
+
+func indexTasks(tasks []models.OfflineTask) map[string]models.OfflineTask {
+	index := make(map[string]models.OfflineTask, len(tasks))
+	for _, task := range tasks {
+		index[TaskKey(task)] = task
+	}
+	return index
+}
+
+// Diff returns the events that turn previous into current. Tasks that appear
+// already finished report add followed by done or failed, so consumers that
+// act on completion never miss a task that finished between two polls.
+func Diff(previous map[string]models.OfflineTask, current []models.OfflineTask, now time.Time) []Event {
+	var events []Event
+	seen := make(map[string]bool, len(current))
+	for i := range current {
+		task := current[i]
+		key := TaskKey(task)
+		seen[key] = true
+		old, existed := previous[key]
+		finished := task.Status == statusDone || task.Status == statusFailed
+		switch {
+		case !existed:
+			events = append(events, Event{Type: EventAdd, Task: &task, Time: now})
+			if finished {
+				events = append(events, Event{Type: finishEvent(task), Task: &task, Time: now})
+			}
+		case finished && old.Status != task.Status:
+			events = append(events, Event{Type: finishEvent(task), Task: &task, Time: now})
+		case old != task:
+			events = append(events, Event{Type: EventProgress, Task: &task, Time: now})
+		}
+	}
+	for key, task := range previous {
+		if !seen[key] {
+			task := task
+			events = append(events, Event{Type: EventRemoved, Task: &task, Time: now})
+		}
+	}
+	return events
+}
+
+func finishEvent(task models.OfflineTask) string {
+	if task.Status == statusFailed {
+		return EventFailed
+	}
+	return EventDone
+}
+
+// TaskKey identifies a task by its info hash, falling back to its URL
+func TaskKey(task models.OfflineTask) string {
+	if task.InfoHash != "" {
+		return task.InfoHash
+	}
+	return "url:" + task.URL
+}
@@ -0,0 +1,182 @@
+package offline
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud-driver/internal/models"
+	"cloud-driver/internal/recordstore"
+)
+
+func TestDiff(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	previous := map[string]models.OfflineTask{
+		"a": {InfoHash: "a", Status: statusRunning, Percent: 10},
+		"b": {InfoHash: "b", Status: statusRunning, Percent: 50},
+		"c": {InfoHash: "c", Status: statusRunning, Percent: 90},
+		"d": {InfoHash: "d", Status: statusTodo},
+		"e": {InfoHash: "e", Status: statusDone, Percent: 100},
+	}
+	current := []models.OfflineTask{
+		{InfoHash: "a", Status: statusRunning, Percent: 20},
+		{InfoHash: "b", Status: statusDone, Percent: 100},
+		{InfoHash: "c", Status: statusFailed, Percent: 90},
+		{InfoHash: "e", Status: statusDone, Percent: 100},
+		{InfoHash: "f", Status: statusTodo},
+		{InfoHash: "g", Status: statusDone, Percent: 100},
+	}
+	var got []string
+	for _, event := range Diff(previous, current, now) {
+		got = append(got, event.Type+":"+event.Task.InfoHash)
+	}
+	want := []string{"progress:a", "done:b", "failed:c", "add:f", "add:g", "done:g", "removed:d"}
+	if len(got) != len(want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("events = %v, want %v", got, want)
+		}
+	}
+}
+
+type fakeLister struct {
+	mu    sync.Mutex
+	calls int
+	tasks []models.OfflineTask
+	err   error
+}
+
+func (f *fakeLister) ListAllOfflineTasks(context.Context, models.Drive115Credentials) ([]models.OfflineTask, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	return append([]models.OfflineTask(nil), f.tasks...), f.err
+}
+
+func (f *fakeLister) set(tasks []models.OfflineTask, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tasks, f.err = tasks, err
+}
+
+func next(t *testing.T, sub *Subscription) Event {
+	t.Helper()
+	select {
+	case event, ok := <-sub.Events():
+		if !ok {
+			t.Fatal("subscription closed")
+		}
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event")
+	}
+	return Event{}
+}
+
+func TestWatcherSharesOnePollerPerAccount(t *testing.T) {
+	lister := &fakeLister{tasks: []models.OfflineTask{{InfoHash: "a", Status: statusRunning}}}
+	errExpired := errors.New("expired")
+	watcher := NewWatcher(lister, Options{
+		MinInterval: time.Millisecond, ActiveInterval: time.Millisecond, MaxInterval: time.Millisecond,
+		Fatal: func(err error) bool { return errors.Is(err, errExpired) },
+	})
+	defer watcher.Close()
+	credentials := models.Drive115Credentials{UID: "uid", CID: "cid", SEID: "seid", KID: "kid"}
+
+	first, err := watcher.Subscribe(credentials)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event := next(t, first); event.Type != EventSnapshot || len(event.Tasks) != 1 {
+		t.Fatalf("first event = %+v", event)
+	}
+	second, err := watcher.Subscribe(models.Drive115Credentials{UID: "uid", CID: "cid", SEID: "seid", KID: "kid", Account: "acct_x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event := next(t, second); event.Type != EventSnapshot {
+		t.Fatalf("late subscriber got %+v", event)
+	}
+	watcher.mu.Lock()
+	pollers := len(watcher.pollers)
+	watcher.mu.Unlock()
+	if pollers != 1 {
+		t.Fatalf("pollers = %d, want 1", pollers)
+	}
+
+	lister.set([]models.OfflineTask{{InfoHash: "a", Status: statusDone, Percent: 100}}, nil)
+	for _, sub := range []*Subscription{first, second} {
+		if event := next(t, sub); event.Type != EventDone || event.Task.InfoHash != "a" {
+			t.Fatalf("event = %+v", event)
+		}
+	}
+
+	second.Close()
+	lister.set(nil, errExpired)
+	if event := next(t, first); event.Type != EventError {
+		t.Fatalf("event = %+v", event)
+	}
+	if _, ok := <-first.Events(); ok {
+		t.Fatal("subscription survived a fatal error")
+	}
+	watcher.mu.Lock()
+	defer watcher.mu.Unlock()
+	if len(watcher.pollers) != 0 {
+		t.Fatal("poller kept running without subscribers")
+	}
+}
+
+func TestFollowReportsTasksFinishedWhileStopped(t *testing.T) {
+	credentials := models.Drive115Credentials{UID: "uid", CID: "cid", SEID: "seid", KID: "kid"}
+	lister := &fakeLister{tasks: []models.OfflineTask{{InfoHash: "a", Status: statusRunning}}}
+	snapshots := recordstore.NewMemoryStore[Snapshot]()
+	options := Options{MinInterval: time.Millisecond, ActiveInterval: time.Millisecond, MaxInterval: time.Millisecond}
+
+	// follow runs Follow on a fresh watcher, as after a restart, until stop
+	follow := func() (<-chan Event, func()) {
+		watcher := NewWatcher(lister, options)
+		ctx, cancel := context.WithCancel(context.Background())
+		events := make(chan Event, 16)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			watcher.Follow(ctx, credentials, time.Millisecond, snapshots, func(event Event) { events <- event })
+		}()
+		return events, func() {
+			cancel()
+			<-done
+			watcher.Close()
+		}
+	}
+
+	_, stop := follow()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		saved, _ := snapshots.Load(context.Background())
+		if len(saved) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("first snapshot was not saved")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	stop()
+
+	// The task finishes while nothing follows the account
+	lister.set([]models.OfflineTask{{InfoHash: "a", Status: statusDone, Percent: 100}}, nil)
+	events, stop := follow()
+	defer stop()
+	select {
+	case event := <-events:
+		if event.Type != EventDone || event.Task.InfoHash != "a" {
+			t.Fatalf("event after restart = %+v", event)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("task finished while stopped was not reported")
+	}
+}
@@ -0,0 +1,223 @@
+// Package recordstore persists JSON records by ID. FileStore keeps each
+// record under its own key in a bbolt file, sealed with AES-GCM since records
+// carry 115 credentials, so saving one record never rewrites the others.
+package recordstore
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"cloud-driver/internal/secretbox"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Record is a value stored under the ID it reports
+type Record interface {
+	RecordID() string
+}
+
+// Store persists records so they survive a restart
+type Store[T Record] interface {
+	// Load returns every stored record
+	Load(ctx context.Context) ([]T, error)
+	// Save creates or replaces the record with the same ID
+	Save(ctx context.Context, record T) error
+	// Delete forgets a record; deleting an unknown ID is not an error
+	Delete(ctx context.Context, id string) error
+	// Close releases the store once its owner has stopped
+	Close() error
+}
+
+// Open opens the file store at path, or returns an in-memory store when path
+// is empty
+func Open[T Record](path, key, name string) (Store[T], error) {
+	if path == "" {
+		return NewMemoryStore[T](), nil
+	}
+	store, err := NewFileStore[T](path, key, name)
+	if err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// OpenIn opens the store called name next to parent: in the same file when
+// parent is a FileStore, in memory otherwise. Closing it leaves the file to
+// parent.
+func OpenIn[T, P Record](parent Store[P], name string) (Store[T], error) {
+	file, ok := parent.(*FileStore[P])
+	if !ok {
+		return NewMemoryStore[T](), nil
+	}
+	store, err := openBucket[T](file.db, file.box, name)
+	if err != nil {
+		return nil, fmt.Errorf("open %s store: %w", name, err)
+	}
+	store.shared = true
+	return store, nil
+}
+
+// MemoryStore keeps records in memory only, so they do not survive a restart
+type MemoryStore[T Record] struct {
+	mu      sync.Mutex
+	records map[string]T
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore[T Record]() *MemoryStore[T] {
+	return &MemoryStore[T]{records: map[string]T{}}
+}
+
+// Load returns every stored record
+func (s *MemoryStore[T]) Load(context.Context) ([]T, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	records := make([]T, 0, len(s.records))
+	for _, record := range s.records {
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// Save creates or replaces the record with the same ID
+func (s *MemoryStore[T]) Save(_ context.Context, record T) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[record.RecordID()] = record
+	return nil
+}
+
+// Delete forgets a record; deleting an unknown ID is not an error
+func (s *MemoryStore[T]) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, id)
+	return nil
+}
+
+// Close does nothing
+func (s *MemoryStore[T]) Close() error {
+	return nil
+}
+
+// FileStore keeps records in a bbolt file, one sealed value per ID
+type FileStore[T Record] struct {
+	db      *bolt.DB
+	box     *secretbox.Box
+	bucket  []byte
+	purpose string
+	shared  bool
+}
+
+// NewFileStore opens or creates the bbolt file at path. name labels the
+// store in errors and binds its sealed values, so records cannot be moved
+// between stores or between IDs.
+func NewFileStore[T Record](path, key, name string) (*FileStore[T], error) {
+	box, err := secretbox.New(key)
+	if err != nil {
+		return nil, fmt.Errorf("%s store key: %w", name, err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("create %s store directory: %w", name, err)
+	}
+	// The file is locked while open, so a second server fails instead of hanging
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open %s store %s: %w", name, path, err)
+	}
+	store, err := openBucket[T](db, box, name)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("open %s store %s: %w", name, path, err)
+	}
+	return store, nil
+}
+
+func openBucket[T Record](db *bolt.DB, box *secretbox.Box, name string) (*FileStore[T], error) {
+	bucket := []byte(name)
+	if err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(bucket)
+		return err
+	}); err != nil {
+		return nil, err
+	}
+	return &FileStore[T]{db: db, box: box, bucket: bucket, purpose: "cloud-driver " + name + " store v2"}, nil
+}
+
+// Load decrypts every stored record
+func (s *FileStore[T]) Load(context.Context) ([]T, error) {
+	var records []T
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.bucket).ForEach(func(key, sealed []byte) error {
+			plain, err := s.box.Open(sealed, s.additionalData(string(key)))
+			if err != nil {
+				return fmt.Errorf("decrypt %s record %s: wrong key or corrupted store", s.bucket, key)
+			}
+			var record T
+			if err := json.Unmarshal(plain, &record); err != nil {
+				return fmt.Errorf("decode %s record %s: %w", s.bucket, key, err)
+			}
+			records = append(records, record)
+			return nil
+		})
+	})
+	return records, err
+}
+
+// Save creates or replaces the record with the same ID
+func (s *FileStore[T]) Save(_ context.Context, record T) error {
+	id := record.RecordID()
+	plain, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	sealed, err := s.box.Seal(plain, s.additionalData(id))
+	if err != nil {
+		return err
+	}
+	if err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.bucket).Put([]byte(id), sealed)
+	}); err != nil {
+		return fmt.Errorf("write %s store: %w", s.bucket, err)
+	}
+	return nil
+}
+
+// Delete forgets a record; deleting an unknown ID is not an error
+func (s *FileStore[T]) Delete(_ context.Context, id string) error {
+	if err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(s.bucket).Delete([]byte(id))
+	}); err != nil {
+		return fmt.Errorf("write %s store: %w", s.bucket, err)
+	}
+	return nil
+}
+
+// Close releases the file, unless the store was opened with OpenIn
+func (s *FileStore[T]) Close() error {
+	if s.shared {
+		return nil
+	}
+	return s.db.Close()
+}
+
+func (s *FileStore[T]) additionalData(id string) []byte {
+	return []byte(s.purpose + "\x00" + id)
+}
+
+// NewID returns prefix followed by 24 random hex digits
+func NewID(prefix string) (string, error) {
+	raw := make([]byte, 12)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(raw), nil
+}
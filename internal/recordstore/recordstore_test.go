@@ -0,0 +1,107 @@
+package recordstore
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testKey = "test-record-store-key-at-least-32-characters"
+
+type testRecord struct {
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+}
+
+func (r testRecord) RecordID() string { return r.ID }
+
+func TestFileStorePersistsSealedRecords(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "nested", "records.db")
+	store, err := NewFileStore[testRecord](path, testKey, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range []testRecord{{ID: "a", Secret: "secret-a"}, {ID: "b", Secret: "secret-b"}, {ID: "a", Secret: "secret-a2"}} {
+		if err := store.Save(ctx, record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Delete(ctx, "b"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete(ctx, "missing"); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(raw, []byte("secret-a")) {
+		t.Fatal("store contains plaintext records")
+	}
+	wrongKey, err := NewFileStore[testRecord](path, "another-record-store-key-of-32-characters", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wrongKey.Load(ctx); err == nil {
+		t.Fatal("records decrypted with the wrong key")
+	}
+	wrongKey.Close()
+
+	reopened, err := NewFileStore[testRecord](path, testKey, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	records, err := reopened.Load(ctx)
+	if err != nil || len(records) != 1 || records[0] != (testRecord{ID: "a", Secret: "secret-a2"}) {
+		t.Fatalf("records = %+v, err = %v", records, err)
+	}
+}
+
+func TestOpenInSharesTheParentFile(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "records.db")
+	open := func() (Store[testRecord], Store[testRecord]) {
+		t.Helper()
+		parent, err := Open[testRecord](path, testKey, "parent")
+		if err != nil {
+			t.Fatal(err)
+		}
+		child, err := OpenIn[testRecord](parent, "child")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parent, child
+	}
+	parent, child := open()
+	if err := child.Save(ctx, testRecord{ID: "a", Secret: "child"}); err != nil {
+		t.Fatal(err)
+	}
+	// Closing the child leaves the file open for the parent
+	if err := child.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := parent.Save(ctx, testRecord{ID: "a", Secret: "parent"}); err != nil {
+		t.Fatal(err)
+	}
+	parent.Close()
+
+	parent, child = open()
+	defer parent.Close()
+	records, err := child.Load(ctx)
+	if err != nil || len(records) != 1 || records[0].Secret != "child" {
+		t.Fatalf("child records = %+v, %v", records, err)
+	}
+	memory, err := OpenIn[testRecord](NewMemoryStore[testRecord](), "child")
+	if _, ok := memory.(*MemoryStore[testRecord]); err != nil || !ok {
+		t.Fatalf("store next to a memory store = %T, %v", memory, err)
+	}
+}
@@ -0,0 +1,159 @@
+package rules
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Audit entry states
+const (
+	AuditOK     = "ok"
+	AuditError  = "error"
+	AuditDryRun = "dry_run"
+)
+
+// maxMemoryAuditEntries bounds the in-memory audit log
+const maxMemoryAuditEntries = 1000
+
+// Entry records one action a rule took, or would have taken in a dry run, on
+// one file of a finished offline task
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Owner    string    `json:"owner"`
+	RuleID   string    `json:"rule_id"`
+	RuleName string    `json:"rule_name"`
+	InfoHash string    `json:"info_hash"`
+	TaskName string    `json:"task_name"`
+	Action   string    `json:"action"`
+	FileID   string    `json:"file_id,omitempty"`
+	FileName string    `json:"file_name,omitempty"`
+	Target   string    `json:"target,omitempty"`
+	Status   string    `json:"status"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// AuditLog records every rule action
+type AuditLog interface {
+	// Append records an entry
+	Append(entry Entry) error
+	// List returns up to limit entries of owner, newest first
+	List(owner string, limit int) ([]Entry, error)
+}
+
+// MemoryAuditLog keeps the latest entries in memory only
+type MemoryAuditLog struct {
+	mu      sync.Mutex
+	entries []Entry
+}
+
+// NewMemoryAuditLog creates an empty in-memory audit log
+func NewMemoryAuditLog() *MemoryAuditLog {
+	return &MemoryAuditLog{}
+}
+
+// Append records an entry, forgetting the oldest beyond the size limit
+func (l *MemoryAuditLog) Append(entry Entry) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, entry)
+	if len(l.entries) > maxMemoryAuditEntries {
+		l.entries = append([]Entry(nil), l.entries[len(l.entries)-maxMemoryAuditEntries:]...)
+	}
+	return nil
+}
+
+// List returns up to limit entries of owner, newest first
+func (l *MemoryAuditLog) List(owner string, limit int) ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return latest(l.entries, owner, limit), nil
+}
+
+// FileAuditLog appends entries to a JSON Lines file. Entries carry no
+// credentials, so the file is not encrypted; rotate it with external tools.
+type FileAuditLog struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileAuditLog opens the audit log at path, creating its directory
+func NewFileAuditLog(path string) (*FileAuditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, fmt.Errorf("create audit log directory: %w", err)
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open audit log: %w", err)
+	}
+	file.Close()
+	return &FileAuditLog{path: path}, nil
+}
+
+// Append writes an entry as one line
+func (l *FileAuditLog) Append(entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	file, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("write audit log: %w", err)
+	}
+	if _, err := file.Write(line); err != nil {
+		file.Close()
+		return fmt.Errorf("write audit log: %w", err)
+	}
+	return file.Close()
+}
+
+// List scans the file and returns up to limit entries of owner, newest
+// first. Lines that do not decode are skipped.
+func (l *FileAuditLog) List(owner string, limit int) ([]Entry, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	file, err := os.Open(l.path)
+	if err != nil {
+		return nil, fmt.Errorf("read audit log: %w", err)
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64<<10), 1<<20)
+	for scanner.Scan() {
+		var entry Entry
+		if json.Unmarshal(scanner.Bytes(), &entry) != nil || entry.Owner != owner {
+			continue
+		}
+		entries = append(entries, entry)
+		// Keep memory bounded on long logs; only the tail is returned
+		if len(entries) > 2*limit && len(entries) > maxMemoryAuditEntries {
+			entries = append([]Entry(nil), entries[len(entries)-limit:]...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read audit log: %w", err)
+	}
+	return latest(entries, owner, limit), nil
+}
+
+// latest returns up to limit entries of owner from oldest-first entries,
+// newest first
+func latest(entries []Entry, owner string, limit int) []Entry {
+	result := make([]Entry, 0, min(limit, len(entries)))
+	for i := len(entries) - 1; i >= 0 && len(result) < limit; i-- {
+		if entries[i].Owner == owner {
+			result = append(result, entries[i])
+		}
+	}
+	return result
+}
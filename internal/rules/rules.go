@@ -0,0 +1,536 @@
+// Package rules organizes the output of finished offline downloads. Each
+// account's rules match a finished task by name and its files by name,
+// extension and size, then move, rename, delete or label the files. Every
+// action is written to an audit log.
+package rules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud-driver/internal/models"
+	"cloud-driver/internal/offline"
+	"cloud-driver/internal/recordstore"
+)
+
+// Action types
+const (
+	ActionMove   = "move"
+	ActionRename = "rename"
+	ActionDelete = "delete"
+	ActionLabel  = "label"
+)
+
+const (
+	maxRulesPerAccount = 50
+	// maxConcurrentTasks bounds the finished tasks being organized at once
+	maxConcurrentTasks = 2
+)
+
+var (
+	// ErrNotFound is returned for unknown rules and rules of other accounts
+	ErrNotFound = errors.New("rule not found")
+	// ErrInvalidRule is returned for rules that cannot be compiled
+	ErrInvalidRule = errors.New("invalid rule")
+	// ErrLimit is returned when an account already has the maximum number of rules
+	ErrLimit = fmt.Errorf("an account can have at most %d rules", maxRulesPerAccount)
+)
+
+// Rule selects the files of finished tasks and the actions to run on them.
+// Empty criteria match everything. Rules of an account run in creation order;
+// files a rule deletes are not seen by later rules.
+type Rule struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// TaskPattern is a regular expression matched against the task name
+	TaskPattern string `json:"task_pattern,omitempty"`
+	// FilePattern is a regular expression matched against each file name
+	FilePattern string `json:"file_pattern,omitempty"`
+	// Extensions are file extensions such as ".url", compared case-insensitively
+	Extensions []string `json:"extensions,omitempty"`
+	MinSize    int64    `json:"min_size,omitempty"`
+	MaxSize    int64    `json:"max_size,omitempty"`
+	Actions    []Action `json:"actions"`
+	// DryRun records the actions in the audit log without running them
+	DryRun    bool      `json:"dry_run"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Action is one step of a rule. Move takes TargetDirID or TargetDirPath, the
+// path being created when missing. Rename takes a Template with the
+// placeholders {name} (file name without extension), {ext} (extension with
+// its dot), {task} (task name), {index} (1-based position among the matched
+// files) and {date} (completion date, YYYY-MM-DD). Label takes a Label name
+// and creates the label when the account has none by that name.
+type Action struct {
+	Type          string `json:"type"`
+	TargetDirID   string `json:"target_dir_id,omitempty"`
+	TargetDirPath string `json:"target_dir_path,omitempty"`
+	Template      string `json:"template,omitempty"`
+	Label         string `json:"label,omitempty"`
+}
+
+// Drive is the part of the 115 service rules act through
+type Drive interface {
+	OfflineTaskFiles(ctx context.Context, credentials models.Drive115Credentials, task models.OfflineTask) ([]models.WalkEntry, error)
+	EnsureDir(ctx context.Context, credentials models.Drive115Credentials, dirPath string) (string, []string, error)
+	MoveFiles(ctx context.Context, credentials models.Drive115Credentials, fileIDs []string, targetDirID string) error
+	RenameFiles(ctx context.Context, credentials models.Drive115Credentials, files []models.RenameFileItem) error
+	DeleteFiles(ctx context.Context, credentials models.Drive115Credentials, fileIDs []string) error
+	LabelFiles(ctx context.Context, credentials models.Drive115Credentials, name string, fileIDs []string) error
+}
+
+// compiledRule is a rule with its patterns compiled
+type compiledRule struct {
+	Rule
+	task       *regexp.Regexp
+	file       *regexp.Regexp
+	extensions map[string]bool
+}
+
+// Manager owns the rules and the account subscriptions that trigger them
+type Manager struct {
+	store     recordstore.Store[Record]
+	followers *offline.Followers
+	audit     AuditLog
+	drive     Drive
+	now       func() time.Time
+	slots     chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	records map[string]Record
+	rules   map[string]*compiledRule
+}
+
+// NewManager loads the stored rules. Call Start to begin watching.
+func NewManager(store recordstore.Store[Record], snapshots recordstore.Store[offline.Snapshot], audit AuditLog, drive Drive, watcher *offline.Watcher) (*Manager, error) {
+	records, err := store.Load(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("load rules: %w", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &Manager{
+		store:   store,
+		audit:   audit,
+		drive:   drive,
+		now:     time.Now,
+		slots:   make(chan struct{}, maxConcurrentTasks),
+		ctx:     ctx,
+		cancel:  cancel,
+		records: make(map[string]Record, len(records)),
+		rules:   make(map[string]*compiledRule, len(records)),
+	}
+	m.followers = offline.NewFollowers(watcher, snapshots, func(ctx context.Context, owner string, credentials models.Drive115Credentials, event offline.Event) {
+		if event.Type != offline.EventDone || ctx.Err() != nil {
+			return
+		}
+		m.wg.Add(1)
+		go m.organize(ctx, owner, credentials, *event.Task)
+	})
+	for _, record := range records {
+		rule, err := compile(record.Rule)
+		if err != nil {
+			log.Printf("skip stored rule %s: %v", record.Rule.ID, err)
+			continue
+		}
+		m.records[record.Rule.ID] = record
+		m.rules[record.Rule.ID] = rule
+		m.followers.Add(record.Credentials)
+	}
+	return m, nil
+}
+
+// Start subscribes to the offline tasks of every account with rules
+func (m *Manager) Start() {
+	m.followers.Start()
+}
+
+// Stop ends the subscriptions and waits for the tasks being organized before
+// closing the store
+func (m *Manager) Stop(ctx context.Context) error {
+	m.cancel()
+	done := make(chan struct{})
+	var err error
+	go func() {
+		err = m.followers.Stop()
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return errors.Join(err, m.store.Close())
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Create validates and stores a rule for the offline tasks of credentials
+func (m *Manager) Create(ctx context.Context, credentials models.Drive115Credentials, rule Rule) (Rule, error) {
+	id, err := recordstore.NewID("rule_")
+	if err != nil {
+		return Rule{}, err
+	}
+	rule.ID, rule.CreatedAt = id, m.now().UTC()
+	for i, extension := range rule.Extensions {
+		rule.Extensions[i] = normalizeExtension(extension)
+	}
+	compiled, err := compile(rule)
+	if err != nil {
+		return Rule{}, err
+	}
+
+	credentials.Account = ""
+	record := Record{Rule: rule, Owner: credentials.Key(), Credentials: credentials}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.owned(record.Owner)) >= maxRulesPerAccount {
+		return Rule{}, ErrLimit
+	}
+	if err := m.store.Save(ctx, record); err != nil {
+		return Rule{}, err
+	}
+	m.records[id] = record
+	m.rules[id] = compiled
+	m.followers.Add(credentials)
+	return rule, nil
+}
+
+// List returns the rules of credentials in the order they run
+func (m *Manager) List(credentials models.Drive115Credentials) []Rule {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	owned := m.owned(credentials.Key())
+	rules := make([]Rule, 0, len(owned))
+	for _, rule := range owned {
+		rules = append(rules, rule.Rule)
+	}
+	return rules
+}
+
+// Delete removes a rule of credentials. The account is no longer polled once
+// its last rule is gone.
+func (m *Manager) Delete(ctx context.Context, credentials models.Drive115Credentials, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record, ok := m.records[id]
+	if !ok || record.Owner != credentials.Key() {
+		return ErrNotFound
+	}
+	if err := m.store.Delete(ctx, id); err != nil {
+		return err
+	}
+	delete(m.records, id)
+	delete(m.rules, id)
+	if err := m.followers.Remove(ctx, record.Owner); err != nil {
+		log.Printf("rule %s: %v", id, err)
+	}
+	return nil
+}
+
+// Audit returns up to limit audit entries of credentials, newest first
+func (m *Manager) Audit(credentials models.Drive115Credentials, limit int) ([]Entry, error) {
+	return m.audit.List(credentials.Key(), limit)
+}
+
+// owned returns the compiled rules of owner in creation order; callers hold m.mu
+func (m *Manager) owned(owner string) []*compiledRule {
+	var rules []*compiledRule
+	for id, record := range m.records {
+		if record.Owner == owner {
+			rules = append(rules, m.rules[id])
+		}
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if !rules[i].CreatedAt.Equal(rules[j].CreatedAt) {
+			return rules[i].CreatedAt.Before(rules[j].CreatedAt)
+		}
+		return rules[i].ID < rules[j].ID
+	})
+	return rules
+}
+
+// organize runs the matching rules of owner on the files of a finished task
+func (m *Manager) organize(ctx context.Context, owner string, credentials models.Drive115Credentials, task models.OfflineTask) {
+	defer m.wg.Done()
+	select {
+	case m.slots <- struct{}{}:
+		defer func() { <-m.slots }()
+	case <-ctx.Done():
+		return
+	}
+
+	m.mu.Lock()
+	var matching []*compiledRule
+	for _, rule := range m.owned(owner) {
+		if rule.task == nil || rule.task.MatchString(task.Name) {
+			matching = append(matching, rule)
+		}
+	}
+	m.mu.Unlock()
+	if len(matching) == 0 {
+		return
+	}
+
+	files, err := m.drive.OfflineTaskFiles(ctx, credentials, task)
+	if err != nil {
+		for _, rule := range matching {
+			m.record(entry(owner, rule, task, "list", models.WalkEntry{}, "", err))
+		}
+		return
+	}
+	for _, rule := range matching {
+		if ctx.Err() != nil {
+			return
+		}
+		var selected []models.WalkEntry
+		for _, file := range files {
+			if rule.matchesFile(file) {
+				selected = append(selected, file)
+			}
+		}
+		if len(selected) == 0 {
+			continue
+		}
+		for _, action := range rule.Actions {
+			selected = m.run(ctx, owner, credentials, rule, action, task, selected)
+			if action.Type == ActionDelete {
+				files = without(files, selected)
+				break
+			}
+		}
+		// Renames made by this rule are visible to the next one
+		files = merge(files, selected)
+	}
+}
+
+// run performs one action on the selected files, records the outcome per file
+// and returns the files as they are afterwards
+func (m *Manager) run(ctx context.Context, owner string, credentials models.Drive115Credentials, rule *compiledRule, action Action, task models.OfflineTask, selected []models.WalkEntry) []models.WalkEntry {
+	ids := make([]string, len(selected))
+	for i, file := range selected {
+		ids[i] = file.ID
+	}
+	var target string
+	var err error
+	switch action.Type {
+	case ActionMove:
+		target = action.TargetDirID
+		if action.TargetDirPath != "" {
+			target = action.TargetDirPath
+			if !rule.DryRun {
+				target, _, err = m.drive.EnsureDir(ctx, credentials, action.TargetDirPath)
+			}
+		}
+		if err == nil && !rule.DryRun {
+			err = m.drive.MoveFiles(ctx, credentials, ids, target)
+		}
+	case ActionDelete:
+		if !rule.DryRun {
+			err = m.drive.DeleteFiles(ctx, credentials, ids)
+		}
+	case ActionLabel:
+		target = action.Label
+		if !rule.DryRun {
+			err = m.drive.LabelFiles(ctx, credentials, action.Label, ids)
+		}
+	case ActionRename:
+		return m.rename(ctx, owner, credentials, rule, action, task, selected)
+	}
+	for _, file := range selected {
+		m.record(entry(owner, rule, task, action.Type, file, target, err))
+	}
+	return selected
+}
+
+// rename renders the template per file, skipping files it would not change
+// or would give an invalid name
+func (m *Manager) rename(ctx context.Context, owner string, credentials models.Drive115Credentials, rule *compiledRule, action Action, task models.OfflineTask, selected []models.WalkEntry) []models.WalkEntry {
+	renamed := append([]models.WalkEntry(nil), selected...)
+	var items []models.RenameFileItem
+	var changed []int
+	for i, file := range selected {
+		name, err := renderName(action.Template, file.Name, task, i+1)
+		if err != nil {
+			m.record(entry(owner, rule, task, ActionRename, file, "", err))
+			continue
+		}
+		if name == file.Name {
+			continue
+		}
+		items = append(items, models.RenameFileItem{FileID: file.ID, Name: name})
+		changed = append(changed, i)
+	}
+	if len(items) == 0 {
+		return renamed
+	}
+	var err error
+	if !rule.DryRun {
+		err = m.drive.RenameFiles(ctx, credentials, items)
+	}
+	for n, i := range changed {
+		m.record(entry(owner, rule, task, ActionRename, selected[i], items[n].Name, err))
+		if err == nil {
+			renamed[i].Name = items[n].Name
+		}
+	}
+	return renamed
+}
+
+func (m *Manager) record(e Entry) {
+	e.Time = m.now().UTC()
+	if err := m.audit.Append(e); err != nil {
+		log.Printf("rule %s audit: %v", e.RuleID, err)
+	}
+}
+
+func entry(owner string, rule *compiledRule, task models.OfflineTask, action string, file models.WalkEntry, target string, err error) Entry {
+	e := Entry{
+		Owner: owner, RuleID: rule.ID, RuleName: rule.Name, InfoHash: task.InfoHash, TaskName: task.Name,
+		Action: action, FileID: file.ID, FileName: file.Name, Target: target, Status: AuditOK,
+	}
+	switch {
+	case err != nil:
+		e.Status, e.Error = AuditError, err.Error()
+	case rule.DryRun:
+		e.Status = AuditDryRun
+	}
+	return e
+}
+
+// matchesFile reports whether a file passes the rule's file criteria
+func (r *compiledRule) matchesFile(file models.WalkEntry) bool {
+	if r.file != nil && !r.file.MatchString(file.Name) {
+		return false
+	}
+	if len(r.extensions) > 0 && !r.extensions[normalizeExtension(path.Ext(file.Name))] {
+		return false
+	}
+	if r.MinSize > 0 && file.Size < r.MinSize {
+		return false
+	}
+	return r.MaxSize <= 0 || file.Size <= r.MaxSize
+}
+
+// compile checks a rule and compiles its patterns
+func compile(rule Rule) (*compiledRule, error) {
+	compiled := &compiledRule{Rule: rule}
+	var err error
+	if rule.TaskPattern != "" {
+		if compiled.task, err = regexp.Compile(rule.TaskPattern); err != nil {
+			return nil, fmt.Errorf("%w: task_pattern: %v", ErrInvalidRule, err)
+		}
+	}
+	if rule.FilePattern != "" {
+		if compiled.file, err = regexp.Compile(rule.FilePattern); err != nil {
+			return nil, fmt.Errorf("%w: file_pattern: %v", ErrInvalidRule, err)
+		}
+	}
+	if len(rule.Extensions) > 0 {
+		compiled.extensions = make(map[string]bool, len(rule.Extensions))
+		for _, extension := range rule.Extensions {
+			compiled.extensions[normalizeExtension(extension)] = true
+		}
+	}
+	if rule.MaxSize > 0 && rule.MinSize > rule.MaxSize {
+		return nil, fmt.Errorf("%w: min_size exceeds max_size", ErrInvalidRule)
+	}
+	if len(rule.Actions) == 0 {
+		return nil, fmt.Errorf("%w: no actions", ErrInvalidRule)
+	}
+	for i, action := range rule.Actions {
+		switch action.Type {
+		case ActionMove:
+			if (action.TargetDirID == "") == (action.TargetDirPath == "") {
+				return nil, fmt.Errorf("%w: action %d: move needs exactly one of target_dir_id and target_dir_path", ErrInvalidRule, i+1)
+			}
+		case ActionRename:
+			if _, err := renderName(action.Template, "file.ext", models.OfflineTask{Name: "task"}, 1); err != nil {
+				return nil, fmt.Errorf("%w: action %d: %v", ErrInvalidRule, i+1, err)
+			}
+		case ActionLabel:
+			if strings.TrimSpace(action.Label) == "" {
+				return nil, fmt.Errorf("%w: action %d: label needs a label name", ErrInvalidRule, i+1)
+			}
+		case ActionDelete:
+			if i != len(rule.Actions)-1 {
+				return nil, fmt.Errorf("%w: action %d: delete must be the last action", ErrInvalidRule, i+1)
+			}
+		default:
+			return nil, fmt.Errorf("%w: action %d: unknown type %q", ErrInvalidRule, i+1, action.Type)
+		}
+	}
+	return compiled, nil
+}
+
+// renderName fills a rename template for one file
+func renderName(template, fileName string, task models.OfflineTask, index int) (string, error) {
+	if template == "" {
+		return "", errors.New("rename needs a template")
+	}
+	extension := path.Ext(fileName)
+	name := strings.NewReplacer(
+		"{name}", strings.TrimSuffix(fileName, extension),
+		"{ext}", extension,
+		"{task}", task.Name,
+		"{index}", strconv.Itoa(index),
+		"{date}", task.UpdatedAt.Format("2006-01-02"),
+	).Replace(template)
+	name = strings.TrimSpace(name)
+	invalidControl := strings.IndexFunc(name, func(r rune) bool { return r < 0x20 || r == 0x7f }) >= 0
+	if name == "" || name == "." || name == ".." || len(name) > 255 || invalidControl || strings.ContainsAny(name, "/\\") {
+		return "", fmt.Errorf("template gives invalid file name %q", name)
+	}
+	return name, nil
+}
+
+func normalizeExtension(extension string) string {
+	extension = strings.ToLower(strings.TrimSpace(extension))
+	if extension != "" && !strings.HasPrefix(extension, ".") {
+		extension = "." + extension
+	}
+	return extension
+}
+
+// without returns files minus removed
+func without(files, removed []models.WalkEntry) []models.WalkEntry {
+	gone := make(map[string]bool, len(removed))
+	for _, file := range removed {
+		gone[file.ID] = true
+	}
+	var kept []models.WalkEntry
+	for _, file := range files {
+		if !gone[file.ID] {
+			kept = append(kept, file)
+		}
+	}
+	return kept
+}
+
+// merge replaces entries of files with their updated copies
+func merge(files, updated []models.WalkEntry) []models.WalkEntry {
+	byID := make(map[string]models.WalkEntry, len(updated))
+	for _, file := range updated {
+		byID[file.ID] = file
+	}
+	merged := make([]models.WalkEntry, len(files))
+	for i, file := range files {
+		if update, ok := byID[file.ID]; ok {
+			file = update
+		}
+		merged[i] = file
+	}
+	return merged
+}
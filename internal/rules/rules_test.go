@@ -0,0 +1,153 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud-driver/internal/models"
+	"cloud-driver/internal/offline"
+	"cloud-driver/internal/recordstore"
+)
+
+type fakeLister struct {
+	mu    sync.Mutex
+	tasks []models.OfflineTask
+}
+
+func (f *fakeLister) ListAllOfflineTasks(context.Context, models.Drive115Credentials) ([]models.OfflineTask, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]models.OfflineTask(nil), f.tasks...), nil
+}
+
+func (f *fakeLister) set(tasks ...models.OfflineTask) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tasks = tasks
+}
+
+type fakeDrive struct {
+	mu    sync.Mutex
+	calls []string
+	files []models.WalkEntry
+}
+
+func (d *fakeDrive) call(format string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.calls = append(d.calls, format)
+}
+
+func (d *fakeDrive) OfflineTaskFiles(context.Context, models.Drive115Credentials, models.OfflineTask) ([]models.WalkEntry, error) {
+	return d.files, nil
+}
+
+func (d *fakeDrive) EnsureDir(_ context.Context, _ models.Drive115Credentials, dirPath string) (string, []string, error) {
+	d.call("ensure " + dirPath)
+	return "500", nil, nil
+}
+
+func (d *fakeDrive) MoveFiles(_ context.Context, _ models.Drive115Credentials, fileIDs []string, targetDirID string) error {
+	d.call("move " + strings.Join(fileIDs, ",") + " to " + targetDirID)
+	return nil
+}
+
+func (d *fakeDrive) RenameFiles(_ context.Context, _ models.Drive115Credentials, files []models.RenameFileItem) error {
+	for _, file := range files {
+		d.call("rename " + file.FileID + " to " + file.Name)
+	}
+	return nil
+}
+
+func (d *fakeDrive) DeleteFiles(_ context.Context, _ models.Drive115Credentials, fileIDs []string) error {
+	d.call("delete " + strings.Join(fileIDs, ","))
+	return nil
+}
+
+func (d *fakeDrive) LabelFiles(_ context.Context, _ models.Drive115Credentials, name string, fileIDs []string) error {
+	return errors.New("labels unavailable")
+}
+
+func TestManagerOrganizesFinishedTask(t *testing.T) {
+	lister := &fakeLister{}
+	lister.set(models.OfflineTask{InfoHash: "a", Name: "Show.S01", Status: "running"})
+	watcher := offline.NewWatcher(lister, offline.Options{
+		MinInterval: time.Millisecond, ActiveInterval: time.Millisecond, MaxInterval: time.Millisecond,
+	})
+	defer watcher.Close()
+	drive := &fakeDrive{files: []models.WalkEntry{
+		{ID: "1", Name: "Show.S01E01.mkv", Size: 1 << 30},
+		{ID: "2", Name: "sample.mkv", Size: 10 << 20},
+		{ID: "3", Name: "Visit us.URL", Size: 100},
+	}}
+	audit := NewMemoryAuditLog()
+	manager, err := NewManager(recordstore.NewMemoryStore[Record](), recordstore.NewMemoryStore[offline.Snapshot](), audit, drive, watcher)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.Start()
+	defer manager.Stop(context.Background())
+
+	credentials := models.Drive115Credentials{UID: "uid", CID: "cid", SEID: "seid", KID: "kid"}
+	ctx := context.Background()
+	if _, err := manager.Create(ctx, credentials, Rule{Name: "junk", FilePattern: "(?i)sample", Actions: []Action{{Type: ActionDelete}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.Create(ctx, credentials, Rule{Name: "links", Extensions: []string{"url"}, Actions: []Action{{Type: ActionDelete}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.Create(ctx, credentials, Rule{
+		Name: "shows", TaskPattern: `^Show\.`, MinSize: 100 << 20,
+		Actions: []Action{
+			{Type: ActionRename, Template: "{task} - {index}{ext}"},
+			{Type: ActionMove, TargetDirPath: "/TV/Show"},
+			{Type: ActionLabel, Label: "tv"},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.Create(ctx, credentials, Rule{Name: "bad", Actions: []Action{{Type: ActionDelete}, {Type: ActionLabel, Label: "x"}}}); !errors.Is(err, ErrInvalidRule) {
+		t.Fatalf("delete before another action accepted: %v", err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	lister.set(models.OfflineTask{InfoHash: "a", Name: "Show.S01", Status: "done", DirID: "9", FileID: "10"})
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		entries, _ := manager.Audit(credentials, 100)
+		if len(entries) == 5 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("audit entries = %+v", entries)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	want := []string{"delete 2", "delete 3", "rename 1 to Show.S01 - 1.mkv", "ensure /TV/Show", "move 1 to 500"}
+	drive.mu.Lock()
+	defer drive.mu.Unlock()
+	if strings.Join(drive.calls, "; ") != strings.Join(want, "; ") {
+		t.Fatalf("calls = %q, want %q", drive.calls, want)
+	}
+	entries, _ := manager.Audit(credentials, 1)
+	if len(entries) != 1 || entries[0].Action != ActionLabel || entries[0].Status != AuditError || entries[0].FileName != "Show.S01 - 1.mkv" {
+		t.Fatalf("latest entry = %+v", entries)
+	}
+}
+
+func TestRenderName(t *testing.T) {
+	task := models.OfflineTask{Name: "Movie (2024)", UpdatedAt: time.Date(2024, 5, 6, 0, 0, 0, 0, time.UTC)}
+	name, err := renderName("{task} {date} {name}{ext}", "cd1.mkv", task, 1)
+	if err != nil || name != "Movie (2024) 2024-05-06 cd1.mkv" {
+		t.Fatalf("name = %q, %v", name, err)
+	}
+	if _, err := renderName("{name}/{ext}", "a.mkv", task, 1); err == nil {
+		t.Fatal("template with a slash accepted")
+	}
+}
@@ -0,0 +1,15 @@
+package rules
+
+import "cloud-driver/internal/models"
+
+// Record is a rule as persisted, with its owner and the credentials it acts with
+type Record struct {
+	Rule        Rule                       `json:"rule"`
+	Owner       string                     `json:"owner"`
+	Credentials models.Drive115Credentials `json:"credentials"`
+}
+
+// RecordID keys the record by its rule ID
+func (r Record) RecordID() string {
+	return r.Rule.ID
+}
@@ -0,0 +1,90 @@
+package s3
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"net/http"
+
+	"cloud-driver/internal/services"
+
+	"github.com/SheltonZhu/115driver/pkg/driver"
+)
+
+// Error is an S3 error response. Its Code is what S3 clients branch on.
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+var (
+	errAccessDenied                      = &Error{http.StatusForbidden, "AccessDenied", "Access Denied"}
+	errAuthorizationHeaderMalformed      = &Error{http.StatusBadRequest, "AuthorizationHeaderMalformed", "The authorization header is malformed"}
+	errAuthorizationQueryParametersError = &Error{http.StatusBadRequest, "AuthorizationQueryParametersError", "The presigned URL is malformed"}
+	errBadDigest                         = &Error{http.StatusBadRequest, "BadDigest", "The Content-MD5 you specified did not match what we received"}
+	errBucketAlreadyOwnedByYou           = &Error{http.StatusConflict, "BucketAlreadyOwnedByYou", "Your previous request to create the named bucket succeeded and you already own it"}
+	errBucketNotEmpty                    = &Error{http.StatusConflict, "BucketNotEmpty", "The bucket you tried to delete is not empty"}
+	errContentSHA256Mismatch             = &Error{http.StatusBadRequest, "XAmzContentSHA256Mismatch", "The provided 'x-amz-content-sha256' header does not match what was computed"}
+	errEntityTooLarge                    = &Error{http.StatusBadRequest, "EntityTooLarge", "Your proposed upload exceeds the maximum allowed object size"}
+	errIncompleteBody                    = &Error{http.StatusBadRequest, "IncompleteBody", "You did not provide the number of bytes specified by the Content-Length HTTP header"}
+	errInternal                          = &Error{http.StatusInternalServerError, "InternalError", "We encountered an internal error, please try again"}
+	errInvalidAccessKeyID                = &Error{http.StatusForbidden, "InvalidAccessKeyId", "The AWS access key ID you provided does not exist in our records"}
+	errInvalidArgument                   = &Error{http.StatusBadRequest, "InvalidArgument", "Invalid argument"}
+	errInvalidBucketName                 = &Error{http.StatusBadRequest, "InvalidBucketName", "The specified bucket is not valid"}
+	errInvalidPart                       = &Error{http.StatusBadRequest, "InvalidPart", "One or more of the specified parts could not be found"}
+	errInvalidPartOrder                  = &Error{http.StatusBadRequest, "InvalidPartOrder", "The list of parts was not in ascending order"}
+	errInvalidRange                      = &Error{http.StatusRequestedRangeNotSatisfiable, "InvalidRange", "The requested range is not satisfiable"}
+	errInvalidRequest                    = &Error{http.StatusBadRequest, "InvalidRequest", "Invalid request"}
+	errMalformedXML                      = &Error{http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema"}
+	errMethodNotAllowed                  = &Error{http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource"}
+	errNoSuchBucket                      = &Error{http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist"}
+	errNoSuchKey                         = &Error{http.StatusNotFound, "NoSuchKey", "The specified key does not exist"}
+	errNoSuchUpload                      = &Error{http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist"}
+	errNotImplemented                    = &Error{http.StatusNotImplemented, "NotImplemented", "A header or query you provided implies functionality that is not implemented"}
+	errRequestTimeTooSkewed              = &Error{http.StatusForbidden, "RequestTimeTooSkewed", "The difference between the request time and the server's time is too large"}
+	errServiceUnavailable                = &Error{http.StatusServiceUnavailable, "ServiceUnavailable", "115 could not complete the request, please retry"}
+	errSignatureDoesNotMatch             = &Error{http.StatusForbidden, "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided"}
+)
+
+// apiError returns base with a more specific message
+func apiError(base *Error, message string) *Error {
+	return &Error{Status: base.Status, Code: base.Code, Message: message}
+}
+
+type errorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource,omitempty"`
+	RequestID string   `xml:"RequestId"`
+}
+
+// toError maps a backend error to an S3 error. 115 rejections that are not
+// about the request itself become ServiceUnavailable so clients retry them.
+func toError(err error, notFound *Error) *Error {
+	var s3Err *Error
+	switch {
+	case errors.As(err, &s3Err):
+		return s3Err
+	case errors.Is(err, driver.ErrNotExist):
+		return notFound
+	case errors.Is(err, driver.ErrExist):
+		return apiError(errInvalidRequest, "a file or directory with that name already exists")
+	case errors.Is(err, driver.ErrWrongParams):
+		return apiError(errInvalidArgument, err.Error())
+	case errors.Is(err, driver.ErrUploadTooLarge), errors.Is(err, services.ErrUploadTooLarge):
+		return errEntityTooLarge
+	case errors.Is(err, driver.ErrNotLogin), errors.Is(err, driver.ErrDoesLoggedOut),
+		errors.Is(err, driver.ErrCredentialInvalid), errors.Is(err, driver.ErrSessionExited),
+		errors.Is(err, driver.ErrBadCookie):
+		return apiError(errAccessDenied, "the 115 session behind this access key has expired")
+	case errors.Is(err, context.Canceled):
+		return apiError(errIncompleteBody, "the request was canceled")
+	}
+	return errServiceUnavailable
+}
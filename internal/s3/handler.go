@@ -0,0 +1,404 @@
+// Package s3 serves a subset of the S3 API over the 115 drive. Buckets are the
+// top-level directories of an account and object keys are paths below them.
+// Requests are signed with SigV4 using locally configured access keys, each of
+// which stands for one 115 account.
+package s3
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+
+	"cloud-driver/internal/models"
+	"cloud-driver/internal/services"
+
+	hash "github.com/SheltonZhu/115driver/pkg/crypto"
+	"github.com/SheltonZhu/115driver/pkg/driver"
+)
+
+// s3Namespace is the XML namespace of every S3 response body
+const s3Namespace = "http://s3.amazonaws.com/doc/2006-03-01/"
+
+// Backend is the part of Drive115Service the S3 gateway uses
+type Backend interface {
+	ResolvePath(ctx context.Context, credentials models.Drive115Credentials, filePath string) (services.PathEntry, error)
+	ListDir(ctx context.Context, credentials models.Drive115Credentials, dirPath string) ([]services.PathEntry, error)
+	Mkdir(ctx context.Context, credentials models.Drive115Credentials, parentID, name string) (string, error)
+	EnsureDir(ctx context.Context, credentials models.Drive115Credentials, dirPath string) (string, []string, error)
+	DeleteFiles(ctx context.Context, credentials models.Drive115Credentials, fileIDs []string) error
+	OpenPickCodeStream(ctx context.Context, credentials models.Drive115Credentials, pickCode, fileName, method string, requestHeader http.Header) (*services.DownloadStream, error)
+	UploadFile(ctx context.Context, credentials models.Drive115Credentials, dirID, name string, source io.ReaderAt, digest hash.DigestResult, progress func(int64)) (bool, error)
+}
+
+// Resolver swaps account handles for stored credentials
+type Resolver interface {
+	Resolve(ctx context.Context, credentials *models.Drive115Credentials) error
+}
+
+// AccessKey maps an S3 access key pair to raw 115 credentials or an account handle
+type AccessKey struct {
+	ID          string
+	Secret      string
+	Credentials models.Drive115Credentials
+}
+
+// Config holds the gateway settings. Request and part bodies are spooled to
+// SpoolDir, the system temp directory when empty, up to MaxUploadSize.
+type Config struct {
+	Region        string
+	SpoolDir      string
+	MaxUploadSize int64
+	AccessKeys    []AccessKey
+}
+
+// Handler serves the S3 API
+type Handler struct {
+	backend       Backend
+	resolver      Resolver
+	region        string
+	spoolDir      string
+	maxUploadSize int64
+	keys          map[string]AccessKey
+	uploads       *uploadStore
+	now           func() time.Time
+}
+
+// NewHandler validates the access keys
+func NewHandler(backend Backend, resolver Resolver, cfg Config) (*Handler, error) {
+	h := &Handler{
+		backend:       backend,
+		resolver:      resolver,
+		region:        cfg.Region,
+		spoolDir:      cfg.SpoolDir,
+		maxUploadSize: cfg.MaxUploadSize,
+		keys:          make(map[string]AccessKey, len(cfg.AccessKeys)),
+		uploads:       newUploadStore(),
+		now:           time.Now,
+	}
+	for _, key := range cfg.AccessKeys {
+		if key.ID == "" || len(key.Secret) < 16 {
+			return nil, fmt.Errorf("s3 access key %q needs an ID and a secret of at least 16 characters", key.ID)
+		}
+		if _, ok := h.keys[key.ID]; ok {
+			return nil, fmt.Errorf("s3 access key %q is configured twice", key.ID)
+		}
+		h.keys[key.ID] = key
+	}
+	return h, nil
+}
+
+// request is one authenticated S3 call
+type request struct {
+	w           http.ResponseWriter
+	r           *http.Request
+	query       url.Values
+	sig         *signature
+	credentials models.Drive115Credentials
+	bucket      string
+	key         string
+}
+
+func (req *request) ctx() context.Context {
+	return req.r.Context()
+}
+
+// bucketPath is the 115 path of the bucket directory
+func (req *request) bucketPath() string {
+	return "/" + req.bucket
+}
+
+// objectPath is the 115 path of the key; a trailing slash is dropped
+func (req *request) objectPath() string {
+	return path.Join("/", req.bucket, req.key)
+}
+
+// unsupportedSubresources are query parameters naming S3 features the
+// gateway does not have; they are rejected rather than served as plain
+// object or bucket requests
+var unsupportedSubresources = []string{
+	"accelerate", "acl", "analytics", "attributes", "cors", "delete", "encryption",
+	"intelligent-tiering", "inventory", "legal-hold", "lifecycle", "logging", "metrics",
+	"notification", "object-lock", "ownershipControls", "policy", "policyStatus",
+	"publicAccessBlock", "replication", "requestPayment", "restore", "retention",
+	"select", "tagging", "torrent", "versioning", "versions", "website",
+}
+
+// ServeHTTP authenticates the request and dispatches it by path and query
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	requestID := newRequestID()
+	w.Header().Set("X-Amz-Request-Id", requestID)
+	w.Header().Set("Server", "cloud-driver")
+
+	if err := h.serve(w, r); err != nil {
+		h.writeError(w, r, err, requestID)
+	}
+}
+
+func (h *Handler) serve(w http.ResponseWriter, r *http.Request) error {
+	sig, err := h.verify(r)
+	if err != nil {
+		return err
+	}
+	credentials := sig.key.Credentials
+	if err := h.resolver.Resolve(r.Context(), &credentials); err != nil {
+		return apiError(errAccessDenied, fmt.Sprintf("the 115 account of access key %q could not be loaded", sig.key.ID))
+	}
+
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	req := &request{w: w, r: r, query: r.URL.Query(), sig: sig, credentials: credentials, bucket: bucket, key: key}
+	for _, name := range unsupportedSubresources {
+		if req.query.Has(name) {
+			return apiError(errNotImplemented, fmt.Sprintf("the %q subresource is not supported", name))
+		}
+	}
+
+	if bucket == "" {
+		if r.Method != http.MethodGet {
+			return errMethodNotAllowed
+		}
+		return h.listBuckets(req)
+	}
+	if err := validateBucketName(bucket); err != nil {
+		return err
+	}
+	if key == "" {
+		return h.serveBucket(req)
+	}
+	if err := validateKey(key); err != nil {
+		return err
+	}
+	return h.serveObject(req)
+}
+
+func (h *Handler) serveBucket(req *request) error {
+	switch req.r.Method {
+	case http.MethodGet:
+		switch {
+		case req.query.Has("location"):
+			return h.bucketLocation(req)
+		case req.query.Has("uploads"):
+			return apiError(errNotImplemented, "listing multipart uploads is not supported")
+		}
+		return h.listObjects(req)
+	case http.MethodHead:
+		_, err := h.resolveBucket(req)
+		return err
+	case http.MethodPut:
+		return h.createBucket(req)
+	case http.MethodDelete:
+		return h.deleteBucket(req)
+	}
+	return errMethodNotAllowed
+}
+
+func (h *Handler) serveObject(req *request) error {
+	uploadID := req.query.Get("uploadId")
+	switch req.r.Method {
+	case http.MethodGet:
+		if uploadID != "" {
+			return h.listParts(req, uploadID)
+		}
+		return h.getObject(req)
+	case http.MethodHead:
+		return h.headObject(req)
+	case http.MethodPut:
+		if req.r.Header.Get("X-Amz-Copy-Source") != "" {
+			return apiError(errNotImplemented, "server-side copy is not supported")
+		}
+		if uploadID != "" {
+			return h.uploadPart(req, uploadID)
+		}
+		return h.putObject(req)
+	case http.MethodPost:
+		switch {
+		case req.query.Has("uploads"):
+			return h.createMultipartUpload(req)
+		case uploadID != "":
+			return h.completeMultipartUpload(req, uploadID)
+		}
+	case http.MethodDelete:
+		if uploadID != "" {
+			return h.abortMultipartUpload(req, uploadID)
+		}
+		return h.deleteObject(req)
+	}
+	return errMethodNotAllowed
+}
+
+type listAllMyBucketsResult struct {
+	XMLName xml.Name     `xml:"ListAllMyBucketsResult"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Owner   owner        `xml:"Owner"`
+	Buckets []bucketInfo `xml:"Buckets>Bucket"`
+}
+
+type owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+type bucketInfo struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+// listBuckets lists the top-level directories. Directories whose names are
+// not valid in a URL path segment are still listed; clients escape them.
+func (h *Handler) listBuckets(req *request) error {
+	entries, err := h.backend.ListDir(req.ctx(), req.credentials, "/")
+	if err != nil {
+		return toError(err, errInternal)
+	}
+	result := listAllMyBucketsResult{
+		Xmlns:   s3Namespace,
+		Owner:   owner{ID: req.sig.key.ID, DisplayName: req.sig.key.ID},
+		Buckets: []bucketInfo{},
+	}
+	for _, entry := range entries {
+		if entry.IsDir {
+			result.Buckets = append(result.Buckets, bucketInfo{Name: entry.Name, CreationDate: formatTime(entry.ModTime)})
+		}
+	}
+	return writeXML(req.w, http.StatusOK, result)
+}
+
+type locationConstraint struct {
+	XMLName xml.Name `xml:"LocationConstraint"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Region  string   `xml:",chardata"`
+}
+
+func (h *Handler) bucketLocation(req *request) error {
+	if _, err := h.resolveBucket(req); err != nil {
+		return err
+	}
+	// us-east-1 is reported as an empty constraint, as S3 does
+	region := h.region
+	if region == "us-east-1" {
+		region = ""
+	}
+	return writeXML(req.w, http.StatusOK, locationConstraint{Xmlns: s3Namespace, Region: region})
+}
+
+// createBucket creates a top-level directory. The request body may carry a
+// location constraint, which is ignored.
+func (h *Handler) createBucket(req *request) error {
+	if !s3BucketName.MatchString(req.bucket) || strings.Contains(req.bucket, "..") {
+		return errInvalidBucketName
+	}
+	_, err := h.backend.ResolvePath(req.ctx(), req.credentials, req.bucketPath())
+	if err == nil {
+		return errBucketAlreadyOwnedByYou
+	}
+	if !errors.Is(err, driver.ErrNotExist) {
+		return toError(err, errInternal)
+	}
+	if _, err := h.backend.Mkdir(req.ctx(), req.credentials, "0", req.bucket); err != nil {
+		return toError(err, errInternal)
+	}
+	req.w.Header().Set("Location", req.bucketPath())
+	req.w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// deleteBucket removes an empty top-level directory
+func (h *Handler) deleteBucket(req *request) error {
+	bucket, err := h.resolveBucket(req)
+	if err != nil {
+		return err
+	}
+	entries, err := h.backend.ListDir(req.ctx(), req.credentials, req.bucketPath())
+	if err != nil {
+		return toError(err, errNoSuchBucket)
+	}
+	if len(entries) > 0 {
+		return errBucketNotEmpty
+	}
+	if err := h.backend.DeleteFiles(req.ctx(), req.credentials, []string{bucket.ID}); err != nil {
+		return toError(err, errNoSuchBucket)
+	}
+	req.w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (h *Handler) resolveBucket(req *request) (services.PathEntry, error) {
+	entry, err := h.backend.ResolvePath(req.ctx(), req.credentials, req.bucketPath())
+	if err != nil {
+		return services.PathEntry{}, toError(err, errNoSuchBucket)
+	}
+	if !entry.IsDir {
+		return services.PathEntry{}, errNoSuchBucket
+	}
+	return entry, nil
+}
+
+// s3BucketName is the S3 naming rule for new buckets
+var s3BucketName = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+
+// validateBucketName accepts any single 115 path component. Names created
+// through the gateway must also follow the S3 rules, checked in createBucket.
+func validateBucketName(bucket string) error {
+	parts, err := services.SplitDirPath(bucket)
+	if err != nil || len(parts) != 1 {
+		return apiError(errInvalidBucketName, fmt.Sprintf("bucket %q is not a valid directory name", bucket))
+	}
+	return nil
+}
+
+// validateKey rejects keys that do not map to exactly one 115 path, such as
+// keys with empty, "." or ".." segments. A single trailing slash marks a
+// directory.
+func validateKey(key string) error {
+	trimmed := strings.TrimSuffix(key, "/")
+	parts, err := services.SplitDirPath(trimmed)
+	if err != nil || strings.Join(parts, "/") != trimmed {
+		return apiError(errInvalidArgument, fmt.Sprintf("key %q cannot be stored as a 115 path", key))
+	}
+	return nil
+}
+
+func (h *Handler) writeError(w http.ResponseWriter, r *http.Request, err error, requestID string) {
+	s3Err := toError(err, errInternal)
+	if s3Err.Status >= http.StatusInternalServerError && s3Err.Status != http.StatusNotImplemented {
+		log.Printf("s3 %s %s: %v", r.Method, r.URL.Path, err)
+	}
+	if r.Method == http.MethodHead {
+		w.WriteHeader(s3Err.Status)
+		return
+	}
+	writeXML(w, s3Err.Status, errorResponse{Code: s3Err.Code, Message: s3Err.Message, Resource: r.URL.Path, RequestID: requestID})
+}
+
+func writeXML(w http.ResponseWriter, status int, body any) error {
+	data, err := xml.Marshal(body)
+	if err != nil {
+		return err
+	}
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+	return nil
+}
+
+// formatTime renders times the way S3 XML bodies do
+func formatTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05.000Z")
+}
+
+func newRequestID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return strings.ToUpper(hex.EncodeToString(b[:]))
+}
@@ -0,0 +1,492 @@
+package s3
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud-driver/internal/services"
+
+	hash "github.com/SheltonZhu/115driver/pkg/crypto"
+	"github.com/SheltonZhu/115driver/pkg/driver"
+)
+
+const (
+	// maxPartNumber is the highest part number S3 accepts
+	maxPartNumber = 10000
+	// multipartLifetime is how long an idle multipart upload is kept
+	multipartLifetime = 24 * time.Hour
+	// completeKeepAlive is how often CompleteMultipartUpload writes whitespace
+	// while 115 receives the file, as S3 does, so clients do not time out
+	completeKeepAlive = 10 * time.Second
+)
+
+// A multipart upload is staged on local disk. 115 must be given the SHA1 of
+// the whole file before the first byte, and it splits files into its own
+// fixed-size parts, so S3 parts cannot be forwarded as they arrive. Complete
+// hashes the staged parts and sends them through InitUpload, UploadPart and
+// CompleteUpload in one pass. Staged uploads do not survive a restart.
+type multipartUpload struct {
+	id          string
+	accessKeyID string
+	bucket      string
+	key         string
+	dir         string
+
+	mu         sync.Mutex
+	parts      map[int]stagedPart
+	lastUsed   time.Time
+	completing bool
+}
+
+type stagedPart struct {
+	path     string
+	size     int64
+	etag     string
+	modified time.Time
+}
+
+type uploadStore struct {
+	mu      sync.Mutex
+	uploads map[string]*multipartUpload
+}
+
+func newUploadStore() *uploadStore {
+	return &uploadStore{uploads: make(map[string]*multipartUpload)}
+}
+
+// get returns the upload with id if it belongs to the request's key
+func (s *uploadStore) get(req *request, id string) (*multipartUpload, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	upload, ok := s.uploads[id]
+	if !ok || upload.accessKeyID != req.sig.key.ID || upload.bucket != req.bucket || upload.key != req.key {
+		return nil, errNoSuchUpload
+	}
+	return upload, nil
+}
+
+func (s *uploadStore) remove(upload *multipartUpload) {
+	s.mu.Lock()
+	delete(s.uploads, upload.id)
+	s.mu.Unlock()
+	os.RemoveAll(upload.dir)
+}
+
+// expire drops uploads that have been idle for longer than multipartLifetime
+func (s *uploadStore) expire(now time.Time) {
+	s.mu.Lock()
+	var expired []*multipartUpload
+	for _, upload := range s.uploads {
+		upload.mu.Lock()
+		if !upload.completing && now.Sub(upload.lastUsed) > multipartLifetime {
+			expired = append(expired, upload)
+		}
+		upload.mu.Unlock()
+	}
+	s.mu.Unlock()
+	for _, upload := range expired {
+		s.remove(upload)
+	}
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+func (h *Handler) createMultipartUpload(req *request) error {
+	if strings.HasSuffix(req.key, "/") {
+		return apiError(errInvalidArgument, "a key ending in a slash is a directory and cannot have content")
+	}
+	if _, err := h.resolveBucket(req); err != nil {
+		return err
+	}
+	h.uploads.expire(h.now())
+
+	var id [16]byte
+	rand.Read(id[:])
+	dir, err := os.MkdirTemp(h.spoolDir, "cloud-driver-s3-*")
+	if err != nil {
+		return toError(err, errInternal)
+	}
+	upload := &multipartUpload{
+		id:          hex.EncodeToString(id[:]),
+		accessKeyID: req.sig.key.ID,
+		bucket:      req.bucket,
+		key:         req.key,
+		dir:         dir,
+		parts:       make(map[int]stagedPart),
+		lastUsed:    h.now(),
+	}
+	h.uploads.mu.Lock()
+	h.uploads.uploads[upload.id] = upload
+	h.uploads.mu.Unlock()
+
+	return writeXML(req.w, http.StatusOK, initiateMultipartUploadResult{
+		Xmlns:    s3Namespace,
+		Bucket:   req.bucket,
+		Key:      req.key,
+		UploadID: upload.id,
+	})
+}
+
+// uploadPart stages one part. A part uploaded again replaces the earlier one.
+func (h *Handler) uploadPart(req *request, uploadID string) error {
+	partNumber, err := strconv.Atoi(req.query.Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > maxPartNumber {
+		return apiError(errInvalidArgument, "partNumber must be an integer between 1 and 10000")
+	}
+	upload, err := h.uploads.get(req, uploadID)
+	if err != nil {
+		return err
+	}
+	body, err := req.sig.body(req.r)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(upload.dir, "part-*")
+	if err != nil {
+		return toError(err, errInternal)
+	}
+	defer func() {
+		file.Close()
+		os.Remove(file.Name())
+	}()
+	digest := md5.New()
+	size, err := io.Copy(io.MultiWriter(file, digest), io.LimitReader(body, h.maxUploadSize+1))
+	if err != nil {
+		return toError(err, errInternal)
+	}
+	sum := digest.Sum(nil)
+	if err := checkContentMD5(req, base64.StdEncoding.EncodeToString(sum)); err != nil {
+		return err
+	}
+
+	upload.mu.Lock()
+	defer upload.mu.Unlock()
+	if upload.completing {
+		return apiError(errInvalidRequest, "the upload is being completed")
+	}
+	total := size
+	for number, part := range upload.parts {
+		if number != partNumber {
+			total += part.size
+		}
+	}
+	if total > h.maxUploadSize {
+		return errEntityTooLarge
+	}
+	partPath := filepath.Join(upload.dir, strconv.Itoa(partNumber))
+	if err := os.Rename(file.Name(), partPath); err != nil {
+		return toError(err, errInternal)
+	}
+	part := stagedPart{path: partPath, size: size, etag: `"` + hex.EncodeToString(sum) + `"`, modified: h.now()}
+	upload.parts[partNumber] = part
+	upload.lastUsed = part.modified
+	req.w.Header().Set("ETag", part.etag)
+	req.w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+type completeMultipartUpload struct {
+	Parts []struct {
+		PartNumber int    `xml:"PartNumber"`
+		ETag       string `xml:"ETag"`
+	} `xml:"Part"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"CompleteMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
+// completeMultipartUpload joins the listed parts and uploads them to 115.
+// Hashing and uploading a large file outlasts client timeouts, so once the
+// request is validated the 200 status is sent and whitespace is written until
+// the result, or an error document, follows, as S3 itself does.
+func (h *Handler) completeMultipartUpload(req *request, uploadID string) error {
+	upload, err := h.uploads.get(req, uploadID)
+	if err != nil {
+		return err
+	}
+	payload, err := req.sig.body(req.r)
+	if err != nil {
+		return err
+	}
+	data, err := io.ReadAll(io.LimitReader(payload, 1<<20))
+	if err != nil {
+		return err
+	}
+	var body completeMultipartUpload
+	if err := xml.Unmarshal(data, &body); err != nil || len(body.Parts) == 0 {
+		return errMalformedXML
+	}
+
+	upload.mu.Lock()
+	parts := make([]stagedPart, 0, len(body.Parts))
+	var partErr error
+	for i, listed := range body.Parts {
+		part, ok := upload.parts[listed.PartNumber]
+		switch {
+		case i > 0 && listed.PartNumber <= body.Parts[i-1].PartNumber:
+			partErr = errInvalidPartOrder
+		case !ok || strings.Trim(listed.ETag, `"`) != strings.Trim(part.etag, `"`):
+			partErr = errInvalidPart
+		}
+		if partErr != nil {
+			break
+		}
+		parts = append(parts, part)
+	}
+	if partErr == nil && upload.completing {
+		partErr = apiError(errInvalidRequest, "the upload is already being completed")
+	}
+	if partErr == nil {
+		upload.completing = true
+	}
+	upload.mu.Unlock()
+	if partErr != nil {
+		return partErr
+	}
+
+	done := make(chan error, 1)
+	var entry services.PathEntry
+	go func() {
+		var err error
+		entry, err = h.completeUpload(req, parts)
+		done <- err
+	}()
+
+	req.w.Header().Set("Content-Type", "application/xml")
+	req.w.WriteHeader(http.StatusOK)
+	io.WriteString(req.w, xml.Header)
+	flusher, _ := req.w.(http.Flusher)
+	ticker := time.NewTicker(completeKeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-done:
+			var result any = completeMultipartUploadResult{
+				Xmlns:    s3Namespace,
+				Location: req.objectPath(),
+				Bucket:   req.bucket,
+				Key:      req.key,
+				ETag:     etag(entry),
+			}
+			if err != nil {
+				upload.mu.Lock()
+				upload.completing = false
+				upload.lastUsed = h.now()
+				upload.mu.Unlock()
+				s3Err := toError(err, errInternal)
+				result = errorResponse{Code: s3Err.Code, Message: s3Err.Message, Resource: req.r.URL.Path, RequestID: req.w.Header().Get("X-Amz-Request-Id")}
+			} else {
+				h.uploads.remove(upload)
+			}
+			data, _ := xml.Marshal(result)
+			req.w.Write(data)
+			return nil
+		case <-ticker.C:
+			io.WriteString(req.w, " ")
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+func (h *Handler) completeUpload(req *request, parts []stagedPart) (services.PathEntry, error) {
+	source, err := openParts(parts)
+	if err != nil {
+		return services.PathEntry{}, err
+	}
+	defer source.Close()
+
+	var digest hash.DigestResult
+	if err := hash.Digest(io.NewSectionReader(source, 0, source.size), &digest); err != nil {
+		return services.PathEntry{}, err
+	}
+	existing, err := h.backend.ResolvePath(req.ctx(), req.credentials, req.objectPath())
+	if err == nil && existing.IsDir {
+		return services.PathEntry{}, apiError(errInvalidRequest, "a directory exists at this key")
+	}
+	if err != nil && !errors.Is(err, driver.ErrNotExist) {
+		return services.PathEntry{}, err
+	}
+	return h.upload(req, source, digest, existing.ID)
+}
+
+// upload places source at the request key, creating missing directories, and
+// then deletes the file it replaces
+func (h *Handler) upload(req *request, source io.ReaderAt, digest hash.DigestResult, replacedID string) (services.PathEntry, error) {
+	ctx := req.ctx()
+	objectPath := req.objectPath()
+	parentID, _, err := h.backend.EnsureDir(ctx, req.credentials, path.Dir(objectPath))
+	if err != nil {
+		return services.PathEntry{}, toError(err, errInternal)
+	}
+	if _, err := h.backend.UploadFile(ctx, req.credentials, parentID, path.Base(objectPath), source, digest, nil); err != nil {
+		return services.PathEntry{}, toError(err, errInternal)
+	}
+	if replacedID != "" {
+		if err := h.backend.DeleteFiles(ctx, req.credentials, []string{replacedID}); err != nil {
+			return services.PathEntry{}, toError(err, errInternal)
+		}
+	}
+	return services.PathEntry{SHA1: digest.QuickID, Size: digest.Size}, nil
+}
+
+func (h *Handler) abortMultipartUpload(req *request, uploadID string) error {
+	upload, err := h.uploads.get(req, uploadID)
+	if err != nil {
+		return err
+	}
+	upload.mu.Lock()
+	completing := upload.completing
+	upload.mu.Unlock()
+	if completing {
+		return apiError(errInvalidRequest, "the upload is being completed")
+	}
+	h.uploads.remove(upload)
+	req.w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+type listPartsResult struct {
+	XMLName              xml.Name   `xml:"ListPartsResult"`
+	Xmlns                string     `xml:"xmlns,attr"`
+	Bucket               string     `xml:"Bucket"`
+	Key                  string     `xml:"Key"`
+	UploadID             string     `xml:"UploadId"`
+	PartNumberMarker     int        `xml:"PartNumberMarker"`
+	NextPartNumberMarker int        `xml:"NextPartNumberMarker"`
+	MaxParts             int        `xml:"MaxParts"`
+	IsTruncated          bool       `xml:"IsTruncated"`
+	StorageClass         string     `xml:"StorageClass"`
+	Parts                []partInfo `xml:"Part"`
+}
+
+type partInfo struct {
+	PartNumber   int    `xml:"PartNumber"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+}
+
+func (h *Handler) listParts(req *request, uploadID string) error {
+	upload, err := h.uploads.get(req, uploadID)
+	if err != nil {
+		return err
+	}
+	result := listPartsResult{Xmlns: s3Namespace, Bucket: req.bucket, Key: req.key, UploadID: uploadID, MaxParts: maxListKeys, StorageClass: "STANDARD"}
+	if value := req.query.Get("max-parts"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return apiError(errInvalidArgument, "max-parts must be a non-negative integer")
+		}
+		result.MaxParts = min(n, maxListKeys)
+	}
+	if value := req.query.Get("part-number-marker"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return apiError(errInvalidArgument, "part-number-marker must be a non-negative integer")
+		}
+		result.PartNumberMarker = n
+	}
+
+	upload.mu.Lock()
+	numbers := make([]int, 0, len(upload.parts))
+	for number := range upload.parts {
+		if number > result.PartNumberMarker {
+			numbers = append(numbers, number)
+		}
+	}
+	sort.Ints(numbers)
+	for _, number := range numbers {
+		if len(result.Parts) == result.MaxParts {
+			result.IsTruncated = true
+			break
+		}
+		part := upload.parts[number]
+		result.Parts = append(result.Parts, partInfo{PartNumber: number, LastModified: formatTime(part.modified), ETag: part.etag, Size: part.size})
+		result.NextPartNumberMarker = number
+	}
+	upload.mu.Unlock()
+	return writeXML(req.w, http.StatusOK, result)
+}
+
+// partsReader reads staged parts back to back
+type partsReader struct {
+	files   []*os.File
+	offsets []int64
+	size    int64
+}
+
+func openParts(parts []stagedPart) (*partsReader, error) {
+	r := &partsReader{}
+	for _, part := range parts {
+		file, err := os.Open(part.path)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.files = append(r.files, file)
+		r.offsets = append(r.offsets, r.size)
+		r.size += part.size
+	}
+	return r, nil
+}
+
+func (r *partsReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= r.size {
+		return 0, io.EOF
+	}
+	read := 0
+	i := sort.Search(len(r.offsets), func(i int) bool { return r.offsets[i] > off }) - 1
+	for ; i < len(r.files) && read < len(p); i++ {
+		partSize := r.size - r.offsets[i]
+		if i+1 < len(r.offsets) {
+			partSize = r.offsets[i+1] - r.offsets[i]
+		}
+		start := off + int64(read) - r.offsets[i]
+		want := min(int64(len(p)-read), partSize-start)
+		n, err := r.files[i].ReadAt(p[read:read+int(want)], start)
+		read += n
+		if err != nil && !(err == io.EOF && int64(n) == want) {
+			return read, err
+		}
+	}
+	if read < len(p) {
+		return read, io.EOF
+	}
+	return read, nil
+}
+
+func (r *partsReader) Close() error {
+	for _, file := range r.files {
+		file.Close()
+	}
+	return nil
+}
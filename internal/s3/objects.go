@@ -0,0 +1,423 @@
+package s3
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	"cloud-driver/internal/services"
+
+	"github.com/SheltonZhu/115driver/pkg/driver"
+)
+
+const (
+	// maxListKeys is the most keys one listing page returns, as in S3
+	maxListKeys = 1000
+	// emptyETag is the MD5 of an empty object, used for directory markers
+	emptyETag = `"d41d8cd98f00b204e9800998ecf8427e"`
+)
+
+type listBucketResult struct {
+	XMLName               xml.Name       `xml:"ListBucketResult"`
+	Xmlns                 string         `xml:"xmlns,attr"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	Marker                *string        `xml:"Marker"`
+	NextMarker            string         `xml:"NextMarker,omitempty"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	KeyCount              *int           `xml:"KeyCount"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	Contents              []objectInfo   `xml:"Contents"`
+	CommonPrefixes        []commonPrefix `xml:"CommonPrefixes"`
+}
+
+type objectInfo struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type commonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+// listItem is a listed key, or a common prefix standing for every key below it
+type listItem struct {
+	key    string
+	entry  services.PathEntry
+	prefix bool
+}
+
+// listObjects serves ListObjectsV2 and the original ListObjects. With the "/"
+// delimiter only the directory named by the prefix is listed. Without it the
+// tree below that directory is walked, so deep unbounded listings are slow on
+// the first page; later pages come from the path cache.
+func (h *Handler) listObjects(req *request) error {
+	if _, err := h.resolveBucket(req); err != nil {
+		return err
+	}
+	query := req.query
+	v2 := query.Get("list-type") == "2"
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	maxKeys := maxListKeys
+	if value := query.Get("max-keys"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return apiError(errInvalidArgument, "max-keys must be a non-negative integer")
+		}
+		maxKeys = min(n, maxListKeys)
+	}
+
+	result := listBucketResult{
+		Xmlns:     s3Namespace,
+		Name:      req.bucket,
+		Prefix:    prefix,
+		Delimiter: delimiter,
+		MaxKeys:   maxKeys,
+	}
+	marker := query.Get("marker")
+	if v2 {
+		result.StartAfter = query.Get("start-after")
+		result.ContinuationToken = query.Get("continuation-token")
+		marker = result.StartAfter
+		if result.ContinuationToken != "" {
+			decoded, err := base64.RawURLEncoding.DecodeString(result.ContinuationToken)
+			if err != nil {
+				return apiError(errInvalidArgument, "the continuation token is not valid")
+			}
+			marker = string(decoded)
+		}
+	} else {
+		result.Marker = &marker
+	}
+
+	items, err := h.listItems(req, prefix, delimiter)
+	if err != nil {
+		return err
+	}
+	var last string
+	for _, item := range items {
+		if item.key <= marker {
+			continue
+		}
+		if len(result.Contents)+len(result.CommonPrefixes) == maxKeys {
+			result.IsTruncated = true
+			break
+		}
+		last = item.key
+		if item.prefix {
+			result.CommonPrefixes = append(result.CommonPrefixes, commonPrefix{Prefix: item.key})
+			continue
+		}
+		result.Contents = append(result.Contents, objectInfo{
+			Key:          item.key,
+			LastModified: formatTime(item.entry.ModTime),
+			ETag:         etag(item.entry),
+			Size:         item.entry.Size,
+			StorageClass: "STANDARD",
+		})
+	}
+	if result.IsTruncated {
+		if v2 {
+			result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(last))
+		} else {
+			result.NextMarker = last
+		}
+	}
+	if v2 {
+		count := len(result.Contents) + len(result.CommonPrefixes)
+		result.KeyCount = &count
+	}
+	return writeXML(req.w, http.StatusOK, result)
+}
+
+// listItems returns the keys under prefix in S3 order, with keys that contain
+// the delimiter after the prefix rolled up into common prefixes
+func (h *Handler) listItems(req *request, prefix, delimiter string) ([]listItem, error) {
+	dirKey := prefix[:strings.LastIndex(prefix, "/")+1]
+	if dirKey != "" && validateKey(dirKey) != nil {
+		return nil, nil
+	}
+
+	var keys []listItem
+	if delimiter == "/" {
+		entries, err := h.backend.ListDir(req.ctx(), req.credentials, path.Join(req.bucketPath(), dirKey))
+		if errors.Is(err, driver.ErrNotExist) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, toError(err, errInternal)
+		}
+		for _, entry := range entries {
+			if entry.IsDir {
+				keys = append(keys, listItem{key: dirKey + entry.Name + "/", prefix: true})
+			} else {
+				keys = append(keys, listItem{key: dirKey + entry.Name, entry: entry})
+			}
+		}
+	} else if err := h.walk(req, dirKey, prefix, &keys); err != nil {
+		return nil, err
+	}
+
+	var items []listItem
+	for _, item := range keys {
+		if strings.HasPrefix(item.key, prefix) {
+			items = append(items, item)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].key < items[j].key })
+	if delimiter == "" || delimiter == "/" {
+		return items, nil
+	}
+
+	rolled := items[:0]
+	for _, item := range items {
+		if i := strings.Index(item.key[len(prefix):], delimiter); i >= 0 {
+			common := item.key[:len(prefix)+i+len(delimiter)]
+			if n := len(rolled); n > 0 && rolled[n-1].key == common {
+				continue
+			}
+			item = listItem{key: common, prefix: true}
+		}
+		rolled = append(rolled, item)
+	}
+	return rolled, nil
+}
+
+// walk collects the files below dirKey, skipping directories whose keys
+// cannot start with prefix
+func (h *Handler) walk(req *request, dirKey, prefix string, keys *[]listItem) error {
+	if err := req.ctx().Err(); err != nil {
+		return err
+	}
+	entries, err := h.backend.ListDir(req.ctx(), req.credentials, path.Join(req.bucketPath(), dirKey))
+	if errors.Is(err, driver.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return toError(err, errInternal)
+	}
+	for _, entry := range entries {
+		key := dirKey + entry.Name
+		if !entry.IsDir {
+			*keys = append(*keys, listItem{key: key, entry: entry})
+			continue
+		}
+		key += "/"
+		if strings.HasPrefix(key, prefix) || strings.HasPrefix(prefix, key) {
+			if err := h.walk(req, key, prefix, keys); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolveObject returns the file at the key, or the directory when the key
+// ends with a slash
+func (h *Handler) resolveObject(req *request) (services.PathEntry, error) {
+	entry, err := h.backend.ResolvePath(req.ctx(), req.credentials, req.objectPath())
+	if errors.Is(err, driver.ErrNotExist) {
+		if _, err := h.resolveBucket(req); err != nil {
+			return services.PathEntry{}, err
+		}
+		return services.PathEntry{}, errNoSuchKey
+	}
+	if err != nil {
+		return services.PathEntry{}, toError(err, errNoSuchKey)
+	}
+	if entry.IsDir != strings.HasSuffix(req.key, "/") {
+		return services.PathEntry{}, errNoSuchKey
+	}
+	return entry, nil
+}
+
+// getObject relays the file from the 115 CDN, forwarding Range and If-Range
+func (h *Handler) getObject(req *request) error {
+	entry, err := h.resolveObject(req)
+	if err != nil {
+		return err
+	}
+	if entry.IsDir {
+		return h.headObject(req)
+	}
+	stream, err := h.backend.OpenPickCodeStream(req.ctx(), req.credentials, entry.PickCode, entry.Name, http.MethodGet, req.r.Header)
+	if err != nil {
+		return toError(err, errNoSuchKey)
+	}
+	defer stream.Response.Body.Close()
+	if stream.Response.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+		return errInvalidRange
+	}
+
+	header := req.w.Header()
+	for _, key := range []string{"Content-Length", "Content-Range"} {
+		if value := stream.Response.Header.Get(key); value != "" {
+			header.Set(key, value)
+		}
+	}
+	setObjectHeaders(req, entry)
+	req.w.WriteHeader(stream.Response.StatusCode)
+	io.Copy(req.w, stream.Response.Body)
+	return nil
+}
+
+// headObject answers from the path cache without touching the CDN
+func (h *Handler) headObject(req *request) error {
+	entry, err := h.resolveObject(req)
+	if err != nil {
+		return err
+	}
+	req.w.Header().Set("Content-Length", strconv.FormatInt(entry.Size, 10))
+	setObjectHeaders(req, entry)
+	req.w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// responseOverrides are the query parameters a presigned GET may use to set
+// response headers
+var responseOverrides = map[string]string{
+	"response-content-type":        "Content-Type",
+	"response-content-language":    "Content-Language",
+	"response-expires":             "Expires",
+	"response-cache-control":       "Cache-Control",
+	"response-content-disposition": "Content-Disposition",
+	"response-content-encoding":    "Content-Encoding",
+}
+
+func setObjectHeaders(req *request, entry services.PathEntry) {
+	header := req.w.Header()
+	header.Set("Accept-Ranges", "bytes")
+	header.Set("ETag", etag(entry))
+	header.Set("Last-Modified", entry.ModTime.UTC().Format(http.TimeFormat))
+	contentType := "application/octet-stream"
+	if entry.IsDir {
+		contentType = "application/x-directory"
+	} else if byExt := mime.TypeByExtension(path.Ext(entry.Name)); byExt != "" {
+		contentType = byExt
+	}
+	header.Set("Content-Type", contentType)
+	for param, name := range responseOverrides {
+		if value := req.query.Get(param); value != "" {
+			header.Set(name, value)
+		}
+	}
+}
+
+// putObject spools the body, uploads it next to any existing file and then
+// deletes the old one, so a failed upload leaves the old object in place. A
+// key ending in a slash creates a directory.
+func (h *Handler) putObject(req *request) error {
+	body, err := req.sig.body(req.r)
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(req.key, "/") {
+		return h.putDirectory(req, body)
+	}
+	if _, err := h.resolveBucket(req); err != nil {
+		return err
+	}
+	existing, err := h.backend.ResolvePath(req.ctx(), req.credentials, req.objectPath())
+	if err == nil && existing.IsDir {
+		return apiError(errInvalidRequest, "a directory exists at this key")
+	}
+	if err != nil && !errors.Is(err, driver.ErrNotExist) {
+		return toError(err, errInternal)
+	}
+
+	spooled, err := services.SpoolFile(body, h.spoolDir, h.maxUploadSize)
+	if err != nil {
+		return toError(err, errInternal)
+	}
+	defer spooled.Close()
+	if err := checkContentMD5(req, spooled.Digest.MD5); err != nil {
+		return err
+	}
+
+	entry, err := h.upload(req, spooled, spooled.Digest, existing.ID)
+	if err != nil {
+		return err
+	}
+	req.w.Header().Set("ETag", etag(entry))
+	req.w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// putDirectory handles a zero-byte directory marker
+func (h *Handler) putDirectory(req *request, body io.Reader) error {
+	if n, err := io.Copy(io.Discard, io.LimitReader(body, 1)); err != nil {
+		return toError(err, errInternal)
+	} else if n > 0 {
+		return apiError(errInvalidArgument, "a key ending in a slash is a directory and cannot have content")
+	}
+	if _, err := h.resolveBucket(req); err != nil {
+		return err
+	}
+	if _, _, err := h.backend.EnsureDir(req.ctx(), req.credentials, req.objectPath()); err != nil {
+		return toError(err, errInternal)
+	}
+	req.w.Header().Set("ETag", emptyETag)
+	req.w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// deleteObject removes a file. A directory marker removes its directory only
+// while it is empty. Missing keys succeed, as in S3.
+func (h *Handler) deleteObject(req *request) error {
+	entry, err := h.resolveObject(req)
+	if errors.Is(err, errNoSuchKey) {
+		req.w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if entry.IsDir {
+		children, err := h.backend.ListDir(req.ctx(), req.credentials, req.objectPath())
+		if err != nil {
+			return toError(err, errInternal)
+		}
+		if len(children) > 0 {
+			req.w.WriteHeader(http.StatusNoContent)
+			return nil
+		}
+	}
+	if err := h.backend.DeleteFiles(req.ctx(), req.credentials, []string{entry.ID}); err != nil {
+		return toError(err, errInternal)
+	}
+	req.w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// checkContentMD5 compares an optional Content-MD5 header with the body digest
+func checkContentMD5(req *request, md5 string) error {
+	if want := req.r.Header.Get("Content-MD5"); want != "" && want != md5 {
+		return errBadDigest
+	}
+	return nil
+}
+
+// etag is the quoted SHA1 115 keeps for a file. S3 uses MD5, which 115 does
+// not store, so clients must not compare it with their own MD5.
+func etag(entry services.PathEntry) string {
+	if entry.SHA1 != "" {
+		return `"` + strings.ToLower(entry.SHA1) + `"`
+	}
+	return fmt.Sprintf(`"%s-%d-%d"`, entry.ID, entry.ModTime.Unix(), entry.Size)
+}
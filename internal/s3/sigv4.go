@@ -0,0 +1,463 @@
+package s3
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	signingAlgorithm = "AWS4-HMAC-SHA256"
+	amzDateFormat    = "20060102T150405Z"
+
+	unsignedPayload          = "UNSIGNED-PAYLOAD"
+	streamingSignedPayload   = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD"
+	streamingSignedTrailer   = "STREAMING-AWS4-HMAC-SHA256-PAYLOAD-TRAILER"
+	streamingUnsignedTrailer = "STREAMING-UNSIGNED-PAYLOAD-TRAILER"
+
+	// maxClockSkew is how far X-Amz-Date may drift from the server clock
+	maxClockSkew = 15 * time.Minute
+	// maxPresignExpiry is the longest X-Amz-Expires S3 accepts
+	maxPresignExpiry = 7 * 24 * time.Hour
+	// maxChunkSize bounds one aws-chunked chunk header so a bad size cannot
+	// make the reader wait for gigabytes before checking a signature
+	maxChunkSize = 64 << 20
+)
+
+var emptySHA256 = hex.EncodeToString(sha256.New().Sum(nil))
+
+// signature is a verified SigV4 request signature. It seeds the chunk
+// signatures of a streaming upload.
+type signature struct {
+	key         AccessKey
+	amzDate     string
+	scope       string
+	signingKey  []byte
+	seed        string
+	payloadHash string
+}
+
+// authorization holds the fields of an Authorization header or presigned query
+type authorization struct {
+	accessKeyID   string
+	date          string
+	region        string
+	service       string
+	signedHeaders []string
+	signature     string
+	amzDate       string
+	payloadHash   string
+	presigned     bool
+	expires       time.Duration
+}
+
+// verify checks the SigV4 signature of r against the configured access keys
+func (h *Handler) verify(r *http.Request) (*signature, error) {
+	auth, err := parseAuthorization(r)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := h.keys[auth.accessKeyID]
+	if !ok {
+		return nil, errInvalidAccessKeyID
+	}
+	if auth.service != "s3" || auth.region != h.region {
+		return nil, apiError(errAuthorizationHeaderMalformed, fmt.Sprintf("the credential scope must use region %q and service s3", h.region))
+	}
+	signedAt, err := time.Parse(amzDateFormat, auth.amzDate)
+	if err != nil || auth.date != auth.amzDate[:8] {
+		return nil, apiError(errAccessDenied, "X-Amz-Date is missing or does not match the credential scope")
+	}
+	now := h.now()
+	if auth.presigned {
+		if auth.expires <= 0 || auth.expires > maxPresignExpiry {
+			return nil, apiError(errAuthorizationQueryParametersError, "X-Amz-Expires must be between 1 and 604800 seconds")
+		}
+		if now.Before(signedAt.Add(-maxClockSkew)) || now.After(signedAt.Add(auth.expires)) {
+			return nil, apiError(errAccessDenied, "Request has expired")
+		}
+	} else if d := now.Sub(signedAt); d > maxClockSkew || d < -maxClockSkew {
+		return nil, errRequestTimeTooSkewed
+	}
+
+	canonical, err := canonicalRequest(r, auth)
+	if err != nil {
+		return nil, err
+	}
+	scope := strings.Join([]string{auth.date, auth.region, auth.service, "aws4_request"}, "/")
+	signingKey := deriveSigningKey(key.Secret, auth.date, auth.region, auth.service)
+	stringToSign := strings.Join([]string{signingAlgorithm, auth.amzDate, scope, sha256Hex([]byte(canonical))}, "\n")
+	expected := hex.EncodeToString(hmacSHA256(signingKey, []byte(stringToSign)))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(auth.signature)) != 1 {
+		return nil, errSignatureDoesNotMatch
+	}
+	return &signature{
+		key:         key,
+		amzDate:     auth.amzDate,
+		scope:       scope,
+		signingKey:  signingKey,
+		seed:        auth.signature,
+		payloadHash: auth.payloadHash,
+	}, nil
+}
+
+func parseAuthorization(r *http.Request) (*authorization, error) {
+	query := r.URL.Query()
+	if query.Get("X-Amz-Algorithm") != "" {
+		return parsePresigned(query)
+	}
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return nil, apiError(errAccessDenied, "Anonymous access is not allowed")
+	}
+	fields, ok := strings.CutPrefix(header, signingAlgorithm+" ")
+	if !ok {
+		return nil, apiError(errAuthorizationHeaderMalformed, "Only AWS4-HMAC-SHA256 signatures are supported")
+	}
+	auth := &authorization{
+		amzDate:     r.Header.Get("X-Amz-Date"),
+		payloadHash: r.Header.Get("X-Amz-Content-Sha256"),
+	}
+	var credential, signedHeaders string
+	for _, field := range strings.Split(fields, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+		switch name {
+		case "Credential":
+			credential = value
+		case "SignedHeaders":
+			signedHeaders = value
+		case "Signature":
+			auth.signature = value
+		}
+	}
+	if err := auth.setCredential(credential); err != nil {
+		return nil, err
+	}
+	if signedHeaders == "" || auth.signature == "" {
+		return nil, apiError(errAuthorizationHeaderMalformed, "the Authorization header needs SignedHeaders and Signature")
+	}
+	auth.signedHeaders = strings.Split(signedHeaders, ";")
+	if auth.payloadHash == "" {
+		return nil, apiError(errInvalidRequest, "Missing required header for this request: x-amz-content-sha256")
+	}
+	return auth, nil
+}
+
+func parsePresigned(query url.Values) (*authorization, error) {
+	if query.Get("X-Amz-Algorithm") != signingAlgorithm {
+		return nil, apiError(errAuthorizationQueryParametersError, "Only AWS4-HMAC-SHA256 signatures are supported")
+	}
+	expires, err := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if err != nil {
+		return nil, apiError(errAuthorizationQueryParametersError, "X-Amz-Expires must be a number of seconds")
+	}
+	auth := &authorization{
+		amzDate:     query.Get("X-Amz-Date"),
+		signature:   query.Get("X-Amz-Signature"),
+		payloadHash: unsignedPayload,
+		presigned:   true,
+		expires:     time.Duration(expires) * time.Second,
+	}
+	if err := auth.setCredential(query.Get("X-Amz-Credential")); err != nil {
+		return nil, err
+	}
+	if auth.signature == "" || query.Get("X-Amz-SignedHeaders") == "" {
+		return nil, apiError(errAuthorizationQueryParametersError, "a presigned URL needs X-Amz-SignedHeaders and X-Amz-Signature")
+	}
+	auth.signedHeaders = strings.Split(query.Get("X-Amz-SignedHeaders"), ";")
+	return auth, nil
+}
+
+// setCredential splits "AKID/20130524/us-east-1/s3/aws4_request"
+func (a *authorization) setCredential(credential string) error {
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[4] != "aws4_request" || parts[0] == "" {
+		return apiError(errAuthorizationHeaderMalformed, "the Credential must look like <key>/<date>/<region>/s3/aws4_request")
+	}
+	a.accessKeyID, a.date, a.region, a.service = parts[0], parts[1], parts[2], parts[3]
+	return nil
+}
+
+// canonicalRequest builds the SigV4 canonical request. S3 signs the path as
+// sent, encoded once, and the host from the Host header.
+func canonicalRequest(r *http.Request, auth *authorization) (string, error) {
+	var headers strings.Builder
+	hasHost := false
+	for _, name := range auth.signedHeaders {
+		var value string
+		switch name {
+		case "host":
+			hasHost = true
+			value = r.Host
+		case "content-length":
+			value = strconv.FormatInt(r.ContentLength, 10)
+		case "transfer-encoding":
+			value = strings.Join(r.TransferEncoding, ",")
+		default:
+			values := r.Header.Values(name)
+			if len(values) == 0 {
+				return "", apiError(errAccessDenied, fmt.Sprintf("signed header %q is missing from the request", name))
+			}
+			trimmed := make([]string, len(values))
+			for i, v := range values {
+				trimmed[i] = strings.Join(strings.Fields(v), " ")
+			}
+			value = strings.Join(trimmed, ",")
+		}
+		headers.WriteString(name + ":" + value + "\n")
+	}
+	if !hasHost {
+		return "", apiError(errAccessDenied, "the host header must be signed")
+	}
+
+	payloadHash := auth.payloadHash
+	if auth.presigned {
+		payloadHash = unsignedPayload
+	}
+	return strings.Join([]string{
+		r.Method,
+		uriEncode(r.URL.Path, false),
+		canonicalQuery(r.URL.Query(), auth.presigned),
+		headers.String(),
+		strings.Join(auth.signedHeaders, ";"),
+		payloadHash,
+	}, "\n"), nil
+}
+
+func canonicalQuery(query url.Values, presigned bool) string {
+	pairs := make([]string, 0, len(query))
+	for name, values := range query {
+		if presigned && name == "X-Amz-Signature" {
+			continue
+		}
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent-encodes everything but unreserved characters, and slashes
+// too when encodeSlash is set, as SigV4 requires
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func deriveSigningKey(secret, date, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), []byte(date))
+	key = hmacSHA256(key, []byte(region))
+	key = hmacSHA256(key, []byte(service))
+	return hmacSHA256(key, []byte("aws4_request"))
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(data)
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// body returns the request payload with its signing verified as it is read.
+// Signed payloads are checked against x-amz-content-sha256 at EOF and
+// aws-chunked payloads chunk by chunk. Trailing checksums are read and
+// dropped; 115 keeps its own SHA1 of the result.
+func (s *signature) body(r *http.Request) (io.Reader, error) {
+	switch s.payloadHash {
+	case unsignedPayload:
+		return r.Body, nil
+	case streamingSignedPayload, streamingSignedTrailer:
+		return newChunkedReader(r.Body, s), nil
+	case streamingUnsignedTrailer:
+		return newChunkedReader(r.Body, nil), nil
+	}
+	if len(s.payloadHash) != sha256.Size*2 {
+		return nil, apiError(errInvalidArgument, "unsupported x-amz-content-sha256 value")
+	}
+	return &hashedReader{source: r.Body, hash: sha256.New(), want: s.payloadHash}, nil
+}
+
+// hashedReader fails the final read when the payload does not match its hash
+type hashedReader struct {
+	source io.Reader
+	hash   hash.Hash
+	want   string
+}
+
+func (r *hashedReader) Read(p []byte) (int, error) {
+	n, err := r.source.Read(p)
+	r.hash.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(r.hash.Sum(nil)) != r.want {
+		return n, errContentSHA256Mismatch
+	}
+	return n, err
+}
+
+// chunkedReader decodes an aws-chunked body. With a signature every chunk,
+// including the final empty one, must carry the signature chained from the
+// previous chunk.
+type chunkedReader struct {
+	source    *bufio.Reader
+	sig       *signature
+	previous  string
+	remaining int64
+	chunkSig  string
+	hash      hash.Hash
+	done      bool
+}
+
+func newChunkedReader(source io.Reader, sig *signature) *chunkedReader {
+	r := &chunkedReader{source: bufio.NewReader(source), sig: sig, hash: sha256.New()}
+	if sig != nil {
+		r.previous = sig.seed
+	}
+	return r
+}
+
+func (r *chunkedReader) Read(p []byte) (int, error) {
+	for r.remaining == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.nextChunk(); err != nil {
+			return 0, err
+		}
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.source.Read(p)
+	r.hash.Write(p[:n])
+	r.remaining -= int64(n)
+	if r.remaining == 0 {
+		if err := r.endChunk(); err != nil {
+			return n, err
+		}
+	} else if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// nextChunk reads a "size;chunk-signature=sig" header. The final chunk is
+// verified straight away, then any trailers are skipped.
+func (r *chunkedReader) nextChunk() error {
+	line, err := r.readLine()
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return err
+	}
+	sizeField, params, _ := strings.Cut(line, ";")
+	size, err := strconv.ParseInt(strings.TrimSpace(sizeField), 16, 64)
+	if err != nil || size < 0 || size > maxChunkSize {
+		return apiError(errIncompleteBody, "invalid aws-chunked chunk header")
+	}
+	r.chunkSig = ""
+	if sig, ok := strings.CutPrefix(params, "chunk-signature="); ok {
+		r.chunkSig = sig
+	}
+	if r.sig != nil && r.chunkSig == "" {
+		return apiError(errSignatureDoesNotMatch, "aws-chunked chunk is missing its signature")
+	}
+	r.hash.Reset()
+	r.remaining = size
+	if size > 0 {
+		return nil
+	}
+
+	r.done = true
+	if err := r.checkSignature(); err != nil {
+		return err
+	}
+	// Trailers end with an empty line; unsigned bodies without trailers end
+	// at the first one
+	for {
+		line, err := r.readLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil || line == "" {
+			return err
+		}
+	}
+}
+
+// endChunk checks the signature of a full chunk and consumes its CRLF
+func (r *chunkedReader) endChunk() error {
+	if err := r.checkSignature(); err != nil {
+		return err
+	}
+	line, err := r.readLine()
+	if err != nil {
+		return err
+	}
+	if line != "" {
+		return apiError(errIncompleteBody, "aws-chunked chunk is longer than its header says")
+	}
+	return nil
+}
+
+func (r *chunkedReader) checkSignature() error {
+	if r.sig == nil {
+		return nil
+	}
+	stringToSign := strings.Join([]string{
+		signingAlgorithm + "-PAYLOAD",
+		r.sig.amzDate,
+		r.sig.scope,
+		r.previous,
+		emptySHA256,
+		hex.EncodeToString(r.hash.Sum(nil)),
+	}, "\n")
+	expected := hex.EncodeToString(hmacSHA256(r.sig.signingKey, []byte(stringToSign)))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(r.chunkSig)) != 1 {
+		return errSignatureDoesNotMatch
+	}
+	r.previous = r.chunkSig
+	return nil
+}
+
+func (r *chunkedReader) readLine() (string, error) {
+	line, err := r.source.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		return "", apiError(errIncompleteBody, "aws-chunked line is too long")
+	}
+	if err == io.EOF && len(line) > 0 {
+		return "", io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimRight(line, "\r\n")), nil
+}
@@ -0,0 +1,79 @@
+package secretbox
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+)
+
+// Box encrypts JSON values into opaque URL-safe tokens with AES-GCM.
+// The additional data binds a token to its purpose so a token minted for one
+// use cannot be replayed as another.
+type Box struct {
+	aead cipher.AEAD
+}
+
+// New derives an AES-256 key from secret, which must be at least 32 characters
+func New(secret string) (*Box, error) {
+	if len(secret) < 32 {
+		return nil, fmt.Errorf("secret must be at least 32 characters")
+	}
+	key := sha256.Sum256([]byte(secret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Box{aead: aead}, nil
+}
+
+// Seal encrypts raw bytes, prefixing the random nonce
+func (b *Box) Seal(plain, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return b.aead.Seal(nonce, nonce, plain, additionalData), nil
+}
+
+// Open decrypts bytes produced by Seal
+func (b *Box) Open(sealed, additionalData []byte) ([]byte, error) {
+	if len(sealed) < b.aead.NonceSize() {
+		return nil, fmt.Errorf("sealed data too short")
+	}
+	nonce, ciphertext := sealed[:b.aead.NonceSize()], sealed[b.aead.NonceSize():]
+	return b.aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+// SealJSON encrypts value as a base64url token
+func (b *Box) SealJSON(value interface{}, additionalData []byte) (string, error) {
+	plain, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	sealed, err := b.Seal(plain, additionalData)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// OpenJSON decrypts a token produced by SealJSON into value
+func (b *Box) OpenJSON(token string, maxSize int, value interface{}, additionalData []byte) bool {
+	if token == "" || len(token) > maxSize {
+		return false
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return false
+	}
+	plain, err := b.Open(sealed, additionalData)
+	return err == nil && json.Unmarshal(plain, value) == nil
+}
@@ -3,24 +3,42 @@ package server
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
+	"cloud-driver/internal/accounts"
 	"cloud-driver/internal/config"
+	"cloud-driver/internal/dav"
 	"cloud-driver/internal/handlers"
+	"cloud-driver/internal/jobs"
 	"cloud-driver/internal/middleware"
+	"cloud-driver/internal/models"
+	"cloud-driver/internal/offline"
+	"cloud-driver/internal/recordstore"
+	"cloud-driver/internal/rules"
+	"cloud-driver/internal/s3"
 	"cloud-driver/internal/services"
+	"cloud-driver/internal/webhooks"
 
 	"github.com/labstack/echo/v4"
 	echomiddleware "github.com/labstack/echo/v4/middleware"
+	"github.com/labstack/gommon/bytes"
 )
 
 // Server represents the HTTP server
 type Server struct {
-	config *config.Config
-	echo   *echo.Echo
+	config   *config.Config
+	echo     *echo.Echo
+	s3       *echo.Echo
+	jobs     *jobs.Manager
+	tasks    *offline.Watcher
+	webhooks *webhooks.Manager
+	rules    *rules.Manager
+	accounts *accounts.FileStore
 }
 
 // New creates a new server instance
@@ -28,19 +46,112 @@ func New(cfg *config.Config) (*Server, error) {
 	// Initialize 115drive service (no database needed)
 	drive115Service := services.NewDrive115Service()
 
+	// Account handles are optional; without a store clients send raw credentials
+	var accountStore accounts.Store
+	var accountFile *accounts.FileStore
+	if cfg.AccountStore.Path != "" {
+		fileStore, err := accounts.NewFileStore(cfg.AccountStore.Path, cfg.AccountStore.Key)
+		if err != nil {
+			return nil, fmt.Errorf("open account store: %w", err)
+		}
+		accountStore, accountFile = fileStore, fileStore
+	}
+
+	sourceUploader, err := newSourceUploader(cfg.SourceUpload, drive115Service)
+	if err != nil {
+		return nil, fmt.Errorf("configure source uploads: %w", err)
+	}
+	jobManager, err := newJobManager(cfg.Jobs, accountStore)
+	if err != nil {
+		return nil, fmt.Errorf("configure jobs: %w", err)
+	}
+	services.RegisterJobs(jobManager, drive115Service, sourceUploader)
+	taskWatcher := offline.NewWatcher(drive115Service, offline.Options{Fatal: isAuthError})
+	webhookManager, err := newWebhookManager(cfg.Webhooks, taskWatcher)
+	if err != nil {
+		return nil, fmt.Errorf("configure webhooks: %w", err)
+	}
+	ruleManager, err := newRuleManager(cfg.Rules, drive115Service, taskWatcher)
+	if err != nil {
+		return nil, fmt.Errorf("configure rules: %w", err)
+	}
+
 	// Initialize handlers
 	healthHandler := handlers.NewHealthHandler()
-	drive115Handler, err := handlers.NewDrive115Handler(drive115Service, cfg.UploadSessionSecret)
+	drive115Handler, err := handlers.NewDrive115Handler(drive115Service, cfg.UploadSessionSecret, accountStore, sourceUploader, jobManager, taskWatcher, webhookManager, ruleManager)
 	if err != nil {
 		return nil, fmt.Errorf("create 115 handler: %w", err)
 	}
 
+	apiKeys := make([]middleware.APIKey, 0, len(cfg.Auth.APIKeys))
+	for _, key := range cfg.Auth.APIKeys {
+		apiKeys = append(apiKeys, middleware.APIKey{Name: key.Name, Key: key.Key, Scopes: key.Scopes})
+	}
+	auth, err := middleware.NewAuthenticator(apiKeys, cfg.Auth.TokenSecret)
+	if err != nil {
+		return nil, fmt.Errorf("configure auth: %w", err)
+	}
+
 	// Setup Echo
 	e := echo.New()
 	e.HideBanner = true
+	e.HTTPErrorHandler = middleware.HTTPErrorHandler
 
 	// Middleware
-	e.Use(echomiddleware.RequestLoggerWithConfig(echomiddleware.RequestLoggerConfig{
+	e.Use(requestLogger())
+	e.Use(echomiddleware.Recover())
+	e.Use(echomiddleware.CORSWithConfig(echomiddleware.CORSConfig{
+		// WebDAV clients send OPTIONS to discover DAV support, not as a preflight
+		Skipper: func(c echo.Context) bool {
+			return cfg.WebDAV.Enabled && underPrefix(c.Request().URL.Path, cfg.WebDAV.Prefix)
+		},
+		AllowOrigins:  cfg.AllowedOrigins,
+		AllowMethods:  []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodOptions},
+		AllowHeaders:  []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization, echo.HeaderContentLength, "X-Part-Number", "Range", "If-Range", middleware.HeaderAPIKey},
+		ExposeHeaders: []string{"Accept-Ranges", "Content-Length", "Content-Range", "Content-Disposition", "ETag"},
+	}))
+
+	e.Use(middleware.ValidationMiddleware())
+	credentialResolver := middleware.NewCredentialResolver(accountStore)
+	e.Use(middleware.CredentialMiddleware(credentialResolver))
+
+	// Setup routes
+	uploadPartBodyLimit := cfg.UploadPartBodyLimit
+	if uploadPartBodyLimit == "" {
+		uploadPartBodyLimit = "17M"
+	}
+	setupRoutes(e, auth, healthHandler, drive115Handler, uploadPartBodyLimit)
+
+	if cfg.WebDAV.Enabled {
+		webdavHandler, err := newWebDAVHandler(cfg.WebDAV, drive115Service, credentialResolver, auth)
+		if err != nil {
+			return nil, fmt.Errorf("configure webdav: %w", err)
+		}
+		setupWebDAV(e, cfg.WebDAV.Prefix, webdavHandler)
+	}
+
+	server := &Server{
+		config:   cfg,
+		echo:     e,
+		jobs:     jobManager,
+		tasks:    taskWatcher,
+		webhooks: webhookManager,
+		rules:    ruleManager,
+		accounts: accountFile,
+	}
+	if cfg.S3.Enabled {
+		s3Handler, err := newS3Handler(cfg.S3, drive115Service, credentialResolver)
+		if err != nil {
+			return nil, fmt.Errorf("configure s3: %w", err)
+		}
+		server.s3 = newS3Server(s3Handler)
+	}
+	return server, nil
+}
+
+// requestLogger writes one JSON line per request to stdout
+func requestLogger() echo.MiddlewareFunc {
+	return echomiddleware.RequestLoggerWithConfig(echomiddleware.RequestLoggerConfig{
 		LogLatency:      true,
 		LogRemoteIP:     true,
 		LogHost:         true,
@@ -98,64 +209,257 @@ func New(cfg *config.Config) (*Server, error) {
 			_, err = os.Stdout.Write(entry)
 			return err
 		},
-	}))
-	e.Use(echomiddleware.Recover())
-	e.Use(echomiddleware.CORSWithConfig(echomiddleware.CORSConfig{
-		AllowOrigins: cfg.AllowedOrigins,
-		AllowMethods: []string{http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodOptions},
-		AllowHeaders: []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization, echo.HeaderContentLength, "X-Part-Number"},
-	}))
-
-	e.Use(middleware.ValidationMiddleware())
-
-	// Setup routes
-	uploadPartBodyLimit := cfg.UploadPartBodyLimit
-	if uploadPartBodyLimit == "" {
-		uploadPartBodyLimit = "17M"
-	}
-	setupRoutes(e, healthHandler, drive115Handler, uploadPartBodyLimit)
-
-	return &Server{
-		config: cfg,
-		echo:   e,
-	}, nil
+	})
 }
 
-// setupRoutes configures all the application routes
-func setupRoutes(e *echo.Echo, healthHandler *handlers.HealthHandler, drive115Handler *handlers.Drive115Handler, uploadPartBodyLimit string) {
+// setupRoutes configures all the application routes. Each 115 route declares
+// the scope a caller needs; signed stream links carry their own authorization.
+func setupRoutes(e *echo.Echo, auth *middleware.Authenticator, healthHandler *handlers.HealthHandler, drive115Handler *handlers.Drive115Handler, uploadPartBodyLimit string) {
 	// Health check
 	e.GET("/health", healthHandler.Check)
 
 	// API routes
 	api := e.Group("/api/v1")
 
+	read := auth.Require(middleware.ScopeRead)
+	write := auth.Require(middleware.ScopeWrite)
+	offline := auth.Require(middleware.ScopeOffline)
+	upload := auth.Require(middleware.ScopeUpload)
+	login := auth.Require(middleware.ScopeLogin)
+
 	// 115drive routes
 	drive115 := api.Group("/115")
 	{
-		drive115.POST("/user", drive115Handler.GetUser)
-		drive115.POST("/tasks", drive115Handler.ListOfflineTasks)
-		drive115.POST("/tasks/add", drive115Handler.AddOfflineTask)
-		drive115.POST("/tasks/delete", drive115Handler.DeleteOfflineTasks)
-		drive115.POST("/tasks/clear", drive115Handler.ClearOfflineTasks)
-		drive115.POST("/files", drive115Handler.ListFiles)
-		drive115.POST("/uploads/init", drive115Handler.InitUpload)
-		drive115.POST("/uploads/status", drive115Handler.UploadStatus)
-		drive115.PUT("/uploads/part", drive115Handler.UploadPart, echomiddleware.BodyLimit(uploadPartBodyLimit))
-		drive115.POST("/uploads/complete", drive115Handler.CompleteUpload)
-		drive115.POST("/uploads/abort", drive115Handler.AbortUpload)
-		drive115.POST("/files/video-check", drive115Handler.CheckFolderVideos)
-		drive115.POST("/files/:id", drive115Handler.GetFileInfo)
-		drive115.POST("/files/:id/download", drive115Handler.DownloadFile)
+		drive115.POST("/user", drive115Handler.GetUser, read)
+		drive115.POST("/tasks", drive115Handler.ListOfflineTasks, read)
+		drive115.POST("/tasks/all", drive115Handler.QueryOfflineTasks, read)
+		drive115.POST("/tasks/add", drive115Handler.AddOfflineTask, offline)
+		drive115.POST("/tasks/add/bulk", drive115Handler.AddOfflineTasksBulk, offline)
+		drive115.POST("/tasks/add/torrent", drive115Handler.AddOfflineTorrent, offline, echomiddleware.BodyLimit("11M"))
+		drive115.POST("/tasks/delete", drive115Handler.DeleteOfflineTasks, offline)
+		drive115.POST("/tasks/clear", drive115Handler.ClearOfflineTasks, offline)
+		drive115.POST("/tasks/events", drive115Handler.TaskEvents, read)
+		drive115.POST("/tasks/events/link", drive115Handler.CreateTaskEventsLink, read)
+		drive115.GET("/tasks/events/:token", drive115Handler.TaskEventsLink)
+		drive115.POST("/webhooks", drive115Handler.CreateWebhook, offline)
+		drive115.POST("/webhooks/list", drive115Handler.ListWebhooks, read)
+		drive115.POST("/webhooks/:id/delete", drive115Handler.DeleteWebhook, offline)
+		drive115.POST("/rules", drive115Handler.CreateRule, write)
+		drive115.POST("/rules/list", drive115Handler.ListRules, read)
+		drive115.POST("/rules/audit", drive115Handler.RuleAudit, read)
+		drive115.POST("/rules/:id/delete", drive115Handler.DeleteRule, write)
+		drive115.POST("/files", drive115Handler.ListFiles, read)
+		drive115.POST("/uploads/init", drive115Handler.InitUpload, upload)
+		drive115.POST("/uploads/status", drive115Handler.UploadStatus, upload)
+		drive115.PUT("/uploads/part", drive115Handler.UploadPart, upload, echomiddleware.BodyLimit(uploadPartBodyLimit))
+		drive115.POST("/uploads/complete", drive115Handler.CompleteUpload, upload)
+		drive115.POST("/uploads/abort", drive115Handler.AbortUpload, upload)
+		drive115.POST("/uploads/source", drive115Handler.UploadFromSource, upload)
+		drive115.POST("/uploads/source/:id", drive115Handler.GetUploadJob, upload)
+		drive115.POST("/files/video-check", drive115Handler.CheckFolderVideos, read)
+		drive115.POST("/files/walk", drive115Handler.WalkFiles, read)
+		drive115.POST("/search", drive115Handler.Search, read)
+		drive115.POST("/recycle", drive115Handler.ListRecycleBin, read)
+		drive115.POST("/recycle/restore", drive115Handler.RestoreRecycleBin, write)
+		drive115.POST("/recycle/purge", drive115Handler.PurgeRecycleBin, write)
+		drive115.POST("/dirs", drive115Handler.Mkdir, write)
+		drive115.POST("/dirs/resolve", drive115Handler.ResolveDir, read)
+		drive115.POST("/dirs/ensure", drive115Handler.EnsureDir, write)
+		drive115.POST("/files/move", drive115Handler.MoveFiles, write)
+		drive115.POST("/files/copy", drive115Handler.CopyFiles, write)
+		drive115.POST("/files/rename", drive115Handler.RenameFiles, write)
+		drive115.POST("/files/delete", drive115Handler.DeleteFiles, write)
+		drive115.POST("/files/:id", drive115Handler.GetFileInfo, read)
+		drive115.POST("/files/:id/download", drive115Handler.DownloadFile, read)
+		drive115.Match([]string{http.MethodGet, http.MethodHead}, "/files/:id/stream", drive115Handler.StreamFile, read)
+		drive115.POST("/files/:id/link", drive115Handler.CreateStreamLink, read)
+		drive115.Match([]string{http.MethodGet, http.MethodHead}, "/stream/:token", drive115Handler.StreamLink)
 
 		// QR Code login routes
-		drive115.POST("/qrcode/start", drive115Handler.QRCodeStart)
-		drive115.POST("/qrcode/image", drive115Handler.QRCodeImage)
-		drive115.POST("/qrcode/status", drive115Handler.QRCodeStatus)
-		drive115.POST("/qrcode/login", drive115Handler.QRCodeLogin)
+		drive115.POST("/qrcode/start", drive115Handler.QRCodeStart, login)
+		drive115.POST("/qrcode/image", drive115Handler.QRCodeImage, login)
+		drive115.POST("/qrcode/status", drive115Handler.QRCodeStatus, login)
+		drive115.POST("/qrcode/login", drive115Handler.QRCodeLogin, login)
+
+		// Stored account routes
+		drive115.POST("/accounts/delete", drive115Handler.DeleteAccount, login)
+	}
+
+	// Background job routes; creating and canceling also check the scope of
+	// the job type
+	jobGroup := api.Group("/jobs")
+	{
+		jobGroup.POST("", drive115Handler.CreateJob, read)
+		jobGroup.POST("/list", drive115Handler.ListJobs, read)
+		jobGroup.POST("/:id", drive115Handler.GetJob, read)
+		jobGroup.POST("/:id/cancel", drive115Handler.CancelJob, read)
+	}
+}
+
+func newSourceUploader(cfg config.SourceUploadConfig, service *services.Drive115Service) (*services.SourceUploader, error) {
+	maxDownloadSize := cfg.MaxDownloadSize
+	if maxDownloadSize == "" {
+		maxDownloadSize = "20G"
+	}
+	size, err := bytes.Parse(maxDownloadSize)
+	if err != nil {
+		return nil, err
+	}
+	return services.NewSourceUploader(service, services.SourceUploadOptions{
+		LocalRoots:           cfg.LocalRoots,
+		SpoolDir:             cfg.SpoolDir,
+		MaxDownloadSize:      size,
+		AllowPrivateNetworks: cfg.AllowPrivateNetworks,
+		Concurrency:          cfg.Concurrency,
+	})
+}
+
+// isAuthError reports 115 errors that retrying with the same credentials cannot fix
+func isAuthError(err error) bool {
+	status, _, known := middleware.ClassifyError(err)
+	return known && status == http.StatusUnauthorized
+}
+
+// newJobManager opens the job store, keeping jobs in memory when no path is set
+func newJobManager(cfg config.JobsConfig, accountStore accounts.Store) (*jobs.Manager, error) {
+	store, err := recordstore.Open[jobs.Record](cfg.Path, cfg.Key, "jobs")
+	if err != nil {
+		return nil, err
 	}
+	return jobs.NewManager(store, jobs.Options{
+		Workers:     cfg.Workers,
+		MaxAttempts: cfg.MaxAttempts,
+		RetryDelay:  cfg.RetryDelay,
+		Retention:   cfg.Retention,
+		Accounts:    accountStore,
+		// Errors 115 reports as the caller's fault fail at once
+		Retryable: func(err error) bool {
+			status, _, known := middleware.ClassifyError(err)
+			return !known || status >= http.StatusInternalServerError
+		},
+	})
 }
 
-// Start starts the HTTP server
+// newWebhookManager opens the webhook store, keeping webhooks in memory when
+// no path is set
+func newWebhookManager(cfg config.WebhooksConfig, watcher *offline.Watcher) (*webhooks.Manager, error) {
+	store, err := recordstore.Open[webhooks.Record](cfg.Path, cfg.Key, "webhooks")
+	if err != nil {
+		return nil, err
+	}
+	// Pending deliveries and the last offline task list seen per account live
+	// in the same file
+	deliveries, err := recordstore.OpenIn[webhooks.PendingDelivery](store, "webhook-deliveries")
+	if err != nil {
+		return nil, errors.Join(err, store.Close())
+	}
+	snapshots, err := recordstore.OpenIn[offline.Snapshot](store, "webhook-tasks")
+	if err != nil {
+		return nil, errors.Join(err, store.Close())
+	}
+	return webhooks.NewManager(store, deliveries, snapshots, watcher, webhooks.Options{
+		MaxAttempts:          cfg.MaxAttempts,
+		RetryDelay:           cfg.RetryDelay,
+		Timeout:              cfg.Timeout,
+		AllowPrivateNetworks: cfg.AllowPrivateNetworks,
+	})
+}
+
+// newRuleManager opens the rule store and audit log, keeping either in memory
+// when no path is set
+func newRuleManager(cfg config.RulesConfig, drive rules.Drive, watcher *offline.Watcher) (*rules.Manager, error) {
+	store, err := recordstore.Open[rules.Record](cfg.Path, cfg.Key, "rules")
+	if err != nil {
+		return nil, err
+	}
+	snapshots, err := recordstore.OpenIn[offline.Snapshot](store, "rule-tasks")
+	if err != nil {
+		return nil, errors.Join(err, store.Close())
+	}
+	var audit rules.AuditLog = rules.NewMemoryAuditLog()
+	if cfg.AuditLog != "" {
+		fileLog, err := rules.NewFileAuditLog(cfg.AuditLog)
+		if err != nil {
+			return nil, err
+		}
+		audit = fileLog
+	}
+	return rules.NewManager(store, snapshots, audit, drive, watcher)
+}
+
+// webdavMethods are the methods routed to the WebDAV handler
+var webdavMethods = []string{
+	http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions,
+	"PROPFIND", "PROPPATCH", "MKCOL", "COPY", "MOVE", "LOCK", "UNLOCK",
+}
+
+func newWebDAVHandler(cfg config.WebDAVConfig, service *services.Drive115Service, resolver *middleware.CredentialResolver, auth *middleware.Authenticator) (*handlers.WebDAVHandler, error) {
+	maxUploadSize, err := bytes.Parse(cfg.MaxUploadSize)
+	if err != nil {
+		return nil, err
+	}
+	users := make([]handlers.WebDAVUser, 0, len(cfg.Users))
+	for _, user := range cfg.Users {
+		users = append(users, handlers.WebDAVUser{
+			Username: user.Username,
+			Password: user.Password,
+			Credentials: models.Drive115Credentials{
+				UID: user.UID, CID: user.CID, SEID: user.SEID, KID: user.KID, Account: user.Account,
+			},
+		})
+	}
+	davHandler := &dav.Handler{Prefix: cfg.Prefix, Backend: service, SpoolDir: cfg.SpoolDir, MaxUploadSize: maxUploadSize}
+	return handlers.NewWebDAVHandler(davHandler, users, resolver, auth)
+}
+
+func newS3Handler(cfg config.S3Config, service *services.Drive115Service, resolver *middleware.CredentialResolver) (*s3.Handler, error) {
+	maxUploadSize, err := bytes.Parse(cfg.MaxUploadSize)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]s3.AccessKey, 0, len(cfg.AccessKeys))
+	for _, key := range cfg.AccessKeys {
+		keys = append(keys, s3.AccessKey{
+			ID:     key.AccessKeyID,
+			Secret: key.SecretAccessKey,
+			Credentials: models.Drive115Credentials{
+				UID: key.UID, CID: key.CID, SEID: key.SEID, KID: key.KID, Account: key.Account,
+			},
+		})
+	}
+	return s3.NewHandler(service, resolver, s3.Config{
+		Region:        cfg.Region,
+		SpoolDir:      cfg.SpoolDir,
+		MaxUploadSize: maxUploadSize,
+		AccessKeys:    keys,
+	})
+}
+
+// newS3Server serves the S3 gateway on its own Echo instance. S3 addresses
+// buckets at the root, so it cannot share the API's URL space.
+func newS3Server(handler *s3.Handler) *echo.Echo {
+	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = true
+	e.Use(requestLogger())
+	e.Use(echomiddleware.Recover())
+	e.Any("/*", echo.WrapHandler(handler))
+	return e
+}
+
+func underPrefix(urlPath, prefix string) bool {
+	return urlPath == prefix || strings.HasPrefix(urlPath, prefix+"/")
+}
+
+// setupWebDAV mounts the WebDAV front-end. It authenticates with basic auth
+// itself, so it sits outside the API scope middleware.
+func setupWebDAV(e *echo.Echo, prefix string, webdavHandler *handlers.WebDAVHandler) {
+	e.Match(webdavMethods, prefix, webdavHandler.Serve)
+	e.Match(webdavMethods, prefix+"/*", webdavHandler.Serve)
+}
+
+// Start starts the HTTP server, and the S3 gateway when enabled. It returns
+// when either listener stops.
 func (s *Server) Start() error {
 	address := fmt.Sprintf("%s:%d", s.config.Server.Host, s.config.Server.Port)
 	protocols := new(http.Protocols)
@@ -163,10 +467,35 @@ func (s *Server) Start() error {
 	protocols.SetUnencryptedHTTP2(true)
 	s.echo.Server.Addr = address
 	s.echo.Server.Protocols = protocols
-	return s.echo.StartServer(s.echo.Server)
+
+	errs := make(chan error, 2)
+	s.jobs.Start()
+	s.webhooks.Start()
+	s.rules.Start()
+	if s.s3 != nil {
+		s.s3.Server.Addr = fmt.Sprintf("%s:%d", s.config.S3.Host, s.config.S3.Port)
+		go func() { errs <- s.s3.StartServer(s.s3.Server) }()
+	}
+	go func() { errs <- s.echo.StartServer(s.echo.Server) }()
+	return <-errs
 }
 
-// Shutdown gracefully shuts down the server
+// Shutdown gracefully shuts down the server. Task event streams never go
+// idle, so they are ended first.
 func (s *Server) Shutdown(ctx context.Context) error {
-	return s.echo.Shutdown(ctx)
+	s.tasks.Close()
+	if s.s3 != nil {
+		if err := s.s3.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+	if err := s.echo.Shutdown(ctx); err != nil {
+		return err
+	}
+	err := errors.Join(s.jobs.Stop(ctx), s.webhooks.Stop(ctx), s.rules.Stop(ctx))
+	// Closed last, since the managers may look up handles until they stop
+	if s.accounts != nil {
+		err = errors.Join(err, s.accounts.Close())
+	}
+	return err
 }
@@ -143,3 +143,74 @@ func TestUploadCORSIntegration(t *testing.T) {
 		}
 	}
 }
+
+func TestWebDAVRoutesRequireBasicAuth(t *testing.T) {
+	server, err := New(&config.Config{
+		UploadSessionSecret: "test-upload-session-secret-at-least-32-characters",
+		WebDAV:              config.WebDAVConfig{Enabled: true, Prefix: "/dav", MaxUploadSize: "1G"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, method := range []string{http.MethodOptions, "PROPFIND", "MKCOL", http.MethodGet} {
+		req := httptest.NewRequest(method, "/dav/Movies", nil)
+		rec := httptest.NewRecorder()
+		server.echo.ServeHTTP(rec, req)
+		if rec.Code != http.StatusUnauthorized {
+			t.Fatalf("%s status = %d, body = %s", method, rec.Code, rec.Body.String())
+		}
+		if got := rec.Header().Get("WWW-Authenticate"); !strings.HasPrefix(got, "Basic") {
+			t.Fatalf("%s WWW-Authenticate = %q", method, got)
+		}
+	}
+}
+
+func TestS3GatewayRejectsUnsignedRequests(t *testing.T) {
+	server, err := New(&config.Config{
+		UploadSessionSecret: "test-upload-session-secret-at-least-32-characters",
+		S3: config.S3Config{
+			Enabled:       true,
+			Region:        "us-east-1",
+			MaxUploadSize: "1G",
+			AccessKeys:    []config.S3AccessKeyConfig{{AccessKeyID: "backup", SecretAccessKey: "backup-secret-key-1234", Account: "acct_test"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/backups?list-type=2", nil)
+	rec := httptest.NewRecorder()
+	server.s3.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), "<Code>AccessDenied</Code>") {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestJobRoutesScopeByJobType(t *testing.T) {
+	server, err := New(&config.Config{
+		UploadSessionSecret: "test-upload-session-secret-at-least-32-characters",
+		Auth:                config.AuthConfig{APIKeys: []config.APIKeyConfig{{Name: "reader", Key: "reader-key-at-least-16", Scopes: []string{"read"}}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentials := `"credentials":{"uid":"uid","cid":"cid","seid":"seid","kid":"kid"}`
+	for _, test := range []struct {
+		path, body string
+		status     int
+	}{
+		{"/api/v1/jobs", `{` + credentials + `,"type":"delete","params":{"file_ids":["1"]}}`, http.StatusForbidden},
+		{"/api/v1/jobs", `{` + credentials + `,"type":"walk","params":{"max_depth":100}}`, http.StatusBadRequest},
+		{"/api/v1/jobs/job_missing", `{` + credentials + `}`, http.StatusNotFound},
+		{"/api/v1/jobs/list", `{` + credentials + `}`, http.StatusOK},
+	} {
+		req := httptest.NewRequest(http.MethodPost, test.path, strings.NewReader(test.body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-API-Key", "reader-key-at-least-16")
+		rec := httptest.NewRecorder()
+		server.echo.ServeHTTP(rec, req)
+		if rec.Code != test.status {
+			t.Errorf("%s %s: status = %d, body = %s", test.path, test.body, rec.Code, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package services
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+
+	"cloud-driver/internal/models"
+
+	"github.com/SheltonZhu/115driver/pkg/driver"
+)
+
+const (
+	// clientPoolSize bounds how many logged-in clients are kept between requests
+	clientPoolSize = 256
+	// clientPoolTTL is how long a login check stays trusted before it is repeated
+	clientPoolTTL = 5 * time.Minute
+)
+
+// clientPool keeps logged-in 115 clients per credential set so requests skip
+// the passport LoginCheck round trip. Entries are evicted least recently used,
+// revalidated after a TTL and dropped when 115 reports the session ended.
+//
+// Pooled clients share one resty client, which is safe for concurrent use, but
+// Pan115Client also stores per-call state (Request, Userkey, UploadMetaInfo),
+// so callers always get a shallow copy.
+type clientPool struct {
+	capacity  int
+	ttl       time.Duration
+	now       func() time.Time
+	newClient func(models.Drive115Credentials) *driver.Pan115Client
+	check     func(*driver.Pan115Client) error
+
+	mu       sync.Mutex
+	order    *list.List
+	entries  map[string]*list.Element
+	inflight map[string]*loginCall
+}
+
+type pooledClient struct {
+	key       string
+	client    *driver.Pan115Client
+	checkedAt time.Time
+}
+
+// loginCall lets concurrent requests for one account share a single LoginCheck
+type loginCall struct {
+	done   chan struct{}
+	client *driver.Pan115Client
+	err    error
+}
+
+func newClientPool() *clientPool {
+	return &clientPool{
+		capacity:  clientPoolSize,
+		ttl:       clientPoolTTL,
+		now:       time.Now,
+		newClient: newDriverClient,
+		check:     func(client *driver.Pan115Client) error { return client.LoginCheck() },
+		order:     list.New(),
+		entries:   map[string]*list.Element{},
+		inflight:  map[string]*loginCall{},
+	}
+}
+
+func newDriverClient(credentials models.Drive115Credentials) *driver.Pan115Client {
+	return driver.New(driver.UA(driver.UA115Browser)).ImportCredential(&driver.Credential{
+		UID: credentials.UID, CID: credentials.CID, SEID: credentials.SEID, KID: credentials.KID,
+	})
+}
+
+// get returns a client whose login was verified within the TTL
+func (p *clientPool) get(credentials models.Drive115Credentials) (*driver.Pan115Client, error) {
+	key := credentials.Key()
+
+	p.mu.Lock()
+	if element, ok := p.entries[key]; ok {
+		entry := element.Value.(*pooledClient)
+		if !entry.checkedAt.IsZero() && p.now().Sub(entry.checkedAt) < p.ttl {
+			p.order.MoveToFront(element)
+			p.mu.Unlock()
+			return copyClient(entry.client), nil
+		}
+	}
+	if call, ok := p.inflight[key]; ok {
+		p.mu.Unlock()
+		<-call.done
+		if call.err != nil {
+			return nil, call.err
+		}
+		return copyClient(call.client), nil
+	}
+	call := &loginCall{done: make(chan struct{})}
+	p.inflight[key] = call
+	client := p.newClient(credentials)
+	if element, ok := p.entries[key]; ok {
+		// Keep the existing cookie jar and connections when revalidating
+		client = copyClient(element.Value.(*pooledClient).client)
+	}
+	p.mu.Unlock()
+
+	call.err = p.check(client)
+	call.client = client
+
+	p.mu.Lock()
+	delete(p.inflight, key)
+	if call.err != nil {
+		p.remove(key)
+	} else {
+		p.store(key, client, p.now())
+	}
+	p.mu.Unlock()
+	close(call.done)
+
+	if call.err != nil {
+		return nil, call.err
+	}
+	return copyClient(client), nil
+}
+
+// getUnchecked returns a pooled client without a login check. A new client is
+// pooled as unchecked so the next get still verifies it.
+func (p *clientPool) getUnchecked(credentials models.Drive115Credentials) *driver.Pan115Client {
+	key := credentials.Key()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if element, ok := p.entries[key]; ok {
+		p.order.MoveToFront(element)
+		return copyClient(element.Value.(*pooledClient).client)
+	}
+	client := p.newClient(credentials)
+	p.store(key, client, time.Time{})
+	return copyClient(client)
+}
+
+// evictOnLogout drops the pooled client when *err shows 115 ended the session.
+// It is meant to be deferred by service methods with a named error result.
+func (p *clientPool) evictOnLogout(credentials models.Drive115Credentials, err *error) {
+	if *err == nil || !isLoggedOut(*err) {
+		return
+	}
+	p.mu.Lock()
+	p.remove(credentials.Key())
+	p.mu.Unlock()
+}
+
+func isLoggedOut(err error) bool {
+	return errors.Is(err, driver.ErrNotLogin) ||
+		errors.Is(err, driver.ErrDoesLoggedOut) ||
+		errors.Is(err, driver.ErrCredentialInvalid)
+}
+
+// store inserts or refreshes an entry; callers must hold p.mu
+func (p *clientPool) store(key string, client *driver.Pan115Client, checkedAt time.Time) {
+	if element, ok := p.entries[key]; ok {
+		entry := element.Value.(*pooledClient)
+		entry.client = client
+		entry.checkedAt = checkedAt
+		p.order.MoveToFront(element)
+		return
+	}
+	p.entries[key] = p.order.PushFront(&pooledClient{key: key, client: client, checkedAt: checkedAt})
+	for p.order.Len() > p.capacity {
+		oldest := p.order.Back()
+		p.order.Remove(oldest)
+		delete(p.entries, oldest.Value.(*pooledClient).key)
+	}
+}
+
+// remove drops an entry; callers must hold p.mu
+func (p *clientPool) remove(key string) {
+	if element, ok := p.entries[key]; ok {
+		p.order.Remove(element)
+		delete(p.entries, key)
+	}
+}
+
+func copyClient(client *driver.Pan115Client) *driver.Pan115Client {
+	c := *client
+	c.Request = nil
+	return &c
+}
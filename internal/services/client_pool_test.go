@@ -0,0 +1,89 @@
+package services
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud-driver/internal/models"
+
+	"github.com/SheltonZhu/115driver/pkg/driver"
+)
+
+func testClientPool(checks *atomic.Int32, release <-chan struct{}) *clientPool {
+	pool := newClientPool()
+	pool.newClient = func(models.Drive115Credentials) *driver.Pan115Client { return &driver.Pan115Client{} }
+	pool.check = func(*driver.Pan115Client) error {
+		checks.Add(1)
+		if release != nil {
+			<-release
+		}
+		return nil
+	}
+	return pool
+}
+
+func TestClientPoolSharesConcurrentLoginCheck(t *testing.T) {
+	var checks atomic.Int32
+	release := make(chan struct{})
+	pool := testClientPool(&checks, release)
+	credentials := models.Drive115Credentials{UID: "uid", CID: "cid", SEID: "seid", KID: "kid"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := pool.get(credentials); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if checks.Load() != 1 {
+		t.Fatalf("login checks = %d, want 1", checks.Load())
+	}
+}
+
+func TestClientPoolRevalidatesAndEvicts(t *testing.T) {
+	var checks atomic.Int32
+	pool := testClientPool(&checks, nil)
+	now := time.Unix(1700000000, 0)
+	pool.now = func() time.Time { return now }
+	pool.capacity = 2
+	first := models.Drive115Credentials{UID: "1", CID: "c", SEID: "s", KID: "k"}
+
+	mustGet := func(credentials models.Drive115Credentials) {
+		t.Helper()
+		if _, err := pool.get(credentials); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mustGet(first)
+	mustGet(first)
+	if checks.Load() != 1 {
+		t.Fatalf("login checks = %d, want 1 while fresh", checks.Load())
+	}
+
+	now = now.Add(clientPoolTTL)
+	mustGet(first)
+	if checks.Load() != 2 {
+		t.Fatalf("login checks = %d, want 2 after TTL", checks.Load())
+	}
+
+	err := driver.ErrDoesLoggedOut
+	pool.evictOnLogout(first, &err)
+	mustGet(first)
+	if checks.Load() != 3 {
+		t.Fatalf("login checks = %d, want 3 after logout eviction", checks.Load())
+	}
+
+	mustGet(models.Drive115Credentials{UID: "2"})
+	mustGet(models.Drive115Credentials{UID: "3"})
+	if _, ok := pool.entries[first.Key()]; ok || pool.order.Len() != 2 {
+		t.Fatalf("pool kept %d entries and the least recently used one", pool.order.Len())
+	}
+}
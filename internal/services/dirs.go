@@ -0,0 +1,145 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"cloud-driver/internal/models"
+
+	"github.com/SheltonZhu/115driver/pkg/driver"
+)
+
+// dirClient is the part of Pan115Client used to resolve and create directories
+type dirClient interface {
+	pathClient
+	Mkdir(parentID string, name string) (string, error)
+}
+
+// SplitDirPath validates a slash-separated directory path and returns its
+// components; the root ("/" or "") has none
+func SplitDirPath(dirPath string) ([]string, error) {
+	var parts []string
+	for _, part := range strings.Split(dirPath, "/") {
+		if part == "" {
+			continue
+		}
+		invalidControl := strings.IndexFunc(part, func(r rune) bool { return r < 0x20 || r == 0x7f }) >= 0
+		if part == "." || part == ".." || len(part) > 255 || invalidControl || strings.Contains(part, "\\") {
+			return nil, fmt.Errorf("invalid path component %q", part)
+		}
+		parts = append(parts, part)
+	}
+	return parts, nil
+}
+
+// JoinDirPath renders path components as an absolute path
+func JoinDirPath(parts []string) string {
+	return "/" + strings.Join(parts, "/")
+}
+
+// Mkdir creates a directory and returns its ID
+func (s *Drive115Service) Mkdir(ctx context.Context, credentials models.Drive115Credentials, parentID, name string) (_ string, err error) {
+	client, err := s.createClient(credentials)
+	if err != nil {
+		return "", err
+	}
+	defer s.clients.evictOnLogout(credentials, &err)
+	id, err := client.Mkdir(parentID, name)
+	if err != nil {
+		return "", err
+	}
+	s.invalidatePaths(credentials, parentID)
+	return id, nil
+}
+
+// ResolveDir returns the ID of the directory at dirPath
+func (s *Drive115Service) ResolveDir(ctx context.Context, credentials models.Drive115Credentials, dirPath string) (_ string, err error) {
+	client, err := s.createClient(credentials)
+	if err != nil {
+		return "", err
+	}
+	defer s.clients.evictOnLogout(credentials, &err)
+	return s.paths.resolveDir(credentials.Key(), client, dirPath)
+}
+
+// EnsureDir resolves dirPath, creating any missing directories. It is
+// idempotent and returns the paths it created.
+func (s *Drive115Service) EnsureDir(ctx context.Context, credentials models.Drive115Credentials, dirPath string) (_ string, _ []string, err error) {
+	parts, err := SplitDirPath(dirPath)
+	if err != nil {
+		return "", nil, err
+	}
+	client, err := s.createClient(credentials)
+	if err != nil {
+		return "", nil, err
+	}
+	defer s.clients.evictOnLogout(credentials, &err)
+	key := credentials.Key()
+	id, created, err := s.paths.ensureDir(ctx, key, client, parts)
+	if len(created) > 0 {
+		s.paths.reset(key)
+	}
+	return id, created, err
+}
+
+// statDirParts looks up a directory path without the cache, confirming the
+// DirName2CID answer with Stat like every other directory lookup
+func (c *pathCache) statDirParts(key string, client pathClient, parts []string) (string, error) {
+	if len(parts) == 0 {
+		return "0", nil
+	}
+	entry, ok, err := c.statDir(key, client, JoinDirPath(parts))
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("directory %s: %w", JoinDirPath(parts), driver.ErrNotExist)
+	}
+	return entry.ID, nil
+}
+
+// ensureDir returns the ID of the directory at parts, creating the missing
+// ones below the deepest existing ancestor
+func (c *pathCache) ensureDir(ctx context.Context, key string, client dirClient, parts []string) (string, []string, error) {
+	id, err := c.statDirParts(key, client, parts)
+	if err == nil || !errors.Is(err, driver.ErrNotExist) {
+		return id, nil, err
+	}
+
+	// Find the deepest existing ancestor, then create the rest below it
+	existing := len(parts) - 1
+	parentID := "0"
+	for ; existing > 0; existing-- {
+		parentID, err = c.statDirParts(key, client, parts[:existing])
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, driver.ErrNotExist) {
+			return "", nil, err
+		}
+	}
+	if existing == 0 {
+		parentID = "0"
+	}
+
+	var created []string
+	for index := existing; index < len(parts); index++ {
+		if err := ctx.Err(); err != nil {
+			return "", created, err
+		}
+		id, err := client.Mkdir(parentID, parts[index])
+		if errors.Is(err, driver.ErrExist) {
+			// Created concurrently by someone else; use theirs
+			id, err = c.statDirParts(key, client, parts[:index+1])
+		} else if err == nil {
+			created = append(created, JoinDirPath(parts[:index+1]))
+		}
+		if err != nil {
+			return "", created, err
+		}
+		parentID = id
+	}
+	return parentID, created, nil
+}
@@ -0,0 +1,170 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/SheltonZhu/115driver/pkg/driver"
+)
+
+// fakeDirs maps directory paths (without leading slash) to IDs. DirName2CID
+// answers the paths in wrong with the given ID instead, like 115 does for some
+// missing paths.
+type fakeDirs struct {
+	ids    map[string]string
+	parent map[string]string
+	wrong  map[string]string
+	mkdirs int
+	race   string
+}
+
+func newFakeDirs(paths ...string) *fakeDirs {
+	dirs := &fakeDirs{ids: map[string]string{}, parent: map[string]string{"0": ""}}
+	for _, path := range paths {
+		dirs.add(path)
+	}
+	return dirs
+}
+
+func (f *fakeDirs) add(path string) string {
+	id := strconv.Itoa(100 + len(f.ids))
+	f.ids[path] = id
+	f.parent[id] = path
+	return id
+}
+
+func (f *fakeDirs) DirName2CID(dir string) (*driver.APIGetDirIDResp, error) {
+	dir = strings.TrimPrefix(dir, "/")
+	id, ok := f.ids[dir]
+	if wrong, bad := f.wrong[dir]; bad {
+		id, ok = wrong, true
+	}
+	if !ok {
+		id = "0"
+	}
+	return &driver.APIGetDirIDResp{CategoryID: driver.IntString(id)}, nil
+}
+
+func (f *fakeDirs) Stat(fileID string) (*driver.FileStatInfo, error) {
+	dirPath, ok := f.parent[fileID]
+	if !ok || fileID == "0" {
+		return nil, driver.ErrNotExist
+	}
+	parts := strings.Split(dirPath, "/")
+	parents := []*driver.DirInfo{{ID: "0", Name: "root"}}
+	for index := range parts[:len(parts)-1] {
+		parents = append(parents, &driver.DirInfo{ID: f.ids[strings.Join(parts[:index+1], "/")], Name: parts[index]})
+	}
+	return &driver.FileStatInfo{Name: parts[len(parts)-1], IsDirectory: true, Parents: parents}, nil
+}
+
+func (f *fakeDirs) List(dirID string, opts ...driver.ListOption) (*[]driver.File, error) {
+	return &[]driver.File{}, nil
+}
+
+func (f *fakeDirs) Mkdir(parentID, name string) (string, error) {
+	f.mkdirs++
+	path := f.parent[parentID]
+	if path != "" {
+		path += "/"
+	}
+	path += name
+	if path == f.race {
+		// Another client creates the directory between resolve and mkdir
+		f.race = ""
+		f.add(path)
+	}
+	if _, ok := f.ids[path]; ok {
+		return "", driver.ErrExist
+	}
+	return f.add(path), nil
+}
+
+func TestSplitDirPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{"/", "/", false},
+		{"", "/", false},
+		{"/a//b/", "/a/b", false},
+		{"a/b", "/a/b", false},
+		{"/a/../b", "", true},
+		{"/a/./b", "", true},
+		{"/a\\b", "", true},
+		{"/a\tb", "", true},
+	}
+	for _, tt := range tests {
+		parts, err := SplitDirPath(tt.path)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("SplitDirPath(%q) error = %v, wantErr %v", tt.path, err, tt.wantErr)
+		}
+		if err == nil && JoinDirPath(parts) != tt.want {
+			t.Fatalf("SplitDirPath(%q) = %q, want %q", tt.path, JoinDirPath(parts), tt.want)
+		}
+	}
+}
+
+func TestStatDirParts(t *testing.T) {
+	dirs := newFakeDirs("a", "a/b")
+	cache := newPathCache()
+	if id, err := cache.statDirParts("acct", dirs, nil); err != nil || id != "0" {
+		t.Fatalf("root = %q, %v", id, err)
+	}
+	if id, err := cache.statDirParts("acct", dirs, []string{"a", "b"}); err != nil || id != dirs.ids["a/b"] {
+		t.Fatalf("a/b = %q, %v", id, err)
+	}
+	if _, err := cache.statDirParts("acct", dirs, []string{"missing"}); !errors.Is(err, driver.ErrNotExist) {
+		t.Fatalf("missing error = %v", err)
+	}
+}
+
+func TestEnsureDirParts(t *testing.T) {
+	dirs := newFakeDirs("a")
+	cache := newPathCache()
+	id, created, err := cache.ensureDir(context.Background(), "acct", dirs, []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != dirs.ids["a/b/c"] || strings.Join(created, ",") != "/a/b,/a/b/c" {
+		t.Fatalf("id %q created %v", id, created)
+	}
+
+	// A second call finds everything and creates nothing
+	again, created, err := cache.ensureDir(context.Background(), "acct", dirs, []string{"a", "b", "c"})
+	if err != nil || again != id || len(created) != 0 || dirs.mkdirs != 2 {
+		t.Fatalf("repeat: id %q created %v mkdirs %d err %v", again, created, dirs.mkdirs, err)
+	}
+}
+
+func TestEnsureDirPartsTreatsExistAsSuccess(t *testing.T) {
+	dirs := newFakeDirs()
+	dirs.race = "x"
+	id, created, err := newPathCache().ensureDir(context.Background(), "acct", dirs, []string{"x", "y"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != dirs.ids["x/y"] || strings.Join(created, ",") != "/x/y" {
+		t.Fatalf("id %q created %v", id, created)
+	}
+}
+
+func TestEnsureDirPartsConfirmsDirName2CID(t *testing.T) {
+	dirs := newFakeDirs("a", "b")
+	// One answer names no directory at all, the other an unrelated one
+	dirs.wrong = map[string]string{"a/gone": "999", "a/other": dirs.ids["b"]}
+	cache := newPathCache()
+	for _, name := range []string{"gone", "other"} {
+		id, created, err := cache.ensureDir(context.Background(), "acct", dirs, []string{"a", name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != dirs.ids["a/"+name] || strings.Join(created, ",") != "/a/"+name {
+			t.Fatalf("%s: id %q created %v", name, id, created)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"cloud-driver/internal/models"
+
+	"github.com/SheltonZhu/115driver/pkg/driver"
+)
+
+// GetDownloadInfo resolves a file's pick code and returns a CDN URL bound to userAgent
+func (s *Drive115Service) GetDownloadInfo(ctx context.Context, credentials models.Drive115Credentials, fileID int64, userAgent string) (_ *models.DownloadInfoResponse, err error) {
+	client, err := s.createClient(credentials)
+	if err != nil {
+		return nil, err
+	}
+	defer s.clients.evictOnLogout(credentials, &err)
+
+	file, info, err := resolveDownload(ctx, client, strconv.FormatInt(fileID, 10), userAgent)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.DownloadInfoResponse{
+		FileID:    file.FileID,
+		FileName:  file.Name,
+		FileSize:  file.Size,
+		PickCode:  file.PickCode,
+		URL:       info.Url.Url,
+		UserAgent: userAgent,
+		Headers:   flattenHeader(info.Header),
+	}, nil
+}
+
+// resolveDownload looks up the pick code for fileID and fetches a download URL
+func resolveDownload(ctx context.Context, client *driver.Pan115Client, fileID, userAgent string) (*driver.File, *driver.DownloadInfo, error) {
+	file, err := client.GetFile(fileID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if file.FileID == "" {
+		return nil, nil, driver.ErrNotExist
+	}
+	if file.IsDirectory {
+		return nil, nil, driver.ErrDownloadDirectory
+	}
+	if file.PickCode == "" {
+		stat, err := client.Stat(fileID)
+		if err != nil {
+			return nil, nil, err
+		}
+		file.PickCode = stat.PickCode
+	}
+	if file.PickCode == "" {
+		return nil, nil, driver.ErrPickCodeIsEmpty
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	info, err := downloadByPickCode(client, file.PickCode, userAgent)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, info, nil
+}
+
+// downloadByPickCode fetches a download URL, falling back to the Android API
+// when the web API refuses the request or returns no URL.
+func downloadByPickCode(client *driver.Pan115Client, pickCode, userAgent string) (*driver.DownloadInfo, error) {
+	info, err := client.DownloadWithUA(pickCode, userAgent)
+	if err != nil || info.Url.Url == "" {
+		var fallbackErr error
+		info, fallbackErr = client.DownloadWithUAByAndroidAPI(pickCode, userAgent)
+		if fallbackErr != nil {
+			if err == nil {
+				err = driver.ErrDownloadEmpty
+			}
+			return nil, fmt.Errorf("%w (android fallback: %v)", err, fallbackErr)
+		}
+	}
+	if info.Url.Url == "" {
+		return nil, driver.ErrDownloadEmpty
+	}
+	return info, nil
+}
+
+func flattenHeader(header http.Header) map[string]string {
+	flat := make(map[string]string, len(header))
+	for key, values := range header {
+		if len(values) > 0 {
+			flat[key] = strings.Join(values, ", ")
+		}
+	}
+	return flat
+}
+
+// DownloadStream is an open CDN response for a 115 file
+type DownloadStream struct {
+	FileName string
+	FileSize int64
+	Response *http.Response
+}
+
+// streamForwardHeaders are the caller request headers relayed to the CDN
+var streamForwardHeaders = []string{"Range", "If-Range"}
+
+// OpenDownloadStream requests a file's bytes from the 115 CDN, forwarding Range
+// and If-Range so partial responses pass through untouched. The caller must
+// close the response body.
+func (s *Drive115Service) OpenDownloadStream(ctx context.Context, credentials models.Drive115Credentials, fileID int64, method string, requestHeader http.Header) (_ *DownloadStream, err error) {
+	client, err := s.createClient(credentials)
+	if err != nil {
+		return nil, err
+	}
+	defer s.clients.evictOnLogout(credentials, &err)
+
+	// The CDN link is fetched and consumed by this server, so bind it to our own User-Agent
+	file, info, err := resolveDownload(ctx, client, strconv.FormatInt(fileID, 10), driver.UA115Browser)
+	if err != nil {
+		return nil, err
+	}
+
+	return openCDNStream(ctx, file, info, method, requestHeader)
+}
+
+// OpenPickCodeStream is OpenDownloadStream for a file whose pick code is already known
+func (s *Drive115Service) OpenPickCodeStream(ctx context.Context, credentials models.Drive115Credentials, pickCode, fileName, method string, requestHeader http.Header) (_ *DownloadStream, err error) {
+	client, err := s.createClient(credentials)
+	if err != nil {
+		return nil, err
+	}
+	defer s.clients.evictOnLogout(credentials, &err)
+
+	info, err := downloadByPickCode(client, pickCode, driver.UA115Browser)
+	if err != nil {
+		return nil, err
+	}
+	file := &driver.File{Name: fileName, PickCode: pickCode, Size: int64(info.FileSize)}
+	return openCDNStream(ctx, file, info, method, requestHeader)
+}
+
+func openCDNStream(ctx context.Context, file *driver.File, info *driver.DownloadInfo, method string, requestHeader http.Header) (*DownloadStream, error) {
+	req, err := http.NewRequestWithContext(ctx, method, info.Url.Url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = info.Header.Clone()
+	if req.Header == nil {
+		req.Header = http.Header{}
+	}
+	for _, key := range streamForwardHeaders {
+		if value := requestHeader.Get(key); value != "" {
+			req.Header.Set(key, value)
+		}
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("request 115 CDN: %w", err)
+	}
+	if resp.StatusCode >= http.StatusBadRequest && resp.StatusCode != http.StatusRequestedRangeNotSatisfiable {
+		resp.Body.Close()
+		return nil, fmt.Errorf("115 CDN returned %s", resp.Status)
+	}
+
+	return &DownloadStream{FileName: file.Name, FileSize: file.Size, Response: resp}, nil
+}
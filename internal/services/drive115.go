@@ -6,6 +6,7 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"cloud-driver/internal/models"
@@ -14,7 +15,12 @@ import (
 )
 
 // Drive115Service provides 115drive cloud storage operations with credentials from requests
-type Drive115Service struct{}
+type Drive115Service struct {
+	clients *clientPool
+	paths   *pathCache
+	// offlineLimits holds a *rate.Limiter per account for bulk offline adds
+	offlineLimits sync.Map
+}
 
 var videoExtensions = map[string]bool{
 	"3gp":  true,
@@ -43,22 +49,14 @@ var trailingFC2PPVNamePattern = regexp.MustCompile(`(^|[^a-z0-9])fc2[- ]?ppv[- ]
 
 // NewDrive115Service creates a new instance of Drive115Service
 func NewDrive115Service() *Drive115Service {
-	return &Drive115Service{}
+	return &Drive115Service{clients: newClientPool(), paths: newPathCache()}
 }
 
-// createClient creates a 115driver client with the provided credentials
+// createClient returns a logged-in 115driver client for the provided credentials,
+// reusing a pooled client while its last login check is fresh
 func (s *Drive115Service) createClient(credentials models.Drive115Credentials) (*driver.Pan115Client, error) {
-	// Create driver credential
-	cr := &driver.Credential{
-		UID:  credentials.UID,
-		CID:  credentials.CID,
-		SEID: credentials.SEID,
-		KID:  credentials.KID,
-	}
-
-	// Create client and verify login
-	client := driver.New(driver.UA(driver.UA115Browser)).ImportCredential(cr)
-	if err := client.LoginCheck(); err != nil {
+	client, err := s.clients.get(credentials)
+	if err != nil {
 		return nil, fmt.Errorf("115 driver login failed: %w", err)
 	}
 
@@ -66,59 +64,68 @@ func (s *Drive115Service) createClient(credentials models.Drive115Credentials) (
 }
 
 // GetUser returns the current user information
-func (s *Drive115Service) GetUser(ctx context.Context, credentials models.Drive115Credentials) (interface{}, error) {
+func (s *Drive115Service) GetUser(ctx context.Context, credentials models.Drive115Credentials) (_ interface{}, err error) {
 	client, err := s.createClient(credentials)
 	if err != nil {
 		return nil, err
 	}
+	defer s.clients.evictOnLogout(credentials, &err)
 	return client.GetUser()
 }
 
 // ListOfflineTasks returns the list of offline download tasks
-func (s *Drive115Service) ListOfflineTasks(ctx context.Context, credentials models.Drive115Credentials, page int64) (interface{}, error) {
+func (s *Drive115Service) ListOfflineTasks(ctx context.Context, credentials models.Drive115Credentials, page int64) (_ interface{}, err error) {
 	client, err := s.createClient(credentials)
 	if err != nil {
 		return nil, err
 	}
+	defer s.clients.evictOnLogout(credentials, &err)
 	return client.ListOfflineTask(page)
 }
 
 // AddOfflineTaskURIs adds new offline download tasks
-func (s *Drive115Service) AddOfflineTaskURIs(ctx context.Context, credentials models.Drive115Credentials, urls []string, saveDirID string) ([]string, error) {
+func (s *Drive115Service) AddOfflineTaskURIs(ctx context.Context, credentials models.Drive115Credentials, urls []string, saveDirID string) (_ []string, err error) {
 	client, err := s.createClient(credentials)
 	if err != nil {
 		return nil, err
 	}
+	defer s.clients.evictOnLogout(credentials, &err)
 
-	client.SetUserAgent(driver.UA115Browser)
-
-	return client.AddOfflineTaskURIs(urls, saveDirID)
+	hashes, err := client.AddOfflineTaskURIs(urls, saveDirID)
+	if err != nil {
+		return nil, err
+	}
+	s.invalidatePaths(credentials, saveDirID)
+	return hashes, nil
 }
 
 // DeleteOfflineTasks deletes offline tasks by their hashes
-func (s *Drive115Service) DeleteOfflineTasks(ctx context.Context, credentials models.Drive115Credentials, hashes []string, deleteFiles bool) error {
+func (s *Drive115Service) DeleteOfflineTasks(ctx context.Context, credentials models.Drive115Credentials, hashes []string, deleteFiles bool) (err error) {
 	client, err := s.createClient(credentials)
 	if err != nil {
 		return err
 	}
+	defer s.clients.evictOnLogout(credentials, &err)
 	return client.DeleteOfflineTasks(hashes, deleteFiles)
 }
 
 // ClearOfflineTasks clears offline tasks with the specified flag
-func (s *Drive115Service) ClearOfflineTasks(ctx context.Context, credentials models.Drive115Credentials, clearFlag int64) error {
+func (s *Drive115Service) ClearOfflineTasks(ctx context.Context, credentials models.Drive115Credentials, clearFlag int64) (err error) {
 	client, err := s.createClient(credentials)
 	if err != nil {
 		return err
 	}
+	defer s.clients.evictOnLogout(credentials, &err)
 	return client.ClearOfflineTasks(clearFlag)
 }
 
 // ListFiles lists one page of files and directories in the specified directory
-func (s *Drive115Service) ListFiles(ctx context.Context, credentials models.Drive115Credentials, dirID, offset, limit int64) (*[]driver.File, error) {
+func (s *Drive115Service) ListFiles(ctx context.Context, credentials models.Drive115Credentials, dirID, offset, limit int64) (_ *[]driver.File, err error) {
 	client, err := s.createClient(credentials)
 	if err != nil {
 		return nil, err
 	}
+	defer s.clients.evictOnLogout(credentials, &err)
 
 	if limit == 0 {
 		limit = 25
@@ -130,11 +137,12 @@ func (s *Drive115Service) ListFiles(ctx context.Context, credentials models.Driv
 }
 
 // CheckFolderVideos checks direct files in a folder for matching videos without returning directories.
-func (s *Drive115Service) CheckFolderVideos(ctx context.Context, credentials models.Drive115Credentials, dirID, limit int64, indexedName string) (*models.CheckFolderVideosResponse, error) {
+func (s *Drive115Service) CheckFolderVideos(ctx context.Context, credentials models.Drive115Credentials, dirID, limit int64, indexedName string) (_ *models.CheckFolderVideosResponse, err error) {
 	client, err := s.createClient(credentials)
 	if err != nil {
 		return nil, err
 	}
+	defer s.clients.evictOnLogout(credentials, &err)
 
 	if limit == 0 {
 		limit = 25
@@ -383,33 +391,6 @@ func isVideoFile(file driver.FileInfo) bool {
 	return videoExtensions[strings.ToLower(parts[len(parts)-1])]
 }
 
-// GetFileInfo returns information about a specific file
-func (s *Drive115Service) GetFileInfo(ctx context.Context, credentials models.Drive115Credentials, fileID int64) (interface{}, error) {
-	client, err := s.createClient(credentials)
-	if err != nil {
-		return nil, err
-	}
-
-	// Use GetInfo method instead of non-existent GetFileInfoByID
-	// Note: This returns system info, not specific file info
-	// For specific file info, we might need to use other methods
-	return client.GetInfo()
-}
-
-// GetDownloadInfo returns download information for a file
-func (s *Drive115Service) GetDownloadInfo(ctx context.Context, credentials models.Drive115Credentials, fileID int64) (interface{}, error) {
-	client, err := s.createClient(credentials)
-	if err != nil {
-		return nil, err
-	}
-
-	// The correct method signature requires a pickCode string, not fileID
-	// This is a placeholder - in a real implementation, you'd need to
-	// get the pickCode for the file first
-	pickCode := strconv.FormatInt(fileID, 10) // This is likely incorrect
-	return client.Download(pickCode)
-}
-
 // QRCodeStart initiates a QR code login session
 func (s *Drive115Service) QRCodeStart(ctx context.Context) (*models.QRCodeStartResponse, error) {
 	// Create a default client without credentials for QR code start
@@ -510,7 +491,7 @@ func (s *Drive115Service) QRCodeLogin(ctx context.Context, uid, sign string, tim
 	}
 
 	return &models.QRCodeLoginResponse{
-		Credentials: models.Drive115Credentials{
+		Credentials: &models.Drive115Credentials{
 			UID:  credential.UID,
 			CID:  credential.CID,
 			SEID: credential.SEID,
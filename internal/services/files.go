@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"cloud-driver/internal/models"
+
+	"github.com/SheltonZhu/115driver/pkg/driver"
+)
+
+// MoveFiles moves files or directories into the target directory
+func (s *Drive115Service) MoveFiles(ctx context.Context, credentials models.Drive115Credentials, fileIDs []string, targetDirID string) (err error) {
+	client, err := s.createClient(credentials)
+	if err != nil {
+		return err
+	}
+	defer s.clients.evictOnLogout(credentials, &err)
+	if err := client.Move(targetDirID, fileIDs...); err != nil {
+		return err
+	}
+	s.invalidatePaths(credentials, fileIDs...)
+	s.invalidatePaths(credentials, targetDirID)
+	return nil
+}
+
+// CopyFiles copies files or directories into the target directory
+func (s *Drive115Service) CopyFiles(ctx context.Context, credentials models.Drive115Credentials, fileIDs []string, targetDirID string) (err error) {
+	client, err := s.createClient(credentials)
+	if err != nil {
+		return err
+	}
+	defer s.clients.evictOnLogout(credentials, &err)
+	if err := client.Copy(targetDirID, fileIDs...); err != nil {
+		return err
+	}
+	s.invalidatePaths(credentials, targetDirID)
+	return nil
+}
+
+// RenameFiles renames each file in order and stops at the first failure
+func (s *Drive115Service) RenameFiles(ctx context.Context, credentials models.Drive115Credentials, files []models.RenameFileItem) (err error) {
+	client, err := s.createClient(credentials)
+	if err != nil {
+		return err
+	}
+	defer s.clients.evictOnLogout(credentials, &err)
+	for _, file := range files {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := client.Rename(file.FileID, file.Name); err != nil {
+			return fmt.Errorf("rename %s: %w", file.FileID, err)
+		}
+		s.invalidatePaths(credentials, file.FileID)
+	}
+	return nil
+}
+
+// DeleteFiles moves files or directories to the recycle bin
+func (s *Drive115Service) DeleteFiles(ctx context.Context, credentials models.Drive115Credentials, fileIDs []string) (err error) {
+	client, err := s.createClient(credentials)
+	if err != nil {
+		return err
+	}
+	defer s.clients.evictOnLogout(credentials, &err)
+	if err := client.Delete(fileIDs...); err != nil {
+		return err
+	}
+	s.invalidatePaths(credentials, fileIDs...)
+	return nil
+}
+
+// GetFileInfo returns metadata, parent chain and labels for a file or directory
+func (s *Drive115Service) GetFileInfo(ctx context.Context, credentials models.Drive115Credentials, fileID int64) (_ *models.FileInfoResponse, err error) {
+	client, err := s.createClient(credentials)
+	if err != nil {
+		return nil, err
+	}
+	defer s.clients.evictOnLogout(credentials, &err)
+
+	fileIDStr := strconv.FormatInt(fileID, 10)
+	file, err := client.GetFile(fileIDStr)
+	if err != nil {
+		return nil, err
+	}
+	if file.FileID == "" {
+		return nil, driver.ErrNotExist
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	stat, err := client.Stat(fileIDStr)
+	if err != nil {
+		return nil, err
+	}
+
+	return fileInfoResponse(file, stat), nil
+}
+
+func fileInfoResponse(file *driver.File, stat *driver.FileStatInfo) *models.FileInfoResponse {
+	info := &models.FileInfoResponse{
+		ID:          file.FileID,
+		ParentID:    file.ParentID,
+		Name:        file.Name,
+		IsDirectory: file.IsDirectory || stat.IsDirectory,
+		Size:        file.Size,
+		SHA1:        file.Sha1,
+		PickCode:    file.PickCode,
+		Star:        file.Star,
+		Parents:     make([]models.DirRef, 0, len(stat.Parents)),
+		ThumbURL:    file.ThumbURL,
+		CreatedAt:   file.CreateTime,
+		UpdatedAt:   file.UpdateTime,
+	}
+	if info.PickCode == "" {
+		info.PickCode = stat.PickCode
+	}
+	if info.SHA1 == "" {
+		info.SHA1 = stat.Sha1
+	}
+	if stat.CreateTime.Unix() > 0 {
+		info.CreatedAt = stat.CreateTime
+	}
+	if stat.UpdateTime.Unix() > 0 {
+		info.UpdatedAt = stat.UpdateTime
+	}
+	if info.IsDirectory {
+		fileCount, dirCount := stat.FileCount, stat.DirCount
+		info.FileCount = &fileCount
+		info.DirCount = &dirCount
+	}
+	info.Labels = fileLabels(file.Labels)
+	for _, parent := range stat.Parents {
+		info.Parents = append(info.Parents, models.DirRef{ID: parent.ID, Name: parent.Name})
+	}
+	return info
+}
+
+func fileLabels(labels []*driver.Label) []models.FileLabel {
+	result := make([]models.FileLabel, 0, len(labels))
+	for _, label := range labels {
+		color := ""
+		if int(label.Color) >= 0 && int(label.Color) < len(driver.LabelColors) {
+			color = driver.LabelColors[label.Color]
+		}
+		result = append(result, models.FileLabel{ID: label.ID, Name: label.Name, Color: color})
+	}
+	return result
+}
+
+func fileRow(file driver.File) models.FileRow {
+	return models.FileRow{
+		ID:          file.FileID,
+		ParentID:    file.ParentID,
+		Name:        file.Name,
+		IsDirectory: file.IsDirectory,
+		Size:        file.Size,
+		SHA1:        file.Sha1,
+		PickCode:    file.PickCode,
+		Star:        file.Star,
+		Labels:      fileLabels(file.Labels),
+		ThumbURL:    file.ThumbURL,
+		CreatedAt:   file.CreateTime,
+		UpdatedAt:   file.UpdateTime,
+	}
+}
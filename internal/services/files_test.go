@@ -0,0 +1,38 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/SheltonZhu/115driver/pkg/driver"
+)
+
+func TestFileInfoResponseMergesStat(t *testing.T) {
+	updated := time.Unix(1_700_000_000, 0)
+	file := &driver.File{
+		FileID: "10", ParentID: "2", Name: "Movies", IsDirectory: true, Star: true,
+		Labels: []*driver.Label{{ID: "7", Name: "watch", Color: 1}},
+	}
+	stat := &driver.FileStatInfo{
+		PickCode: "pc", IsDirectory: true, FileCount: 3, DirCount: 1, UpdateTime: updated,
+		Parents: []*driver.DirInfo{{ID: "0", Name: "root"}, {ID: "2", Name: "Media"}},
+	}
+	info := fileInfoResponse(file, stat)
+	if info.PickCode != "pc" || !info.UpdatedAt.Equal(updated) || !info.Star {
+		t.Fatalf("info = %+v", info)
+	}
+	if info.FileCount == nil || *info.FileCount != 3 || info.DirCount == nil || *info.DirCount != 1 {
+		t.Fatalf("counts = %v, %v", info.FileCount, info.DirCount)
+	}
+	if len(info.Parents) != 2 || info.Parents[1].Name != "Media" {
+		t.Fatalf("parents = %+v", info.Parents)
+	}
+	if len(info.Labels) != 1 || info.Labels[0].Color != "#FF4B30" {
+		t.Fatalf("labels = %+v", info.Labels)
+	}
+
+	info = fileInfoResponse(&driver.File{FileID: "11", Name: "a.mp4", Size: 5}, &driver.FileStatInfo{})
+	if info.FileCount != nil || info.DirCount != nil || info.IsDirectory {
+		t.Fatalf("file info = %+v", info)
+	}
+}
@@ -0,0 +1,201 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"cloud-driver/internal/jobs"
+	"cloud-driver/internal/models"
+)
+
+// Background job types
+const (
+	JobWalk         = "walk"
+	JobMove         = "move"
+	JobCopy         = "copy"
+	JobDelete       = "delete"
+	JobVideoCheck   = "video_check"
+	JobSourceUpload = "source_upload"
+	JobOfflineAdd   = "offline_add"
+)
+
+const (
+	// jobBatchSize is how many files a move, copy or delete job sends per call
+	jobBatchSize = 100
+	// maxWalkJobEntries bounds the entries a walk job keeps in its result
+	maxWalkJobEntries = 10000
+)
+
+var errWalkJobFull = errors.New("walk result is full")
+
+// WalkJob walks the tree below DirID
+type WalkJob struct {
+	DirID       int64 `json:"dir_id"`
+	MaxDepth    int   `json:"max_depth,omitempty"`
+	Concurrency int   `json:"concurrency,omitempty"`
+	FilesOnly   bool  `json:"files_only,omitempty"`
+}
+
+// WalkJobResult holds the walked entries. Truncated is set when the tree had
+// more entries than a job keeps; stream /files/walk for those.
+type WalkJobResult struct {
+	Entries     []models.WalkEntry `json:"entries"`
+	Files       int                `json:"files"`
+	Directories int                `json:"directories"`
+	Truncated   bool               `json:"truncated"`
+}
+
+// FileBatchJob moves, copies or deletes FileIDs. TargetDirID is unused by deletes.
+type FileBatchJob struct {
+	FileIDs     []string `json:"file_ids"`
+	TargetDirID string   `json:"target_dir_id,omitempty"`
+}
+
+// FileBatchJobResult counts the files processed
+type FileBatchJobResult struct {
+	Count       int    `json:"count"`
+	TargetDirID string `json:"target_dir_id,omitempty"`
+}
+
+// VideoCheckJob checks the direct files of DirID for videos
+type VideoCheckJob struct {
+	DirID       int64  `json:"dir_id"`
+	Limit       int64  `json:"limit,omitempty"`
+	IndexedName string `json:"indexed_name,omitempty"`
+}
+
+// OfflineAddJob adds URLs as offline tasks into SaveDirID, any number at a
+// time
+type OfflineAddJob struct {
+	URLs              []string `json:"urls"`
+	SaveDirID         string   `json:"save_dir_id"`
+	SkipExistingFiles bool     `json:"skip_existing_files,omitempty"`
+}
+
+// OfflineAddJobResult holds one result per URL, in order. QuotaExhausted is
+// set when the job stopped early because the offline quota ran out. A retried
+// job reports URLs that an earlier attempt added as task_exists.
+type OfflineAddJobResult struct {
+	Added          int                       `json:"added"`
+	Skipped        int                       `json:"skipped"`
+	Failed         int                       `json:"failed"`
+	QuotaExhausted bool                      `json:"quota_exhausted"`
+	Results        []models.OfflineAddResult `json:"results"`
+}
+
+// RegisterJobs installs the handlers for every background job type
+func RegisterJobs(manager *jobs.Manager, service *Drive115Service, sources *SourceUploader) {
+	manager.Register(JobWalk, service.runWalkJob)
+	manager.Register(JobMove, func(ctx context.Context, run *jobs.Run) (interface{}, error) {
+		return runFileBatchJob(ctx, run, func(ctx context.Context, ids []string, target string) error {
+			return service.MoveFiles(ctx, run.Credentials, ids, target)
+		})
+	})
+	manager.Register(JobCopy, func(ctx context.Context, run *jobs.Run) (interface{}, error) {
+		return runFileBatchJob(ctx, run, func(ctx context.Context, ids []string, target string) error {
+			return service.CopyFiles(ctx, run.Credentials, ids, target)
+		})
+	})
+	manager.Register(JobDelete, func(ctx context.Context, run *jobs.Run) (interface{}, error) {
+		return runFileBatchJob(ctx, run, func(ctx context.Context, ids []string, _ string) error {
+			return service.DeleteFiles(ctx, run.Credentials, ids)
+		})
+	})
+	manager.Register(JobVideoCheck, func(ctx context.Context, run *jobs.Run) (interface{}, error) {
+		var params VideoCheckJob
+		if err := run.Decode(&params); err != nil {
+			return nil, jobs.Permanent(err)
+		}
+		return service.CheckFolderVideos(ctx, run.Credentials, params.DirID, params.Limit, params.IndexedName)
+	})
+	manager.Register(JobOfflineAdd, service.runOfflineAddJob)
+	manager.Register(JobSourceUpload, func(ctx context.Context, run *jobs.Run) (interface{}, error) {
+		var params SourceUploadRequest
+		if err := run.Decode(&params); err != nil {
+			return nil, jobs.Permanent(err)
+		}
+		result, err := sources.Upload(ctx, run.Credentials, params, func(stage string, done, total int64) {
+			run.Report(jobs.Progress{Done: done, Total: total, Message: stage})
+		})
+		if errors.Is(err, ErrSourceNotAllowed) {
+			err = jobs.Permanent(err)
+		}
+		return result, err
+	})
+}
+
+func (s *Drive115Service) runWalkJob(ctx context.Context, run *jobs.Run) (interface{}, error) {
+	var params WalkJob
+	if err := run.Decode(&params); err != nil {
+		return nil, jobs.Permanent(err)
+	}
+	result := WalkJobResult{Entries: []models.WalkEntry{}}
+	options := WalkOptions{MaxDepth: params.MaxDepth, Concurrency: params.Concurrency, FilesOnly: params.FilesOnly}
+	err := s.Walk(ctx, run.Credentials, params.DirID, options, func(entry models.WalkEntry) error {
+		if len(result.Entries) == maxWalkJobEntries {
+			result.Truncated = true
+			return errWalkJobFull
+		}
+		result.Entries = append(result.Entries, entry)
+		if entry.IsDirectory {
+			result.Directories++
+		} else {
+			result.Files++
+		}
+		run.Report(jobs.Progress{Done: int64(len(result.Entries)), Message: entry.Path})
+		return nil
+	})
+	if err != nil && !errors.Is(err, errWalkJobFull) {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (s *Drive115Service) runOfflineAddJob(ctx context.Context, run *jobs.Run) (interface{}, error) {
+	var params OfflineAddJob
+	if err := run.Decode(&params); err != nil {
+		return nil, jobs.Permanent(err)
+	}
+	total := int64(len(params.URLs))
+	results, err := s.AddOfflineTasksBulk(ctx, run.Credentials, params.URLs, params.SaveDirID, params.SkipExistingFiles, func(done int) {
+		run.Report(jobs.Progress{Done: int64(done), Total: total})
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := OfflineAddJobResult{Results: results}
+	for _, r := range results {
+		switch r.Status {
+		case OfflineURIAdded:
+			result.Added++
+		case OfflineURISkipped:
+			result.Skipped++
+		default:
+			result.Failed++
+			result.QuotaExhausted = result.QuotaExhausted || r.Reason == OfflineFailQuotaExhausted
+		}
+	}
+	return result, nil
+}
+
+// runFileBatchJob applies apply to the job's files in batches. Progress counts
+// the files done, so a retried or resumed job continues after the last
+// finished batch instead of repeating it.
+func runFileBatchJob(ctx context.Context, run *jobs.Run, apply func(ctx context.Context, ids []string, target string) error) (interface{}, error) {
+	var params FileBatchJob
+	if err := run.Decode(&params); err != nil {
+		return nil, jobs.Permanent(err)
+	}
+	total := int64(len(params.FileIDs))
+	done := min(max(run.Checkpoint().Done, 0), total)
+	for done < total {
+		end := min(done+jobBatchSize, total)
+		if err := apply(ctx, params.FileIDs[done:end], params.TargetDirID); err != nil {
+			return nil, fmt.Errorf("files %d-%d: %w", done+1, end, err)
+		}
+		done = end
+		run.Commit(jobs.Progress{Done: done, Total: total})
+	}
+	return FileBatchJobResult{Count: len(params.FileIDs), TargetDirID: params.TargetDirID}, nil
+}
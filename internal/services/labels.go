@@ -0,0 +1,51 @@
+package services
+
+import (
+	"context"
+	"fmt"
+
+	"cloud-driver/internal/models"
+
+	"github.com/SheltonZhu/115driver/pkg/driver"
+)
+
+// LabelFiles adds the label called name to files, creating the label first
+// when the account has none by that name
+func (s *Drive115Service) LabelFiles(ctx context.Context, credentials models.Drive115Credentials, name string, fileIDs []string) (err error) {
+	client, err := s.createClient(credentials)
+	if err != nil {
+		return err
+	}
+	defer s.clients.evictOnLogout(credentials, &err)
+
+	labelID, err := findLabel(client, name)
+	if err != nil {
+		return err
+	}
+	if labelID == "" {
+		if err := client.AddLabel(name, driver.LabelColors[0]); err != nil {
+			return err
+		}
+		if labelID, err = findLabel(client, name); err != nil {
+			return err
+		}
+		if labelID == "" {
+			return fmt.Errorf("label %q: %w", name, driver.ErrNotExist)
+		}
+	}
+	return client.AddFileLabels([]string{labelID}, fileIDs...)
+}
+
+// findLabel returns the ID of the label named exactly name, or "" if none
+func findLabel(client *driver.Pan115Client, name string) (string, error) {
+	labels, err := client.ListLabels(name)
+	if err != nil {
+		return "", err
+	}
+	for _, label := range labels {
+		if label != nil && label.Name == name {
+			return label.ID, nil
+		}
+	}
+	return "", nil
+}
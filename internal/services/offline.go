@@ -0,0 +1,218 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud-driver/internal/models"
+
+	"github.com/SheltonZhu/115driver/pkg/driver"
+)
+
+const (
+	// maxOfflineTaskPages bounds how many task pages one listing reads
+	maxOfflineTaskPages     = 1000
+	offlineTaskQueryLimit   = 100
+	offlineTaskSortProgress = "progress"
+	offlineTaskSortSpeed    = "speed"
+)
+
+// Offline task states in models.OfflineTask
+const (
+	OfflineTaskTodo    = "todo"
+	OfflineTaskRunning = "running"
+	OfflineTaskDone    = "done"
+	OfflineTaskFailed  = "failed"
+)
+
+// ListAllOfflineTasks reads every page of the offline task list
+func (s *Drive115Service) ListAllOfflineTasks(ctx context.Context, credentials models.Drive115Credentials) (_ []models.OfflineTask, err error) {
+	client, err := s.createClient(credentials)
+	if err != nil {
+		return nil, err
+	}
+	defer s.clients.evictOnLogout(credentials, &err)
+
+	tasks := make([]models.OfflineTask, 0)
+	for page := int64(1); page <= maxOfflineTaskPages; page++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		resp, err := client.ListOfflineTask(page)
+		if err != nil {
+			return nil, err
+		}
+		for _, task := range resp.Tasks {
+			if task != nil {
+				tasks = append(tasks, offlineTask(task))
+			}
+		}
+		if len(resp.Tasks) == 0 || page >= resp.PageCount {
+			break
+		}
+	}
+	return tasks, nil
+}
+
+// offlineTaskStatusText labels task states for display
+var offlineTaskStatusText = map[string]string{
+	OfflineTaskTodo:    "Queued",
+	OfflineTaskRunning: "Downloading",
+	OfflineTaskDone:    "Completed",
+	OfflineTaskFailed:  "Failed",
+}
+
+// QueryOfflineTasks reads every task page and returns one filtered, sorted
+// page. req.SaveDirID must already be resolved from any path.
+func (s *Drive115Service) QueryOfflineTasks(ctx context.Context, req models.TaskQueryRequest) (*models.TaskQueryResponse, error) {
+	tasks, err := s.ListAllOfflineTasks(ctx, req.Credentials)
+	if err != nil {
+		return nil, err
+	}
+	return FilterOfflineTasks(tasks, req, time.Now()), nil
+}
+
+// FilterOfflineTasks applies the filters, sort and page of req to tasks and
+// sets the ETA of running tasks from now
+func FilterOfflineTasks(tasks []models.OfflineTask, req models.TaskQueryRequest, now time.Time) *models.TaskQueryResponse {
+	name := strings.ToLower(req.Name)
+	matched := make([]models.OfflineTask, 0, len(tasks))
+	for _, task := range tasks {
+		switch {
+		case len(req.Status) > 0 && !slices.Contains(req.Status, task.Status),
+			name != "" && !strings.Contains(strings.ToLower(task.Name), name),
+			!req.AddedAfter.IsZero() && !task.AddedAt.After(req.AddedAfter),
+			req.SaveDirID != "" && task.DirID != req.SaveDirID:
+			continue
+		}
+		matched = append(matched, task)
+	}
+
+	key := func(task models.OfflineTask) float64 { return float64(task.AddedAt.UnixNano()) }
+	switch req.Sort {
+	case offlineTaskSortProgress:
+		key = func(task models.OfflineTask) float64 { return task.Percent }
+	case offlineTaskSortSpeed:
+		key = func(task models.OfflineTask) float64 { return task.RateDownload }
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		a, b := key(matched[i]), key(matched[j])
+		if a == b {
+			return matched[i].InfoHash < matched[j].InfoHash
+		}
+		return (a < b) == req.Asc
+	})
+
+	limit := req.Limit
+	if limit == 0 {
+		limit = offlineTaskQueryLimit
+	}
+	response := &models.TaskQueryResponse{Tasks: []models.OfflineTask{}, Total: len(matched), Offset: req.Offset, Limit: limit}
+	if req.Offset < len(matched) {
+		response.Tasks = matched[req.Offset:min(req.Offset+limit, len(matched))]
+	}
+	for i := range response.Tasks {
+		task := &response.Tasks[i]
+		if task.Status == OfflineTaskRunning && task.LeftTime > 0 {
+			eta := now.Add(time.Duration(task.LeftTime) * time.Second).UTC().Truncate(time.Second)
+			task.ETA = &eta
+		}
+	}
+	if next := req.Offset + len(response.Tasks); len(response.Tasks) > 0 && next < len(matched) {
+		response.NextOffset = &next
+	}
+	return response
+}
+
+// offlineTask normalizes a driver task
+func offlineTask(task *driver.OfflineTask) models.OfflineTask {
+	status := OfflineTaskTodo
+	switch {
+	case task.IsDone():
+		status = OfflineTaskDone
+	case task.IsFailed():
+		status = OfflineTaskFailed
+	case task.IsRunning():
+		status = OfflineTaskRunning
+	}
+	return models.OfflineTask{
+		InfoHash:     task.InfoHash,
+		Name:         task.Name,
+		Size:         task.Size,
+		URL:          task.Url,
+		Status:       status,
+		StatusText:   offlineTaskStatusText[status],
+		Percent:      task.Percent,
+		RateDownload: task.RateDownload,
+		Peers:        task.Peers,
+		LeftTime:     task.LeftTime,
+		FileID:       task.FileId,
+		DirID:        task.DirId,
+		AddedAt:      time.Unix(task.AddTime, 0).UTC(),
+		UpdatedAt:    time.Unix(task.UpdateTime, 0).UTC(),
+	}
+}
+
+// offlineTaskListPage is the page size used to find a task's output
+const offlineTaskListPage = 1000
+
+// OfflineTaskFiles lists the files a finished task produced. The task's
+// output is looked up by ID in its save directory; a directory is walked and
+// its files returned, a single file is returned as is.
+func (s *Drive115Service) OfflineTaskFiles(ctx context.Context, credentials models.Drive115Credentials, task models.OfflineTask) ([]models.WalkEntry, error) {
+	dirID, err := strconv.ParseInt(task.DirID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("offline task %s has no save directory: %w", task.InfoHash, driver.ErrNotExist)
+	}
+	output, err := s.findTaskOutput(ctx, credentials, dirID, task)
+	if err != nil {
+		return nil, err
+	}
+	if !output.IsDirectory {
+		return []models.WalkEntry{{
+			ID: output.FileID, ParentID: output.ParentID, Name: output.Name, Path: "/" + output.Name,
+			Size: output.Size, SHA1: output.Sha1, PickCode: output.PickCode, UpdatedAt: output.UpdateTime,
+		}}, nil
+	}
+
+	outputID, err := strconv.ParseInt(output.FileID, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("offline task %s output %q: %w", task.InfoHash, output.FileID, driver.ErrNotExist)
+	}
+	var files []models.WalkEntry
+	err = s.Walk(ctx, credentials, outputID, WalkOptions{FilesOnly: true}, func(entry models.WalkEntry) error {
+		files = append(files, entry)
+		return nil
+	})
+	return files, err
+}
+
+// findTaskOutput finds the entry of the save directory a task wrote, by file
+// ID when 115 reports one and by name otherwise
+func (s *Drive115Service) findTaskOutput(ctx context.Context, credentials models.Drive115Credentials, dirID int64, task models.OfflineTask) (driver.File, error) {
+	for offset := int64(0); ; offset += offlineTaskListPage {
+		if err := ctx.Err(); err != nil {
+			return driver.File{}, err
+		}
+		page, err := s.ListFiles(ctx, credentials, dirID, offset, offlineTaskListPage)
+		if err != nil {
+			return driver.File{}, err
+		}
+		if page == nil {
+			page = &[]driver.File{}
+		}
+		for _, file := range *page {
+			if (task.FileID != "" && file.FileID == task.FileID) || (task.FileID == "" && file.Name == task.Name) {
+				return file, nil
+			}
+		}
+		if len(*page) < offlineTaskListPage {
+			return driver.File{}, fmt.Errorf("output of offline task %s: %w", task.InfoHash, driver.ErrNotExist)
+		}
+	}
+}
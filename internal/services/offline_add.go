@@ -0,0 +1,391 @@
+package services
+
+import (
+	"context"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud-driver/internal/models"
+
+	"github.com/SheltonZhu/115driver/pkg/driver"
+	"golang.org/x/time/rate"
+)
+
+// Outcomes of one URI of an offline add request
+const (
+	OfflineURIAdded   = "added"
+	OfflineURISkipped = "skipped"
+	OfflineURIFailed  = "failed"
+)
+
+const (
+	// offlineBatchSize is the most URIs sent to 115 in one add call
+	offlineBatchSize = 50
+	// offlineBatchRetryDelay is the wait before retrying a transient failure,
+	// growing with every attempt
+	offlineBatchRetryDelay = 5 * time.Second
+	// offlineBulkInterval spaces an account's add calls in bulk submissions
+	offlineBulkInterval = 2 * time.Second
+	// offlineBulkAttempts is how often a bulk add call is tried
+	offlineBulkAttempts = 3
+)
+
+// Reasons a URI was skipped
+const (
+	// OfflineSkipRepeated marks a URI that an earlier one in the request matches
+	OfflineSkipRepeated = "repeated"
+	// OfflineSkipTaskExists marks a URI that matches an existing offline task
+	OfflineSkipTaskExists = "task_exists"
+	// OfflineSkipFileExists marks a URI whose name is taken in the save directory
+	OfflineSkipFileExists = "file_exists"
+)
+
+// Reasons a URI failed
+const (
+	OfflineFailInvalidLink    = "invalid_link"
+	OfflineFailQuotaExhausted = "quota_exhausted"
+	OfflineFailError          = "error"
+)
+
+// OfflineURI is a download URI reduced for comparison
+type OfflineURI struct {
+	// Key identifies the download: the info hash of a magnet link, the hash of
+	// an ed2k link or the normalized URL
+	Key string
+	// InfoHash is the lowercase hex BitTorrent info hash of a magnet link
+	InfoHash string
+	// Name is the file name the link suggests, if any
+	Name string
+}
+
+// ParseOfflineURI normalizes a magnet, ed2k or http(s)/ftp URI. Magnet links
+// with the same info hash, or URLs differing only in scheme and host case,
+// default port or fragment, get the same key.
+func ParseOfflineURI(raw string) OfflineURI {
+	raw = strings.TrimSpace(raw)
+	lower := strings.ToLower(raw)
+	switch {
+	case strings.HasPrefix(lower, "magnet:?"):
+		query, _ := url.ParseQuery(raw[len("magnet:?"):])
+		var uri OfflineURI
+		for key, values := range query {
+			if key != "xt" && !strings.HasPrefix(key, "xt.") {
+				continue
+			}
+			for _, value := range values {
+				if hash := btihHash(value); hash != "" {
+					uri.InfoHash = hash
+				}
+			}
+		}
+		uri.Name = cleanURIName(query.Get("dn"))
+		if uri.InfoHash != "" {
+			uri.Key = "btih:" + uri.InfoHash
+			return uri
+		}
+	case strings.HasPrefix(lower, "ed2k://|file|"):
+		fields := strings.Split(raw[len("ed2k://|file|"):], "|")
+		if len(fields) >= 3 && len(fields[2]) == 32 {
+			name, _ := url.PathUnescape(fields[0])
+			return OfflineURI{Key: "ed2k:" + strings.ToLower(fields[2]), Name: cleanURIName(name)}
+		}
+	default:
+		if u, err := url.Parse(raw); err == nil && u.Host != "" {
+			u.Scheme = strings.ToLower(u.Scheme)
+			u.Host = strings.ToLower(u.Host)
+			if host, port, err := net.SplitHostPort(u.Host); err == nil && defaultPorts[u.Scheme] == port {
+				u.Host = host
+				if strings.Contains(host, ":") {
+					u.Host = "[" + host + "]"
+				}
+			}
+			u.Fragment, u.RawFragment = "", ""
+			if u.Path == "" {
+				u.Path, u.RawPath = "/", ""
+			}
+			return OfflineURI{Key: u.String(), Name: cleanURIName(path.Base(u.Path))}
+		}
+	}
+	return OfflineURI{Key: raw}
+}
+
+var defaultPorts = map[string]string{"http": "80", "https": "443", "ftp": "21"}
+
+// btihHash returns the lowercase hex info hash of an xt value, which carries
+// it as 40 hex or 32 base32 characters
+func btihHash(xt string) string {
+	if len(xt) < len("urn:btih:") || !strings.EqualFold(xt[:len("urn:btih:")], "urn:btih:") {
+		return ""
+	}
+	hash := xt[len("urn:btih:"):]
+	switch len(hash) {
+	case 40:
+		if _, err := hex.DecodeString(hash); err == nil {
+			return strings.ToLower(hash)
+		}
+	case 32:
+		if sum, err := base32.StdEncoding.DecodeString(strings.ToUpper(hash)); err == nil {
+			return hex.EncodeToString(sum)
+		}
+	}
+	return ""
+}
+
+// supportedOfflineURI accepts the schemes the add request validation does
+func supportedOfflineURI(raw string) bool {
+	return strings.HasPrefix(raw, "http://") || strings.HasPrefix(raw, "https://") || strings.HasPrefix(raw, "magnet:")
+}
+
+func cleanURIName(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "/" || name == "." || name == ".." {
+		return ""
+	}
+	return name
+}
+
+// skipOfflineURIs decides which URIs must not be submitted: those with an
+// unsupported scheme, those an earlier URI of the list matches, those matching
+// a task by info hash or URL, and those whose suggested name is in names. It
+// returns one result per URI, with only those filled in, and the indexes of
+// the rest.
+func skipOfflineURIs(urls []string, tasks []models.OfflineTask, names map[string]bool) ([]models.OfflineAddResult, []int) {
+	existing := make(map[string]string, 2*len(tasks))
+	for _, task := range tasks {
+		if task.URL != "" {
+			existing[ParseOfflineURI(task.URL).Key] = task.InfoHash
+		}
+		if task.InfoHash != "" {
+			existing["btih:"+strings.ToLower(task.InfoHash)] = task.InfoHash
+		}
+	}
+
+	results := make([]models.OfflineAddResult, len(urls))
+	seen := make(map[string]bool, len(urls))
+	var pending []int
+	for i, raw := range urls {
+		results[i].URL = raw
+		uri := ParseOfflineURI(raw)
+		hash, exists := existing[uri.Key]
+		switch {
+		case !supportedOfflineURI(raw):
+			results[i].Status, results[i].Reason = OfflineURIFailed, OfflineFailInvalidLink
+		case seen[uri.Key]:
+			results[i].Status, results[i].Reason = OfflineURISkipped, OfflineSkipRepeated
+		case exists:
+			results[i].Status, results[i].Reason, results[i].ExistingHash = OfflineURISkipped, OfflineSkipTaskExists, hash
+		case uri.Name != "" && names[uri.Name]:
+			results[i].Status, results[i].Reason = OfflineURISkipped, OfflineSkipFileExists
+		default:
+			pending = append(pending, i)
+		}
+		seen[uri.Key] = true
+	}
+	return results, pending
+}
+
+// AddOfflineTasks adds the URIs that are not offline tasks yet and reports
+// one result per URI, in order. URIs repeating an earlier one or matching an
+// existing task are skipped; with skipExistingFiles, so are URIs whose name is
+// already taken in the save directory. Should 115 reject the batch for one
+// duplicate or invalid link, the URIs are retried one by one so the others
+// are not lost.
+func (s *Drive115Service) AddOfflineTasks(ctx context.Context, credentials models.Drive115Credentials, urls []string, saveDirID string, skipExistingFiles bool) ([]models.OfflineAddResult, error) {
+	return s.addOfflineURIs(ctx, credentials, urls, saveDirID, skipExistingFiles, offlineAddOptions{attempts: 1})
+}
+
+// AddOfflineTasksBulk adds any number of URIs as AddOfflineTasks does. The
+// account's add calls are spaced by offlineBulkInterval and transient
+// failures are retried. progress, when set, is called with the number of URIs
+// decided so far.
+func (s *Drive115Service) AddOfflineTasksBulk(ctx context.Context, credentials models.Drive115Credentials, urls []string, saveDirID string, skipExistingFiles bool, progress func(done int)) ([]models.OfflineAddResult, error) {
+	limiter, _ := s.offlineLimits.LoadOrStore(credentials.Key(), rate.NewLimiter(rate.Every(offlineBulkInterval), 1))
+	return s.addOfflineURIs(ctx, credentials, urls, saveDirID, skipExistingFiles, offlineAddOptions{
+		limiter:    limiter.(*rate.Limiter),
+		attempts:   offlineBulkAttempts,
+		retryDelay: offlineBatchRetryDelay,
+		progress:   progress,
+	})
+}
+
+// offlineAddOptions tunes addOfflineURIs
+type offlineAddOptions struct {
+	// limiter spaces the calls to 115 when set
+	limiter *rate.Limiter
+	// attempts is how often a call is tried on transient failures
+	attempts int
+	// retryDelay is the wait before the first retry, growing per attempt
+	retryDelay time.Duration
+	// progress, when set, is called with the number of URIs decided
+	progress func(done int)
+}
+
+// addOfflineURIs skips duplicates as AddOfflineTasks describes and submits
+// the rest in batches of offlineBatchSize. Once the quota is used up, the URI
+// that hit it and all later ones fail with OfflineFailQuotaExhausted.
+func (s *Drive115Service) addOfflineURIs(ctx context.Context, credentials models.Drive115Credentials, urls []string, saveDirID string, skipExistingFiles bool, options offlineAddOptions) ([]models.OfflineAddResult, error) {
+	tasks, err := s.ListAllOfflineTasks(ctx, credentials)
+	if err != nil {
+		return nil, err
+	}
+	var names map[string]bool
+	if skipExistingFiles {
+		if names, err = s.dirNames(ctx, credentials, saveDirID); err != nil {
+			return nil, err
+		}
+	}
+	results, pending := skipOfflineURIs(urls, tasks, names)
+	done := len(urls) - len(pending)
+	if options.progress != nil {
+		options.progress(done)
+	}
+
+	submitter := offlineSubmitter{
+		options: options,
+		addURIs: func(ctx context.Context, uris []string) ([]string, error) {
+			return s.AddOfflineTaskURIs(ctx, credentials, uris, saveDirID)
+		},
+	}
+	if err := submitter.submitAll(ctx, urls, pending, results, done); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// offlineSubmitter sends batches of URIs to 115 for one account and save
+// directory through addURIs
+type offlineSubmitter struct {
+	options offlineAddOptions
+	addURIs func(ctx context.Context, uris []string) ([]string, error)
+}
+
+// submitAll submits the pending URIs in batches of offlineBatchSize. done is
+// the number of URIs decided before, for progress.
+func (o *offlineSubmitter) submitAll(ctx context.Context, urls []string, pending []int, results []models.OfflineAddResult, done int) error {
+	for start := 0; start < len(pending); start += offlineBatchSize {
+		batch := pending[start:min(start+offlineBatchSize, len(pending))]
+		err := o.submit(ctx, urls, batch, results)
+		if errors.Is(err, driver.ErrOfflineNoTimes) {
+			for _, index := range pending[start:] {
+				if results[index].Status == "" {
+					results[index].Status, results[index].Reason = OfflineURIFailed, OfflineFailQuotaExhausted
+				}
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		done += len(batch)
+		if o.options.progress != nil {
+			o.options.progress(done)
+		}
+	}
+	return nil
+}
+
+// submit fills in the results of the URIs at the batch indexes. A batch 115
+// rejects for a duplicate or an invalid link is split into single URIs. It
+// returns ErrOfflineNoTimes once the quota is used up, leaving the undecided
+// results empty, and any error that is not about a particular URI.
+func (o *offlineSubmitter) submit(ctx context.Context, urls []string, batch []int, results []models.OfflineAddResult) error {
+	uris := make([]string, len(batch))
+	for i, index := range batch {
+		uris[i] = urls[index]
+	}
+	hashes, err := o.add(ctx, uris)
+	perURI := errors.Is(err, driver.ErrOfflineTaskExisted) || errors.Is(err, driver.ErrOfflineInvalidLink)
+	switch {
+	case err == nil:
+		for i, index := range batch {
+			if hashes[i] == "" {
+				results[index].Status, results[index].Reason = OfflineURIFailed, OfflineFailError
+				results[index].Error = "115 returned no task for this URI"
+				continue
+			}
+			results[index].Status, results[index].Hash = OfflineURIAdded, hashes[i]
+		}
+	case perURI && len(batch) > 1:
+		for _, index := range batch {
+			if err := o.submit(ctx, urls, []int{index}, results); err != nil {
+				return err
+			}
+		}
+	case errors.Is(err, driver.ErrOfflineTaskExisted):
+		results[batch[0]].Status, results[batch[0]].Reason = OfflineURISkipped, OfflineSkipTaskExists
+	case errors.Is(err, driver.ErrOfflineInvalidLink):
+		results[batch[0]].Status, results[batch[0]].Reason = OfflineURIFailed, OfflineFailInvalidLink
+	default:
+		return err
+	}
+	return nil
+}
+
+// add makes one add call, waiting for the limiter and retrying transient
+// failures with a growing delay
+func (o *offlineSubmitter) add(ctx context.Context, uris []string) ([]string, error) {
+	for attempt := 1; ; attempt++ {
+		if o.options.limiter != nil {
+			if err := o.options.limiter.Wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+		hashes, err := o.addURIs(ctx, uris)
+		if err == nil || attempt >= o.options.attempts || !transientOfflineError(err) {
+			return hashes, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Duration(attempt) * o.options.retryDelay):
+		}
+	}
+}
+
+// transientOfflineError reports whether an add call may succeed when tried
+// again: network failures and 115 answers without a known error code
+func transientOfflineError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, driver.ErrUnexpected)
+}
+
+// dirNames returns the names of the files and directories in a directory
+func (s *Drive115Service) dirNames(ctx context.Context, credentials models.Drive115Credentials, dirID string) (map[string]bool, error) {
+	if dirID == "" {
+		dirID = "0"
+	}
+	id, err := strconv.ParseInt(dirID, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[string]bool)
+	for offset := int64(0); ; offset += offlineTaskListPage {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		page, err := s.ListFiles(ctx, credentials, id, offset, offlineTaskListPage)
+		if err != nil {
+			return nil, err
+		}
+		if page == nil {
+			return names, nil
+		}
+		for _, file := range *page {
+			names[file.Name] = true
+		}
+		if len(*page) < offlineTaskListPage {
+			return names, nil
+		}
+	}
+}
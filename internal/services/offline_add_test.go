@@ -0,0 +1,119 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+
+	"cloud-driver/internal/models"
+
+	"github.com/SheltonZhu/115driver/pkg/driver"
+)
+
+func TestParseOfflineURI(t *testing.T) {
+	hash := "c12fe1c06bba254a9dc9f519b335aa7c1367a88a"
+	tests := []struct {
+		raw, key, name string
+	}{
+		{"magnet:?xt=urn:btih:C12FE1C06BBA254A9DC9F519B335AA7C1367A88A&dn=Movie+2024&tr=udp://x", "btih:" + hash, "Movie 2024"},
+		{"magnet:?dn=x&xt=urn:btih:YEX6DQDLXISUVHOJ6UM3GNNKPQJWPKEK", "btih:" + hash, "x"},
+		{"ed2k://|file|a%20b.iso|1024|0123456789ABCDEF0123456789ABCDEF|/", "ed2k:0123456789abcdef0123456789abcdef", "a b.iso"},
+		{"HTTPS://Example.COM:443/dl/file.zip?x=1#frag", "https://example.com/dl/file.zip?x=1", "file.zip"},
+		{"http://example.com", "http://example.com/", ""},
+		{"http://example.com:8080/a", "http://example.com:8080/a", "a"},
+	}
+	for _, tt := range tests {
+		uri := ParseOfflineURI(tt.raw)
+		if uri.Key != tt.key || uri.Name != tt.name {
+			t.Errorf("ParseOfflineURI(%q) = %+v, want key %q name %q", tt.raw, uri, tt.key, tt.name)
+		}
+	}
+}
+
+func TestSkipOfflineURIs(t *testing.T) {
+	tasks := []models.OfflineTask{
+		{InfoHash: "c12fe1c06bba254a9dc9f519b335aa7c1367a88a", URL: "magnet:?xt=urn:btih:c12fe1c06bba254a9dc9f519b335aa7c1367a88a"},
+		{InfoHash: "ffff", URL: "https://example.com/old.zip"},
+	}
+	urls := []string{
+		"magnet:?xt=urn:btih:YEX6DQDLXISUVHOJ6UM3GNNKPQJWPKEK&dn=movie",
+		"https://EXAMPLE.com/old.zip#x",
+		"https://example.com/new.zip",
+		"https://example.com:443/new.zip",
+		"https://example.com/taken.zip",
+	}
+	results, pending := skipOfflineURIs(urls, tasks, map[string]bool{"taken.zip": true})
+	if len(pending) != 1 || pending[0] != 2 {
+		t.Fatalf("pending = %v", pending)
+	}
+	want := []struct{ status, reason, existing string }{
+		{OfflineURISkipped, OfflineSkipTaskExists, "c12fe1c06bba254a9dc9f519b335aa7c1367a88a"},
+		{OfflineURISkipped, OfflineSkipTaskExists, "ffff"},
+		{"", "", ""},
+		{OfflineURISkipped, OfflineSkipRepeated, ""},
+		{OfflineURISkipped, OfflineSkipFileExists, ""},
+	}
+	for i, w := range want {
+		got := results[i]
+		if got.URL != urls[i] || got.Status != w.status || got.Reason != w.reason || got.ExistingHash != w.existing {
+			t.Errorf("results[%d] = %+v", i, got)
+		}
+	}
+}
+
+func TestOfflineSubmitter(t *testing.T) {
+	urls := make([]string, 0, 60)
+	for i := range 58 {
+		urls = append(urls, fmt.Sprintf("https://example.com/%d", i))
+	}
+	urls = append(urls, "https://example.com/bad", "https://example.com/quota")
+	results := make([]models.OfflineAddResult, len(urls))
+	pending := make([]int, len(urls))
+	for i := range urls {
+		results[i].URL = urls[i]
+		pending[i] = i
+	}
+
+	var calls [][]string
+	flaky := true
+	submitter := offlineSubmitter{
+		options: offlineAddOptions{attempts: 2, retryDelay: time.Millisecond},
+		addURIs: func(_ context.Context, uris []string) ([]string, error) {
+			calls = append(calls, uris)
+			if flaky {
+				flaky = false
+				return nil, driver.ErrUnexpected
+			}
+			if slices.Contains(uris, "https://example.com/bad") {
+				return nil, driver.ErrOfflineInvalidLink
+			}
+			if slices.Contains(uris, "https://example.com/quota") {
+				return nil, driver.ErrOfflineNoTimes
+			}
+			hashes := make([]string, len(uris))
+			for i, uri := range uris {
+				hashes[i] = "hash-" + uri[len("https://example.com/"):]
+			}
+			return hashes, nil
+		},
+	}
+	if err := submitter.submitAll(context.Background(), urls, pending, results, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Retried first batch, then the second batch split into its 10 URIs
+	if len(calls) != 2+1+10 || len(calls[0]) != 50 || len(calls[1]) != 50 || len(calls[2]) != 10 {
+		t.Fatalf("%d calls", len(calls))
+	}
+	if results[0].Status != OfflineURIAdded || results[0].Hash != "hash-0" || results[57].Hash != "hash-57" {
+		t.Fatalf("added = %+v, %+v", results[0], results[57])
+	}
+	if results[58].Status != OfflineURIFailed || results[58].Reason != OfflineFailInvalidLink {
+		t.Fatalf("bad = %+v", results[58])
+	}
+	if results[59].Status != OfflineURIFailed || results[59].Reason != OfflineFailQuotaExhausted {
+		t.Fatalf("quota = %+v", results[59])
+	}
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"cloud-driver/internal/models"
+)
+
+func TestFilterOfflineTasks(t *testing.T) {
+	now := time.Unix(1700000000, 0).UTC()
+	tasks := []models.OfflineTask{
+		{InfoHash: "a", Name: "Ubuntu ISO", Status: OfflineTaskRunning, Percent: 40, RateDownload: 300, LeftTime: 90, DirID: "1", AddedAt: now.Add(-3 * time.Hour)},
+		{InfoHash: "b", Name: "ubuntu server", Status: OfflineTaskRunning, Percent: 80, RateDownload: 100, DirID: "1", AddedAt: now.Add(-2 * time.Hour)},
+		{InfoHash: "c", Name: "Ubuntu docs", Status: OfflineTaskDone, Percent: 100, DirID: "1", AddedAt: now.Add(-time.Hour)},
+		{InfoHash: "d", Name: "Ubuntu old", Status: OfflineTaskRunning, Percent: 10, DirID: "1", AddedAt: now.Add(-48 * time.Hour)},
+		{InfoHash: "e", Name: "Ubuntu elsewhere", Status: OfflineTaskRunning, Percent: 50, DirID: "2", AddedAt: now.Add(-time.Hour)},
+	}
+	req := models.TaskQueryRequest{
+		Status: []string{OfflineTaskRunning, OfflineTaskDone}, Name: "UBUNTU", AddedAfter: now.Add(-24 * time.Hour),
+		SaveDirID: "1", Sort: "speed", Limit: 2,
+	}
+
+	page := FilterOfflineTasks(tasks, req, now)
+	if page.Total != 3 || len(page.Tasks) != 2 || page.Tasks[0].InfoHash != "a" || page.Tasks[1].InfoHash != "b" {
+		t.Fatalf("page = %+v", page)
+	}
+	if page.Tasks[0].ETA == nil || !page.Tasks[0].ETA.Equal(now.Add(90*time.Second)) || page.Tasks[1].ETA != nil {
+		t.Fatalf("etas = %v, %v", page.Tasks[0].ETA, page.Tasks[1].ETA)
+	}
+	if page.NextOffset == nil || *page.NextOffset != 2 {
+		t.Fatalf("next offset = %v", page.NextOffset)
+	}
+
+	req.Sort, req.Asc, req.Offset = "", true, 0
+	page = FilterOfflineTasks(tasks, req, now)
+	if page.Tasks[0].InfoHash != "a" || page.Tasks[1].InfoHash != "b" {
+		t.Fatalf("oldest first = %+v", page.Tasks)
+	}
+	req.Sort, req.Asc = "progress", false
+	if page = FilterOfflineTasks(tasks, req, now); page.Tasks[0].InfoHash != "c" {
+		t.Fatalf("most progress first = %+v", page.Tasks)
+	}
+}
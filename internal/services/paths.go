@@ -0,0 +1,420 @@
+package services
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud-driver/internal/models"
+
+	"github.com/SheltonZhu/115driver/pkg/driver"
+)
+
+const (
+	// pathCacheAccounts bounds how many accounts keep a path cache
+	pathCacheAccounts = 256
+	// pathCacheEntries bounds the cached paths per account before it is reset
+	pathCacheEntries = 20000
+	// pathCacheTTL bounds how stale a cached path or listing can be when the
+	// drive is changed outside this server
+	pathCacheTTL = time.Minute
+)
+
+var rootEntry = PathEntry{ID: "0", IsDir: true}
+
+// pathClient is the part of Pan115Client used to resolve paths
+type pathClient interface {
+	DirName2CID(dir string) (*driver.APIGetDirIDResp, error)
+	Stat(fileID string) (*driver.FileStatInfo, error)
+	List(dirID string, opts ...driver.ListOption) (*[]driver.File, error)
+}
+
+// PathEntry is a resolved file or directory. Directories found only through
+// another directory's parent chain carry no size, time or pick code.
+type PathEntry struct {
+	ID       string
+	Name     string
+	IsDir    bool
+	Size     int64
+	PickCode string
+	SHA1     string
+	ModTime  time.Time
+}
+
+// pathCache maps slash-separated paths to 115 IDs per account. Directories are
+// found with DirName2CID and confirmed with Stat, whose parent chain also
+// records every ancestor; files are found in a cached listing of their parent.
+// Every cached ID remembers its path, so a mutation on an ID drops that path,
+// everything below it and its parent's listing.
+type pathCache struct {
+	capacity int
+	ttl      time.Duration
+	now      func() time.Time
+
+	mu       sync.Mutex
+	order    *list.List
+	accounts map[string]*list.Element
+}
+
+type accountPaths struct {
+	key      string
+	entries  map[string]cachedPath
+	paths    map[string]string
+	listings map[string]cachedListing
+}
+
+type cachedPath struct {
+	PathEntry
+	expires time.Time
+}
+
+type cachedListing struct {
+	children map[string]PathEntry
+	expires  time.Time
+}
+
+func newPathCache() *pathCache {
+	return &pathCache{
+		capacity: pathCacheAccounts,
+		ttl:      pathCacheTTL,
+		now:      time.Now,
+		order:    list.New(),
+		accounts: make(map[string]*list.Element),
+	}
+}
+
+// resolve returns the entry at a slash-separated path
+func (c *pathCache) resolve(key string, client pathClient, dirPath string) (PathEntry, error) {
+	parts, err := SplitDirPath(dirPath)
+	if err != nil {
+		return PathEntry{}, err
+	}
+	return c.resolveParts(key, client, parts)
+}
+
+// resolveDir resolves a path that must be a directory
+func (c *pathCache) resolveDir(key string, client pathClient, dirPath string) (string, error) {
+	entry, err := c.resolve(key, client, dirPath)
+	if err != nil {
+		return "", err
+	}
+	if !entry.IsDir {
+		return "", fmt.Errorf("%s is not a directory: %w", dirPath, driver.ErrNotExist)
+	}
+	return entry.ID, nil
+}
+
+func (c *pathCache) resolveParts(key string, client pathClient, parts []string) (PathEntry, error) {
+	if len(parts) == 0 {
+		return rootEntry, nil
+	}
+	fullPath := JoinDirPath(parts)
+	if entry, ok := c.lookup(key, fullPath); ok {
+		return entry, nil
+	}
+	name := parts[len(parts)-1]
+	if parent, ok := c.lookup(key, JoinDirPath(parts[:len(parts)-1])); ok && parent.IsDir {
+		if children, ok := c.listing(key, parent.ID); ok {
+			return childEntry(children, fullPath, name)
+		}
+	}
+
+	if entry, ok, err := c.statDir(key, client, fullPath); err != nil || ok {
+		return entry, err
+	}
+
+	// Not a directory, so look for a file in the parent's listing
+	parent, err := c.resolveParts(key, client, parts[:len(parts)-1])
+	if err != nil {
+		return PathEntry{}, err
+	}
+	if !parent.IsDir {
+		return PathEntry{}, fmt.Errorf("%s: %w", fullPath, driver.ErrNotExist)
+	}
+	children, ok := c.listing(key, parent.ID)
+	if !ok {
+		files, err := client.List(parent.ID)
+		if err != nil {
+			return PathEntry{}, err
+		}
+		children = c.storeListing(key, JoinDirPath(parts[:len(parts)-1]), parent.ID, *files)
+	}
+	return childEntry(children, fullPath, name)
+}
+
+// list returns the children of the directory at dirPath, from cache when fresh
+func (c *pathCache) list(key string, client pathClient, dirPath string) ([]PathEntry, error) {
+	parts, err := SplitDirPath(dirPath)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := c.resolveParts(key, client, parts)
+	if err != nil {
+		return nil, err
+	}
+	if !dir.IsDir {
+		return nil, fmt.Errorf("%s is not a directory: %w", dirPath, driver.ErrNotExist)
+	}
+	children, ok := c.listing(key, dir.ID)
+	if !ok {
+		files, err := client.List(dir.ID)
+		if err != nil {
+			return nil, err
+		}
+		children = c.storeListing(key, JoinDirPath(parts), dir.ID, *files)
+	}
+	entries := make([]PathEntry, 0, len(children))
+	for _, entry := range children {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, nil
+}
+
+// statDir asks DirName2CID for a directory and confirms the answer with Stat,
+// because 115 answers some missing paths with an unrelated ID
+func (c *pathCache) statDir(key string, client pathClient, fullPath string) (PathEntry, bool, error) {
+	result, err := client.DirName2CID(fullPath)
+	if err != nil {
+		return PathEntry{}, false, err
+	}
+	id := string(result.CategoryID)
+	if id == "" || id == "0" {
+		return PathEntry{}, false, nil
+	}
+	stat, err := client.Stat(id)
+	if err != nil {
+		if errors.Is(err, driver.ErrNotExist) {
+			return PathEntry{}, false, nil
+		}
+		return PathEntry{}, false, err
+	}
+	if !stat.IsDirectory || statPath(stat) != fullPath {
+		return PathEntry{}, false, nil
+	}
+	return c.storeStat(key, id, stat), true, nil
+}
+
+func childEntry(children map[string]PathEntry, fullPath, name string) (PathEntry, error) {
+	entry, ok := children[name]
+	if !ok {
+		return PathEntry{}, fmt.Errorf("%s: %w", fullPath, driver.ErrNotExist)
+	}
+	return entry, nil
+}
+
+func (c *pathCache) lookup(key, fullPath string) (PathEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	account := c.account(key, false)
+	if account == nil {
+		return PathEntry{}, false
+	}
+	entry, ok := account.entries[fullPath]
+	if !ok || !c.now().Before(entry.expires) {
+		return PathEntry{}, false
+	}
+	return entry.PathEntry, true
+}
+
+func (c *pathCache) listing(key, dirID string) (map[string]PathEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	account := c.account(key, false)
+	if account == nil {
+		return nil, false
+	}
+	listing, ok := account.listings[dirID]
+	if !ok || !c.now().Before(listing.expires) {
+		return nil, false
+	}
+	return listing.children, true
+}
+
+// storeStat records a directory and its ancestors from its Stat parent chain
+func (c *pathCache) storeStat(key, id string, stat *driver.FileStatInfo) PathEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	account := c.account(key, true)
+	expires := c.now().Add(c.ttl)
+	dirPath := "/"
+	for _, parent := range stat.Parents {
+		if parent.ID == "0" {
+			continue
+		}
+		dirPath = path.Join(dirPath, parent.Name)
+		account.put(dirPath, PathEntry{ID: parent.ID, Name: parent.Name, IsDir: true}, expires)
+	}
+	entry := PathEntry{ID: id, Name: stat.Name, IsDir: true, PickCode: stat.PickCode, ModTime: stat.UpdateTime}
+	account.put(path.Join(dirPath, stat.Name), entry, expires)
+	c.trim(account)
+	return entry
+}
+
+// storeListing records a directory listing and every child's path
+func (c *pathCache) storeListing(key, dirPath, dirID string, files []driver.File) map[string]PathEntry {
+	children := make(map[string]PathEntry, len(files))
+	for _, file := range files {
+		children[file.Name] = PathEntry{
+			ID:       file.FileID,
+			Name:     file.Name,
+			IsDir:    file.IsDirectory,
+			Size:     file.Size,
+			PickCode: file.PickCode,
+			SHA1:     file.Sha1,
+			ModTime:  file.UpdateTime,
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	account := c.account(key, true)
+	expires := c.now().Add(c.ttl)
+	account.listings[dirID] = cachedListing{children: children, expires: expires}
+	for name, entry := range children {
+		account.put(path.Join(dirPath, name), entry, expires)
+	}
+	c.trim(account)
+	return children
+}
+
+// invalidate drops cached state for IDs touched by a mutation: each ID's path
+// and everything below it, its listing and its parent's listing
+func (c *pathCache) invalidate(key string, ids ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	account := c.account(key, false)
+	if account == nil {
+		return
+	}
+	for _, id := range ids {
+		delete(account.listings, id)
+		idPath, ok := account.paths[id]
+		if !ok {
+			continue
+		}
+		if parent, ok := account.entries[path.Dir(idPath)]; ok {
+			delete(account.listings, parent.ID)
+		} else if path.Dir(idPath) == "/" {
+			delete(account.listings, "0")
+		}
+		prefix := idPath + "/"
+		for entryPath, entry := range account.entries {
+			if entryPath == idPath || strings.HasPrefix(entryPath, prefix) {
+				delete(account.entries, entryPath)
+				delete(account.paths, entry.ID)
+				delete(account.listings, entry.ID)
+			}
+		}
+	}
+}
+
+// reset drops everything cached for an account, for mutations whose effects
+// cannot be tied to known IDs
+func (c *pathCache) reset(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.accounts[key]; ok {
+		c.order.Remove(element)
+		delete(c.accounts, key)
+	}
+}
+
+// account returns the cache for key, creating it when asked; callers must hold c.mu
+func (c *pathCache) account(key string, create bool) *accountPaths {
+	if element, ok := c.accounts[key]; ok {
+		c.order.MoveToFront(element)
+		return element.Value.(*accountPaths)
+	}
+	if !create {
+		return nil
+	}
+	account := &accountPaths{
+		key:      key,
+		entries:  make(map[string]cachedPath),
+		paths:    make(map[string]string),
+		listings: make(map[string]cachedListing),
+	}
+	c.accounts[key] = c.order.PushFront(account)
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.accounts, oldest.Value.(*accountPaths).key)
+	}
+	return account
+}
+
+// trim resets an account that outgrew its bound; callers must hold c.mu
+func (c *pathCache) trim(account *accountPaths) {
+	if len(account.entries) > pathCacheEntries {
+		account.entries = make(map[string]cachedPath)
+		account.paths = make(map[string]string)
+		account.listings = make(map[string]cachedListing)
+	}
+}
+
+func (a *accountPaths) put(entryPath string, entry PathEntry, expires time.Time) {
+	if old, ok := a.entries[entryPath]; ok && old.ID != entry.ID {
+		delete(a.paths, old.ID)
+	}
+	a.entries[entryPath] = cachedPath{PathEntry: entry, expires: expires}
+	a.paths[entry.ID] = entryPath
+}
+
+// ResolvePath returns the file or directory at a slash-separated path
+func (s *Drive115Service) ResolvePath(ctx context.Context, credentials models.Drive115Credentials, filePath string) (_ PathEntry, err error) {
+	client, err := s.createClient(credentials)
+	if err != nil {
+		return PathEntry{}, err
+	}
+	defer s.clients.evictOnLogout(credentials, &err)
+	return s.paths.resolve(credentials.Key(), client, filePath)
+}
+
+// ListDir returns the files and directories in the directory at dirPath,
+// sorted by name
+func (s *Drive115Service) ListDir(ctx context.Context, credentials models.Drive115Credentials, dirPath string) (_ []PathEntry, err error) {
+	client, err := s.createClient(credentials)
+	if err != nil {
+		return nil, err
+	}
+	defer s.clients.evictOnLogout(credentials, &err)
+	return s.paths.list(credentials.Key(), client, dirPath)
+}
+
+// ResolvePaths returns the IDs of files or directories at each path, in order
+func (s *Drive115Service) ResolvePaths(ctx context.Context, credentials models.Drive115Credentials, filePaths []string) (_ []string, err error) {
+	client, err := s.createClient(credentials)
+	if err != nil {
+		return nil, err
+	}
+	defer s.clients.evictOnLogout(credentials, &err)
+
+	key := credentials.Key()
+	ids := make([]string, 0, len(filePaths))
+	for _, filePath := range filePaths {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		entry, err := s.paths.resolve(key, client, filePath)
+		if err != nil {
+			return nil, err
+		}
+		if entry.ID == "0" {
+			return nil, fmt.Errorf("%s: the root directory cannot be changed: %w", filePath, driver.ErrWrongParams)
+		}
+		ids = append(ids, entry.ID)
+	}
+	return ids, nil
+}
+
+// invalidatePaths drops cached paths for IDs changed by a successful mutation
+func (s *Drive115Service) invalidatePaths(credentials models.Drive115Credentials, ids ...string) {
+	s.paths.invalidate(credentials.Key(), ids...)
+}
@@ -0,0 +1,196 @@
+package services
+
+import (
+	"errors"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/SheltonZhu/115driver/pkg/driver"
+)
+
+// fakeDrive is a tiny drive for path resolution: IDs map to a name, parent and
+// directory flag, and calls are counted per method
+type fakeDrive struct {
+	nodes map[string]fakeNode
+	calls map[string]int
+}
+
+type fakeNode struct {
+	name, parent string
+	dir          bool
+}
+
+func newFakeDrive() *fakeDrive {
+	return &fakeDrive{nodes: map[string]fakeNode{}, calls: map[string]int{}}
+}
+
+func (f *fakeDrive) add(id, parent, name string, dir bool) {
+	f.nodes[id] = fakeNode{name: name, parent: parent, dir: dir}
+}
+
+func (f *fakeDrive) pathOf(id string) string {
+	if id == "0" {
+		return "/"
+	}
+	node := f.nodes[id]
+	return path.Join(f.pathOf(node.parent), node.name)
+}
+
+func (f *fakeDrive) DirName2CID(dir string) (*driver.APIGetDirIDResp, error) {
+	f.calls["dirname"]++
+	for id, node := range f.nodes {
+		if node.dir && f.pathOf(id) == "/"+strings.TrimPrefix(dir, "/") {
+			return &driver.APIGetDirIDResp{CategoryID: driver.IntString(id)}, nil
+		}
+	}
+	return &driver.APIGetDirIDResp{CategoryID: "0"}, nil
+}
+
+func (f *fakeDrive) Stat(fileID string) (*driver.FileStatInfo, error) {
+	f.calls["stat"]++
+	node, ok := f.nodes[fileID]
+	if !ok {
+		return nil, driver.ErrNotExist
+	}
+	stat := &driver.FileStatInfo{Name: node.name, IsDirectory: node.dir}
+	var parents []*driver.DirInfo
+	for parent := node.parent; parent != "0"; parent = f.nodes[parent].parent {
+		parents = append([]*driver.DirInfo{{ID: parent, Name: f.nodes[parent].name}}, parents...)
+	}
+	stat.Parents = append([]*driver.DirInfo{{ID: "0", Name: "root"}}, parents...)
+	return stat, nil
+}
+
+func (f *fakeDrive) List(dirID string, opts ...driver.ListOption) (*[]driver.File, error) {
+	f.calls["list"]++
+	var files []driver.File
+	for id, node := range f.nodes {
+		if node.parent == dirID {
+			files = append(files, driver.File{FileID: id, ParentID: dirID, Name: node.name, IsDirectory: node.dir})
+		}
+	}
+	return &files, nil
+}
+
+func newTestDrive() *fakeDrive {
+	drive := newFakeDrive()
+	drive.add("10", "0", "Movies", true)
+	drive.add("11", "10", "2024", true)
+	drive.add("12", "11", "a.mkv", false)
+	drive.add("13", "11", "b.mkv", false)
+	return drive
+}
+
+func TestPathCacheResolvesDirectoriesAndAncestors(t *testing.T) {
+	drive := newTestDrive()
+	cache := newPathCache()
+
+	entry, err := cache.resolve("acct", drive, "/Movies/2024")
+	if err != nil || entry.ID != "11" || !entry.IsDir || entry.Name != "2024" {
+		t.Fatalf("resolve = %+v, %v", entry, err)
+	}
+	// The Stat parent chain recorded the ancestor too
+	entry, err = cache.resolve("acct", drive, "/Movies")
+	if err != nil || entry.ID != "10" {
+		t.Fatalf("ancestor = %+v, %v", entry, err)
+	}
+	if drive.calls["dirname"] != 1 || drive.calls["stat"] != 1 {
+		t.Fatalf("calls = %v", drive.calls)
+	}
+}
+
+func TestPathCacheResolvesFilesFromListing(t *testing.T) {
+	drive := newTestDrive()
+	cache := newPathCache()
+
+	for _, name := range []string{"a.mkv", "b.mkv"} {
+		entry, err := cache.resolve("acct", drive, "/Movies/2024/"+name)
+		if err != nil || entry.IsDir {
+			t.Fatalf("%s = %+v, %v", name, entry, err)
+		}
+	}
+	if drive.calls["list"] != 1 {
+		t.Fatalf("listed %d times, want 1", drive.calls["list"])
+	}
+	if _, err := cache.resolve("acct", drive, "/Movies/2024/missing.mkv"); !errors.Is(err, driver.ErrNotExist) {
+		t.Fatalf("missing error = %v", err)
+	}
+	if drive.calls["list"] != 1 || drive.calls["dirname"] != 2 {
+		t.Fatalf("a cached listing should answer misses: %v", drive.calls)
+	}
+}
+
+func TestPathCacheRejectsMismatchedDirName2CID(t *testing.T) {
+	drive := newTestDrive()
+	cache := newPathCache()
+	if _, err := cache.resolveDir("acct", drive, "/Movies/2025"); !errors.Is(err, driver.ErrNotExist) {
+		t.Fatalf("missing dir error = %v", err)
+	}
+	if _, err := cache.resolveDir("acct", drive, "/Movies/2024/a.mkv"); !errors.Is(err, driver.ErrNotExist) {
+		t.Fatalf("file as dir error = %v", err)
+	}
+}
+
+func TestPathCacheInvalidate(t *testing.T) {
+	drive := newTestDrive()
+	cache := newPathCache()
+	if _, err := cache.resolve("acct", drive, "/Movies/2024/a.mkv"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Renaming the year directory drops it, its files and its parent's listing
+	drive.nodes["11"] = fakeNode{name: "2023", parent: "10", dir: true}
+	cache.invalidate("acct", "11")
+	if _, err := cache.resolve("acct", drive, "/Movies/2024/a.mkv"); !errors.Is(err, driver.ErrNotExist) {
+		t.Fatalf("stale path resolved: %v", err)
+	}
+	entry, err := cache.resolve("acct", drive, "/Movies/2023/a.mkv")
+	if err != nil || entry.ID != "12" {
+		t.Fatalf("renamed path = %+v, %v", entry, err)
+	}
+
+	// A new file in a cached directory appears once that directory is invalidated
+	drive.add("14", "11", "c.mkv", false)
+	if _, err := cache.resolve("acct", drive, "/Movies/2023/c.mkv"); !errors.Is(err, driver.ErrNotExist) {
+		t.Fatalf("expected the cached listing to miss: %v", err)
+	}
+	cache.invalidate("acct", "11")
+	if entry, err := cache.resolve("acct", drive, "/Movies/2023/c.mkv"); err != nil || entry.ID != "14" {
+		t.Fatalf("new file = %+v, %v", entry, err)
+	}
+}
+
+func TestPathCacheIsPerAccount(t *testing.T) {
+	drive := newTestDrive()
+	cache := newPathCache()
+	if _, err := cache.resolve("one", drive, "/Movies"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.resolve("two", drive, "/Movies"); err != nil {
+		t.Fatal(err)
+	}
+	if drive.calls["dirname"] != 2 {
+		t.Fatalf("accounts shared a cache: %v", drive.calls)
+	}
+}
+
+func TestPathCacheList(t *testing.T) {
+	drive := newTestDrive()
+	cache := newPathCache()
+
+	entries, err := cache.list("acct", drive, "/Movies/2024")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Name != "a.mkv" || entries[1].Name != "b.mkv" {
+		t.Fatalf("entries = %+v", entries)
+	}
+	// Children of a listed directory resolve without another call
+	if _, err := cache.resolve("acct", drive, "/Movies/2024/b.mkv"); err != nil {
+		t.Fatal(err)
+	}
+	if drive.calls["list"] != 1 || drive.calls["dirname"] != 1 {
+		t.Fatalf("calls = %v", drive.calls)
+	}
+}
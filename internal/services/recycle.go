@@ -0,0 +1,81 @@
+package services
+
+import (
+	"context"
+	"time"
+
+	"cloud-driver/internal/models"
+
+	"github.com/SheltonZhu/115driver/pkg/driver"
+)
+
+const recycleBinDefaultLimit = 40
+
+// ListRecycleBin returns one page of deleted items
+func (s *Drive115Service) ListRecycleBin(ctx context.Context, credentials models.Drive115Credentials, offset, limit int) (_ *models.RecycleBinListResponse, err error) {
+	client, err := s.createClient(credentials)
+	if err != nil {
+		return nil, err
+	}
+	defer s.clients.evictOnLogout(credentials, &err)
+
+	if limit == 0 {
+		limit = recycleBinDefaultLimit
+	}
+	items, err := client.ListRecycleBin(offset, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &models.RecycleBinListResponse{
+		Items:  make([]models.RecycleBinItem, 0, len(items)),
+		Offset: offset,
+		Limit:  limit,
+	}
+	for _, item := range items {
+		response.Items = append(response.Items, recycleBinItem(item))
+	}
+	// The list API returns no total, so a full page means there may be more
+	if len(items) == limit {
+		next := offset + limit
+		response.NextOffset = &next
+	}
+	return response, nil
+}
+
+// RestoreRecycleBin moves items out of the recycle bin to their original directories
+func (s *Drive115Service) RestoreRecycleBin(ctx context.Context, credentials models.Drive115Credentials, ids []string) (err error) {
+	client, err := s.createClient(credentials)
+	if err != nil {
+		return err
+	}
+	defer s.clients.evictOnLogout(credentials, &err)
+	if err := client.RevertRecycleBin(ids...); err != nil {
+		return err
+	}
+	// Restored items return to directories we cannot name without a lookup
+	s.paths.reset(credentials.Key())
+	return nil
+}
+
+// PurgeRecycleBin permanently deletes items, or the whole bin when ids is empty.
+// password is the account's 115 safe password.
+func (s *Drive115Service) PurgeRecycleBin(ctx context.Context, credentials models.Drive115Credentials, password string, ids []string) (err error) {
+	client, err := s.createClient(credentials)
+	if err != nil {
+		return err
+	}
+	defer s.clients.evictOnLogout(credentials, &err)
+	return client.CleanRecycleBin(password, ids...)
+}
+
+func recycleBinItem(item driver.RecycleBinItem) models.RecycleBinItem {
+	return models.RecycleBinItem{
+		ID:         item.FileId,
+		Name:       item.FileName,
+		Size:       int64(item.FileSize),
+		ParentID:   string(item.ParentId),
+		ParentName: item.ParentName,
+		DeletedAt:  time.Unix(int64(item.DeleteTime), 0),
+	}
+}
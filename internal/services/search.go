@@ -0,0 +1,83 @@
+package services
+
+import (
+	"context"
+	"strconv"
+
+	"cloud-driver/internal/models"
+
+	"github.com/SheltonZhu/115driver/pkg/driver"
+)
+
+const searchDefaultLimit = 30
+
+// searchTypes maps request type names to the 115 search type filter
+var searchTypes = map[string]int{
+	"all":     0,
+	"folder":  1,
+	"doc":     2,
+	"image":   3,
+	"video":   4,
+	"audio":   5,
+	"archive": 6,
+}
+
+// Search runs a 115 file search and returns one page of normalized rows
+func (s *Drive115Service) Search(ctx context.Context, req models.SearchRequest) (_ *models.SearchResponse, err error) {
+	client, err := s.createClient(req.Credentials)
+	if err != nil {
+		return nil, err
+	}
+	defer s.clients.evictOnLogout(req.Credentials, &err)
+
+	result, err := client.Search(searchOption(req))
+	if err != nil {
+		return nil, err
+	}
+
+	limit := req.Limit
+	if limit == 0 {
+		limit = searchDefaultLimit
+	}
+	response := &models.SearchResponse{
+		Files:       make([]models.FileRow, 0, len(result.Files)),
+		Count:       result.Count,
+		FileCount:   result.FileCount,
+		FolderCount: result.FolderCount,
+		Offset:      req.Offset,
+		Limit:       limit,
+	}
+	for _, file := range result.Files {
+		response.Files = append(response.Files, fileRow(file))
+	}
+	if next := req.Offset + len(result.Files); len(result.Files) > 0 && next < result.Count {
+		response.NextOffset = &next
+	}
+	return response, nil
+}
+
+func searchOption(req models.SearchRequest) *driver.SearchOption {
+	opts := &driver.SearchOption{
+		Offset:       req.Offset,
+		Limit:        req.Limit,
+		SearchValue:  req.Keyword,
+		Date:         req.Date,
+		Cid:          strconv.FormatInt(req.DirID, 10),
+		Type:         searchTypes[req.Type],
+		CountFolders: 1,
+		Suffix:       req.Suffix,
+		Order:        req.Order,
+		Asc:          driver.BoolToInt(req.Asc),
+	}
+	if opts.Limit == 0 {
+		opts.Limit = searchDefaultLimit
+	}
+	if opts.Order == "" {
+		// Newest first unless the caller picks an order
+		opts.Order = "user_utime"
+	}
+	if req.Star {
+		opts.Star = "1"
+	}
+	return opts
+}
@@ -0,0 +1,17 @@
+package services
+
+import (
+	"testing"
+
+	"cloud-driver/internal/models"
+)
+
+func TestSearchOption(t *testing.T) {
+	opts := searchOption(models.SearchRequest{Keyword: "movie", DirID: 42, Type: "video", Star: true, Asc: true, Offset: 30})
+	if opts.SearchValue != "movie" || opts.Cid != "42" || opts.Type != 4 || opts.Star != "1" || opts.Asc != 1 || opts.Offset != 30 {
+		t.Fatalf("unexpected options %+v", opts)
+	}
+	if opts.Limit != searchDefaultLimit || opts.Order != "user_utime" {
+		t.Fatalf("defaults not applied: %+v", opts)
+	}
+}
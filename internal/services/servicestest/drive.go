@@ -0,0 +1,194 @@
+// Package servicestest provides an in-memory 115 drive for tests of the
+// front-ends built on the services package.
+package servicestest
+
+import (
+	"context"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud-driver/internal/models"
+	"cloud-driver/internal/services"
+
+	hash "github.com/SheltonZhu/115driver/pkg/crypto"
+	"github.com/SheltonZhu/115driver/pkg/driver"
+)
+
+// Drive is an in-memory drive keyed by ID. The root directory is "0", and
+// pick codes are "pc" followed by the file ID. BeforeCopy, when set, runs at
+// the start of every CopyFiles call.
+type Drive struct {
+	Nodes      map[string]*Node
+	Uploads    []Upload
+	Copies     int
+	BeforeCopy func()
+	next       int
+}
+
+// Node is a file or directory of a Drive
+type Node struct {
+	Name, Parent string
+	Dir          bool
+	Data         string
+}
+
+// Upload records one UploadFile call
+type Upload struct {
+	Path   string
+	Digest hash.DigestResult
+}
+
+// NewDrive returns an empty drive
+func NewDrive() *Drive {
+	return &Drive{Nodes: map[string]*Node{}, next: 100}
+}
+
+// Add creates a node under parent and returns its ID
+func (d *Drive) Add(parent, name string, dir bool, data string) string {
+	d.next++
+	id := fmt.Sprint(d.next)
+	d.Nodes[id] = &Node{Name: name, Parent: parent, Dir: dir, Data: data}
+	return id
+}
+
+func (d *Drive) entry(id string) services.PathEntry {
+	if id == "0" {
+		return services.PathEntry{ID: "0", IsDir: true}
+	}
+	node := d.Nodes[id]
+	entry := services.PathEntry{ID: id, Name: node.Name, IsDir: node.Dir, Size: int64(len(node.Data)), PickCode: "pc" + id, ModTime: time.Unix(1700000000, 0)}
+	if !node.Dir {
+		entry.SHA1 = fmt.Sprintf("%X", sha1.Sum([]byte(node.Data)))
+	}
+	return entry
+}
+
+func (d *Drive) child(parent, name string) string {
+	for id, node := range d.Nodes {
+		if node.Parent == parent && node.Name == name {
+			return id
+		}
+	}
+	return ""
+}
+
+func (d *Drive) path(id string) string {
+	p := ""
+	for ; id != "0"; id = d.Nodes[id].Parent {
+		p = "/" + d.Nodes[id].Name + p
+	}
+	return p
+}
+
+func (d *Drive) ResolvePath(ctx context.Context, credentials models.Drive115Credentials, filePath string) (services.PathEntry, error) {
+	id := "0"
+	for _, part := range strings.Split(strings.Trim(path.Clean("/"+filePath), "/"), "/") {
+		if part == "" {
+			continue
+		}
+		if id = d.child(id, part); id == "" {
+			return services.PathEntry{}, driver.ErrNotExist
+		}
+	}
+	return d.entry(id), nil
+}
+
+func (d *Drive) ListDir(ctx context.Context, credentials models.Drive115Credentials, dirPath string) ([]services.PathEntry, error) {
+	dir, err := d.ResolvePath(ctx, credentials, dirPath)
+	if err != nil {
+		return nil, err
+	}
+	var entries []services.PathEntry
+	for id, node := range d.Nodes {
+		if node.Parent == dir.ID {
+			entries = append(entries, d.entry(id))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, nil
+}
+
+func (d *Drive) Mkdir(ctx context.Context, credentials models.Drive115Credentials, parentID, name string) (string, error) {
+	return d.Add(parentID, name, true, ""), nil
+}
+
+func (d *Drive) EnsureDir(ctx context.Context, credentials models.Drive115Credentials, dirPath string) (string, []string, error) {
+	id := "0"
+	var created []string
+	for _, part := range strings.Split(strings.Trim(dirPath, "/"), "/") {
+		if part == "" {
+			continue
+		}
+		next := d.child(id, part)
+		if next == "" {
+			next = d.Add(id, part, true, "")
+			created = append(created, part)
+		}
+		id = next
+	}
+	return id, created, nil
+}
+
+func (d *Drive) MoveFiles(ctx context.Context, credentials models.Drive115Credentials, fileIDs []string, targetDirID string) error {
+	for _, id := range fileIDs {
+		d.Nodes[id].Parent = targetDirID
+	}
+	return nil
+}
+
+func (d *Drive) CopyFiles(ctx context.Context, credentials models.Drive115Credentials, fileIDs []string, targetDirID string) error {
+	d.Copies++
+	if d.BeforeCopy != nil {
+		d.BeforeCopy()
+	}
+	for _, id := range fileIDs {
+		node := *d.Nodes[id]
+		d.Add(targetDirID, node.Name, node.Dir, node.Data)
+	}
+	return nil
+}
+
+func (d *Drive) RenameFiles(ctx context.Context, credentials models.Drive115Credentials, files []models.RenameFileItem) error {
+	for _, file := range files {
+		d.Nodes[file.FileID].Name = file.Name
+	}
+	return nil
+}
+
+func (d *Drive) DeleteFiles(ctx context.Context, credentials models.Drive115Credentials, fileIDs []string) error {
+	for _, id := range fileIDs {
+		delete(d.Nodes, id)
+	}
+	return nil
+}
+
+// OpenPickCodeStream serves the file with http.ServeContent, so Range and
+// HEAD behave like the CDN
+func (d *Drive) OpenPickCodeStream(ctx context.Context, credentials models.Drive115Credentials, pickCode, fileName, method string, requestHeader http.Header) (*services.DownloadStream, error) {
+	node, ok := d.Nodes[strings.TrimPrefix(pickCode, "pc")]
+	if !ok {
+		return nil, driver.ErrNotExist
+	}
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(method, "/", nil)
+	request.Header = requestHeader
+	http.ServeContent(recorder, request, fileName, time.Time{}, strings.NewReader(node.Data))
+	return &services.DownloadStream{FileName: fileName, FileSize: int64(len(node.Data)), Response: recorder.Result()}, nil
+}
+
+func (d *Drive) UploadFile(ctx context.Context, credentials models.Drive115Credentials, dirID, name string, source io.ReaderAt, digest hash.DigestResult, progress func(int64)) (bool, error) {
+	data, err := io.ReadAll(io.NewSectionReader(source, 0, digest.Size))
+	if err != nil {
+		return false, err
+	}
+	d.Add(dirID, name, false, string(data))
+	d.Uploads = append(d.Uploads, Upload{Path: d.path(dirID) + "/" + name, Digest: digest})
+	return false, nil
+}
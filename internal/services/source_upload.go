@@ -0,0 +1,306 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cloud-driver/internal/models"
+	"cloud-driver/internal/netguard"
+
+	hash "github.com/SheltonZhu/115driver/pkg/crypto"
+	"github.com/SheltonZhu/115driver/pkg/driver"
+)
+
+// Source upload stages, reported with the bytes processed in that stage
+const (
+	SourceStageDownloading = "downloading"
+	SourceStageHashing     = "hashing"
+	SourceStageUploading   = "uploading"
+)
+
+// ErrSourceNotAllowed is returned for local paths outside the configured
+// roots and for URLs that resolve to private addresses
+var ErrSourceNotAllowed = errors.New("upload source is not allowed")
+
+// SourceUploadOptions configures server-side uploads. Local paths must lie
+// below one of LocalRoots. URL downloads are spooled to SpoolDir and limited to
+// MaxDownloadSize; they may reach private networks only when
+// AllowPrivateNetworks is set. Concurrency bounds the uploads running at once.
+type SourceUploadOptions struct {
+	LocalRoots           []string
+	SpoolDir             string
+	MaxDownloadSize      int64
+	AllowPrivateNetworks bool
+	Concurrency          int
+}
+
+// SourceUploadRequest names exactly one of a URL and a local path, and the
+// directory to upload into
+type SourceUploadRequest struct {
+	URL      string `json:"url,omitempty"`
+	Path     string `json:"path,omitempty"`
+	DirID    string `json:"dir_id"`
+	FileName string `json:"file_name,omitempty"`
+}
+
+// SourceUploadResult describes the uploaded file
+type SourceUploadResult struct {
+	DirID    string `json:"dir_id"`
+	FileName string `json:"file_name"`
+	FileSize int64  `json:"file_size"`
+	SHA1     string `json:"sha1"`
+	Instant  bool   `json:"instant"`
+}
+
+// SourceUploader uploads files whose bytes the server fetches itself, from an
+// HTTP(S) URL or a whitelisted local path. It hashes the source as
+// GetDigestResult does, so 115 can place known files instantly, and otherwise
+// drives the OSS multipart upload.
+type SourceUploader struct {
+	service    *Drive115Service
+	options    SourceUploadOptions
+	localRoots []string
+	client     *http.Client
+	slots      chan struct{}
+}
+
+// NewSourceUploader resolves the local roots and builds the download client
+func NewSourceUploader(service *Drive115Service, options SourceUploadOptions) (*SourceUploader, error) {
+	if options.Concurrency <= 0 {
+		options.Concurrency = 1
+	}
+	u := &SourceUploader{
+		service: service,
+		options: options,
+		slots:   make(chan struct{}, options.Concurrency),
+	}
+	for _, root := range options.LocalRoots {
+		if !filepath.IsAbs(root) {
+			return nil, fmt.Errorf("upload source root %q must be absolute", root)
+		}
+		resolved, err := filepath.EvalSymlinks(root)
+		if err != nil {
+			return nil, fmt.Errorf("upload source root %q: %w", root, err)
+		}
+		u.localRoots = append(u.localRoots, resolved)
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if !options.AllowPrivateNetworks {
+		dialer.Control = netguard.PublicOnly(ErrSourceNotAllowed)
+	}
+	u.client = &http.Client{
+		Transport: &http.Transport{
+			// No proxy: the address check must see the real destination
+			DialContext:           dialer.DialContext,
+			ForceAttemptHTTP2:     true,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: time.Minute,
+		},
+	}
+	return u, nil
+}
+
+// Prepare validates the source and resolves local paths, so paths outside the
+// roots are refused before a job is queued. Upload prepares again in case the
+// file or the roots changed in the meantime.
+func (u *SourceUploader) Prepare(req SourceUploadRequest) (SourceUploadRequest, error) {
+	if (req.URL == "") == (req.Path == "") {
+		return req, fmt.Errorf("exactly one of url and path is required: %w", driver.ErrWrongParams)
+	}
+	if req.Path != "" {
+		resolved, err := u.localPath(req.Path)
+		if err != nil {
+			return req, err
+		}
+		req.Path = resolved
+		if req.FileName == "" {
+			req.FileName = filepath.Base(resolved)
+		}
+		return req, nil
+	}
+	if parsed, err := url.Parse(req.URL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return req, fmt.Errorf("url must be an absolute http or https URL: %w", driver.ErrWrongParams)
+	}
+	return req, nil
+}
+
+// Upload fetches and hashes the source, then uploads it. progress, when set,
+// is called with the current stage and the bytes processed and expected in
+// it; the expected size is 0 when unknown.
+func (u *SourceUploader) Upload(ctx context.Context, credentials models.Drive115Credentials, req SourceUploadRequest, progress func(stage string, done, total int64)) (_ *SourceUploadResult, err error) {
+	req, err = u.Prepare(req)
+	if err != nil {
+		return nil, err
+	}
+	if progress == nil {
+		progress = func(string, int64, int64) {}
+	}
+	select {
+	case u.slots <- struct{}{}:
+		defer func() { <-u.slots }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	var source io.ReaderAt
+	var digest hash.DigestResult
+	if req.Path != "" {
+		file, err := os.Open(req.Path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		info, err := file.Stat()
+		if err != nil {
+			return nil, err
+		}
+		reader := &progressReader{ctx: ctx, source: file, report: func(done int64) { progress(SourceStageHashing, done, info.Size()) }}
+		if err := hash.Digest(reader, &digest); err != nil {
+			return nil, err
+		}
+		source = file
+	} else {
+		spooled, name, err := u.download(ctx, req.URL, progress)
+		if err != nil {
+			return nil, err
+		}
+		defer spooled.Close()
+		if req.FileName == "" {
+			req.FileName = name
+		}
+		source, digest = spooled, spooled.Digest
+	}
+
+	progress(SourceStageUploading, 0, digest.Size)
+	instant, err := u.service.UploadFile(ctx, credentials, req.DirID, req.FileName, source, digest, func(sent int64) {
+		progress(SourceStageUploading, sent, digest.Size)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &SourceUploadResult{DirID: req.DirID, FileName: req.FileName, FileSize: digest.Size, SHA1: digest.QuickID, Instant: instant}, nil
+}
+
+// localPath resolves symlinks and checks the file lies below a configured root
+func (u *SourceUploader) localPath(name string) (string, error) {
+	if !filepath.IsAbs(name) {
+		return "", fmt.Errorf("path must be absolute: %w", driver.ErrWrongParams)
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Clean(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", fmt.Errorf("path %s: %w", name, driver.ErrNotExist)
+	}
+	if err != nil {
+		return "", err
+	}
+	allowed := false
+	for _, root := range u.localRoots {
+		if rel, err := filepath.Rel(root, resolved); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return "", fmt.Errorf("path %s: %w", name, ErrSourceNotAllowed)
+	}
+	info, err := os.Stat(resolved)
+	if err != nil {
+		return "", err
+	}
+	if !info.Mode().IsRegular() || info.Size() == 0 {
+		return "", fmt.Errorf("path %s is not a non-empty regular file: %w", name, driver.ErrWrongParams)
+	}
+	return resolved, nil
+}
+
+// download spools the URL to disk, hashing it on the way. It returns the file
+// name suggested by the response.
+func (u *SourceUploader) download(ctx context.Context, rawURL string, progress func(string, int64, int64)) (*SpooledFile, string, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := u.client.Do(request)
+	if err != nil {
+		return nil, "", fmt.Errorf("download source: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err := fmt.Errorf("source returned %s", resp.Status)
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			err = fmt.Errorf("%w: %w", err, driver.ErrWrongParams)
+		}
+		return nil, "", err
+	}
+	if u.options.MaxDownloadSize > 0 && resp.ContentLength > u.options.MaxDownloadSize {
+		return nil, "", fmt.Errorf("source is %d bytes: %w", resp.ContentLength, ErrUploadTooLarge)
+	}
+
+	total := max(resp.ContentLength, 0)
+	reader := &progressReader{ctx: ctx, source: resp.Body, report: func(done int64) { progress(SourceStageDownloading, done, total) }}
+	spooled, err := SpoolFile(reader, u.options.SpoolDir, u.options.MaxDownloadSize)
+	if err != nil {
+		return nil, "", err
+	}
+	if spooled.Digest.Size == 0 {
+		spooled.Close()
+		return nil, "", fmt.Errorf("source is empty: %w", driver.ErrWrongParams)
+	}
+	return spooled, sourceFileName(resp), nil
+}
+
+// sourceFileName takes the name from Content-Disposition, or else from the
+// last segment of the final URL
+func sourceFileName(resp *http.Response) string {
+	if _, params, err := mime.ParseMediaType(resp.Header.Get("Content-Disposition")); err == nil {
+		if name := cleanSourceName(params["filename"]); name != "" {
+			return name
+		}
+	}
+	if name := cleanSourceName(path.Base(resp.Request.URL.Path)); name != "" {
+		return name
+	}
+	return "download"
+}
+
+func cleanSourceName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	invalidControl := strings.IndexFunc(name, func(r rune) bool { return r < 0x20 || r == 0x7f }) >= 0
+	if name == "." || name == ".." || name == "/" || len(name) > 255 || invalidControl {
+		return ""
+	}
+	return name
+}
+
+// progressReader reports the running total of bytes read and stops once ctx
+// is done, so hashing a large local file can be canceled
+type progressReader struct {
+	ctx    context.Context
+	source io.Reader
+	done   int64
+	report func(int64)
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	n, err := r.source.Read(p)
+	if n > 0 {
+		r.done += int64(n)
+		r.report(r.done)
+	}
+	return n, err
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SheltonZhu/115driver/pkg/driver"
+)
+
+func TestSourceUploaderLocalPath(t *testing.T) {
+	root, outside := t.TempDir(), t.TempDir()
+	inside := filepath.Join(root, "movie.mkv")
+	secret := filepath.Join(outside, "secret")
+	for _, name := range []string{inside, secret} {
+		if err := os.WriteFile(name, []byte("data"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(secret, filepath.Join(root, "escape")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "empty"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	uploader, err := NewSourceUploader(nil, SourceUploadOptions{LocalRoots: []string{root}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := uploader.localPath(inside); err != nil {
+		t.Fatalf("file inside root rejected: %v", err)
+	}
+	for name, want := range map[string]error{
+		secret:                         ErrSourceNotAllowed,
+		filepath.Join(root, "escape"):  ErrSourceNotAllowed,
+		filepath.Join(root, "..", "x"): driver.ErrNotExist,
+		filepath.Join(root, "missing"): driver.ErrNotExist,
+		filepath.Join(root, "empty"):   driver.ErrWrongParams,
+		root:                           driver.ErrWrongParams,
+		"movie.mkv":                    driver.ErrWrongParams,
+		filepath.Join(root, "..", filepath.Base(outside), "secret"): ErrSourceNotAllowed,
+	} {
+		if _, err := uploader.localPath(name); !errors.Is(err, want) {
+			t.Errorf("%s: got %v, want %v", name, err, want)
+		}
+	}
+}
+
+func TestSourceFileName(t *testing.T) {
+	for _, test := range []struct {
+		disposition, rawURL, want string
+	}{
+		{`attachment; filename="report.pdf"`, "https://example.com/dl?id=1", "report.pdf"},
+		{`attachment; filename="../../etc/passwd"`, "https://example.com/a", "passwd"},
+		{"", "https://example.com/files/video%20one.mp4", "video one.mp4"},
+		{"", "https://example.com/", "download"},
+	} {
+		target, _ := url.Parse(test.rawURL)
+		resp := &http.Response{Header: http.Header{}, Request: &http.Request{URL: target}}
+		if test.disposition != "" {
+			resp.Header.Set("Content-Disposition", test.disposition)
+		}
+		if got := sourceFileName(resp); got != test.want {
+			t.Errorf("%q %s: got %q, want %q", test.disposition, test.rawURL, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+
+	"cloud-driver/internal/models"
+	"cloud-driver/internal/recordstore"
+	"cloud-driver/internal/torrent"
+
+	hash "github.com/SheltonZhu/115driver/pkg/crypto"
+)
+
+// torrentDirPath holds uploaded torrent files until their task is added. 115
+// only reads the metainfo of a torrent that is stored in the cloud.
+const torrentDirPath = "/cloud-driver/torrents"
+
+// AddOfflineTorrent adds an offline task for the selected files of a parsed
+// torrent, given by metainfo indexes. The torrent file is uploaded under
+// torrentDirPath first and deleted again once the task is added or fails. It
+// returns the info hash of the task.
+func (s *Drive115Service) AddOfflineTorrent(ctx context.Context, credentials models.Drive115Credentials, data []byte, meta *torrent.MetaInfo, selected []int, saveDirID string) (_ string, err error) {
+	dirID, _, err := s.EnsureDir(ctx, credentials, torrentDirPath)
+	if err != nil {
+		return "", fmt.Errorf("create torrent directory: %w", err)
+	}
+	var digest hash.DigestResult
+	if err := hash.Digest(bytes.NewReader(data), &digest); err != nil {
+		return "", err
+	}
+	// A unique name, so concurrent adds of one torrent delete only their own file
+	name, err := recordstore.NewID(meta.InfoHash + "-")
+	if err != nil {
+		return "", err
+	}
+	name += ".torrent"
+	if _, err := s.UploadFile(ctx, credentials, dirID, name, bytes.NewReader(data), digest, nil); err != nil {
+		return "", fmt.Errorf("upload torrent file: %w", err)
+	}
+	defer s.removeTorrentFile(context.WithoutCancel(ctx), credentials, name)
+
+	client, err := s.createClient(credentials)
+	if err != nil {
+		return "", err
+	}
+	defer s.clients.evictOnLogout(credentials, &err)
+
+	info, err := client.GetTorrentInfo(strings.ToUpper(digest.QuickID))
+	if err != nil {
+		return "", err
+	}
+	if !strings.EqualFold(info.InfoHash, meta.InfoHash) {
+		return "", fmt.Errorf("115 read info hash %s from the torrent, expected %s", info.InfoHash, meta.InfoHash)
+	}
+	wanted, err := remoteTorrentIndexes(meta, len(info.Files), selected)
+	if err != nil {
+		return "", err
+	}
+	infoHash, err := client.AddOfflineTaskTorrent(info.InfoHash, wanted, saveDirID)
+	if err != nil {
+		return "", err
+	}
+	s.invalidatePaths(credentials, saveDirID)
+	return infoHash, nil
+}
+
+// removeTorrentFile deletes an uploaded torrent file. 115 keeps the torrent it
+// has read, so the file is not needed afterwards; a failure only leaves it
+// behind.
+func (s *Drive115Service) removeTorrentFile(ctx context.Context, credentials models.Drive115Credentials, name string) {
+	entry, err := s.ResolvePath(ctx, credentials, torrentDirPath+"/"+name)
+	if err != nil {
+		return
+	}
+	_ = s.DeleteFiles(ctx, credentials, []string{entry.ID})
+}
+
+// remoteTorrentIndexes maps metainfo file indexes to the indexes of 115's file
+// list, which leaves out padding files when it does not list every file
+func remoteTorrentIndexes(meta *torrent.MetaInfo, remoteCount int, selected []int) ([]int, error) {
+	if remoteCount == len(meta.Files) {
+		return selected, nil
+	}
+	remote := make([]int, len(meta.Files))
+	count := 0
+	for i, file := range meta.Files {
+		remote[i] = count
+		if !file.Padding {
+			count++
+		}
+	}
+	if remoteCount != count {
+		return nil, fmt.Errorf("115 lists %d files in the torrent, expected %d", remoteCount, count)
+	}
+	wanted := make([]int, len(selected))
+	for i, index := range selected {
+		wanted[i] = remote[index]
+	}
+	return wanted, nil
+}
@@ -0,0 +1,23 @@
+package services
+
+import (
+	"slices"
+	"testing"
+
+	"cloud-driver/internal/torrent"
+)
+
+func TestRemoteTorrentIndexes(t *testing.T) {
+	meta := &torrent.MetaInfo{Files: []torrent.File{
+		{Path: "a.mkv"}, {Path: ".pad/1", Padding: true}, {Path: "b.mkv"}, {Path: "c.nfo"},
+	}}
+	if wanted, err := remoteTorrentIndexes(meta, 4, []int{0, 3}); err != nil || !slices.Equal(wanted, []int{0, 3}) {
+		t.Fatalf("full list: %v, %v", wanted, err)
+	}
+	if wanted, err := remoteTorrentIndexes(meta, 3, []int{0, 2, 3}); err != nil || !slices.Equal(wanted, []int{0, 1, 2}) {
+		t.Fatalf("without padding: %v, %v", wanted, err)
+	}
+	if _, err := remoteTorrentIndexes(meta, 2, []int{0}); err == nil {
+		t.Fatal("mismatched file count accepted")
+	}
+}
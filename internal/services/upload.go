@@ -44,7 +44,7 @@ type UploadProgress struct {
 	Complete      bool
 }
 
-func (s *Drive115Service) InitUpload(ctx context.Context, req models.UploadInitRequest, expiresAt int64) (*UploadInitResult, error) {
+func (s *Drive115Service) InitUpload(ctx context.Context, req models.UploadInitRequest, expiresAt int64) (_ *UploadInitResult, err error) {
 	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
@@ -52,6 +52,7 @@ func (s *Drive115Service) InitUpload(ctx context.Context, req models.UploadInitR
 	if err != nil {
 		return nil, err
 	}
+	defer s.clients.evictOnLogout(req.Credentials, &err)
 	result, err := client.RapidUploadByHash(req.FileSize, req.FileName, req.DirID, req.PreSHA1, req.SHA1, req.SignKey, req.SignValue)
 	if err != nil {
 		return nil, err
@@ -59,6 +60,7 @@ func (s *Drive115Service) InitUpload(ctx context.Context, req models.UploadInitR
 
 	switch result.Status {
 	case 2:
+		s.invalidatePaths(req.Credentials, req.DirID)
 		return &UploadInitResult{State: "instant"}, nil
 	case 7:
 		return &UploadInitResult{State: "sign_check", SignKey: result.SignKey, SignCheck: result.SignCheck}, nil
@@ -116,7 +118,7 @@ func (s *Drive115Service) UploadPart(ctx context.Context, session UploadSession,
 	if err != nil {
 		return err
 	}
-	client := newUploadClient(session.Credentials)
+	client := s.uploadClient(session.Credentials)
 	bucket, token, err := uploadBucket(client, session.Bucket)
 	if err != nil {
 		return err
@@ -167,7 +169,7 @@ func (s *Drive115Service) CompleteUpload(ctx context.Context, session UploadSess
 		return fmt.Errorf("upload is incomplete")
 	}
 
-	client := newUploadClient(session.Credentials)
+	client := s.uploadClient(session.Credentials)
 	bucket, token, err := uploadBucket(client, session.Bucket)
 	if err != nil {
 		return err
@@ -186,14 +188,18 @@ func (s *Drive115Service) CompleteUpload(ctx context.Context, session UploadSess
 	if err := json.Unmarshal(callbackBody, &result); err != nil {
 		return fmt.Errorf("decode 115 upload callback: %w", err)
 	}
-	return result.Err(string(callbackBody))
+	if err := result.Err(string(callbackBody)); err != nil {
+		return err
+	}
+	s.invalidatePaths(session.Credentials, session.DirID)
+	return nil
 }
 
 func (s *Drive115Service) AbortUpload(ctx context.Context, session UploadSession) error {
 	if err := ctx.Err(); err != nil {
 		return err
 	}
-	client := newUploadClient(session.Credentials)
+	client := s.uploadClient(session.Credentials)
 	bucket, token, err := uploadBucket(client, session.Bucket)
 	if err != nil {
 		return err
@@ -209,7 +215,7 @@ func (s *Drive115Service) listUploadParts(ctx context.Context, session UploadSes
 	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
-	client := newUploadClient(session.Credentials)
+	client := s.uploadClient(session.Credentials)
 	bucket, token, err := uploadBucket(client, session.Bucket)
 	if err != nil {
 		return nil, err
@@ -239,10 +245,10 @@ func (s *Drive115Service) listUploadParts(ctx context.Context, session UploadSes
 	return parts, nil
 }
 
-func newUploadClient(credentials models.Drive115Credentials) *driver.Pan115Client {
-	return driver.New(driver.UA(driver.UA115Browser)).ImportCredential(&driver.Credential{
-		UID: credentials.UID, CID: credentials.CID, SEID: credentials.SEID, KID: credentials.KID,
-	})
+// uploadClient skips the login check: the session was verified at init and
+// OSS rejects the part upload itself if the account has logged out
+func (s *Drive115Service) uploadClient(credentials models.Drive115Credentials) *driver.Pan115Client {
+	return s.clients.getUnchecked(credentials)
 }
 
 func uploadBucket(client *driver.Pan115Client, bucketName string) (*oss.Bucket, *driver.UploadOSSTokenResp, error) {
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"cloud-driver/internal/models"
+
+	hash "github.com/SheltonZhu/115driver/pkg/crypto"
+)
+
+// serverUploadLifetime bounds the OSS session of an upload driven by the server
+const serverUploadLifetime = 24 * time.Hour
+
+// ErrUploadTooLarge is returned when a spooled body exceeds its size limit
+var ErrUploadTooLarge = errors.New("upload exceeds the size limit")
+
+// SpooledFile is an upload body staged on local disk together with the
+// digests 115 needs to place it. Close removes the file.
+type SpooledFile struct {
+	*os.File
+	Digest hash.DigestResult
+}
+
+// SpoolFile copies source into a temporary file in dir, hashing it on the way
+// as GetDigestResult does. maxSize of zero means no limit.
+func SpoolFile(source io.Reader, dir string, maxSize int64) (_ *SpooledFile, err error) {
+	file, err := os.CreateTemp(dir, "cloud-driver-upload-*")
+	if err != nil {
+		return nil, err
+	}
+	spooled := &SpooledFile{File: file}
+	defer func() {
+		if err != nil {
+			spooled.Close()
+		}
+	}()
+
+	if maxSize > 0 {
+		source = io.LimitReader(source, maxSize+1)
+	}
+	if err := hash.Digest(io.TeeReader(source, file), &spooled.Digest); err != nil {
+		return nil, err
+	}
+	if maxSize > 0 && spooled.Digest.Size > maxSize {
+		return nil, ErrUploadTooLarge
+	}
+	return spooled, nil
+}
+
+// Close closes and removes the spooled file
+func (f *SpooledFile) Close() error {
+	closeErr := f.File.Close()
+	if err := os.Remove(f.Name()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return closeErr
+}
+
+// UploadFile places digest.Size bytes of source in dirID under name. It tries
+// rapid upload first, answers a sign check from source, and otherwise sends the
+// bytes through the same OSS multipart session as the resumable upload API.
+// progress, when set, is called with the bytes sent after each part. It reports
+// whether 115 placed the file without receiving its bytes.
+func (s *Drive115Service) UploadFile(ctx context.Context, credentials models.Drive115Credentials, dirID, name string, source io.ReaderAt, digest hash.DigestResult, progress func(int64)) (bool, error) {
+	req := models.UploadInitRequest{
+		Credentials: credentials,
+		DirID:       dirID,
+		FileName:    name,
+		FileSize:    digest.Size,
+		SHA1:        strings.ToUpper(digest.QuickID),
+		PreSHA1:     strings.ToUpper(digest.PreID),
+	}
+	expiresAt := time.Now().Add(serverUploadLifetime).Unix()
+	result, err := s.InitUpload(ctx, req, expiresAt)
+	if err != nil {
+		return false, err
+	}
+	if result.State == "sign_check" {
+		req.SignKey = result.SignKey
+		if req.SignValue, err = digestRange(source, result.SignCheck); err != nil {
+			return false, err
+		}
+		if result, err = s.InitUpload(ctx, req, expiresAt); err != nil {
+			return false, err
+		}
+	}
+
+	switch result.State {
+	case "instant":
+		if progress != nil {
+			progress(digest.Size)
+		}
+		return true, nil
+	case "upload":
+	default:
+		return false, fmt.Errorf("unexpected 115 upload state %q", result.State)
+	}
+
+	session := *result.Session
+	if err := s.uploadParts(ctx, session, source, progress); err != nil {
+		// The caller's context may be what failed, so abort on a fresh one
+		_ = s.AbortUpload(context.WithoutCancel(ctx), session)
+		return false, err
+	}
+	return false, nil
+}
+
+func (s *Drive115Service) uploadParts(ctx context.Context, session UploadSession, source io.ReaderAt, progress func(int64)) error {
+	for offset, part := int64(0), 1; offset < session.FileSize; part++ {
+		size := min(session.PartSize, session.FileSize-offset)
+		if err := s.UploadPart(ctx, session, part, io.NewSectionReader(source, offset, size)); err != nil {
+			return fmt.Errorf("upload part %d: %w", part, err)
+		}
+		offset += size
+		if progress != nil {
+			progress(offset)
+		}
+	}
+	return s.CompleteUpload(ctx, session)
+}
+
+// digestRange hashes the inclusive byte range "start-end" that 115 asks for in
+// a sign check
+func digestRange(source io.ReaderAt, rangeSpec string) (string, error) {
+	var start, end int64
+	if _, err := fmt.Sscanf(rangeSpec, "%d-%d", &start, &end); err != nil || start < 0 || end < start {
+		return "", fmt.Errorf("invalid 115 sign check range %q", rangeSpec)
+	}
+	digest := sha1.New()
+	if _, err := io.Copy(digest, io.NewSectionReader(source, start, end-start+1)); err != nil {
+		return "", err
+	}
+	return strings.ToUpper(hex.EncodeToString(digest.Sum(nil))), nil
+}
@@ -0,0 +1,199 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"time"
+
+	"cloud-driver/internal/models"
+
+	"github.com/SheltonZhu/115driver/pkg/driver"
+)
+
+const (
+	walkPageSize           = 1000
+	walkDefaultMaxDepth    = 64
+	walkDefaultConcurrency = 2
+	// walkRequestDelay spaces each worker's list calls like folderVideoScanPageDelay
+	walkRequestDelay = 500 * time.Millisecond
+)
+
+// WalkOptions bounds a recursive directory walk
+type WalkOptions struct {
+	// MaxDepth is how many directory levels below the root are listed
+	MaxDepth int
+	// Concurrency is how many directories are listed in parallel
+	Concurrency int
+	// FilesOnly skips directory entries in the output; directories are still walked
+	FilesOnly bool
+}
+
+// walkListFunc lists one page of a directory
+type walkListFunc func(ctx context.Context, dirID string, offset int64) (*driver.FileListResp, error)
+
+type walkDir struct {
+	id    string
+	path  string
+	depth int
+}
+
+// walkPage is one listed page handed from a worker to the walk coordinator
+type walkPage struct {
+	dir   walkDir
+	files []driver.FileInfo
+	last  bool
+	err   error
+}
+
+// Walk lists every file and directory below dirID, calling visit once per
+// entry from a single goroutine. Entries carry their full path from the root.
+func (s *Drive115Service) Walk(ctx context.Context, credentials models.Drive115Credentials, dirID int64, opts WalkOptions, visit func(models.WalkEntry) error) (err error) {
+	client, err := s.createClient(credentials)
+	if err != nil {
+		return err
+	}
+	defer s.clients.evictOnLogout(credentials, &err)
+
+	root := walkDir{id: strconv.FormatInt(dirID, 10), path: "/"}
+	if dirID != 0 {
+		stat, err := client.Stat(root.id)
+		if err != nil {
+			return err
+		}
+		if !stat.IsDirectory {
+			return fmt.Errorf("directory %s: %w", root.id, driver.ErrNotExist)
+		}
+		root.path = statPath(stat)
+	}
+
+	list := func(ctx context.Context, dirID string, offset int64) (*driver.FileListResp, error) {
+		// Each call builds its own request: workers share one client copy
+		return driver.GetFiles(
+			client.Client.R().SetContext(ctx).ForceContentType("application/json;charset=UTF-8"),
+			dirID,
+			driver.WithLimit(walkPageSize),
+			driver.WithOffset(offset),
+			driver.WithShowDirEnable(true),
+		)
+	}
+	return walk(ctx, list, root, opts, walkRequestDelay, visit)
+}
+
+// statPath joins a directory's parent chain into an absolute path
+func statPath(stat *driver.FileStatInfo) string {
+	dirPath := "/"
+	for _, parent := range stat.Parents {
+		if parent.ID != "0" {
+			dirPath = path.Join(dirPath, parent.Name)
+		}
+	}
+	return path.Join(dirPath, stat.Name)
+}
+
+func walk(ctx context.Context, list walkListFunc, root walkDir, opts WalkOptions, delay time.Duration, visit func(models.WalkEntry) error) error {
+	if opts.MaxDepth <= 0 {
+		opts.MaxDepth = walkDefaultMaxDepth
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = walkDefaultConcurrency
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	work := make(chan walkDir)
+	defer close(work)
+	pages := make(chan walkPage)
+	for i := 0; i < opts.Concurrency; i++ {
+		go walkWorker(ctx, list, delay, work, pages)
+	}
+
+	// The coordinator owns the queue so workers never block each other
+	queue := []walkDir{root}
+	inflight := 0
+	for len(queue) > 0 || inflight > 0 {
+		var send chan walkDir
+		var next walkDir
+		if len(queue) > 0 {
+			send, next = work, queue[0]
+		}
+
+		select {
+		case send <- next:
+			queue = queue[1:]
+			inflight++
+		case page := <-pages:
+			if page.err != nil {
+				return page.err
+			}
+			if page.last {
+				inflight--
+			}
+			for index := range page.files {
+				file := (&driver.File{}).From(&page.files[index])
+				entry := models.WalkEntry{
+					ID:          file.FileID,
+					ParentID:    page.dir.id,
+					Name:        file.Name,
+					Path:        path.Join(page.dir.path, file.Name),
+					IsDirectory: file.IsDirectory,
+					Size:        file.Size,
+					SHA1:        file.Sha1,
+					PickCode:    file.PickCode,
+					Depth:       page.dir.depth + 1,
+					UpdatedAt:   file.UpdateTime,
+				}
+				if entry.IsDirectory && entry.Depth < opts.MaxDepth {
+					queue = append(queue, walkDir{id: entry.ID, path: entry.Path, depth: entry.Depth})
+				}
+				if entry.IsDirectory && opts.FilesOnly {
+					continue
+				}
+				if err := visit(entry); err != nil {
+					return err
+				}
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// walkWorker lists directories page by page, pausing between list calls
+func walkWorker(ctx context.Context, list walkListFunc, delay time.Duration, work <-chan walkDir, pages chan<- walkPage) {
+	first := true
+	for dir := range work {
+		offset := int64(0)
+		for {
+			if !first {
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(delay):
+				}
+			}
+			first = false
+
+			page := walkPage{dir: dir}
+			result, err := list(ctx, dir.id, offset)
+			if err != nil {
+				page.err = err
+			} else {
+				page.files = result.Files
+				offset += int64(len(result.Files))
+				page.last = len(result.Files) == 0 || offset >= int64(result.Count)
+			}
+
+			select {
+			case pages <- page:
+			case <-ctx.Done():
+				return
+			}
+			if page.err != nil || page.last {
+				break
+			}
+		}
+	}
+}
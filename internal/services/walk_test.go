@@ -0,0 +1,82 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"testing"
+
+	"cloud-driver/internal/models"
+
+	"github.com/SheltonZhu/115driver/pkg/driver"
+)
+
+// fakeTree lists directories from a map of directory ID to children
+type fakeTree map[string][]driver.FileInfo
+
+func (tree fakeTree) list(_ context.Context, dirID string, offset int64) (*driver.FileListResp, error) {
+	children, ok := tree[dirID]
+	if !ok {
+		return nil, driver.ErrNotExist
+	}
+	// Two entries per page to exercise paging
+	end := offset + 2
+	if end > int64(len(children)) {
+		end = int64(len(children))
+	}
+	return &driver.FileListResp{Count: len(children), Offset: int(offset), Files: children[offset:end]}, nil
+}
+
+func fakeDir(id int, name string) driver.FileInfo {
+	return driver.FileInfo{CategoryID: driver.IntString(strconv.Itoa(id)), Name: name}
+}
+
+func fakeFile(id, name string) driver.FileInfo {
+	return driver.FileInfo{FileID: id, Name: name}
+}
+
+func TestWalkStreamsFullPaths(t *testing.T) {
+	tree := fakeTree{
+		"0":  {fakeDir(10, "Movies"), fakeFile("1", "a.txt"), fakeFile("2", "b.txt")},
+		"10": {fakeDir(20, "2024"), fakeFile("3", "c.mkv")},
+		"20": {fakeFile("4", "d.mkv")},
+	}
+	cases := map[string]struct {
+		opts WalkOptions
+		want []string
+	}{
+		"all":        {opts: WalkOptions{Concurrency: 3}, want: []string{"/Movies", "/Movies/2024", "/Movies/2024/d.mkv", "/Movies/c.mkv", "/a.txt", "/b.txt"}},
+		"files only": {opts: WalkOptions{FilesOnly: true}, want: []string{"/Movies/2024/d.mkv", "/Movies/c.mkv", "/a.txt", "/b.txt"}},
+		"max depth":  {opts: WalkOptions{MaxDepth: 1}, want: []string{"/Movies", "/a.txt", "/b.txt"}},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			var got []string
+			err := walk(context.Background(), tree.list, walkDir{id: "0", path: "/"}, tc.opts, 0, func(entry models.WalkEntry) error {
+				got = append(got, entry.Path)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(got)
+			if len(got) != len(tc.want) {
+				t.Fatalf("paths = %v, want %v", got, tc.want)
+			}
+			for index := range got {
+				if got[index] != tc.want[index] {
+					t.Fatalf("paths = %v, want %v", got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func TestWalkStopsOnListError(t *testing.T) {
+	tree := fakeTree{"0": {fakeDir(10, "Missing")}}
+	err := walk(context.Background(), tree.list, walkDir{id: "0", path: "/"}, WalkOptions{}, 0, func(models.WalkEntry) error { return nil })
+	if !errors.Is(err, driver.ErrNotExist) {
+		t.Fatalf("err = %v, want ErrNotExist", err)
+	}
+}
@@ -0,0 +1,261 @@
+// Package torrent reads BitTorrent v1 metainfo files
+package torrent
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// maxDepth bounds the nesting of bencoded values; real metainfo needs four
+const maxDepth = 32
+
+var (
+	// ErrInvalid is returned for data that is not a usable v1 metainfo file
+	ErrInvalid = errors.New("invalid torrent file")
+	// ErrSelection is returned for file indexes that name no downloadable file
+	ErrSelection = errors.New("invalid torrent file selection")
+)
+
+// File is one file of a torrent, in metainfo order
+type File struct {
+	Index int    `json:"index"`
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	// Padding marks BEP 47 alignment files, which clients never save
+	Padding bool `json:"padding,omitempty"`
+}
+
+// MetaInfo is the part of a metainfo file needed to add and preview a task
+type MetaInfo struct {
+	// InfoHash is the lowercase hex SHA-1 of the bencoded info dictionary
+	InfoHash string `json:"info_hash"`
+	Name     string `json:"name"`
+	Size     int64  `json:"size"`
+	Files    []File `json:"files"`
+}
+
+// Parse decodes a metainfo file. Paths of multi-file torrents are relative to
+// the torrent name and use forward slashes.
+func Parse(data []byte) (*MetaInfo, error) {
+	d := decoder{data: data}
+	value, err := d.value(0)
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(data) {
+		return nil, fmt.Errorf("%w: trailing data after metainfo", ErrInvalid)
+	}
+	root, ok := value.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: metainfo is not a dictionary", ErrInvalid)
+	}
+	info, ok := root["info"].(map[string]any)
+	if !ok || d.info == nil {
+		return nil, fmt.Errorf("%w: missing info dictionary", ErrInvalid)
+	}
+
+	sum := sha1.Sum(d.info)
+	meta := &MetaInfo{InfoHash: hex.EncodeToString(sum[:])}
+	if meta.Name, err = pathElement(info, "name"); err != nil {
+		return nil, err
+	}
+	if length, ok := info["length"].(int64); ok {
+		if length < 0 {
+			return nil, fmt.Errorf("%w: negative file length", ErrInvalid)
+		}
+		meta.Size = length
+		meta.Files = []File{{Path: meta.Name, Size: length}}
+		return meta, nil
+	}
+	files, ok := info["files"].([]any)
+	if !ok {
+		if _, v2 := info["file tree"]; v2 {
+			return nil, fmt.Errorf("%w: BitTorrent v2-only torrents are not supported", ErrInvalid)
+		}
+		return nil, fmt.Errorf("%w: info has neither length nor files", ErrInvalid)
+	}
+	for i, entry := range files {
+		file, err := parseFile(entry)
+		if err != nil {
+			return nil, fmt.Errorf("file %d: %w", i, err)
+		}
+		file.Index = i
+		meta.Size += file.Size
+		meta.Files = append(meta.Files, file)
+	}
+	if len(meta.Files) == 0 {
+		return nil, fmt.Errorf("%w: torrent has no files", ErrInvalid)
+	}
+	return meta, nil
+}
+
+// Select returns the sorted, de-duplicated indexes of the files to download.
+// No indexes selects every file but padding.
+func (m *MetaInfo) Select(indexes []int) ([]int, error) {
+	selected := make([]bool, len(m.Files))
+	for _, index := range indexes {
+		if index < 0 || index >= len(m.Files) {
+			return nil, fmt.Errorf("%w: no file %d", ErrSelection, index)
+		}
+		if m.Files[index].Padding {
+			return nil, fmt.Errorf("%w: file %d is padding", ErrSelection, index)
+		}
+		selected[index] = true
+	}
+	var result []int
+	for i, file := range m.Files {
+		if selected[i] || (len(indexes) == 0 && !file.Padding) {
+			result = append(result, i)
+		}
+	}
+	return result, nil
+}
+
+func parseFile(entry any) (File, error) {
+	dict, ok := entry.(map[string]any)
+	if !ok {
+		return File{}, fmt.Errorf("%w: file entry is not a dictionary", ErrInvalid)
+	}
+	length, ok := dict["length"].(int64)
+	if !ok || length < 0 {
+		return File{}, fmt.Errorf("%w: missing or negative file length", ErrInvalid)
+	}
+	list, ok := dict["path.utf-8"].([]any)
+	if !ok {
+		list, ok = dict["path"].([]any)
+	}
+	if !ok || len(list) == 0 {
+		return File{}, fmt.Errorf("%w: missing file path", ErrInvalid)
+	}
+	parts := make([]string, len(list))
+	for i, part := range list {
+		name, ok := part.(string)
+		if !ok || !validElement(name) {
+			return File{}, fmt.Errorf("%w: invalid file path element %q", ErrInvalid, fmt.Sprint(part))
+		}
+		parts[i] = strings.ToValidUTF8(name, "\uFFFD")
+	}
+	attr, _ := dict["attr"].(string)
+	return File{
+		Path:    path.Join(parts...),
+		Size:    length,
+		Padding: strings.Contains(attr, "p"),
+	}, nil
+}
+
+// pathElement reads a name key, preferring its .utf-8 variant. Names in
+// legacy encodings such as GBK are kept, with invalid bytes replaced.
+func pathElement(dict map[string]any, key string) (string, error) {
+	name, ok := dict[key+".utf-8"].(string)
+	if !ok {
+		name, ok = dict[key].(string)
+	}
+	if !ok || !validElement(name) {
+		return "", fmt.Errorf("%w: invalid %s", ErrInvalid, key)
+	}
+	return strings.ToValidUTF8(name, "\uFFFD"), nil
+}
+
+func validElement(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, "/\x00")
+}
+
+// decoder reads bencoded values and remembers the raw bytes of the top-level
+// info dictionary, whose hash identifies the torrent
+type decoder struct {
+	data []byte
+	pos  int
+	info []byte
+}
+
+func (d *decoder) value(depth int) (any, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("%w: values nested too deeply", ErrInvalid)
+	}
+	if d.pos >= len(d.data) {
+		return nil, fmt.Errorf("%w: unexpected end of data", ErrInvalid)
+	}
+	switch c := d.data[d.pos]; {
+	case c == 'i':
+		d.pos++
+		return d.integer('e')
+	case c == 'l':
+		d.pos++
+		var list []any
+		for d.pos < len(d.data) && d.data[d.pos] != 'e' {
+			item, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, item)
+		}
+		return list, d.end()
+	case c == 'd':
+		d.pos++
+		dict := make(map[string]any)
+		for d.pos < len(d.data) && d.data[d.pos] != 'e' {
+			key, err := d.str()
+			if err != nil {
+				return nil, err
+			}
+			start := d.pos
+			item, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if depth == 0 && key == "info" {
+				d.info = d.data[start:d.pos]
+			}
+			dict[key] = item
+		}
+		return dict, d.end()
+	case c >= '0' && c <= '9':
+		return d.str()
+	default:
+		return nil, fmt.Errorf("%w: unexpected byte %q at %d", ErrInvalid, c, d.pos)
+	}
+}
+
+func (d *decoder) end() error {
+	if d.pos >= len(d.data) {
+		return fmt.Errorf("%w: unexpected end of data", ErrInvalid)
+	}
+	d.pos++
+	return nil
+}
+
+func (d *decoder) str() (string, error) {
+	if d.pos >= len(d.data) || d.data[d.pos] < '0' || d.data[d.pos] > '9' {
+		return "", fmt.Errorf("%w: expected string at %d", ErrInvalid, d.pos)
+	}
+	length, err := d.integer(':')
+	if err != nil {
+		return "", err
+	}
+	if length < 0 || length > int64(len(d.data)-d.pos) {
+		return "", fmt.Errorf("%w: string length out of range", ErrInvalid)
+	}
+	s := string(d.data[d.pos : d.pos+int(length)])
+	d.pos += int(length)
+	return s, nil
+}
+
+func (d *decoder) integer(terminator byte) (int64, error) {
+	end := bytes.IndexByte(d.data[d.pos:], terminator)
+	if end < 0 {
+		return 0, fmt.Errorf("%w: unterminated integer", ErrInvalid)
+	}
+	text := string(d.data[d.pos : d.pos+end])
+	n, err := strconv.ParseInt(text, 10, 64)
+	if err != nil || text == "-0" || (len(text) > 1 && (text[0] == '0' || strings.HasPrefix(text, "-0"))) {
+		return 0, fmt.Errorf("%w: invalid integer %q", ErrInvalid, text)
+	}
+	d.pos += end + 1
+	return n, nil
+}
@@ -0,0 +1,69 @@
+package torrent
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+func TestParseMultiFile(t *testing.T) {
+	info := "d5:filesld6:lengthi1000e4:pathl6:Sample10:sample.mkveed4:attr1:p6:lengthi24e4:pathl4:.pad2:24eed6:lengthi5000e4:pathl9:movie.mkveee4:name5:Movie12:piece lengthi16384e6:pieces0:e"
+	data := []byte("d8:announce9:udp://x/a4:info" + info + "e")
+	meta, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha1.Sum([]byte(info))
+	if meta.InfoHash != hex.EncodeToString(sum[:]) {
+		t.Fatalf("info hash = %s", meta.InfoHash)
+	}
+	if meta.Name != "Movie" || meta.Size != 6024 || len(meta.Files) != 3 {
+		t.Fatalf("meta = %+v", meta)
+	}
+	if f := meta.Files[0]; f.Index != 0 || f.Path != "Sample/sample.mkv" || f.Size != 1000 || f.Padding {
+		t.Fatalf("files[0] = %+v", f)
+	}
+	if !meta.Files[1].Padding || meta.Files[2].Path != "movie.mkv" || meta.Files[2].Index != 2 {
+		t.Fatalf("files = %+v", meta.Files)
+	}
+	if selected, err := meta.Select(nil); err != nil || len(selected) != 2 || selected[0] != 0 || selected[1] != 2 {
+		t.Fatalf("default selection = %v, %v", selected, err)
+	}
+	if selected, err := meta.Select([]int{2, 2}); err != nil || len(selected) != 1 || selected[0] != 2 {
+		t.Fatalf("selection = %v, %v", selected, err)
+	}
+	for _, indexes := range [][]int{{1}, {3}, {-1}} {
+		if _, err := meta.Select(indexes); !errors.Is(err, ErrSelection) {
+			t.Errorf("Select(%v) err = %v", indexes, err)
+		}
+	}
+}
+
+func TestParseSingleFile(t *testing.T) {
+	meta, err := Parse([]byte("d4:infod6:lengthi42e4:name5:a.iso12:piece lengthi16384e6:pieces0:ee"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if meta.Size != 42 || len(meta.Files) != 1 || meta.Files[0].Path != "a.iso" {
+		t.Fatalf("meta = %+v", meta)
+	}
+}
+
+func TestParseRejectsInvalid(t *testing.T) {
+	for name, data := range map[string]string{
+		"empty":          "",
+		"not a dict":     "li1ee",
+		"no info":        "d3:fooi1ee",
+		"truncated":      "d4:infod6:lengthi42e",
+		"leading zero":   "d4:infod6:lengthi042e4:name1:aee",
+		"traversal":      "d4:infod5:filesld6:lengthi1e4:pathl2:..1:aeee4:name1:aee",
+		"long string":    "d4:info99:x",
+		"trailing bytes": "d4:infod6:lengthi1e4:name1:aeeX",
+		"v2 only":        "d4:infod9:file treede4:name1:a12:meta versioni2eee",
+	} {
+		if _, err := Parse([]byte(data)); !errors.Is(err, ErrInvalid) {
+			t.Errorf("%s: err = %v", name, err)
+		}
+	}
+}
@@ -107,6 +107,10 @@ func (v *Validator) getErrorMessage(fe validator.FieldError) string {
 	switch fe.Tag() {
 	case "required":
 		return fmt.Sprintf("%s is required", fe.Field())
+	case "required_without":
+		return fmt.Sprintf("%s is required when %s is not provided", fe.Field(), strings.ToLower(fe.Param()))
+	case "excluded_with":
+		return fmt.Sprintf("%s cannot be combined with %s", fe.Field(), strings.ToLower(fe.Param()))
 	case "min":
 		return fmt.Sprintf("%s must be at least %s characters long", fe.Field(), fe.Param())
 	case "max":
@@ -45,6 +45,17 @@ func TestDrive115CredentialsValidation(t *testing.T) {
 			expectError: true,
 			errorCount:  1,
 		},
+		{
+			name:        "Account handle only",
+			credentials: models.Drive115Credentials{Account: "acct_Zm9vYmFy"},
+			expectError: false,
+		},
+		{
+			name:        "Account handle with spaces (invalid)",
+			credentials: models.Drive115Credentials{Account: "acct Zm9v"},
+			expectError: true,
+			errorCount:  1,
+		},
 		{
 			name:        "All fields empty",
 			credentials: models.Drive115Credentials{},
@@ -276,3 +287,118 @@ func TestFileInfoRequestValidation(t *testing.T) {
 		})
 	}
 }
+
+func TestSearchRequestValidation(t *testing.T) {
+	validator := New()
+
+	validCredentials := models.Drive115Credentials{
+		UID:  "12345",
+		CID:  "abcdef",
+		SEID: "67890",
+		KID:  "xyz123",
+	}
+
+	tests := []struct {
+		name        string
+		request     models.SearchRequest
+		expectError bool
+	}{
+		{name: "Keyword", request: models.SearchRequest{Credentials: validCredentials, Keyword: "movie"}},
+		{name: "Type only", request: models.SearchRequest{Credentials: validCredentials, Type: "video"}},
+		{name: "Starred only", request: models.SearchRequest{Credentials: validCredentials, Star: true}},
+		{name: "No filter", request: models.SearchRequest{Credentials: validCredentials}, expectError: true},
+		{name: "Unknown type", request: models.SearchRequest{Credentials: validCredentials, Type: "movie"}, expectError: true},
+		{name: "Bad date", request: models.SearchRequest{Credentials: validCredentials, Date: "2024/01/01"}, expectError: true},
+		{name: "Limit too high", request: models.SearchRequest{Credentials: validCredentials, Keyword: "a", Limit: 101}, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.ValidateStruct(tt.request)
+
+			if tt.expectError && err == nil {
+				t.Errorf("Expected validation error, but got none")
+			} else if !tt.expectError && err != nil {
+				t.Errorf("Expected no validation error, but got: %v", err)
+			}
+		})
+	}
+}
+
+func TestRecycleBinPurgeRequestValidation(t *testing.T) {
+	validator := New()
+
+	validCredentials := models.Drive115Credentials{
+		UID:  "12345",
+		CID:  "abcdef",
+		SEID: "67890",
+		KID:  "xyz123",
+	}
+
+	tests := []struct {
+		name        string
+		request     models.RecycleBinPurgeRequest
+		expectError bool
+	}{
+		{name: "Selected items", request: models.RecycleBinPurgeRequest{Credentials: validCredentials, Password: "123456", IDs: []string{"1", "2"}}},
+		{name: "Whole bin", request: models.RecycleBinPurgeRequest{Credentials: validCredentials, Password: "123456", All: true}},
+		{name: "Neither ids nor all", request: models.RecycleBinPurgeRequest{Credentials: validCredentials, Password: "123456"}, expectError: true},
+		{name: "Both ids and all", request: models.RecycleBinPurgeRequest{Credentials: validCredentials, Password: "123456", IDs: []string{"1"}, All: true}, expectError: true},
+		{name: "Missing password", request: models.RecycleBinPurgeRequest{Credentials: validCredentials, All: true}, expectError: true},
+		{name: "Non-numeric id", request: models.RecycleBinPurgeRequest{Credentials: validCredentials, Password: "123456", IDs: []string{"abc"}}, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.ValidateStruct(tt.request)
+
+			if tt.expectError && err == nil {
+				t.Errorf("Expected validation error, but got none")
+			} else if !tt.expectError && err != nil {
+				t.Errorf("Expected no validation error, but got: %v", err)
+			}
+		})
+	}
+}
+
+func TestPathAddressedRequestValidation(t *testing.T) {
+	validator := New()
+
+	validCredentials := models.Drive115Credentials{
+		UID:  "12345",
+		CID:  "abcdef",
+		SEID: "67890",
+		KID:  "xyz123",
+	}
+
+	tests := []struct {
+		name        string
+		request     interface{}
+		expectError bool
+	}{
+		{name: "List by path", request: models.ListFilesRequest{Credentials: validCredentials, Path: "/Movies"}},
+		{name: "List by path and dir id", request: models.ListFilesRequest{Credentials: validCredentials, Path: "/Movies", DirID: 10}, expectError: true},
+		{name: "Move by paths", request: models.MoveFilesRequest{Credentials: validCredentials, Paths: []string{"/a.mkv"}, TargetDirPath: "/Movies"}},
+		{name: "Move without files", request: models.MoveFilesRequest{Credentials: validCredentials, TargetDirPath: "/Movies"}, expectError: true},
+		{name: "Move by ids and paths", request: models.MoveFilesRequest{Credentials: validCredentials, FileIDs: []string{"1"}, Paths: []string{"/a.mkv"}}, expectError: true},
+		{name: "Move without target", request: models.MoveFilesRequest{Credentials: validCredentials, FileIDs: []string{"1"}}, expectError: true},
+		{name: "Copy to root", request: models.CopyFilesRequest{Credentials: validCredentials, FileIDs: []string{"1"}, TargetDirID: "0"}},
+		{name: "Move to dir id and path", request: models.MoveFilesRequest{Credentials: validCredentials, FileIDs: []string{"1"}, TargetDirID: "2", TargetDirPath: "/Movies"}, expectError: true},
+		{name: "Delete empty path", request: models.DeleteFilesRequest{Credentials: validCredentials, Paths: []string{""}}, expectError: true},
+		{name: "Rename by path", request: models.RenameFilesRequest{Credentials: validCredentials, Files: []models.RenameFileItem{{Path: "/a.mkv", Name: "b.mkv"}}}},
+		{name: "Rename by id and path", request: models.RenameFilesRequest{Credentials: validCredentials, Files: []models.RenameFileItem{{FileID: "1", Path: "/a.mkv", Name: "b.mkv"}}}, expectError: true},
+		{name: "Offline save dir id and path", request: models.OfflineDownloadRequest{Credentials: validCredentials, URLs: []string{"magnet:?xt=urn:btih:abc"}, SaveDirID: "1", SaveDirPath: "/Downloads"}, expectError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validator.ValidateStruct(tt.request)
+
+			if tt.expectError && err == nil {
+				t.Errorf("Expected validation error, but got none")
+			} else if !tt.expectError && err != nil {
+				t.Errorf("Expected no validation error, but got: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,30 @@
+package webhooks
+
+import "cloud-driver/internal/models"
+
+// Record is a webhook as persisted, with its signing secret, owner and the
+// credentials its offline tasks are polled with
+type Record struct {
+	Webhook     Webhook                    `json:"webhook"`
+	Secret      string                     `json:"secret"`
+	Owner       string                     `json:"owner"`
+	Credentials models.Drive115Credentials `json:"credentials"`
+}
+
+// RecordID keys the record by its webhook ID
+func (r Record) RecordID() string {
+	return r.Webhook.ID
+}
+
+// PendingDelivery is a delivery the webhook has neither acknowledged nor
+// failed yet, kept with its body so retries survive a restart
+type PendingDelivery struct {
+	WebhookID string   `json:"webhook_id"`
+	Delivery  Delivery `json:"delivery"`
+	Body      []byte   `json:"body"`
+}
+
+// RecordID keys the pending delivery by its delivery ID
+func (p PendingDelivery) RecordID() string {
+	return p.Delivery.ID
+}
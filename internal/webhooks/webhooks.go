@@ -0,0 +1,493 @@
+// Package webhooks notifies HTTP endpoints when offline download tasks finish.
+// Every account with webhooks is followed on the shared offline task watcher;
+// finished and failed tasks are POSTed as HMAC-signed JSON and retried with
+// exponential backoff.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"cloud-driver/internal/models"
+	"cloud-driver/internal/netguard"
+	"cloud-driver/internal/offline"
+	"cloud-driver/internal/recordstore"
+)
+
+// Events a webhook can subscribe to
+const (
+	EventDone   = offline.EventDone
+	EventFailed = offline.EventFailed
+)
+
+// Headers sent with every delivery. The signature header reads
+// "t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">".
+const (
+	HeaderEvent     = "X-Cloud-Driver-Event"
+	HeaderDelivery  = "X-Cloud-Driver-Delivery"
+	HeaderSignature = "X-Cloud-Driver-Signature"
+)
+
+// Delivery states
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+const (
+	maxWebhooksPerAccount = 20
+	// maxConcurrentDeliveries bounds the requests in flight across all webhooks
+	maxConcurrentDeliveries = 8
+)
+
+var (
+	// ErrNotFound is returned for unknown webhooks and webhooks of other accounts
+	ErrNotFound = errors.New("webhook not found")
+	// ErrInvalidURL is returned for webhook URLs that are not absolute http(s) URLs
+	ErrInvalidURL = errors.New("webhook url must be an absolute http or https URL")
+	// ErrLimit is returned when an account already has the maximum number of webhooks
+	ErrLimit = fmt.Errorf("an account can have at most %d webhooks", maxWebhooksPerAccount)
+	// ErrAddressNotAllowed is returned when a delivery would reach a
+	// non-public address while private networks are not allowed
+	ErrAddressNotAllowed = errors.New("webhook address is not public")
+)
+
+// Webhook is the client-facing view of a subscription. The secret is never
+// returned.
+type Webhook struct {
+	ID           string    `json:"id"`
+	URL          string    `json:"url"`
+	Events       []string  `json:"events"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastDelivery *Delivery `json:"last_delivery,omitempty"`
+}
+
+// Delivery is the outcome of the latest notification sent to a webhook.
+// Pending deliveries are stored until they succeed or fail, so a restart
+// resumes them; the outcome is kept in memory only.
+type Delivery struct {
+	ID             string    `json:"id"`
+	Event          string    `json:"event"`
+	InfoHash       string    `json:"info_hash"`
+	Status         string    `json:"status"`
+	Attempts       int       `json:"attempts"`
+	ResponseStatus int       `json:"response_status,omitempty"`
+	Error          string    `json:"error,omitempty"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// Payload is the JSON body of a delivery
+type Payload struct {
+	ID        string             `json:"id"`
+	Event     string             `json:"event"`
+	WebhookID string             `json:"webhook_id"`
+	Time      time.Time          `json:"time"`
+	Task      models.OfflineTask `json:"task"`
+}
+
+// Options tunes deliveries. Zero values take the defaults noted per field.
+type Options struct {
+	// MaxAttempts is the number of tries per delivery (8)
+	MaxAttempts int
+	// RetryDelay is the wait before the second try, doubling after each
+	// failure (10s)
+	RetryDelay time.Duration
+	// MaxRetryDelay caps the wait between tries (10m)
+	MaxRetryDelay time.Duration
+	// Timeout bounds one try (10s)
+	Timeout time.Duration
+	// AllowPrivateNetworks lets deliveries reach loopback and private addresses
+	AllowPrivateNetworks bool
+}
+
+// Manager owns the webhooks, the account subscriptions that feed them and the
+// deliveries in flight
+type Manager struct {
+	store      recordstore.Store[Record]
+	deliveries recordstore.Store[PendingDelivery]
+	followers  *offline.Followers
+	options    Options
+	client     *http.Client
+	now        func() time.Time
+	slots      chan struct{}
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	records map[string]Record
+	last    map[string]Delivery
+	// pending holds the stored deliveries until Start resumes them
+	pending []PendingDelivery
+}
+
+// NewManager loads the stored webhooks and pending deliveries. Call Start to
+// begin watching.
+func NewManager(store recordstore.Store[Record], deliveries recordstore.Store[PendingDelivery], snapshots recordstore.Store[offline.Snapshot], watcher *offline.Watcher, options Options) (*Manager, error) {
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = 8
+	}
+	if options.RetryDelay <= 0 {
+		options.RetryDelay = 10 * time.Second
+	}
+	if options.MaxRetryDelay <= 0 {
+		options.MaxRetryDelay = 10 * time.Minute
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = 10 * time.Second
+	}
+	records, err := store.Load(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("load webhooks: %w", err)
+	}
+	pending, err := deliveries.Load(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("load webhook deliveries: %w", err)
+	}
+
+	dialer := &net.Dialer{Timeout: options.Timeout, KeepAlive: 30 * time.Second}
+	if !options.AllowPrivateNetworks {
+		dialer.Control = netguard.PublicOnly(ErrAddressNotAllowed)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m := &Manager{
+		store:      store,
+		deliveries: deliveries,
+		options:    options,
+		client: &http.Client{
+			// No proxy: the address check must see the real destination
+			Transport: &http.Transport{DialContext: dialer.DialContext, ForceAttemptHTTP2: true, TLSHandshakeTimeout: options.Timeout},
+			// A redirect could lead past the URL the subscriber registered
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		now:     time.Now,
+		slots:   make(chan struct{}, maxConcurrentDeliveries),
+		ctx:     ctx,
+		cancel:  cancel,
+		records: make(map[string]Record, len(records)),
+		last:    map[string]Delivery{},
+		pending: pending,
+	}
+	m.followers = offline.NewFollowers(watcher, snapshots, func(_ context.Context, owner string, _ models.Drive115Credentials, event offline.Event) {
+		m.dispatch(owner, event)
+	})
+	for _, record := range records {
+		m.records[record.Webhook.ID] = record
+		m.followers.Add(record.Credentials)
+	}
+	return m, nil
+}
+
+// Start resumes the stored deliveries and subscribes to the offline tasks of
+// every account with webhooks
+func (m *Manager) Start() {
+	m.mu.Lock()
+	pending := m.pending
+	m.pending = nil
+	for _, stored := range pending {
+		if _, ok := m.records[stored.WebhookID]; !ok {
+			m.forget(stored.Delivery.ID)
+			continue
+		}
+		if last, ok := m.last[stored.WebhookID]; !ok || last.UpdatedAt.Before(stored.Delivery.UpdatedAt) {
+			m.last[stored.WebhookID] = stored.Delivery
+		}
+		m.wg.Add(1)
+		go m.deliver(stored)
+	}
+	m.mu.Unlock()
+	m.followers.Start()
+}
+
+// Stop ends the subscriptions and abandons pending retries, waiting for the
+// requests in flight before closing the store
+func (m *Manager) Stop(ctx context.Context) error {
+	m.cancel()
+	done := make(chan struct{})
+	var err error
+	go func() {
+		err = m.followers.Stop()
+		m.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return errors.Join(err, m.deliveries.Close(), m.store.Close())
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Create registers a webhook for the offline tasks of credentials. events
+// defaults to both done and failed.
+func (m *Manager) Create(ctx context.Context, credentials models.Drive115Credentials, rawURL, secret string, events []string) (Webhook, error) {
+	target, err := url.Parse(rawURL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return Webhook{}, ErrInvalidURL
+	}
+	if len(events) == 0 {
+		events = []string{EventDone, EventFailed}
+	}
+	events = slices.Compact(slices.Sorted(slices.Values(events)))
+	for _, event := range events {
+		if event != EventDone && event != EventFailed {
+			return Webhook{}, fmt.Errorf("unknown webhook event %q", event)
+		}
+	}
+	id, err := recordstore.NewID("wh_")
+	if err != nil {
+		return Webhook{}, err
+	}
+
+	credentials.Account = ""
+	record := Record{
+		Webhook:     Webhook{ID: id, URL: target.String(), Events: events, CreatedAt: m.now().UTC()},
+		Secret:      secret,
+		Owner:       credentials.Key(),
+		Credentials: credentials,
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.owned(record.Owner)) >= maxWebhooksPerAccount {
+		return Webhook{}, ErrLimit
+	}
+	if err := m.store.Save(ctx, record); err != nil {
+		return Webhook{}, err
+	}
+	m.records[id] = record
+	m.followers.Add(credentials)
+	return record.Webhook, nil
+}
+
+// List returns the webhooks of credentials, oldest first
+func (m *Manager) List(credentials models.Drive115Credentials) []Webhook {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	records := m.owned(credentials.Key())
+	webhooks := make([]Webhook, 0, len(records))
+	for _, record := range records {
+		webhook := record.Webhook
+		if delivery, ok := m.last[webhook.ID]; ok {
+			webhook.LastDelivery = &delivery
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	sort.Slice(webhooks, func(i, j int) bool { return webhooks[i].CreatedAt.Before(webhooks[j].CreatedAt) })
+	return webhooks
+}
+
+// Delete removes a webhook of credentials. Retries still pending for it are
+// dropped, and the account is no longer polled once its last webhook is gone.
+func (m *Manager) Delete(ctx context.Context, credentials models.Drive115Credentials, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	record, ok := m.records[id]
+	if !ok || record.Owner != credentials.Key() {
+		return ErrNotFound
+	}
+	if err := m.store.Delete(ctx, id); err != nil {
+		return err
+	}
+	delete(m.records, id)
+	delete(m.last, id)
+	if err := m.followers.Remove(ctx, record.Owner); err != nil {
+		log.Printf("webhook %s: %v", id, err)
+	}
+	return nil
+}
+
+// owned returns the records of owner; callers hold m.mu
+func (m *Manager) owned(owner string) []Record {
+	var records []Record
+	for _, record := range m.records {
+		if record.Owner == owner {
+			records = append(records, record)
+		}
+	}
+	return records
+}
+
+// dispatch queues a delivery to every webhook of owner that wants the event
+func (m *Manager) dispatch(owner string, event offline.Event) {
+	if event.Type != EventDone && event.Type != EventFailed {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.ctx.Err() != nil {
+		return
+	}
+	for _, record := range m.owned(owner) {
+		if !slices.Contains(record.Webhook.Events, event.Type) {
+			continue
+		}
+		id, err := recordstore.NewID("dlv_")
+		if err != nil {
+			log.Printf("webhook %s: %v", record.Webhook.ID, err)
+			continue
+		}
+		body, err := json.Marshal(Payload{ID: id, Event: event.Type, WebhookID: record.Webhook.ID, Time: event.Time, Task: *event.Task})
+		if err != nil {
+			log.Printf("webhook %s: %v", record.Webhook.ID, err)
+			continue
+		}
+		pending := PendingDelivery{
+			WebhookID: record.Webhook.ID,
+			Delivery:  Delivery{ID: id, Event: event.Type, InfoHash: event.Task.InfoHash, Status: DeliveryPending, UpdatedAt: m.now().UTC()},
+			Body:      body,
+		}
+		if err := m.deliveries.Save(m.ctx, pending); err != nil {
+			// Still sent, but not resumed after a restart
+			log.Printf("webhook %s: save delivery %s: %v", record.Webhook.ID, id, err)
+		}
+		m.last[record.Webhook.ID] = pending.Delivery
+		m.wg.Add(1)
+		go m.deliver(pending)
+	}
+}
+
+// deliver sends a pending delivery until the webhook accepts it, the error is
+// permanent, the attempts run out or the webhook is deleted. Stopping leaves
+// it stored for the next start.
+func (m *Manager) deliver(pending PendingDelivery) {
+	defer m.wg.Done()
+	webhookID, delivery, body := pending.WebhookID, pending.Delivery, pending.Body
+	for attempt := delivery.Attempts + 1; ; attempt++ {
+		select {
+		case m.slots <- struct{}{}:
+		case <-m.ctx.Done():
+			return
+		}
+		m.mu.Lock()
+		record, ok := m.records[webhookID]
+		m.mu.Unlock()
+		if !ok {
+			<-m.slots
+			m.forget(delivery.ID)
+			return
+		}
+		status, err := m.post(record, delivery, body)
+		<-m.slots
+		if m.ctx.Err() != nil {
+			return
+		}
+
+		delivery.Attempts, delivery.ResponseStatus, delivery.UpdatedAt = attempt, status, m.now().UTC()
+		delivery.Error = ""
+		switch {
+		case err == nil:
+			delivery.Status = DeliveryDelivered
+		case retryable(status) && !errors.Is(err, ErrAddressNotAllowed) && attempt < m.options.MaxAttempts:
+			delivery.Error = err.Error()
+		default:
+			delivery.Status, delivery.Error = DeliveryFailed, err.Error()
+			log.Printf("webhook %s: delivery %s failed after %d attempts: %v", webhookID, delivery.ID, attempt, err)
+		}
+		m.record(webhookID, delivery)
+		if delivery.Status != DeliveryPending {
+			m.forget(delivery.ID)
+			return
+		}
+		pending.Delivery = delivery
+		if err := m.deliveries.Save(m.ctx, pending); err != nil {
+			log.Printf("webhook %s: save delivery %s: %v", webhookID, delivery.ID, err)
+		}
+
+		timer := time.NewTimer(m.retryDelay(attempt))
+		select {
+		case <-m.ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+// record keeps delivery as the webhook's latest unless a newer delivery
+// started since
+func (m *Manager) record(webhookID string, delivery Delivery) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if last, ok := m.last[webhookID]; ok && last.ID == delivery.ID {
+		m.last[webhookID] = delivery
+	}
+}
+
+// forget removes a delivery that will not be tried again from the store
+func (m *Manager) forget(deliveryID string) {
+	if err := m.deliveries.Delete(context.Background(), deliveryID); err != nil {
+		log.Printf("webhook delivery %s: %v", deliveryID, err)
+	}
+}
+
+// post makes one delivery attempt and returns the response status, or 0 when
+// no response arrived
+func (m *Manager) post(record Record, delivery Delivery, body []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(m.ctx, m.options.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, record.Webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(m.now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "cloud-driver-webhooks/1")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, delivery.ID)
+	req.Header.Set(HeaderSignature, "t="+timestamp+",v1="+Sign(record.Secret, timestamp, body))
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+func (m *Manager) retryDelay(attempt int) time.Duration {
+	delay := m.options.RetryDelay
+	for i := 1; i < attempt && delay < m.options.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, m.options.MaxRetryDelay)
+}
+
+// retryable reports whether a failed attempt may succeed later. Network
+// errors (status 0), timeouts, rate limits and server errors are retried;
+// other responses mean the endpoint rejected the delivery.
+func retryable(status int) bool {
+	return status == 0 || status == http.StatusRequestTimeout || status == http.StatusTooEarly ||
+		status == http.StatusTooManyRequests || status >= 500
+}
+
+// Sign returns the hex HMAC-SHA256 of "<timestamp>.<body>" under secret, as
+// sent in the v1 part of the signature header
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
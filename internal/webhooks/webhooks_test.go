@@ -0,0 +1,198 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud-driver/internal/models"
+	"cloud-driver/internal/offline"
+	"cloud-driver/internal/recordstore"
+)
+
+type fakeLister struct {
+	mu    sync.Mutex
+	tasks []models.OfflineTask
+}
+
+func (f *fakeLister) ListAllOfflineTasks(context.Context, models.Drive115Credentials) ([]models.OfflineTask, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]models.OfflineTask(nil), f.tasks...), nil
+}
+
+func (f *fakeLister) set(tasks ...models.OfflineTask) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.tasks = tasks
+}
+
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+func TestManagerDeliversSignedPayloadWithRetry(t *testing.T) {
+	deliveries := make(chan received, 4)
+	var mu sync.Mutex
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		calls++
+		first := calls == 1
+		mu.Unlock()
+		if first {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		deliveries <- received{header: r.Header.Clone(), body: body}
+	}))
+	defer server.Close()
+
+	lister := &fakeLister{}
+	lister.set(models.OfflineTask{InfoHash: "a", Name: "movie", Status: "running"})
+	watcher := offline.NewWatcher(lister, offline.Options{
+		MinInterval: time.Millisecond, ActiveInterval: time.Millisecond, MaxInterval: time.Millisecond,
+	})
+	defer watcher.Close()
+	manager, err := NewManager(recordstore.NewMemoryStore[Record](), recordstore.NewMemoryStore[PendingDelivery](), recordstore.NewMemoryStore[offline.Snapshot](), watcher, Options{RetryDelay: time.Millisecond, AllowPrivateNetworks: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.Start()
+	defer manager.Stop(context.Background())
+
+	credentials := models.Drive115Credentials{UID: "uid", CID: "cid", SEID: "seid", KID: "kid"}
+	webhook, err := manager.Create(context.Background(), credentials, server.URL+"/hook", "0123456789abcdef", []string{EventDone})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Let the first snapshot land before the task finishes, so completion
+	// arrives as a change rather than as part of the initial state
+	time.Sleep(50 * time.Millisecond)
+	lister.set(models.OfflineTask{InfoHash: "a", Name: "movie", Size: 42, Status: "done", Percent: 100, FileID: "7", DirID: "9"})
+
+	var got received
+	select {
+	case got = <-deliveries:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no delivery")
+	}
+	signature := got.header.Get(HeaderSignature)
+	timestamp, mac, ok := strings.Cut(strings.TrimPrefix(signature, "t="), ",v1=")
+	if !ok || mac != Sign("0123456789abcdef", timestamp, got.body) {
+		t.Fatalf("signature %q does not verify", signature)
+	}
+	var payload Payload
+	if err := json.Unmarshal(got.body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Event != EventDone || payload.WebhookID != webhook.ID || payload.Task.FileID != "7" || payload.Task.DirID != "9" || payload.Task.Size != 42 {
+		t.Fatalf("payload = %+v", payload)
+	}
+	if got.header.Get(HeaderDelivery) != payload.ID {
+		t.Fatalf("delivery header %q, payload id %q", got.header.Get(HeaderDelivery), payload.ID)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		list := manager.List(credentials)
+		if len(list) == 1 && list[0].LastDelivery != nil && list[0].LastDelivery.Status == DeliveryDelivered {
+			if list[0].LastDelivery.Attempts != 2 {
+				t.Fatalf("attempts = %d, want 2", list[0].LastDelivery.Attempts)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("last delivery = %+v", list)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	other := models.Drive115Credentials{UID: "other", CID: "cid", SEID: "seid", KID: "kid"}
+	if err := manager.Delete(context.Background(), other, webhook.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("delete by another account: %v", err)
+	}
+	if err := manager.Delete(context.Background(), credentials, webhook.ID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestManagerRefusesPrivateAddresses(t *testing.T) {
+	manager, err := NewManager(recordstore.NewMemoryStore[Record](), recordstore.NewMemoryStore[PendingDelivery](), recordstore.NewMemoryStore[offline.Snapshot](), offline.NewWatcher(&fakeLister{}, offline.Options{}), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manager.Create(context.Background(), models.Drive115Credentials{}, "ftp://example.com/hook", "0123456789abcdef", nil); !errors.Is(err, ErrInvalidURL) {
+		t.Fatalf("ftp URL accepted: %v", err)
+	}
+}
+
+func TestManagerResumesStoredDeliveries(t *testing.T) {
+	deliveries := make(chan received, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		deliveries <- received{header: r.Header.Clone(), body: body}
+	}))
+	defer server.Close()
+
+	// A webhook with a delivery left pending by the last run, and a delivery
+	// whose webhook is gone
+	credentials := models.Drive115Credentials{UID: "uid", CID: "cid", SEID: "seid", KID: "kid"}
+	store := recordstore.NewMemoryStore[Record]()
+	pending := recordstore.NewMemoryStore[PendingDelivery]()
+	ctx := context.Background()
+	record := Record{Webhook: Webhook{ID: "wh_1", URL: server.URL, Events: []string{EventDone}}, Secret: "0123456789abcdef", Owner: credentials.Key(), Credentials: credentials}
+	if err := store.Save(ctx, record); err != nil {
+		t.Fatal(err)
+	}
+	for _, stored := range []PendingDelivery{
+		{WebhookID: "wh_1", Delivery: Delivery{ID: "dlv_1", Event: EventDone, Status: DeliveryPending, Attempts: 1}, Body: []byte(`{"id":"dlv_1"}`)},
+		{WebhookID: "wh_gone", Delivery: Delivery{ID: "dlv_2", Event: EventDone, Status: DeliveryPending}, Body: []byte(`{"id":"dlv_2"}`)},
+	} {
+		if err := pending.Save(ctx, stored); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	watcher := offline.NewWatcher(&fakeLister{}, offline.Options{})
+	defer watcher.Close()
+	manager, err := NewManager(store, pending, recordstore.NewMemoryStore[offline.Snapshot](), watcher, Options{AllowPrivateNetworks: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manager.Start()
+	defer manager.Stop(ctx)
+
+	select {
+	case got := <-deliveries:
+		if got.header.Get(HeaderDelivery) != "dlv_1" || string(got.body) != `{"id":"dlv_1"}` {
+			t.Fatalf("resumed delivery %q: %s", got.header.Get(HeaderDelivery), got.body)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stored delivery was not resumed")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		left, _ := pending.Load(ctx)
+		list := manager.List(credentials)
+		if len(left) == 0 && list[0].LastDelivery != nil && list[0].LastDelivery.Status == DeliveryDelivered {
+			if list[0].LastDelivery.Attempts != 2 {
+				t.Fatalf("attempts = %d, want 2", list[0].LastDelivery.Attempts)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("pending = %+v, last delivery = %+v", left, list[0].LastDelivery)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
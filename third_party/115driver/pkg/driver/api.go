@@ -28,6 +28,11 @@ const (
 	ApiFileInfo = "https://webapi.115.com/files/get_info"
 	ApiFileSearch = "https://webapi.115.com/files/search"
 
+	// label
+	ApiLabelList = "https://webapi.115.com/label/list"
+	ApiLabelAdd  = "https://webapi.115.com/label/add_multi"
+	ApiFileLabel = "https://webapi.115.com/files/batch_label"
+
 	// share
 	ApiShareSnap = "https://115cdn.com/webapi/share/snap"
 
@@ -41,6 +46,8 @@ const (
 	ApiDelOfflineUrl   = "https://lixian.115.com/lixian/?ct=lixian&ac=task_del"
 	ApiListOfflineUrl  = "https://lixian.115.com/lixian/?ct=lixian&ac=task_lists"
 	ApiClearOfflineUrl = "https://lixian.115.com/lixian/?ct=lixian&ac=task_clear"
+	ApiTorrentInfoUrl  = "https://lixian.115.com/lixian/?ct=lixian&ac=torrent"
+	ApiAddOfflineBtUrl = "https://lixian.115.com/lixianssp/?ac=add_task_bt"
 
 	// upload
 	ApiUploadInfo        = "https://proapi.115.com/app/uploadinfo"
@@ -178,3 +178,16 @@ func TestDownloadWithUA_EmptyUA_SendsNoUA(t *testing.T) {
 	}
 	assertNoUA(t, tr.wireUAs, *serverUAs)
 }
+
+// newMockAPIClient returns a client whose requests to the absolute 115 API
+// URLs reach handler instead.
+func newMockAPIClient(t *testing.T, handler http.HandlerFunc) *Pan115Client {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(WithClient(&http.Client{Transport: &recordingTransport{base: &http.Transport{}, mockURL: u}}))
+}
@@ -1,5 +1,10 @@
 package driver
 
+import (
+	"net/url"
+	"strings"
+)
+
 var (
 	LabelColors = []string{
 		// No Color
@@ -39,3 +44,64 @@ type Label struct {
 }
 
 type LabelColor int
+
+type LabelListResponse struct {
+	BasicResp
+	Data struct {
+		Total StringInt    `json:"total"`
+		List  []*LabelInfo `json:"list"`
+	} `json:"data"`
+}
+
+// ListLabels lists the file labels whose name contains keyword
+func (c *Pan115Client) ListLabels(keyword string) ([]*LabelInfo, error) {
+	result := LabelListResponse{}
+	req := c.NewRequest().
+		SetQueryParams(map[string]string{
+			"keyword": keyword,
+			"offset":  "0",
+			"limit":   "1150",
+		}).
+		SetResult(&result).
+		ForceContentType("application/json;charset=UTF-8")
+
+	resp, err := req.Get(ApiLabelList)
+	if err = CheckErr(err, &result, resp); err != nil {
+		return nil, err
+	}
+	return result.Data.List, nil
+}
+
+// AddLabel creates a file label, color is one of LabelColors
+func (c *Pan115Client) AddLabel(name, color string) error {
+	form := url.Values{}
+	form.Add("name[]", name+"\x07"+color)
+	result := BasicResp{}
+	req := c.NewRequest().
+		SetFormDataFromValues(form).
+		SetResult(&result).
+		ForceContentType("application/json;charset=UTF-8")
+
+	resp, err := req.Post(ApiLabelAdd)
+	return CheckErr(err, &result, resp)
+}
+
+// AddFileLabels adds labels to files, keeping the labels they already have
+func (c *Pan115Client) AddFileLabels(labelIDs []string, fileIDs ...string) error {
+	if len(fileIDs) == 0 || len(labelIDs) == 0 {
+		return nil
+	}
+	form := map[string]string{
+		"file_ids":   strings.Join(fileIDs, ","),
+		"file_label": strings.Join(labelIDs, ","),
+		"action":     "add",
+	}
+	result := BasicResp{}
+	req := c.NewRequest().
+		SetFormData(form).
+		SetResult(&result).
+		ForceContentType("application/json;charset=UTF-8")
+
+	resp, err := req.Post(ApiFileLabel)
+	return CheckErr(err, &result, resp)
+}
@@ -0,0 +1,63 @@
+package driver
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestListLabels(t *testing.T) {
+	client := newMockAPIClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodGet, r.Method)
+		assert.Equal(t, "/label/list", r.URL.Path)
+		query := r.URL.Query()
+		assert.Equal(t, "tv", query.Get("keyword"))
+		assert.Equal(t, "0", query.Get("offset"))
+		assert.Equal(t, "1150", query.Get("limit"))
+		_, _ = w.Write([]byte(`{"state":true,"data":{"total":"1","list":[
+			{"id":"5","name":"tv","color":"#FF0000","sort":"3","create_time":1700000000,"update_time":1700000001}
+		]}}`))
+	})
+
+	labels, err := client.ListLabels("tv")
+	require.NoError(t, err)
+	require.Len(t, labels, 1)
+	assert.Equal(t, LabelInfo{ID: "5", Name: "tv", Color: "#FF0000", Sort: 3, CreateTime: 1700000000, UpdateTime: 1700000001}, *labels[0])
+}
+
+func TestAddLabel(t *testing.T) {
+	client := newMockAPIClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/label/add_multi", r.URL.Path)
+		require.NoError(t, r.ParseForm())
+		// The name and color are joined by a BEL character
+		assert.Equal(t, []string{"tv\x07#FF0000"}, r.PostForm["name[]"])
+		_, _ = w.Write([]byte(`{"state":true}`))
+	})
+
+	require.NoError(t, client.AddLabel("tv", "#FF0000"))
+}
+
+func TestAddFileLabels(t *testing.T) {
+	requests := 0
+	client := newMockAPIClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/files/batch_label", r.URL.Path)
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "1,2", r.PostForm.Get("file_ids"))
+		assert.Equal(t, "5,6", r.PostForm.Get("file_label"))
+		assert.Equal(t, "add", r.PostForm.Get("action"))
+		_, _ = w.Write([]byte(`{"state":false,"errno":990001}`))
+	})
+
+	assert.Error(t, client.AddFileLabels([]string{"5", "6"}, "1", "2"))
+	assert.Equal(t, 1, requests)
+
+	// Nothing to label sends nothing
+	require.NoError(t, client.AddFileLabels([]string{"5"}))
+	require.NoError(t, client.AddFileLabels(nil, "1"))
+	assert.Equal(t, 1, requests)
+}
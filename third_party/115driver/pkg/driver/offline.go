@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/url"
 	"strconv"
+	"strings"
 
 	crypto "github.com/SheltonZhu/115driver/pkg/crypto/m115"
 )
@@ -103,10 +104,6 @@ func (c *Pan115Client) AddOfflineTaskURIs(uris []string, saveDirID string, opts
 		c.UserID = userInfo.UserID
 	}
 
-	key := crypto.GenerateKey()
-
-	result := DownloadResp{}
-
 	params := map[string]string{
 		"ac":         "add_task_urls",
 		"wp_path_id": saveDirID,
@@ -117,39 +114,118 @@ func (c *Pan115Client) AddOfflineTaskURIs(uris []string, saveDirID string, opts
 		key := fmt.Sprintf("url[%d]", i)
 		params[key] = uri
 	}
-	paramsBytes, err := json.Marshal(params)
-	if err != nil {
+	taskInfos := OfflineAddUrlResponse{}
+	if err := c.postOfflineEncoded(ApiAddOfflineUrl, params, &taskInfos); err != nil {
 		return nil, err
 	}
 
-	data := crypto.Encode(paramsBytes, key)
+	hashes = make([]string, count)
+	for i, task := range taskInfos.Result {
+		hashes[i] = task.InfoHash
+	}
+	return hashes, nil
+}
+
+// GetTorrentInfo reads the metainfo of a torrent file stored in the cloud,
+// identified by the sha1 of the file. 115 remembers the torrent afterwards,
+// so its info hash can be passed to AddOfflineTaskTorrent.
+func (c *Pan115Client) GetTorrentInfo(sha1 string) (*TorrentInfo, error) {
+	if isCalledByAlistV3() {
+		return nil, ErrorNotSupportAlist
+	}
+	result := TorrentInfo{}
 	req := c.NewRequest().
-		SetQueryParam("t", Now().String()).
-		SetFormData(map[string]string{"data": data}).
-		ForceContentType("application/json").
-		SetResult(&result)
+		SetFormData(map[string]string{"sha1": sha1}).
+		SetResult(&result).
+		ForceContentType("application/json;charset=UTF-8")
 
-	resp, err := req.Post(ApiAddOfflineUrl)
+	resp, err := req.Post(ApiTorrentInfoUrl)
 
 	if err := CheckErr(err, &result, resp); err != nil {
 		return nil, err
 	}
+	return &result, nil
+}
+
+// AddOfflineTaskTorrent adds an offline task for the wanted files of a torrent
+// 115 already knows, given by indexes into TorrentInfo.Files.
+func (c *Pan115Client) AddOfflineTaskTorrent(infoHash string, wanted []int, saveDirID string, opts ...OfflineOption) (string, error) {
+	if isCalledByAlistV3() {
+		return "", ErrorNotSupportAlist
+	}
+	opt := DefaultOfflineOptions()
+
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	if c.UserID <= 0 {
+		userInfo, err := c.GetUser()
+		if err != nil {
+			return "", err
+		}
+		c.UserID = userInfo.UserID
+	}
 
-	bytes, err := crypto.Decode(string(result.EncodedData), key)
+	indexes := make([]string, len(wanted))
+	for i, index := range wanted {
+		indexes[i] = strconv.Itoa(index)
+	}
+	params := map[string]string{
+		"ac":         "add_task_bt",
+		"info_hash":  infoHash,
+		"wanted":     strings.Join(indexes, ","),
+		"savepath":   "",
+		"wp_path_id": saveDirID,
+		"app_ver":    opt.appVer,
+		"uid":        strconv.FormatInt(c.UserID, 10),
+	}
+	task := OfflineAddTorrentResponse{}
+	if err := c.postOfflineEncoded(ApiAddOfflineBtUrl, params, &task); err != nil {
+		return "", err
+	}
+	if err := task.Err(); err != nil {
+		return "", err
+	}
+	return task.InfoHash, nil
+}
+
+// offlineEncode and offlineDecode are the m115 codec of the offline task
+// apis. Tests replace them, since answers cannot be encoded without 115's
+// private key.
+var (
+	offlineEncode = crypto.Encode
+	offlineDecode = crypto.Decode
+)
+
+// postOfflineEncoded posts params m115-encoded to an offline task api and
+// decodes the encoded answer into v.
+func (c *Pan115Client) postOfflineEncoded(api string, params map[string]string, v any) error {
+	paramsBytes, err := json.Marshal(params)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	taskInfos := OfflineAddUrlResponse{}
-	if err := json.Unmarshal(bytes, &taskInfos); err != nil {
-		return nil, err
+	key := crypto.GenerateKey()
+	result := DownloadResp{}
+	data := offlineEncode(paramsBytes, key)
+	req := c.NewRequest().
+		SetQueryParam("t", Now().String()).
+		SetFormData(map[string]string{"data": data}).
+		ForceContentType("application/json").
+		SetResult(&result)
+
+	resp, err := req.Post(api)
+
+	if err := CheckErr(err, &result, resp); err != nil {
+		return err
 	}
 
-	hashes = make([]string, count)
-	for i, task := range taskInfos.Result {
-		hashes[i] = task.InfoHash
+	bytes, err := offlineDecode(string(result.EncodedData), key)
+	if err != nil {
+		return err
 	}
-	return hashes, nil
+	return json.Unmarshal(bytes, v)
 }
 
 // DeleteOfflineTasks deletes tasks.
@@ -201,6 +277,29 @@ type OfflineTaskResponse struct {
 	Url      string `json:"url"`
 }
 
+type OfflineAddTorrentResponse struct {
+	BasicResp
+	InfoHash string `json:"info_hash"`
+	Name     string `json:"name"`
+}
+
+// TorrentInfo describes a torrent as 115 parsed it.
+type TorrentInfo struct {
+	BasicResp
+	Name      string         `json:"torrent_name"`
+	InfoHash  string         `json:"info_hash"`
+	FileSize  int64          `json:"file_size"`
+	FileCount int64          `json:"file_count"`
+	Files     []*TorrentFile `json:"torrent_filelist_web"`
+}
+
+// TorrentFile is one file of a torrent, in 115's order.
+type TorrentFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	Wanted int    `json:"wanted"`
+}
+
 type OfflineTaskResp struct {
 	BasicResp
 	Total     int64          `json:"total"`
@@ -0,0 +1,106 @@
+package driver
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	crypto "github.com/SheltonZhu/115driver/pkg/crypto/m115"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// usePlainOfflineCodec swaps the m115 codec of the offline apis for plain
+// base64, so the mock server can read requests and write answers.
+func usePlainOfflineCodec(t *testing.T) {
+	encode, decode := offlineEncode, offlineDecode
+	t.Cleanup(func() { offlineEncode, offlineDecode = encode, decode })
+	offlineEncode = func(input []byte, _ crypto.Key) string {
+		return base64.StdEncoding.EncodeToString(input)
+	}
+	offlineDecode = func(input string, _ crypto.Key) ([]byte, error) {
+		return base64.StdEncoding.DecodeString(input)
+	}
+}
+
+// encodedAnswer wraps answer the way the offline apis return it.
+func encodedAnswer(answer string) string {
+	return `{"state":true,"data":"` + base64.StdEncoding.EncodeToString([]byte(answer)) + `"}`
+}
+
+func TestGetTorrentInfo(t *testing.T) {
+	client := newMockAPIClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/lixian/", r.URL.Path)
+		assert.Equal(t, "torrent", r.URL.Query().Get("ac"))
+		require.NoError(t, r.ParseForm())
+		assert.Equal(t, "0123456789ABCDEF0123456789ABCDEF01234567", r.PostForm.Get("sha1"))
+		_, _ = w.Write([]byte(`{
+			"state": true,
+			"torrent_name": "Movie",
+			"info_hash": "a1b2c3",
+			"file_size": 1073741834,
+			"file_count": 2,
+			"torrent_filelist_web": [
+				{"path": "Movie/movie.mkv", "size": 1073741824, "wanted": 1},
+				{"path": "Movie/movie.nfo", "size": 10, "wanted": 0}
+			]
+		}`))
+	})
+
+	info, err := client.GetTorrentInfo("0123456789ABCDEF0123456789ABCDEF01234567")
+	require.NoError(t, err)
+	assert.Equal(t, "Movie", info.Name)
+	assert.Equal(t, "a1b2c3", info.InfoHash)
+	assert.Equal(t, int64(1073741834), info.FileSize)
+	assert.Equal(t, int64(2), info.FileCount)
+	require.Len(t, info.Files, 2)
+	assert.Equal(t, TorrentFile{Path: "Movie/movie.mkv", Size: 1073741824, Wanted: 1}, *info.Files[0])
+	assert.Equal(t, TorrentFile{Path: "Movie/movie.nfo", Size: 10}, *info.Files[1])
+}
+
+func TestGetTorrentInfoReportsErrors(t *testing.T) {
+	client := newMockAPIClient(t, func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"state":false,"errno":10004,"error_msg":"bad torrent"}`))
+	})
+
+	_, err := client.GetTorrentInfo("0123456789ABCDEF0123456789ABCDEF01234567")
+	assert.ErrorIs(t, err, ErrOfflineInvalidLink)
+}
+
+func TestAddOfflineTaskTorrent(t *testing.T) {
+	usePlainOfflineCodec(t)
+	answer := `{"state":true,"info_hash":"a1b2c3","name":"Movie"}`
+	client := newMockAPIClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/lixianssp/", r.URL.Path)
+		assert.Equal(t, "add_task_bt", r.URL.Query().Get("ac"))
+		assert.NotEmpty(t, r.URL.Query().Get("t"))
+		require.NoError(t, r.ParseForm())
+		decoded, err := base64.StdEncoding.DecodeString(r.PostForm.Get("data"))
+		require.NoError(t, err)
+		var params map[string]string
+		require.NoError(t, json.Unmarshal(decoded, &params))
+		assert.Equal(t, map[string]string{
+			"ac":         "add_task_bt",
+			"info_hash":  "a1b2c3",
+			"wanted":     "0,2",
+			"savepath":   "",
+			"wp_path_id": "9",
+			"app_ver":    DefaultOfflineOptions().appVer,
+			"uid":        "42",
+		}, params)
+		_, _ = w.Write([]byte(encodedAnswer(answer)))
+	})
+	client.UserID = 42
+
+	infoHash, err := client.AddOfflineTaskTorrent("a1b2c3", []int{0, 2}, "9")
+	require.NoError(t, err)
+	assert.Equal(t, "a1b2c3", infoHash)
+
+	// A task that already exists fails inside the encoded answer
+	answer = `{"state":false,"errno":10008,"error_msg":"task exists"}`
+	_, err = client.AddOfflineTaskTorrent("a1b2c3", []int{0, 2}, "9")
+	assert.ErrorIs(t, err, ErrOfflineTaskExisted)
+}
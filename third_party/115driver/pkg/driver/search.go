@@ -88,9 +88,17 @@ type SearchResult struct {
 	IsAsc int `json:"is_asc"`
 }
 
+// searchResp is FileListResp plus the counts only the search API returns
+type searchResp struct {
+	FileListResp
+
+	FileCount   StringInt `json:"file_count"`
+	FolderCount StringInt `json:"folder_count"`
+}
+
 // Search searches for files using given options
 func (c *Pan115Client) Search(opts *SearchOption) (*SearchResult, error) {
-	result := FileListResp{}
+	result := searchResp{}
 	params := map[string]string{
 		"aid":           "7",
 		"cid":           "0",
@@ -161,8 +169,8 @@ func (c *Pan115Client) Search(opts *SearchOption) (*SearchResult, error) {
 	// Convert results
 	searchResult := &SearchResult{
 		Count:       result.Count,
-		FileCount:   0, // Not available in FileListResp
-		FolderCount: 0, // Not available in FileListResp
+		FileCount:   int(result.FileCount),
+		FolderCount: int(result.FolderCount),
 		PageSize:    result.PageSize,
 		Offset:      result.Offset,
 		Order:       result.Order,
@@ -0,0 +1,52 @@
+package driver
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSearchReportsFileAndFolderCounts(t *testing.T) {
+	client := newMockAPIClient(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodGet, r.Method)
+		assert.Equal(t, "/files/search", r.URL.Path)
+		query := r.URL.Query()
+		assert.Equal(t, "show", query.Get("search_value"))
+		assert.Equal(t, "9", query.Get("cid"))
+		assert.Equal(t, "20", query.Get("offset"))
+		assert.Equal(t, "10", query.Get("limit"))
+		assert.Equal(t, "1", query.Get("count_folders"))
+		assert.Equal(t, "0", query.Get("asc"))
+		_, _ = w.Write([]byte(`{
+			"state": true,
+			"count": 2,
+			"file_count": "1",
+			"folder_count": "1",
+			"page_size": 10,
+			"offset": 20,
+			"order": "user_ptime",
+			"is_asc": 0,
+			"data": [
+				{"fid": "1", "cid": "9", "n": "show.mkv", "s": "42", "pc": "pc1", "sha": "ABC"},
+				{"cid": "10", "pid": "9", "n": "Show", "pc": "pc2"}
+			]
+		}`))
+	})
+
+	result, err := client.Search(&SearchOption{SearchValue: "show", Cid: "9", Offset: 20, Limit: 10, CountFolders: 1})
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Count)
+	assert.Equal(t, 1, result.FileCount)
+	assert.Equal(t, 1, result.FolderCount)
+	assert.Equal(t, 10, result.PageSize)
+	assert.Equal(t, 20, result.Offset)
+	require.Len(t, result.Files, 2)
+	assert.Equal(t, "1", result.Files[0].FileID)
+	assert.Equal(t, "show.mkv", result.Files[0].Name)
+	assert.Equal(t, int64(42), result.Files[0].Size)
+	assert.False(t, result.Files[0].IsDirectory)
+	assert.Equal(t, "10", result.Files[1].FileID)
+	assert.True(t, result.Files[1].IsDirectory)
+}